@@ -0,0 +1,61 @@
+package gridfs
+
+import (
+	"context"
+	"errors"
+	"io"
+
+	"github.com/kunmingliu/messenger/domain"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/gridfs"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+type gridfsBlobStore struct {
+	DB *mongo.Database
+}
+
+const (
+	bucketName = "blob"
+)
+
+func NewGridFSBlobStore(DB *mongo.Database) domain.BlobStore {
+	return &gridfsBlobStore{DB}
+}
+
+// bucket creates a new bucket for every call since the deadline of gridfs.Bucket is shared by all its operations.
+func (g *gridfsBlobStore) bucket(ctx context.Context) (*gridfs.Bucket, error) {
+	bucket, err := gridfs.NewBucket(g.DB, options.GridFSBucket().SetName(bucketName))
+	if err != nil {
+		return nil, err
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		bucket.SetReadDeadline(deadline)
+		bucket.SetWriteDeadline(deadline)
+	}
+	return bucket, nil
+}
+
+func (g *gridfsBlobStore) Put(ctx context.Context, key string, r io.Reader) error {
+	bucket, err := g.bucket(ctx)
+	if err != nil {
+		return err
+	}
+	_, err = bucket.UploadFromStream(key, r)
+	return err
+}
+
+func (g *gridfsBlobStore) Get(ctx context.Context, key string) (io.ReadCloser, error) {
+	bucket, err := g.bucket(ctx)
+	if err != nil {
+		return nil, err
+	}
+	stream, err := bucket.OpenDownloadStreamByName(key)
+	if errors.Is(err, gridfs.ErrFileNotFound) {
+		return nil, domain.ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return stream, nil
+}
@@ -0,0 +1,73 @@
+package local
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"testing"
+
+	"github.com/kunmingliu/messenger/domain"
+)
+
+func Test_localBlobStore_PutAndGet(t *testing.T) {
+	ctx := context.Background()
+	store, err := NewLocalBlobStore(t.TempDir())
+	if err != nil {
+		t.Fatalf("create store failed, err: %v", err)
+	}
+
+	content := []byte("fake image")
+	err = store.Put(ctx, "325708", bytes.NewReader(content))
+	if err != nil {
+		t.Errorf("put failed, err: %v", err)
+	}
+
+	r, err := store.Get(ctx, "325708")
+	if err != nil {
+		t.Fatalf("get failed, err: %v", err)
+	}
+	defer r.Close()
+	b, _ := io.ReadAll(r)
+	if !bytes.Equal(b, content) {
+		t.Errorf("content inconsistent, content:%s, expected content:%s", b, content)
+	}
+}
+
+func Test_localBlobStore_Get(t *testing.T) {
+	ctx := context.Background()
+	store, err := NewLocalBlobStore(t.TempDir())
+	if err != nil {
+		t.Fatalf("create store failed, err: %v", err)
+	}
+
+	cases := []struct {
+		name string
+		key  string
+		err  error
+	}{
+		{
+			name: "get failed because blob doesn't exist",
+			key:  "not-exist",
+			err:  domain.ErrNotFound,
+		},
+		{
+			name: "get failed because key escapes from the directory",
+			key:  "../secret",
+			err:  domain.ErrBadParamInput,
+		},
+		{
+			name: "get failed because key is empty",
+			key:  "",
+			err:  domain.ErrBadParamInput,
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			_, err := store.Get(ctx, c.key)
+			if err != c.err {
+				t.Errorf("error inconsistent, caught error:%v, expected error:%v", err, c.err)
+			}
+		})
+	}
+}
@@ -0,0 +1,145 @@
+package cmd
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"github.com/kunmingliu/messenger/domain"
+	_eventMongoRepo "github.com/kunmingliu/messenger/event/repository/mongo"
+	_eventPostgresRepo "github.com/kunmingliu/messenger/event/repository/postgres"
+	_eventSQLiteRepo "github.com/kunmingliu/messenger/event/repository/sqlite"
+	_messageMongoRepo "github.com/kunmingliu/messenger/message/repository/mongo"
+	_messagePostgresRepo "github.com/kunmingliu/messenger/message/repository/postgres"
+	_messageSQLiteRepo "github.com/kunmingliu/messenger/message/repository/sqlite"
+	"github.com/kunmingliu/messenger/migration"
+	_mongoMigration "github.com/kunmingliu/messenger/migration/mongo"
+	_postgresMigration "github.com/kunmingliu/messenger/migration/postgres"
+	_sqliteMigration "github.com/kunmingliu/messenger/migration/sqlite"
+	_ "modernc.org/sqlite"
+)
+
+// database holds the repositories of the database selected by db.driver.
+type database struct {
+	messageRepo domain.MessageRepository
+	eventRepo   domain.EventRepository
+	migrator    *migration.Migrator
+	// mongoDB is nil unless the driver is mongo, it's required by the GridFS blob store.
+	mongoDB *mongo.Database
+	close   func(ctx context.Context) error
+}
+
+func openDatabase(ctx context.Context) (*database, error) {
+	switch config.DBConfig.Driver {
+	case "mongo", "":
+		if err := validateCredential(); err != nil {
+			return nil, err
+		}
+		return openMongo(ctx)
+	case "postgres":
+		if err := validateCredential(); err != nil {
+			return nil, err
+		}
+		return openPostgres(ctx)
+	case "sqlite":
+		return openSQLite(ctx)
+	default:
+		return nil, fmt.Errorf("unsupported db driver: %s", config.DBConfig.Driver)
+	}
+}
+
+func validateCredential() error {
+	if config.DBConfig.User == "" {
+		return errors.New("db_user shouldn't be empty")
+	}
+
+	if config.DBConfig.Password == "" {
+		return errors.New("db_password shouldn't be empty")
+	}
+	return nil
+}
+
+// dbPort returns the configured port or the default port of the driver.
+func dbPort(defaultPort string) string {
+	if config.DBConfig.Port == "" {
+		return defaultPort
+	}
+	return config.DBConfig.Port
+}
+
+func openMongo(ctx context.Context) (*database, error) {
+	uri := url.URL{
+		Scheme: "mongodb",
+		User:   url.UserPassword(config.DBConfig.User, config.DBConfig.Password),
+		Host:   config.DBConfig.Host + ":" + dbPort("27017"),
+	}
+	client, err := mongo.Connect(ctx, options.Client().ApplyURI(uri.String()))
+	if err != nil {
+		return nil, err
+	}
+	db := client.Database(config.DBConfig.Name)
+	return &database{
+		messageRepo: _messageMongoRepo.NewMongoRepository(db),
+		eventRepo:   _eventMongoRepo.NewMongoRepository(db),
+		migrator:    _mongoMigration.NewMigrator(db),
+		mongoDB:     db,
+		close:       client.Disconnect,
+	}, nil
+}
+
+func openPostgres(ctx context.Context) (*database, error) {
+	dsn := url.URL{
+		Scheme:   "postgres",
+		User:     url.UserPassword(config.DBConfig.User, config.DBConfig.Password),
+		Host:     config.DBConfig.Host + ":" + dbPort("5432"),
+		Path:     config.DBConfig.Name,
+		RawQuery: url.Values{"sslmode": []string{config.DBConfig.SSLMode}}.Encode(),
+	}
+	db, err := sql.Open("postgres", dsn.String())
+	if err != nil {
+		return nil, err
+	}
+	if err = db.PingContext(ctx); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return &database{
+		messageRepo: _messagePostgresRepo.NewPostgresRepository(db),
+		eventRepo:   _eventPostgresRepo.NewPostgresRepository(db),
+		migrator:    _postgresMigration.NewMigrator(db),
+		close: func(ctx context.Context) error {
+			return db.Close()
+		},
+	}, nil
+}
+
+func openSQLite(ctx context.Context) (*database, error) {
+	if err := os.MkdirAll(filepath.Dir(config.DBConfig.Path), 0o755); err != nil {
+		return nil, err
+	}
+	// writers wait for the lock instead of failing immediately, and WAL lets readers run alongside the writer
+	dsn := "file:" + config.DBConfig.Path + "?_pragma=busy_timeout(5000)&_pragma=journal_mode(WAL)"
+	db, err := sql.Open("sqlite", dsn)
+	if err != nil {
+		return nil, err
+	}
+	if err = db.PingContext(ctx); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return &database{
+		messageRepo: _messageSQLiteRepo.NewSQLiteRepository(db),
+		eventRepo:   _eventSQLiteRepo.NewSQLiteRepository(db),
+		migrator:    _sqliteMigration.NewMigrator(db),
+		close: func(ctx context.Context) error {
+			return db.Close()
+		},
+	}, nil
+}
@@ -0,0 +1,83 @@
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	_blobLocalRepo "github.com/kunmingliu/messenger/blob/repository/local"
+	"github.com/kunmingliu/messenger/domain"
+	_eventMemoryRepo "github.com/kunmingliu/messenger/event/repository/memory"
+	_messageMemoryRepo "github.com/kunmingliu/messenger/message/repository/memory"
+	"github.com/kunmingliu/messenger/provider"
+	_lineProvider "github.com/kunmingliu/messenger/provider/line"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// demoChannel is the only channel in the demo mode, its webhook is at both /webhook and /webhook/demo.
+const demoChannel = "demo"
+
+// DemoProvider is a fake provider for the demo mode. Its webhook accepts the messages and events in JSON, e.g.
+// {"messages": [{"user_id": "U1", "type": "text", "message": "hello"}]}, and the outbound messages are only logged.
+type DemoProvider struct{}
+
+func (d *DemoProvider) ParseRequest(r *http.Request) (webhook domain.Webhook, err error) {
+	var body struct {
+		Messages []domain.Message `json:"messages"`
+		Events   []domain.Event   `json:"events"`
+	}
+	if err = json.NewDecoder(r.Body).Decode(&body); err != nil {
+		return
+	}
+	// every inbound message can be replied in a minute like Line
+	expiresAt := time.Now().UTC().Add(_lineProvider.ReplyTokenTTL)
+	for i := range body.Messages {
+		body.Messages[i].ReplyToken = primitive.NewObjectID().Hex()
+		body.Messages[i].ReplyExpiresAt = &expiresAt
+	}
+	webhook.Messages = body.Messages
+	webhook.Events = body.Events
+	return
+}
+
+func (d *DemoProvider) SendMessage(ctx context.Context, msg string) error {
+	log.Printf("demo: broadcast %q", msg)
+	return nil
+}
+
+func (d *DemoProvider) ReplyMessage(ctx context.Context, replyToken, msg string) error {
+	log.Printf("demo: reply %q with token %s", msg, replyToken)
+	return nil
+}
+
+func (d *DemoProvider) PushMessage(ctx context.Context, userID, msg string) error {
+	log.Printf("demo: push %q to %s", msg, userID)
+	return nil
+}
+
+func (d *DemoProvider) Multicast(ctx context.Context, userIDs []string, msg string) error {
+	log.Printf("demo: multicast %q to %s", msg, strings.Join(userIDs, ", "))
+	return nil
+}
+
+// GetContent returns the content id as the content since there is no real media in the demo mode.
+func (d *DemoProvider) GetContent(ctx context.Context, contentID string) (content io.ReadCloser, contentType string, err error) {
+	return io.NopCloser(strings.NewReader(contentID)), "text/plain", nil
+}
+
+func startDemoServer() {
+	log.Printf("demo: messages and events are kept in memory and lost on exit")
+	blobStore, err := _blobLocalRepo.NewLocalBlobStore(config.BlobConfig.Path)
+	if err != nil {
+		panic(err)
+	}
+	registry := provider.NewRegistry()
+	if err = registry.Register(demoChannel, &DemoProvider{}); err != nil {
+		panic(err)
+	}
+	serve(registry, _messageMemoryRepo.NewMemoryRepository(), _eventMemoryRepo.NewMemoryRepository(), blobStore)
+}
@@ -0,0 +1,97 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/kunmingliu/messenger/migration"
+	"github.com/spf13/cobra"
+)
+
+var migrateCmd = &cobra.Command{
+	Use:   "migrate",
+	Short: "migrate the database to the latest version",
+	Long: `The migrate command applies the pending migrations which create the collections and indexes messenger needs.
+    Use the up and down subcommands to migrate to a specific version, and status to show the applied version.`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return runMigration(func(ctx context.Context, m *migration.Migrator) error {
+			return m.Up(ctx, m.Latest())
+		})
+	},
+}
+
+var migrateUpCmd = &cobra.Command{
+	Use:   "up",
+	Short: "apply the pending migrations up to the target version(the latest by default)",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return runMigration(func(ctx context.Context, m *migration.Migrator) error {
+			target := m.Latest()
+			if cmd.Flags().Changed("to") {
+				target, _ = cmd.Flags().GetInt("to")
+			}
+			return m.Up(ctx, target)
+		})
+	},
+}
+
+var migrateDownCmd = &cobra.Command{
+	Use:   "down",
+	Short: "revert the applied migrations down to the target version(one version back by default)",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return runMigration(func(ctx context.Context, m *migration.Migrator) error {
+			version, err := m.Version(ctx)
+			if err != nil {
+				return err
+			}
+			target := version - 1
+			if cmd.Flags().Changed("to") {
+				target, _ = cmd.Flags().GetInt("to")
+			}
+			if target < 0 {
+				target = 0
+			}
+			return m.Down(ctx, target)
+		})
+	},
+}
+
+var migrateStatusCmd = &cobra.Command{
+	Use:   "status",
+	Short: "show the applied and the latest version",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return runMigration(func(ctx context.Context, m *migration.Migrator) error {
+			return nil
+		})
+	},
+}
+
+func runMigration(run func(ctx context.Context, m *migration.Migrator) error) error {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	db, err := openDatabase(ctx)
+	if err != nil {
+		return err
+	}
+	defer db.close(ctx)
+
+	m := db.migrator
+	if err = run(ctx, m); err != nil {
+		return err
+	}
+	version, err := m.Version(ctx)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("current version: %d, latest version: %d\n", version, m.Latest())
+	return nil
+}
+
+func init() {
+	migrateUpCmd.Flags().IntP("to", "", 0, "target version")
+	migrateDownCmd.Flags().IntP("to", "", 0, "target version")
+
+	migrateCmd.AddCommand(migrateUpCmd, migrateDownCmd, migrateStatusCmd)
+	rootCmd.AddCommand(migrateCmd)
+}
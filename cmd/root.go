@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"os"
 	"strings"
+	"time"
 
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
@@ -12,32 +13,189 @@ import (
 type ServerConfig struct {
 	Port string `mapstructure:"port"`
 }
-type LineConfig struct {
-	Secret string `mapstructure:"secret"`
-	Token  string `mapstructure:"token"`
+
+// ChannelConfig is what the channels of every platform have in common.
+type ChannelConfig struct {
+	// ID is used in the webhook path /webhook/{id} and the messages of the channel.
+	ID string `mapstructure:"id"`
 }
-type DBConfig struct {
-	User     string `mapstructure:"user"`
+
+func (c *ChannelConfig) channel() *ChannelConfig {
+	return c
+}
+
+// PlatformConfig lists the channels of a platform, the usual single account is at the top level and more accounts are
+// listed in Channels.
+type PlatformConfig[T any] struct {
+	Channel  T   `mapstructure:",squash"`
+	Channels []T `mapstructure:"channels"`
+}
+
+// channelConfig is the pointer to the config of a channel, configured reports whether the credentials of the channel
+// are set since the one at the top level is skipped otherwise.
+type channelConfig[T any] interface {
+	*T
+	channel() *ChannelConfig
+	configured() bool
+}
+
+type LineChannelConfig struct {
+	ChannelConfig `mapstructure:",squash"`
+	Secret        string `mapstructure:"secret"`
+	Token         string `mapstructure:"token"`
+}
+
+func (c *LineChannelConfig) configured() bool {
+	return c.Secret != "" || c.Token != ""
+}
+
+type TelegramChannelConfig struct {
+	ChannelConfig `mapstructure:",squash"`
+	Token         string `mapstructure:"token"`
+	// SecretToken is given to setWebhook and checked on every webhook request.
+	SecretToken string `mapstructure:"secret_token"`
+}
+
+func (c *TelegramChannelConfig) configured() bool {
+	return c.Token != ""
+}
+
+type SlackChannelConfig struct {
+	ChannelConfig `mapstructure:",squash"`
+	Token         string `mapstructure:"token"`
+	// SigningSecret signs every request of the Events API.
+	SigningSecret string `mapstructure:"signing_secret"`
+}
+
+func (c *SlackChannelConfig) configured() bool {
+	return c.Token != "" || c.SigningSecret != ""
+}
+
+type FacebookChannelConfig struct {
+	ChannelConfig `mapstructure:",squash"`
+	AppSecret     string `mapstructure:"app_secret"`
+	AccessToken   string `mapstructure:"access_token"`
+	// VerifyToken is given to the webhook subscription and checked when it's verified.
+	VerifyToken string `mapstructure:"verify_token"`
+}
+
+func (c *FacebookChannelConfig) configured() bool {
+	return c.AppSecret != "" || c.AccessToken != ""
+}
+
+type DiscordChannelConfig struct {
+	ChannelConfig `mapstructure:",squash"`
+	ApplicationID string `mapstructure:"application_id"`
+	// PublicKey is in hex and verifies the signatures of interactions.
+	PublicKey string `mapstructure:"public_key"`
+	Token     string `mapstructure:"token"`
+}
+
+func (c *DiscordChannelConfig) configured() bool {
+	return c.PublicKey != "" || c.Token != ""
+}
+
+type EmailChannelConfig struct {
+	ChannelConfig `mapstructure:",squash"`
+	// Address receives the mails and sends the messages.
+	Address string `mapstructure:"address"`
+	// Listen is the address of the SMTP server receiving mails, e.g. ":2525".
+	Listen string `mapstructure:"listen"`
+	// Relay is the host:port of the SMTP server sending mails.
+	Relay    string `mapstructure:"relay"`
+	Username string `mapstructure:"username"`
 	Password string `mapstructure:"password"`
-	Host     string `mapstructure:"host"`
-	Port     string `mapstructure:"port"`
+	Subject  string `mapstructure:"subject"`
+}
+
+func (c *EmailChannelConfig) configured() bool {
+	return c.Address != ""
+}
+
+type SMSChannelConfig struct {
+	ChannelConfig `mapstructure:",squash"`
+	AccountSID    string `mapstructure:"account_sid"`
+	AuthToken     string `mapstructure:"auth_token"`
+	// From is the phone number sending the messages in E.164.
+	From string `mapstructure:"from"`
+	// WebhookURL is the public url of the webhook signed by Twilio, it's rebuilt from the request if it's empty.
+	WebhookURL string `mapstructure:"webhook_url"`
+	// BaseURL replaces the REST API of Twilio, e.g. with a compatible service.
+	BaseURL string `mapstructure:"base_url"`
+}
+
+func (c *SMSChannelConfig) configured() bool {
+	return c.AccountSID != "" || c.AuthToken != ""
+}
+
+type WhatsAppChannelConfig struct {
+	ChannelConfig `mapstructure:",squash"`
+	PhoneNumberID string `mapstructure:"phone_number_id"`
+	AppSecret     string `mapstructure:"app_secret"`
+	AccessToken   string `mapstructure:"access_token"`
+	VerifyToken   string `mapstructure:"verify_token"`
+}
+
+func (c *WhatsAppChannelConfig) configured() bool {
+	return c.PhoneNumberID != "" || c.AccessToken != ""
+}
+
+type GenericChannelConfig struct {
+	ChannelConfig `mapstructure:",squash"`
+	Secret        string `mapstructure:"secret"`
+	// URL is where the messages sent are posted, the channel only receives messages if it's empty.
+	URL string `mapstructure:"url"`
+	// Attempts is how many times a message is posted before giving up.
+	Attempts int `mapstructure:"attempts"`
+}
+
+func (c *GenericChannelConfig) configured() bool {
+	return c.Secret != ""
+}
+
+type DBConfig struct {
+	Driver      string `mapstructure:"driver"`
+	Name        string `mapstructure:"name"`
+	SSLMode     string `mapstructure:"sslmode"`
+	Path        string `mapstructure:"path"`
+	User        string `mapstructure:"user"`
+	Password    string `mapstructure:"password"`
+	Host        string `mapstructure:"host"`
+	Port        string `mapstructure:"port"`
+	AutoMigrate bool   `mapstructure:"auto_migrate"`
+}
+type BlobConfig struct {
+	Driver string `mapstructure:"driver"`
+	Path   string `mapstructure:"path"`
+	// Timeout bounds the download and the storage of the content of a media.
+	Timeout time.Duration `mapstructure:"timeout"`
 }
 type Config struct {
 	ServerConfig `mapstructure:"server"`
-	LineConfig   `mapstructure:"line"`
+	Line         PlatformConfig[LineChannelConfig]     `mapstructure:"line"`
+	Telegram     PlatformConfig[TelegramChannelConfig] `mapstructure:"telegram"`
+	Slack        PlatformConfig[SlackChannelConfig]    `mapstructure:"slack"`
+	Facebook     PlatformConfig[FacebookChannelConfig] `mapstructure:"facebook"`
+	Discord      PlatformConfig[DiscordChannelConfig]  `mapstructure:"discord"`
+	Email        PlatformConfig[EmailChannelConfig]    `mapstructure:"email"`
+	SMS          PlatformConfig[SMSChannelConfig]      `mapstructure:"sms"`
+	WhatsApp     PlatformConfig[WhatsAppChannelConfig] `mapstructure:"whatsapp"`
+	Generic      PlatformConfig[GenericChannelConfig]  `mapstructure:"generic"`
 	DBConfig     `mapstructure:"db"`
+	BlobConfig   `mapstructure:"blob"`
 }
 
 var (
 	configName string
+	demo       bool
 	config     Config
 )
 
 var rootCmd = &cobra.Command{
 	Use:   "messenger",
-	Short: "messenger - a http server that integrates Line and MongoDB",
-	Long: `The messenger is a http server that integrates Line and MongoDB.
-    You can use config, environment variables or CLI flags to set basic configuration to connect to Line and MongoDB.`,
+	Short: "messenger - a http server that integrates Line, Telegram, Slack, Facebook, Discord, email, SMS, WhatsApp, in-house systems and databases",
+	Long: `The messenger is a http server that integrates Line, Telegram, Slack, Facebook, Discord, email, SMS, WhatsApp, in-house systems and databases.
+    You can use config, environment variables or CLI flags to set basic configuration to connect to them.`,
 	Run: func(cmd *cobra.Command, args []string) {
 		startServer()
 	},
@@ -52,24 +210,117 @@ func Execute() {
 
 func init() {
 	cobra.OnInitialize(initConfig)
-	rootCmd.Flags().StringVarP(&configName, "config", "c", "", "config file(it should be placed in the root path)")
+	rootCmd.PersistentFlags().StringVarP(&configName, "config", "c", "", "config file(it should be placed in the root path)")
+	rootCmd.Flags().BoolVarP(&demo, "demo", "", false, "run with in-memory storage and a fake provider, nothing is persisted")
 	rootCmd.Flags().StringP("sever_port", "", "8085", "server port")
+	rootCmd.Flags().StringP("line_id", "", "line", "channel id of Line used in the webhook path and the messages")
 	rootCmd.Flags().StringP("line_secret", "", "", "channel secret of Line")
 	rootCmd.Flags().StringP("line_token", "", "", "channel access token of Line")
+	rootCmd.Flags().StringP("telegram_id", "", "telegram", "channel id of Telegram used in the webhook path and the messages")
+	rootCmd.Flags().StringP("telegram_token", "", "", "bot token of Telegram")
+	rootCmd.Flags().StringP("telegram_secret_token", "", "", "secret token of the Telegram webhook, required with the bot token")
+	rootCmd.Flags().StringP("slack_id", "", "slack", "channel id of Slack used in the webhook path and the messages")
+	rootCmd.Flags().StringP("slack_token", "", "", "bot token of Slack")
+	rootCmd.Flags().StringP("slack_signing_secret", "", "", "signing secret of the Slack app")
+	rootCmd.Flags().StringP("facebook_id", "", "facebook", "channel id of Facebook used in the webhook path and the messages")
+	rootCmd.Flags().StringP("facebook_app_secret", "", "", "app secret of the Facebook app")
+	rootCmd.Flags().StringP("facebook_access_token", "", "", "page access token of Facebook")
+	rootCmd.Flags().StringP("facebook_verify_token", "", "", "verify token of the Facebook webhook")
+	rootCmd.Flags().StringP("discord_id", "", "discord", "channel id of Discord used in the webhook path and the messages")
+	rootCmd.Flags().StringP("discord_application_id", "", "", "application id of Discord")
+	rootCmd.Flags().StringP("discord_public_key", "", "", "public key of the Discord application")
+	rootCmd.Flags().StringP("discord_token", "", "", "bot token of Discord")
+	rootCmd.Flags().StringP("email_id", "", "email", "channel id of email used in the messages")
+	rootCmd.Flags().StringP("email_address", "", "", "email address receiving mails and sending messages")
+	rootCmd.Flags().StringP("email_listen", "", ":2525", "address of the SMTP server receiving mails")
+	rootCmd.Flags().StringP("email_relay", "", "", "host:port of the SMTP relay sending mails")
+	rootCmd.Flags().StringP("email_username", "", "", "username of the SMTP relay")
+	rootCmd.Flags().StringP("email_password", "", "", "password of the SMTP relay")
+	rootCmd.Flags().StringP("email_subject", "", "", "subject of the mails sent")
+	rootCmd.Flags().StringP("sms_id", "", "sms", "channel id of SMS used in the webhook path and the messages")
+	rootCmd.Flags().StringP("sms_account_sid", "", "", "account sid of Twilio")
+	rootCmd.Flags().StringP("sms_auth_token", "", "", "auth token of Twilio")
+	rootCmd.Flags().StringP("sms_from", "", "", "phone number sending SMS")
+	rootCmd.Flags().StringP("sms_webhook_url", "", "", "public url of the SMS webhook")
+	rootCmd.Flags().StringP("sms_base_url", "", "", "base url of the REST API replacing Twilio")
+	rootCmd.Flags().StringP("whatsapp_id", "", "whatsapp", "channel id of WhatsApp used in the webhook path and the messages")
+	rootCmd.Flags().StringP("whatsapp_phone_number_id", "", "", "phone number id of the WhatsApp Business Account")
+	rootCmd.Flags().StringP("whatsapp_app_secret", "", "", "app secret of the Meta app")
+	rootCmd.Flags().StringP("whatsapp_access_token", "", "", "access token of the WhatsApp Business Account")
+	rootCmd.Flags().StringP("whatsapp_verify_token", "", "", "verify token of the WhatsApp webhook")
+	rootCmd.Flags().StringP("generic_id", "", "generic", "channel id of the in-house system used in the webhook path and the messages")
+	rootCmd.Flags().StringP("generic_secret", "", "", "secret signing the requests of the in-house system")
+	rootCmd.Flags().StringP("generic_url", "", "", "url of the in-house system receiving the messages sent")
+	rootCmd.Flags().IntP("generic_attempts", "", 3, "how many times a message is posted to the in-house system")
+
+	rootCmd.PersistentFlags().StringP("db_driver", "", "mongo", "database driver(mongo, postgres or sqlite)")
+	rootCmd.PersistentFlags().StringP("db_name", "", "db", "database name")
+	rootCmd.PersistentFlags().StringP("db_sslmode", "", "disable", "sslmode of postgres")
+	rootCmd.PersistentFlags().StringP("db_path", "", "data/messenger.db", "database file of sqlite")
+	rootCmd.PersistentFlags().StringP("db_user", "u", "", "database user")
+	rootCmd.PersistentFlags().StringP("db_password", "p", "", "database password")
+	rootCmd.PersistentFlags().StringP("db_host", "", "localhost", "database host")
+	rootCmd.PersistentFlags().StringP("db_port", "", "", "database port(27017 for mongo and 5432 for postgres by default)")
+	rootCmd.Flags().BoolP("db_auto_migrate", "", true, "apply pending migrations on server start")
 
-	rootCmd.Flags().StringP("db_user", "u", "", "database user")
-	rootCmd.Flags().StringP("db_password", "p", "", "database password")
-	rootCmd.Flags().StringP("db_host", "", "localhost", "database host")
-	rootCmd.Flags().StringP("db_port", "", "27017", "database port")
+	rootCmd.Flags().StringP("blob_driver", "", "local", "storage of media content(local or gridfs)")
+	rootCmd.Flags().StringP("blob_path", "", "data/blob", "directory of media content for local storage")
+	rootCmd.Flags().DurationP("blob_timeout", "", time.Minute, "how long the content of a media can take to be downloaded and stored")
 
-	viper.BindPFlag("sever.port", rootCmd.Flags().Lookup("sever_port"))
-	viper.BindPFlag("line.token", rootCmd.Flags().Lookup("line_secret"))
+	viper.BindPFlag("server.port", rootCmd.Flags().Lookup("sever_port"))
+	viper.BindPFlag("line.id", rootCmd.Flags().Lookup("line_id"))
+	viper.BindPFlag("line.secret", rootCmd.Flags().Lookup("line_secret"))
 	viper.BindPFlag("line.token", rootCmd.Flags().Lookup("line_token"))
+	viper.BindPFlag("telegram.id", rootCmd.Flags().Lookup("telegram_id"))
+	viper.BindPFlag("telegram.token", rootCmd.Flags().Lookup("telegram_token"))
+	viper.BindPFlag("telegram.secret_token", rootCmd.Flags().Lookup("telegram_secret_token"))
+	viper.BindPFlag("slack.id", rootCmd.Flags().Lookup("slack_id"))
+	viper.BindPFlag("slack.token", rootCmd.Flags().Lookup("slack_token"))
+	viper.BindPFlag("slack.signing_secret", rootCmd.Flags().Lookup("slack_signing_secret"))
+	viper.BindPFlag("facebook.id", rootCmd.Flags().Lookup("facebook_id"))
+	viper.BindPFlag("facebook.app_secret", rootCmd.Flags().Lookup("facebook_app_secret"))
+	viper.BindPFlag("facebook.access_token", rootCmd.Flags().Lookup("facebook_access_token"))
+	viper.BindPFlag("facebook.verify_token", rootCmd.Flags().Lookup("facebook_verify_token"))
+	viper.BindPFlag("discord.id", rootCmd.Flags().Lookup("discord_id"))
+	viper.BindPFlag("discord.application_id", rootCmd.Flags().Lookup("discord_application_id"))
+	viper.BindPFlag("discord.public_key", rootCmd.Flags().Lookup("discord_public_key"))
+	viper.BindPFlag("discord.token", rootCmd.Flags().Lookup("discord_token"))
+	viper.BindPFlag("email.id", rootCmd.Flags().Lookup("email_id"))
+	viper.BindPFlag("email.address", rootCmd.Flags().Lookup("email_address"))
+	viper.BindPFlag("email.listen", rootCmd.Flags().Lookup("email_listen"))
+	viper.BindPFlag("email.relay", rootCmd.Flags().Lookup("email_relay"))
+	viper.BindPFlag("email.username", rootCmd.Flags().Lookup("email_username"))
+	viper.BindPFlag("email.password", rootCmd.Flags().Lookup("email_password"))
+	viper.BindPFlag("email.subject", rootCmd.Flags().Lookup("email_subject"))
+	viper.BindPFlag("sms.id", rootCmd.Flags().Lookup("sms_id"))
+	viper.BindPFlag("sms.account_sid", rootCmd.Flags().Lookup("sms_account_sid"))
+	viper.BindPFlag("sms.auth_token", rootCmd.Flags().Lookup("sms_auth_token"))
+	viper.BindPFlag("sms.from", rootCmd.Flags().Lookup("sms_from"))
+	viper.BindPFlag("sms.webhook_url", rootCmd.Flags().Lookup("sms_webhook_url"))
+	viper.BindPFlag("sms.base_url", rootCmd.Flags().Lookup("sms_base_url"))
+	viper.BindPFlag("whatsapp.id", rootCmd.Flags().Lookup("whatsapp_id"))
+	viper.BindPFlag("whatsapp.phone_number_id", rootCmd.Flags().Lookup("whatsapp_phone_number_id"))
+	viper.BindPFlag("whatsapp.app_secret", rootCmd.Flags().Lookup("whatsapp_app_secret"))
+	viper.BindPFlag("whatsapp.access_token", rootCmd.Flags().Lookup("whatsapp_access_token"))
+	viper.BindPFlag("whatsapp.verify_token", rootCmd.Flags().Lookup("whatsapp_verify_token"))
+	viper.BindPFlag("generic.id", rootCmd.Flags().Lookup("generic_id"))
+	viper.BindPFlag("generic.secret", rootCmd.Flags().Lookup("generic_secret"))
+	viper.BindPFlag("generic.url", rootCmd.Flags().Lookup("generic_url"))
+	viper.BindPFlag("generic.attempts", rootCmd.Flags().Lookup("generic_attempts"))
+
+	viper.BindPFlag("db.driver", rootCmd.PersistentFlags().Lookup("db_driver"))
+	viper.BindPFlag("db.name", rootCmd.PersistentFlags().Lookup("db_name"))
+	viper.BindPFlag("db.sslmode", rootCmd.PersistentFlags().Lookup("db_sslmode"))
+	viper.BindPFlag("db.path", rootCmd.PersistentFlags().Lookup("db_path"))
+	viper.BindPFlag("db.user", rootCmd.PersistentFlags().Lookup("db_user"))
+	viper.BindPFlag("db.password", rootCmd.PersistentFlags().Lookup("db_password"))
+	viper.BindPFlag("db.host", rootCmd.PersistentFlags().Lookup("db_host"))
+	viper.BindPFlag("db.port", rootCmd.PersistentFlags().Lookup("db_port"))
+	viper.BindPFlag("db.auto_migrate", rootCmd.Flags().Lookup("db_auto_migrate"))
 
-	viper.BindPFlag("db.user", rootCmd.Flags().Lookup("db_user"))
-	viper.BindPFlag("db.password", rootCmd.Flags().Lookup("db_password"))
-	viper.BindPFlag("db.host", rootCmd.Flags().Lookup("db_host"))
-	viper.BindPFlag("db.port", rootCmd.Flags().Lookup("db_port"))
+	viper.BindPFlag("blob.driver", rootCmd.Flags().Lookup("blob_driver"))
+	viper.BindPFlag("blob.path", rootCmd.Flags().Lookup("blob_path"))
+	viper.BindPFlag("blob.timeout", rootCmd.Flags().Lookup("blob_timeout"))
 }
 
 func initConfig() {
@@ -2,101 +2,250 @@ package cmd
 
 import (
 	"context"
+	"errors"
 	"fmt"
-	"net/http"
+	"log"
+	"net"
 	"time"
 
 	"github.com/gin-gonic/gin"
-	"github.com/line/line-bot-sdk-go/v7/linebot"
-
 	"go.mongodb.org/mongo-driver/mongo"
-	"go.mongodb.org/mongo-driver/mongo/options"
 
+	_blobGridFSRepo "github.com/kunmingliu/messenger/blob/repository/gridfs"
+	_blobLocalRepo "github.com/kunmingliu/messenger/blob/repository/local"
 	"github.com/kunmingliu/messenger/domain"
+	_eventHttpDelivery "github.com/kunmingliu/messenger/event/delivery/http"
+	_eventUsecase "github.com/kunmingliu/messenger/event/usecase"
 	_messageHttpDelivery "github.com/kunmingliu/messenger/message/delivery/http"
-	_messageRepo "github.com/kunmingliu/messenger/message/repository/mongo"
 	_messageUsecase "github.com/kunmingliu/messenger/message/usecase"
+	"github.com/kunmingliu/messenger/provider"
+	_discordProvider "github.com/kunmingliu/messenger/provider/discord"
+	_emailProvider "github.com/kunmingliu/messenger/provider/email"
+	_facebookProvider "github.com/kunmingliu/messenger/provider/facebook"
+	_genericProvider "github.com/kunmingliu/messenger/provider/generic"
+	_lineProvider "github.com/kunmingliu/messenger/provider/line"
+	_slackProvider "github.com/kunmingliu/messenger/provider/slack"
+	_smsProvider "github.com/kunmingliu/messenger/provider/sms"
+	_telegramProvider "github.com/kunmingliu/messenger/provider/telegram"
+	_whatsappProvider "github.com/kunmingliu/messenger/provider/whatsapp"
 )
 
-type LineProvider struct {
-	linebot.Client
+// channels returns the channels of the platform, the one at the top level comes first if it's configured and its id
+// defaults to the name of the platform.
+func channels[T any, PT channelConfig[T]](platform string, c PlatformConfig[T]) []T {
+	var result []T
+	if PT(&c.Channel).configured() {
+		channel := c.Channel
+		if PT(&channel).channel().ID == "" {
+			PT(&channel).channel().ID = platform
+		}
+		result = append(result, channel)
+	}
+	return append(result, c.Channels...)
 }
 
-func (l *LineProvider) ParseRequest(r *http.Request) (msg domain.Message, err error) {
-	events, err := l.Client.ParseRequest(r)
-	if err != nil {
-		return
+func newEmailProvider(channel EmailChannelConfig) (*_emailProvider.EmailProvider, error) {
+	var options []_emailProvider.Option
+	if channel.Username != "" {
+		options = append(options, _emailProvider.WithAuth(channel.Username, channel.Password))
+	}
+	if channel.Subject != "" {
+		options = append(options, _emailProvider.WithSubject(channel.Subject))
 	}
+	return _emailProvider.NewEmailProvider(channel.Address, channel.Relay, options...)
+}
 
-	for _, event := range events {
-		if event.Type == linebot.EventTypeMessage {
-			userID := event.Source.UserID
-			switch message := event.Message.(type) {
-			case *linebot.TextMessage:
-				msg.UserID = userID
-				msg.Message = message.Text
-			}
-		}
+func newSMSProvider(channel SMSChannelConfig) (*_smsProvider.SMSProvider, error) {
+	var options []_smsProvider.Option
+	if channel.WebhookURL != "" {
+		options = append(options, _smsProvider.WithWebhookURL(channel.WebhookURL))
 	}
-	return
+	if channel.BaseURL != "" {
+		options = append(options, _smsProvider.WithBaseURL(channel.BaseURL))
+	}
+	return _smsProvider.NewSMSProvider(channel.AccountSID, channel.AuthToken, channel.From, options...)
 }
 
-func (l *LineProvider) SendMessage(msg string) (err error) {
-	_, err = l.Client.BroadcastMessage(linebot.NewTextMessage(msg)).Do()
-	return
+func newGenericProvider(channel GenericChannelConfig) (*_genericProvider.GenericProvider, error) {
+	var options []_genericProvider.Option
+	if channel.URL != "" {
+		options = append(options, _genericProvider.WithOutboundURL(channel.URL))
+	}
+	if channel.Attempts > 0 {
+		options = append(options, _genericProvider.WithRetry(channel.Attempts, _genericProvider.DefaultBackoff))
+	}
+	return _genericProvider.NewGenericProvider(channel.Secret, options...)
 }
 
-func startServer() {
-	if config.Secret == "" {
-		panic("secret shouldn't be empty")
+// register creates and registers the provider of each channel of the platform.
+func register[T any, PT channelConfig[T]](registry *provider.Registry, platform string, c PlatformConfig[T], newProvider func(channel T) (domain.Provider, error)) error {
+	for _, channel := range channels[T, PT](platform, c) {
+		channelID := PT(&channel).channel().ID
+		p, err := newProvider(channel)
+		if err != nil {
+			return fmt.Errorf("%s channel %s: %w", platform, channelID, err)
+		}
+		if err = registry.Register(channelID, p); err != nil {
+			return fmt.Errorf("%s channel %s: %w", platform, channelID, err)
+		}
 	}
+	return nil
+}
 
-	if config.Token == "" {
-		panic("token shouldn't be empty")
+// newProviderRegistry registers a provider for each channel in the config, the first channel is the default one.
+func newProviderRegistry() (*provider.Registry, error) {
+	registry := provider.NewRegistry()
+	for _, err := range []error{
+		register(registry, "line", config.Line, func(channel LineChannelConfig) (domain.Provider, error) {
+			return _lineProvider.NewLineProvider(channel.Secret, channel.Token)
+		}),
+		register(registry, "telegram", config.Telegram, func(channel TelegramChannelConfig) (domain.Provider, error) {
+			return _telegramProvider.NewTelegramProvider(channel.Token, channel.SecretToken)
+		}),
+		register(registry, "slack", config.Slack, func(channel SlackChannelConfig) (domain.Provider, error) {
+			return _slackProvider.NewSlackProvider(channel.Token, channel.SigningSecret)
+		}),
+		register(registry, "facebook", config.Facebook, func(channel FacebookChannelConfig) (domain.Provider, error) {
+			return _facebookProvider.NewFacebookProvider(channel.AppSecret, channel.AccessToken, channel.VerifyToken)
+		}),
+		register(registry, "discord", config.Discord, func(channel DiscordChannelConfig) (domain.Provider, error) {
+			return _discordProvider.NewDiscordProvider(channel.ApplicationID, channel.PublicKey, channel.Token)
+		}),
+		register(registry, "email", config.Email, func(channel EmailChannelConfig) (domain.Provider, error) {
+			return newEmailProvider(channel)
+		}),
+		register(registry, "sms", config.SMS, func(channel SMSChannelConfig) (domain.Provider, error) {
+			return newSMSProvider(channel)
+		}),
+		register(registry, "whatsapp", config.WhatsApp, func(channel WhatsAppChannelConfig) (domain.Provider, error) {
+			return _whatsappProvider.NewWhatsAppProvider(channel.PhoneNumberID, channel.AppSecret, channel.AccessToken, channel.VerifyToken)
+		}),
+		register(registry, "generic", config.Generic, func(channel GenericChannelConfig) (domain.Provider, error) {
+			return newGenericProvider(channel)
+		}),
+	} {
+		if err != nil {
+			return nil, err
+		}
+	}
+	if registry.DefaultChannel() == "" {
+		return nil, errors.New("no channel is configured")
 	}
+	return registry, nil
+}
 
-	if config.DBConfig.User == "" {
-		panic("db_user shouldn't be empty")
+func newBlobStore(db *mongo.Database) (domain.BlobStore, error) {
+	switch config.BlobConfig.Driver {
+	case "gridfs":
+		if db == nil {
+			return nil, errors.New("gridfs blob driver requires the mongo db driver")
+		}
+		return _blobGridFSRepo.NewGridFSBlobStore(db), nil
+	case "local", "":
+		return _blobLocalRepo.NewLocalBlobStore(config.BlobConfig.Path)
+	default:
+		return nil, fmt.Errorf("unsupported blob driver: %s", config.BlobConfig.Driver)
 	}
+}
 
-	if config.DBConfig.Password == "" {
-		panic("db_password shouldn't be empty")
+func startServer() {
+	if demo {
+		startDemoServer()
+		return
 	}
 
-	bot, err := linebot.New(config.Secret, config.Token)
+	registry, err := newProviderRegistry()
 	if err != nil {
 		panic(err)
 	}
 
-	provider := &LineProvider{
-		*bot,
-	}
-
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancel()
 
-	url := fmt.Sprintf("mongodb://%s:%s@%s:%s", config.User, config.Password, config.Host, config.DBConfig.Port)
-	client, err := mongo.Connect(ctx, options.Client().ApplyURI(url))
+	db, err := openDatabase(ctx)
 	if err != nil {
 		panic(err)
 	}
 
 	defer func() {
-		if err = client.Disconnect(ctx); err != nil {
+		if err = db.close(ctx); err != nil {
 			panic(err)
 		}
 	}()
 
-	db := client.Database("db")
+	if config.DBConfig.AutoMigrate {
+		if err = db.migrator.Up(ctx, db.migrator.Latest()); err != nil {
+			panic(err)
+		}
+	}
+
+	blobStore, err := newBlobStore(db.mongoDB)
+	if err != nil {
+		panic(err)
+	}
+
+	serve(registry, db.messageRepo, db.eventRepo, blobStore)
+}
 
+// serveEmails starts the SMTP servers of the email channels, the messages of the mails received are stored like the
+// ones of webhooks.
+func serveEmails(registry domain.ProviderRegistry, messageUsecase domain.MessageUsecase) error {
+	emails := channels("email", config.Email)
+	if len(emails) > 1 {
+		// the channels can't share the default address
+		for _, channel := range emails {
+			if channel.Listen == "" {
+				return fmt.Errorf("email channel %s: listen is required when more than one email channel is configured", channel.ID)
+			}
+		}
+	}
+	for _, channel := range emails {
+		p, err := registry.Provider(channel.ID)
+		if err != nil {
+			// the channels in the config aren't registered in demo mode
+			continue
+		}
+		emailProvider, ok := p.(*_emailProvider.EmailProvider)
+		if !ok {
+			continue
+		}
+		listen := channel.Listen
+		if listen == "" {
+			listen = ":2525"
+		}
+		l, err := net.Listen("tcp", listen)
+		if err != nil {
+			return fmt.Errorf("email channel %s: %w", channel.ID, err)
+		}
+
+		channelID := channel.ID
+		go func() {
+			err := emailProvider.Serve(l, func(webhook domain.Webhook) error {
+				for i := range webhook.Messages {
+					webhook.Messages[i].ChannelID = channelID
+				}
+				return messageUsecase.InsertMany(context.Background(), webhook.Messages)
+			})
+			log.Printf("smtp server of email channel %s stopped, err:%v", channelID, err)
+		}()
+	}
+	return nil
+}
+
+func serve(registry domain.ProviderRegistry, messageRepo domain.MessageRepository, eventRepo domain.EventRepository, blobStore domain.BlobStore) {
 	e := gin.New()
 	e.Use(gin.Logger())
 	e.Use(gin.Recovery())
 
-	messageRepo := _messageRepo.NewMongoRepository(db)
 	timeoutContext := 5 * time.Second
-	messageUsecase := _messageUsecase.NewMessageUsecase(messageRepo, provider, timeoutContext)
-	_messageHttpDelivery.NewMessageHandler(e, messageUsecase)
+	messageUsecase := _messageUsecase.NewMessageUsecase(messageRepo, registry, blobStore, timeoutContext,
+		_messageUsecase.WithContentTimeout(config.BlobConfig.Timeout))
+	eventUsecase := _eventUsecase.NewEventUsecase(eventRepo, timeoutContext)
+	_messageHttpDelivery.NewMessageHandler(e, messageUsecase, eventUsecase)
+	_eventHttpDelivery.NewEventHandler(e, eventUsecase)
+	if err := serveEmails(registry, messageUsecase); err != nil {
+		panic(err)
+	}
 
 	e.Run(":" + config.ServerConfig.Port)
 }
@@ -0,0 +1,13 @@
+package domain
+
+import (
+	"context"
+	"io"
+)
+
+//go:generate mockgen -destination=../internal/mocks/domain/blob_store_mock.go -package=domain github.com/kunmingliu/messenger/domain BlobStore
+type BlobStore interface {
+	Put(ctx context.Context, key string, r io.Reader) error
+	// Get returns ErrNotFound if there is no blob stored with the key, the caller should close the reader.
+	Get(ctx context.Context, key string) (io.ReadCloser, error)
+}
@@ -0,0 +1,36 @@
+package domain
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"time"
+)
+
+// MessageCursor points at the last message of a page and the next page starts right after it.
+type MessageCursor struct {
+	CreatedAt time.Time `json:"t"`
+	ID        string    `json:"id"`
+}
+
+func NewMessageCursor(m Message) MessageCursor {
+	return MessageCursor{CreatedAt: m.CreatedAt, ID: m.ID}
+}
+
+// String encodes the cursor into an opaque token which is safe to be put in the url.
+func (c MessageCursor) String() string {
+	b, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// ParseMessageCursor decodes the token made by MessageCursor.String, it returns ErrBadParamInput if the token is invalid.
+func ParseMessageCursor(s string) (*MessageCursor, error) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, ErrBadParamInput
+	}
+	var c MessageCursor
+	if err = json.Unmarshal(b, &c); err != nil || c.ID == "" {
+		return nil, ErrBadParamInput
+	}
+	return &c, nil
+}
@@ -0,0 +1,26 @@
+package domain
+
+import (
+	"testing"
+	"time"
+)
+
+func TestMessageCursor(t *testing.T) {
+	now := time.Date(2022, 11, 17, 18, 12, 48, 570000000, time.UTC)
+	cursor := NewMessageCursor(Message{ID: `ObjectID("637679a05803b5a6c9d7e170")`, CreatedAt: now})
+
+	parsed, err := ParseMessageCursor(cursor.String())
+	if err != nil {
+		t.Fatalf("parse cursor failed, err: %v", err)
+	}
+	if parsed.ID != cursor.ID || !parsed.CreatedAt.Equal(cursor.CreatedAt) {
+		t.Errorf("cursor inconsistent, cursor:%+v, expected cursor:%+v", parsed, cursor)
+	}
+
+	for _, token := range []string{"not base64!", "bm90IGpzb24", "e30"} {
+		_, err = ParseMessageCursor(token)
+		if err != ErrBadParamInput {
+			t.Errorf("error inconsistent, token:%v, caught error:%v, expected error:%v", token, err, ErrBadParamInput)
+		}
+	}
+}
@@ -0,0 +1,12 @@
+package domain
+
+import "errors"
+
+var (
+	// ErrNotFound will be returned if the requested item does not exist
+	ErrNotFound = errors.New("your requested item is not found")
+	// ErrBadParamInput will be returned if the given param is not valid
+	ErrBadParamInput = errors.New("given param is not valid")
+	// ErrInvalidSignature will be returned if a webhook request isn't signed by the platform
+	ErrInvalidSignature = errors.New("the signature of the request is invalid")
+)
@@ -0,0 +1,58 @@
+package domain
+
+import (
+	"context"
+	"time"
+)
+
+type EventType string
+
+const (
+	EventTypeFollow       EventType = "follow"
+	EventTypeUnfollow     EventType = "unfollow"
+	EventTypeJoin         EventType = "join"
+	EventTypeLeave        EventType = "leave"
+	EventTypeMemberJoined EventType = "memberJoined"
+	EventTypeMemberLeft   EventType = "memberLeft"
+)
+
+// Event records the lifecycle of the relationship between users, groups and our account.
+type Event struct {
+	ID string `bson:"_id" json:"id"`
+	// ChannelID is the account of the platform the event is received by.
+	ChannelID string `bson:"channel_id" json:"channel_id"`
+	// EventID is the id of the webhook event given by the provider, it keeps redelivered events from being stored twice
+	// in the same channel.
+	EventID   string    `bson:"event_id,omitempty" json:"event_id,omitempty"`
+	CreatedAt time.Time `bson:"created_at" json:"created_at"`
+	Timestamp time.Time `bson:"timestamp" json:"timestamp"`
+	Type      EventType `bson:"type" json:"type"`
+	UserID    string    `bson:"user_id,omitempty" json:"user_id,omitempty"`
+	GroupID   string    `bson:"group_id,omitempty" json:"group_id,omitempty"`
+	RoomID    string    `bson:"room_id,omitempty" json:"room_id,omitempty"`
+	// Members are the users joining or leaving the group, only for memberJoined and memberLeft events.
+	Members []string `bson:"members,omitempty" json:"members,omitempty"`
+}
+
+// EventFilter narrows down the events, the zero value of each field means no restriction.
+type EventFilter struct {
+	ChannelID string
+	Types     []EventType
+	// UserIDs matches both the user triggering the event and the members joining or leaving.
+	UserIDs []string
+	From    *time.Time
+	To      *time.Time
+}
+
+//go:generate mockgen -destination=../internal/mocks/domain/event_repository_mock.go -package=domain github.com/kunmingliu/messenger/domain EventRepository
+type EventRepository interface {
+	// InsertMany ignores the events whose EventID has been stored.
+	InsertMany(ctx context.Context, e []Event) error
+	Fetch(ctx context.Context, filter EventFilter, offset, limit int64) (events *[]Event, totalCount int64, err error)
+}
+
+//go:generate mockgen -destination=../internal/mocks/domain/event_usecase_mock.go -package=domain github.com/kunmingliu/messenger/domain EventUsecase
+type EventUsecase interface {
+	InsertMany(ctx context.Context, e []Event) error
+	Fetch(ctx context.Context, filter EventFilter, offset, limit int64) (events *[]Event, totalCount int64, err error)
+}
@@ -2,34 +2,290 @@ package domain
 
 import (
 	"context"
+	"io"
 	"net/http"
 	"time"
 )
 
+type MessageType string
+
+const (
+	MessageTypeText     MessageType = "text"
+	MessageTypeImage    MessageType = "image"
+	MessageTypeVideo    MessageType = "video"
+	MessageTypeAudio    MessageType = "audio"
+	MessageTypeFile     MessageType = "file"
+	MessageTypeSticker  MessageType = "sticker"
+	MessageTypeLocation MessageType = "location"
+	MessageTypePostback MessageType = "postback"
+	MessageTypeTemplate MessageType = "template"
+)
+
+type Direction string
+
+const (
+	DirectionInbound  Direction = "inbound"
+	DirectionOutbound Direction = "outbound"
+)
+
+// DeliveryStatus is the progress of sending an outbound message to the provider. Delivered and read are only reported
+// by the providers implementing TrackingProvider.
+type DeliveryStatus string
+
+const (
+	DeliveryStatusPending   DeliveryStatus = "pending"
+	DeliveryStatusSent      DeliveryStatus = "sent"
+	DeliveryStatusDelivered DeliveryStatus = "delivered"
+	DeliveryStatusRead      DeliveryStatus = "read"
+	DeliveryStatusFailed    DeliveryStatus = "failed"
+)
+
+type Sticker struct {
+	PackageID string `bson:"package_id" json:"package_id"`
+	StickerID string `bson:"sticker_id" json:"sticker_id"`
+}
+
+type Location struct {
+	Title     string  `bson:"title" json:"title"`
+	Address   string  `bson:"address" json:"address"`
+	Latitude  float64 `bson:"latitude" json:"latitude"`
+	Longitude float64 `bson:"longitude" json:"longitude"`
+}
+
+type File struct {
+	Name string `bson:"name" json:"name"`
+	Size int    `bson:"size" json:"size"`
+}
+
+// Media refers to binary content kept by the provider, e.g. images, videos, audios and files.
+type Media struct {
+	ContentID string `bson:"content_id" json:"content_id"`
+	// BlobKey refers to the content saved in BlobStore, it's empty if the content hasn't been downloaded.
+	BlobKey     string `bson:"blob_key,omitempty" json:"blob_key,omitempty"`
+	ContentType string `bson:"content_type,omitempty" json:"content_type,omitempty"`
+	// Duration is in milliseconds and only available for videos and audios.
+	Duration int `bson:"duration,omitempty" json:"duration,omitempty"`
+}
+
+// PostbackParams is the value selected by the user with the datetime picker or rich menu switch action.
+type PostbackParams struct {
+	Date               string `bson:"date,omitempty" json:"date,omitempty"`
+	Time               string `bson:"time,omitempty" json:"time,omitempty"`
+	Datetime           string `bson:"datetime,omitempty" json:"datetime,omitempty"`
+	NewRichMenuAliasID string `bson:"new_rich_menu_alias_id,omitempty" json:"new_rich_menu_alias_id,omitempty"`
+	Status             string `bson:"status,omitempty" json:"status,omitempty"`
+}
+
+// PostbackOutcome is what the PostbackHandler matching the postback data returned.
+type PostbackOutcome struct {
+	Prefix string `bson:"prefix" json:"prefix"`
+	Result string `bson:"result,omitempty" json:"result,omitempty"`
+	Error  string `bson:"error,omitempty" json:"error,omitempty"`
+}
+
+type Postback struct {
+	Data   string          `bson:"data" json:"data"`
+	Params *PostbackParams `bson:"params,omitempty" json:"params,omitempty"`
+	// Outcome is absent if there is no handler registered for the data.
+	Outcome *PostbackOutcome `bson:"outcome,omitempty" json:"outcome,omitempty"`
+}
+
+// PostbackHandler handles the postback messages whose data starts with the prefix it's registered with.
+type PostbackHandler func(ctx context.Context, msg Message) (result string, err error)
+
+// Template is a message approved by the platform in advance, it can be sent to the users who haven't talked to the
+// channel recently. Parameters fill the placeholders of the body in order.
+type Template struct {
+	Name       string   `bson:"name" json:"name"`
+	Language   string   `bson:"language" json:"language"`
+	Parameters []string `bson:"parameters,omitempty" json:"parameters,omitempty"`
+}
+
+// Payload holds the data of non-text messages and only the field matching the message type is set.
+type Payload struct {
+	Sticker  *Sticker  `bson:"sticker,omitempty" json:"sticker,omitempty"`
+	Location *Location `bson:"location,omitempty" json:"location,omitempty"`
+	File     *File     `bson:"file,omitempty" json:"file,omitempty"`
+	Media    *Media    `bson:"media,omitempty" json:"media,omitempty"`
+	Postback *Postback `bson:"postback,omitempty" json:"postback,omitempty"`
+	Template *Template `bson:"template,omitempty" json:"template,omitempty"`
+}
+
 type Message struct {
-	ID        string     `bson:"_id" json:"id"`
+	ID string `bson:"_id" json:"id"`
+	// ChannelID is the account of the platform the message is received by or sent from.
+	ChannelID string `bson:"channel_id" json:"channel_id"`
+	// EventID is the id of the webhook event given by the provider, it keeps redelivered events from being stored twice
+	// in the same channel.
+	EventID   string     `bson:"event_id,omitempty" json:"event_id,omitempty"`
 	CreatedAt time.Time  `bson:"created_at" json:"created_at"`
 	UpdatedAt *time.Time `bson:"updated_at" json:"updated_at"`
-	UserID    string     `bson:"user_id" json:"user_id" validate:"required"`
-	Message   string     `bson:"message" json:"message" validate:"required"`
+	// UserID is the sender of inbound messages or the recipient of outbound messages, it's empty for broadcasts.
+	UserID    string      `bson:"user_id" json:"user_id"`
+	Direction Direction   `bson:"direction" json:"direction"`
+	Type      MessageType `bson:"type" json:"type"`
+	Message   string      `bson:"message" json:"message"`
+	Payload   *Payload    `bson:"payload,omitempty" json:"payload,omitempty"`
+	// ReplyToken allows answering the message directly until ReplyExpiresAt, it's empty if the provider doesn't support it.
+	ReplyToken     string     `bson:"reply_token,omitempty" json:"-"`
+	ReplyExpiresAt *time.Time `bson:"reply_expires_at,omitempty" json:"-"`
+	// Status and Error are only for outbound messages.
+	Status DeliveryStatus `bson:"status,omitempty" json:"status,omitempty"`
+	Error  string         `bson:"error,omitempty" json:"error,omitempty"`
+}
+
+type SortOrder string
+
+const (
+	SortOrderAsc  SortOrder = "asc"
+	SortOrderDesc SortOrder = "desc"
+)
+
+type SearchMode string
+
+const (
+	// SearchModeSubstring matches the messages containing the keyword case-insensitively.
+	SearchModeSubstring SearchMode = "substring"
+	// SearchModeText matches the messages with the full-text index of the database.
+	SearchModeText SearchMode = "text"
+)
+
+// MessageQuery narrows down and orders the messages, the zero value of each filter means no restriction.
+type MessageQuery struct {
+	ChannelID string
+	UserIDs   []string
+	Types     []MessageType
+	// From and To are compared with the created time of messages, both ends are inclusive.
+	From       *time.Time
+	To         *time.Time
+	Search     string
+	SearchMode SearchMode
+	// Sort orders the messages by the created time, the newest comes first by default.
+	Sort SortOrder
+	// After continues from the message the cursor points at, it can't be used with Offset.
+	After  *MessageCursor
+	Offset int64
+	Limit  int64
+	// SkipCount avoids counting the messages matching the query and the total count is always zero.
+	SkipCount bool
+}
+
+// SendResult reports the delivery to a chunk of recipients.
+type SendResult struct {
+	UserIDs []string `json:"user_ids"`
+	Error   string   `json:"error,omitempty"`
 }
 
+// WebhookResponse is what the platform expects in the response of a webhook request.
+type WebhookResponse struct {
+	StatusCode  int
+	ContentType string
+	Body        []byte
+}
+
+// StatusUpdate is the delivery of an outbound message reported by the platform.
+type StatusUpdate struct {
+	// MessageID is the id of the outbound message given to TrackingProvider.PushTracked.
+	MessageID string
+	ChannelID string
+	Status    DeliveryStatus
+	Error     string
+}
+
+// Webhook is the content parsed from a webhook request of the provider.
+type Webhook struct {
+	Messages []Message
+	Events   []Event
+	Statuses []StatusUpdate
+	// Response replaces the default response if it's set, e.g. the challenge of a verification request.
+	Response *WebhookResponse
+}
+
+// Provider talks to a single channel, i.e. an account of the platform.
+//
 //go:generate mockgen -destination=../internal/mocks/domain/provider_mock.go -package=domain github.com/kunmingliu/messenger/domain Provider
 type Provider interface {
-	ParseRequest(r *http.Request) (Message, error)
-	SendMessage(msg string) error
+	ParseRequest(r *http.Request) (Webhook, error)
+	SendMessage(ctx context.Context, msg string) error
+	ReplyMessage(ctx context.Context, replyToken, msg string) error
+	PushMessage(ctx context.Context, userID, msg string) error
+	Multicast(ctx context.Context, userIDs []string, msg string) error
+	// GetContent downloads the binary of media messages, the caller should close the reader.
+	GetContent(ctx context.Context, contentID string) (content io.ReadCloser, contentType string, err error)
+}
+
+// TrackingProvider is implemented by the providers which report the delivery of the messages sent with webhooks.
+//
+//go:generate mockgen -destination=../internal/mocks/domain/tracking_provider_mock.go -package=domain github.com/kunmingliu/messenger/domain TrackingProvider
+type TrackingProvider interface {
+	// PushTracked sends the outbound text or template message to msg.UserID, the platform reports its delivery in the
+	// Statuses of the webhooks with msg.ID.
+	PushTracked(ctx context.Context, msg Message) error
+}
+
+// TemplateSender is implemented by the tracking providers which tell whether PushTracked can send templates.
+type TemplateSender interface {
+	CanSendTemplates() bool
+}
+
+// OutboundSender is implemented by the providers which send every outbound message with the id it's stored with, so
+// the receiver can tell them apart. The usecase sends through it instead of SendMessage, PushMessage and Multicast.
+//
+//go:generate mockgen -destination=../internal/mocks/domain/outbound_sender_mock.go -package=domain github.com/kunmingliu/messenger/domain OutboundSender
+type OutboundSender interface {
+	// SendOutbound sends the outbound text message to msg.UserID, or broadcasts it if msg.UserID is empty.
+	SendOutbound(ctx context.Context, msg Message) error
+}
+
+// Broadcaster is implemented by the providers which tell whether SendMessage reaches anyone, the ones which can only
+// message the conversations the account is in report false so a broadcast fails before it's recorded.
+type Broadcaster interface {
+	CanBroadcast() bool
+}
+
+// ProviderRegistry looks up the provider of each channel.
+//
+//go:generate mockgen -destination=../internal/mocks/domain/provider_registry_mock.go -package=domain github.com/kunmingliu/messenger/domain ProviderRegistry
+type ProviderRegistry interface {
+	// Provider returns the provider of the channel, it returns ErrNotFound if the channel isn't registered.
+	Provider(channelID string) (Provider, error)
+	// DefaultChannel is the channel used if a request doesn't specify one, it's empty if there is no channel.
+	DefaultChannel() string
 }
 
 //go:generate mockgen -destination=../internal/mocks/domain/repository_mock.go -package=domain github.com/kunmingliu/messenger/domain MessageRepository
 type MessageRepository interface {
 	Insert(ctx context.Context, m *Message) error
-	GetByUserID(ctx context.Context, offset, limit int64, userIds ...string) (messages *[]Message, totalCount int64, err error)
+	// InsertMany ignores the messages whose EventID has been stored.
+	InsertMany(ctx context.Context, m []Message) error
+	GetByID(ctx context.Context, id string) (*Message, error)
+	// StoredEventIDs returns the ones of eventIDs which have been stored in the channel.
+	StoredEventIDs(ctx context.Context, channelID string, eventIDs []string) ([]string, error)
+	UpdateStatus(ctx context.Context, ids []string, status DeliveryStatus, errMsg string) error
+	// AdvanceStatus applies the status to the outbound message of the channel only if its current status is one of
+	// from. The check and the update are a single operation, so concurrent updates can't move the status back.
+	AdvanceStatus(ctx context.Context, update StatusUpdate, from []DeliveryStatus) error
+	Fetch(ctx context.Context, query MessageQuery) (messages *[]Message, totalCount int64, err error)
 }
 
 //go:generate mockgen -destination=../internal/mocks/domain/usecase_mock.go -package=domain github.com/kunmingliu/messenger/domain MessageUsecase
 type MessageUsecase interface {
 	Insert(ctx context.Context, m *Message) error
-	ParseRequest(r *http.Request) (Message, error)
-	Send(msg string) error
-	GetByUserID(ctx context.Context, offset, limit int64, userIds ...string) (messages *[]Message, totalCount int64, err error)
+	InsertMany(ctx context.Context, m []Message) error
+	// ParseRequest parses the webhook request of the channel, the default channel is used if channelID is empty.
+	ParseRequest(channelID string, r *http.Request) (Webhook, error)
+	// HandlePostback registers the handler for the postback data starting with prefix, the longest prefix wins.
+	HandlePostback(prefix string, h PostbackHandler)
+	// Send broadcasts the message if there is no recipient, otherwise the results of each chunk of recipients are returned.
+	// The message is sent from the default channel if channelID is empty.
+	Send(ctx context.Context, channelID string, msg string, userIDs ...string) (results []SendResult, err error)
+	// SendTemplate sends the template to each user, it fails with ErrBadParamInput if the channel has no templates.
+	SendTemplate(ctx context.Context, channelID string, template Template, userIDs ...string) (results []SendResult, err error)
+	// UpdateStatuses applies the statuses reported by the webhooks, a status never goes back, e.g. from read to delivered.
+	UpdateStatuses(ctx context.Context, statuses []StatusUpdate) error
+	// Reply answers the message with its reply token while it's valid, otherwise the reply is pushed to the user.
+	Reply(ctx context.Context, id string, msg string) error
+	// GetContent returns the stored content of a media message, the caller should close the reader.
+	GetContent(ctx context.Context, id string) (content io.ReadCloser, contentType string, err error)
+	Fetch(ctx context.Context, query MessageQuery) (messages *[]Message, totalCount int64, err error)
 }
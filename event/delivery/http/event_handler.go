@@ -0,0 +1,108 @@
+package http
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/kunmingliu/messenger/domain"
+)
+
+type ResponseError struct {
+	Error string `json:"error"`
+}
+
+type EventHandler struct {
+	EventUsecase domain.EventUsecase
+}
+
+func getStatusCode(err error) int {
+	switch {
+	case errors.Is(err, domain.ErrBadParamInput):
+		return http.StatusBadRequest
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+func NewEventHandler(e *gin.Engine, es domain.EventUsecase) {
+	handler := &EventHandler{
+		EventUsecase: es,
+	}
+
+	e.GET("/events", handler.GetEvents)
+}
+
+func parseTime(c *gin.Context, key string) (*time.Time, error) {
+	value := c.Query(key)
+	if value == "" {
+		return nil, nil
+	}
+	t, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return nil, err
+	}
+	return &t, nil
+}
+
+func (h *EventHandler) GetEvents(c *gin.Context) {
+	var filter domain.EventFilter
+	for _, t := range c.QueryArray("type") {
+		filter.Types = append(filter.Types, domain.EventType(t))
+	}
+	filter.UserIDs = c.QueryArray("user_id")
+	filter.ChannelID = c.Query("channel_id")
+
+	var err error
+	filter.From, err = parseTime(c, "from")
+	if err != nil {
+		c.JSON(http.StatusBadRequest, ResponseError{Error: err.Error()})
+		return
+	}
+	filter.To, err = parseTime(c, "to")
+	if err != nil {
+		c.JSON(http.StatusBadRequest, ResponseError{Error: err.Error()})
+		return
+	}
+
+	offset, err := strconv.Atoi(c.DefaultQuery("offset", "0"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, ResponseError{Error: err.Error()})
+		return
+	}
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", "20"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, ResponseError{Error: err.Error()})
+		return
+	}
+
+	ctx := c.Request.Context()
+	events, totalCount, err := h.EventUsecase.Fetch(ctx, filter, int64(offset), int64(limit))
+	if err != nil {
+		c.JSON(getStatusCode(err), ResponseError{Error: err.Error()})
+		return
+	}
+
+	hasNext := true
+	nextOffset := limit + offset
+	if (int64)(nextOffset) >= totalCount {
+		hasNext = false
+	}
+
+	resp := gin.H{
+		"total_count": totalCount,
+		"offset":      offset,
+		"limit":       limit,
+		"has_next":    hasNext,
+	}
+
+	//return empty array instead
+	if events == nil || len(*events) == 0 {
+		resp["data"] = []interface{}{}
+	} else {
+		resp["data"] = *events
+	}
+	c.JSON(http.StatusOK, resp)
+}
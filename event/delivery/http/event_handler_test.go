@@ -0,0 +1,118 @@
+package http
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang/mock/gomock"
+	"github.com/kunmingliu/messenger/domain"
+	mockDomain "github.com/kunmingliu/messenger/internal/mocks/domain"
+)
+
+func TestEventHandler_GetEvents(t *testing.T) {
+	ctl := gomock.NewController(t)
+	defer ctl.Finish()
+	now := time.Now().UTC()
+	from := time.Date(2022, 11, 1, 0, 0, 0, 0, time.UTC)
+	to := time.Date(2022, 11, 30, 0, 0, 0, 0, time.UTC)
+
+	fakeEvents := []domain.Event{
+		{
+			ID:        "1",
+			Type:      domain.EventTypeFollow,
+			UserID:    "user1",
+			Timestamp: now,
+			CreatedAt: now,
+		},
+		{
+			ID:        "2",
+			Type:      domain.EventTypeMemberJoined,
+			GroupID:   "group1",
+			Members:   []string{"user1"},
+			Timestamp: now,
+			CreatedAt: now,
+		},
+	}
+	filter := domain.EventFilter{
+		ChannelID: "line",
+		Types:     []domain.EventType{domain.EventTypeFollow, domain.EventTypeMemberJoined},
+		UserIDs:   []string{"user1"},
+		From:      &from,
+		To:        &to,
+	}
+	fakeError := errors.New("fake error")
+	mockUsecase := mockDomain.NewMockEventUsecase(ctl)
+	gomock.InOrder(
+		mockUsecase.EXPECT().Fetch(gomock.Any(), filter, int64(0), int64(20)).Return(&fakeEvents, int64(len(fakeEvents)), nil),
+		mockUsecase.EXPECT().Fetch(gomock.Any(), domain.EventFilter{}, int64(0), int64(20)).Return(nil, int64(0), fakeError),
+	)
+
+	e := gin.New()
+	NewEventHandler(e, mockUsecase)
+
+	cases := []struct {
+		name     string
+		query    string
+		success  bool
+		httpCode int
+		err      string
+	}{
+		{
+			name:     "get success",
+			query:    "?channel_id=line&type=follow&type=memberJoined&user_id=user1&from=2022-11-01T00:00:00Z&to=2022-11-30T00:00:00Z",
+			success:  true,
+			httpCode: http.StatusOK,
+		},
+		{
+			name:     "get failed because of internal error",
+			success:  false,
+			httpCode: http.StatusInternalServerError,
+			err:      fakeError.Error(),
+		},
+		{
+			name:     "get failed because time is invalid",
+			query:    "?from=yesterday",
+			success:  false,
+			httpCode: http.StatusBadRequest,
+			err:      `parsing time "yesterday" as "2006-01-02T15:04:05Z07:00": cannot parse "yesterday" as "2006"`,
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			req, _ := http.NewRequest("GET", "/events"+c.query, nil)
+			w := httptest.NewRecorder()
+			e.ServeHTTP(w, req)
+
+			if w.Code != c.httpCode {
+				t.Errorf("code inconsistent, code:%v, expected code:%v", w.Code, c.httpCode)
+			}
+			var response map[string]interface{}
+			json.Unmarshal(w.Body.Bytes(), &response)
+			if !c.success {
+				if response["error"] != c.err {
+					t.Errorf("response inconsistent, response:%v, expected response:%v", response["error"], c.err)
+				}
+				return
+			}
+
+			totalCount := response["total_count"].(float64)
+			if totalCount != float64(len(fakeEvents)) {
+				t.Errorf("total count inconsistent, count:%v, expected count:%v", totalCount, len(fakeEvents))
+			}
+
+			var events []domain.Event
+			b, _ := json.Marshal(response["data"])
+			json.Unmarshal(b, &events)
+			if !reflect.DeepEqual(events, fakeEvents) {
+				t.Errorf("data inconsistent, events:%v, expected events:%v", events, fakeEvents)
+			}
+		})
+	}
+}
@@ -0,0 +1,124 @@
+package memory
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/kunmingliu/messenger/domain"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// memoryRepository keeps the events in memory, it's for tests and the demo mode only since nothing is persisted.
+type memoryRepository struct {
+	mu     sync.RWMutex
+	events []domain.Event
+	// eventIDs are the event ids which have been stored
+	eventIDs map[eventKey]bool
+}
+
+// eventKey identifies a webhook event, the event ids are only unique in a channel.
+type eventKey struct {
+	channelID string
+	eventID   string
+}
+
+func NewMemoryRepository() domain.EventRepository {
+	return &memoryRepository{eventIDs: map[eventKey]bool{}}
+}
+
+func clone(e domain.Event) domain.Event {
+	if e.Members != nil {
+		e.Members = append([]string{}, e.Members...)
+	}
+	return e
+}
+
+func (m *memoryRepository) InsertMany(ctx context.Context, events []domain.Event) error {
+	now := time.Now().UTC()
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for i := range events {
+		events[i].ID = primitive.NewObjectID().Hex()
+		events[i].CreatedAt = now
+		if events[i].EventID != "" {
+			key := eventKey{events[i].ChannelID, events[i].EventID}
+			if m.eventIDs[key] {
+				continue
+			}
+			m.eventIDs[key] = true
+		}
+		m.events = append(m.events, clone(events[i]))
+	}
+	return nil
+}
+
+func containsAny(values []string, targets []string) bool {
+	for _, v := range values {
+		for _, t := range targets {
+			if v == t {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// match reports whether the event satisfies every field of the filter.
+func match(e domain.Event, f domain.EventFilter) bool {
+	if f.ChannelID != "" && e.ChannelID != f.ChannelID {
+		return false
+	}
+	if len(f.Types) > 0 {
+		found := false
+		for _, t := range f.Types {
+			if t == e.Type {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	if len(f.UserIDs) > 0 && !containsAny([]string{e.UserID}, f.UserIDs) && !containsAny(e.Members, f.UserIDs) {
+		return false
+	}
+	if f.From != nil && e.Timestamp.Before(*f.From) {
+		return false
+	}
+	if f.To != nil && e.Timestamp.After(*f.To) {
+		return false
+	}
+	return true
+}
+
+func (m *memoryRepository) Fetch(ctx context.Context, f domain.EventFilter, offset, limit int64) (*[]domain.Event, int64, error) {
+	m.mu.RLock()
+	var matched []domain.Event
+	for _, e := range m.events {
+		if match(e, f) {
+			matched = append(matched, clone(e))
+		}
+	}
+	m.mu.RUnlock()
+
+	// the latest comes first
+	sort.SliceStable(matched, func(i, j int) bool {
+		return matched[i].Timestamp.After(matched[j].Timestamp)
+	})
+
+	totalCount := int64(len(matched))
+	events := matched
+	if offset >= int64(len(events)) {
+		events = nil
+	} else {
+		events = events[offset:]
+	}
+	if limit > 0 && limit < int64(len(events)) {
+		events = events[:limit]
+	}
+	return &events, totalCount, nil
+}
@@ -0,0 +1,14 @@
+package memory
+
+import (
+	"testing"
+
+	"github.com/kunmingliu/messenger/domain"
+	"github.com/kunmingliu/messenger/event/repository/testsuite"
+)
+
+func Test_memoryRepository_suite(t *testing.T) {
+	testsuite.Run(t, func(t *testing.T) domain.EventRepository {
+		return NewMemoryRepository()
+	})
+}
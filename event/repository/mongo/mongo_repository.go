@@ -0,0 +1,99 @@
+package mongo
+
+import (
+	"context"
+	"time"
+
+	"github.com/kunmingliu/messenger/domain"
+	"github.com/kunmingliu/messenger/internal/mongodb"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+type mongoRepository struct {
+	DB         *mongo.Database
+	Collection *mongo.Collection
+}
+
+const (
+	collectionName = "events"
+)
+
+func NewMongoRepository(DB *mongo.Database) domain.EventRepository {
+	return &mongoRepository{DB, DB.Collection(collectionName)}
+}
+
+func (m *mongoRepository) InsertMany(ctx context.Context, events []domain.Event) error {
+	if len(events) == 0 {
+		return nil
+	}
+	now := time.Now().UTC()
+	models := make([]mongo.WriteModel, len(events))
+	for i := range events {
+		events[i].ID = primitive.NewObjectID().Hex()
+		events[i].CreatedAt = now
+		models[i] = mongodb.EventWriteModel(events[i].ChannelID, events[i].EventID, events[i])
+	}
+	_, err := m.Collection.BulkWrite(ctx, models, options.BulkWrite().SetOrdered(false))
+	if err != nil && !mongodb.IsDuplicateOnly(err) {
+		return err
+	}
+	return nil
+}
+
+func buildFilter(f domain.EventFilter) bson.D {
+	filter := bson.D{}
+
+	if f.ChannelID != "" {
+		filter = append(filter, bson.E{Key: "channel_id", Value: f.ChannelID})
+	}
+
+	if len(f.Types) > 0 {
+		filter = append(filter, bson.E{Key: "type", Value: bson.D{{Key: "$in", Value: f.Types}}})
+	}
+
+	if len(f.UserIDs) > 0 {
+		filter = append(filter, bson.E{Key: "$or", Value: bson.A{
+			bson.D{{Key: "user_id", Value: bson.D{{Key: "$in", Value: f.UserIDs}}}},
+			bson.D{{Key: "members", Value: bson.D{{Key: "$in", Value: f.UserIDs}}}},
+		}})
+	}
+
+	timestamp := bson.D{}
+	if f.From != nil {
+		timestamp = append(timestamp, bson.E{Key: "$gte", Value: *f.From})
+	}
+	if f.To != nil {
+		timestamp = append(timestamp, bson.E{Key: "$lte", Value: *f.To})
+	}
+	if len(timestamp) > 0 {
+		filter = append(filter, bson.E{Key: "timestamp", Value: timestamp})
+	}
+	return filter
+}
+
+func (m *mongoRepository) Fetch(ctx context.Context, f domain.EventFilter, offset, limit int64) (*[]domain.Event, int64, error) {
+	filter := buildFilter(f)
+
+	findOptions := options.Find()
+	findOptions.SetSort(bson.D{{Key: "timestamp", Value: -1}})
+	findOptions.SetSkip(offset)
+	findOptions.SetLimit(limit)
+
+	totalCount, err := m.Collection.CountDocuments(ctx, filter)
+	if err != nil {
+		return nil, 0, err
+	}
+	cursor, err := m.Collection.Find(ctx, filter, findOptions)
+	if err != nil {
+		return nil, 0, err
+	}
+	var events []domain.Event
+	err = cursor.All(ctx, &events)
+	if err != nil {
+		return nil, 0, err
+	}
+	return &events, totalCount, nil
+}
@@ -0,0 +1,170 @@
+package mongo
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/kunmingliu/messenger/domain"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/integration/mtest"
+)
+
+func Test_buildFilter(t *testing.T) {
+	from := time.Date(2022, 11, 1, 0, 0, 0, 0, time.UTC)
+	to := time.Date(2022, 11, 30, 0, 0, 0, 0, time.UTC)
+
+	cases := []struct {
+		name     string
+		filter   domain.EventFilter
+		expected bson.D
+	}{
+		{
+			name:     "empty filter",
+			filter:   domain.EventFilter{},
+			expected: bson.D{},
+		},
+		{
+			name: "filter by types and users",
+			filter: domain.EventFilter{
+				Types:   []domain.EventType{domain.EventTypeFollow, domain.EventTypeUnfollow},
+				UserIDs: []string{"user1", "user2"},
+			},
+			expected: bson.D{
+				{Key: "type", Value: bson.D{{Key: "$in", Value: []domain.EventType{domain.EventTypeFollow, domain.EventTypeUnfollow}}}},
+				{Key: "$or", Value: bson.A{
+					bson.D{{Key: "user_id", Value: bson.D{{Key: "$in", Value: []string{"user1", "user2"}}}}},
+					bson.D{{Key: "members", Value: bson.D{{Key: "$in", Value: []string{"user1", "user2"}}}}},
+				}},
+			},
+		},
+		{
+			name:   "filter by time range",
+			filter: domain.EventFilter{From: &from, To: &to},
+			expected: bson.D{
+				{Key: "timestamp", Value: bson.D{{Key: "$gte", Value: from}, {Key: "$lte", Value: to}}},
+			},
+		},
+		{
+			name:   "filter by start time only",
+			filter: domain.EventFilter{From: &from},
+			expected: bson.D{
+				{Key: "timestamp", Value: bson.D{{Key: "$gte", Value: from}}},
+			},
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			got := buildFilter(c.filter)
+			if !reflect.DeepEqual(got, c.expected) {
+				t.Errorf("filter inconsistent, filter:%v, expected filter:%v", got, c.expected)
+			}
+		})
+	}
+}
+
+func Test_mongoRepository_InsertMany(t *testing.T) {
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+	defer mt.Close()
+
+	mt.Run("insert multiple events", func(mt *mtest.T) {
+		events := []domain.Event{
+			{Type: domain.EventTypeFollow, UserID: "user1", Timestamp: time.Now().UTC()},
+			{Type: domain.EventTypeMemberJoined, GroupID: "group1", Members: []string{"user2"}, Timestamp: time.Now().UTC()},
+		}
+		mt.AddMockResponses(mtest.CreateSuccessResponse())
+
+		m := &mongoRepository{
+			DB:         mt.DB,
+			Collection: mt.Coll,
+		}
+		err := m.InsertMany(context.Background(), events)
+		if err != nil {
+			t.Errorf("insert failed, err: %v", err)
+		}
+		for _, e := range events {
+			if e.ID == "" || e.CreatedAt.IsZero() {
+				t.Errorf("id and created time should be assigned, event:%+v", e)
+			}
+		}
+	})
+}
+
+func Test_mongoRepository_InsertMany_idempotent(t *testing.T) {
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+	defer mt.Close()
+
+	mt.Run("upsert events and ignore duplicated ones", func(mt *mtest.T) {
+		mt.AddMockResponses(mtest.CreateWriteErrorsResponse(mtest.WriteError{
+			Index:   0,
+			Code:    11000,
+			Message: "E11000 duplicate key error",
+		}))
+
+		m := &mongoRepository{
+			DB:         mt.DB,
+			Collection: mt.Coll,
+		}
+		err := m.InsertMany(context.Background(), []domain.Event{
+			{EventID: "event1", Type: domain.EventTypeFollow, UserID: "user1"},
+		})
+		if err != nil {
+			t.Errorf("duplicated events should be ignored, err: %v", err)
+		}
+
+		started := mt.GetStartedEvent()
+		if started == nil || started.CommandName != "update" {
+			t.Fatalf("command inconsistent, command:%v, expected command:%v", started, "update")
+		}
+		if upsert := started.Command.Lookup("updates", "0", "upsert").Boolean(); !upsert {
+			t.Errorf("upsert should be enabled")
+		}
+	})
+}
+
+func Test_mongoRepository_Fetch(t *testing.T) {
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+	defer mt.Close()
+
+	mt.Run("Fetch success", func(mt *mtest.T) {
+		now := time.Now().UTC().Truncate(time.Millisecond)
+		event := domain.Event{
+			ID:        primitive.NewObjectID().Hex(),
+			Type:      domain.EventTypeFollow,
+			UserID:    "user1",
+			Timestamp: now,
+			CreatedAt: now,
+		}
+
+		mt.AddMockResponses(
+			mtest.CreateCursorResponse(1, "db.events", mtest.FirstBatch, bson.D{
+				{Key: "n", Value: 1},
+			}),
+			mtest.CreateCursorResponse(0, "db.events", mtest.FirstBatch, bson.D{
+				{Key: "_id", Value: event.ID},
+				{Key: "type", Value: event.Type},
+				{Key: "user_id", Value: event.UserID},
+				{Key: "timestamp", Value: event.Timestamp},
+				{Key: "created_at", Value: event.CreatedAt},
+			}),
+		)
+
+		m := &mongoRepository{
+			DB:         mt.DB,
+			Collection: mt.Coll,
+		}
+		events, totalCount, err := m.Fetch(context.Background(), domain.EventFilter{UserIDs: []string{"user1"}}, 0, 20)
+		if err != nil {
+			t.Fatalf("fetch events failed, err: %v", err)
+		}
+		if totalCount != 1 || events == nil || len(*events) != 1 {
+			t.Fatalf("count inconsistent, total count:%v, events:%v", totalCount, events)
+		}
+		if !reflect.DeepEqual((*events)[0], event) {
+			t.Errorf("data inconsistent, origin:%+v, new:%+v", (*events)[0], event)
+		}
+	})
+}
@@ -0,0 +1,166 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/kunmingliu/messenger/domain"
+	"github.com/lib/pq"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+type postgresRepository struct {
+	DB *sql.DB
+}
+
+const (
+	tableName = "events"
+	columns   = "id, channel_id, event_id, created_at, timestamp, type, user_id, group_id, room_id, members"
+	// insertChunkSize keeps the number of parameters of a statement under the limit of PostgreSQL.
+	insertChunkSize = 1000
+)
+
+func NewPostgresRepository(DB *sql.DB) domain.EventRepository {
+	return &postgresRepository{DB}
+}
+
+func (p *postgresRepository) insert(ctx context.Context, tx *sql.Tx, events []domain.Event) error {
+	var (
+		sb   strings.Builder
+		args []interface{}
+	)
+	sb.WriteString("INSERT INTO " + tableName + " (" + columns + ") VALUES ")
+	for i, e := range events {
+		if i > 0 {
+			sb.WriteString(", ")
+		}
+		n := len(args)
+		fmt.Fprintf(&sb, "($%d, $%d, $%d, $%d, $%d, $%d, $%d, $%d, $%d, $%d)", n+1, n+2, n+3, n+4, n+5, n+6, n+7, n+8, n+9, n+10)
+		members := e.Members
+		if members == nil {
+			members = []string{}
+		}
+		args = append(args, e.ID, e.ChannelID, sql.NullString{String: e.EventID, Valid: e.EventID != ""}, e.CreatedAt, e.Timestamp,
+			e.Type, e.UserID, e.GroupID, e.RoomID, pq.Array(members))
+	}
+	// the event whose event id has been stored in the channel is ignored
+	sb.WriteString(" ON CONFLICT (channel_id, event_id) DO NOTHING")
+	_, err := tx.ExecContext(ctx, sb.String(), args...)
+	return err
+}
+
+func (p *postgresRepository) InsertMany(ctx context.Context, events []domain.Event) error {
+	if len(events) == 0 {
+		return nil
+	}
+	now := time.Now().UTC().Truncate(time.Microsecond)
+	for i := range events {
+		events[i].ID = primitive.NewObjectID().Hex()
+		events[i].CreatedAt = now
+	}
+	tx, err := p.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	for start := 0; start < len(events); start += insertChunkSize {
+		end := start + insertChunkSize
+		if end > len(events) {
+			end = len(events)
+		}
+		if err = p.insert(ctx, tx, events[start:end]); err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// buildWhere translates the filter into the WHERE clause and its arguments, the clause is empty if the filter is empty.
+func buildWhere(f domain.EventFilter) (string, []interface{}) {
+	var (
+		conditions []string
+		args       []interface{}
+	)
+	arg := func(v interface{}) string {
+		args = append(args, v)
+		return fmt.Sprintf("$%d", len(args))
+	}
+
+	if f.ChannelID != "" {
+		conditions = append(conditions, "channel_id = "+arg(f.ChannelID))
+	}
+
+	if len(f.Types) > 0 {
+		types := make([]string, len(f.Types))
+		for i, t := range f.Types {
+			types[i] = string(t)
+		}
+		conditions = append(conditions, "type = ANY("+arg(pq.Array(types))+")")
+	}
+
+	if len(f.UserIDs) > 0 {
+		userIDs := arg(pq.Array(f.UserIDs))
+		conditions = append(conditions, fmt.Sprintf("(user_id = ANY(%s) OR members && %s)", userIDs, userIDs))
+	}
+
+	if f.From != nil {
+		conditions = append(conditions, "timestamp >= "+arg(*f.From))
+	}
+	if f.To != nil {
+		conditions = append(conditions, "timestamp <= "+arg(*f.To))
+	}
+
+	if len(conditions) == 0 {
+		return "", nil
+	}
+	return " WHERE " + strings.Join(conditions, " AND "), args
+}
+
+func (p *postgresRepository) Fetch(ctx context.Context, f domain.EventFilter, offset, limit int64) (*[]domain.Event, int64, error) {
+	where, args := buildWhere(f)
+
+	var totalCount int64
+	err := p.DB.QueryRowContext(ctx, "SELECT COUNT(*) FROM "+tableName+where, args...).Scan(&totalCount)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	query := "SELECT " + columns + " FROM " + tableName + where + " ORDER BY timestamp DESC"
+	if limit > 0 {
+		query += fmt.Sprintf(" LIMIT %d", limit)
+	}
+	if offset > 0 {
+		query += fmt.Sprintf(" OFFSET %d", offset)
+	}
+	rows, err := p.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+	var events []domain.Event
+	for rows.Next() {
+		var (
+			e       domain.Event
+			eventID sql.NullString
+			members pq.StringArray
+		)
+		err = rows.Scan(&e.ID, &e.ChannelID, &eventID, &e.CreatedAt, &e.Timestamp, &e.Type, &e.UserID, &e.GroupID, &e.RoomID, &members)
+		if err != nil {
+			return nil, 0, err
+		}
+		e.EventID = eventID.String
+		e.CreatedAt = e.CreatedAt.UTC()
+		e.Timestamp = e.Timestamp.UTC()
+		if len(members) > 0 {
+			e.Members = members
+		}
+		events = append(events, e)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, 0, err
+	}
+	return &events, totalCount, nil
+}
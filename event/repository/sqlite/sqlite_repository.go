@@ -0,0 +1,181 @@
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/kunmingliu/messenger/domain"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+type sqliteRepository struct {
+	DB *sql.DB
+}
+
+const (
+	tableName = "events"
+	columns   = "id, channel_id, event_id, created_at, timestamp, type, user_id, group_id, room_id, members"
+	// insertChunkSize keeps the number of parameters of a statement under the limit of SQLite.
+	insertChunkSize = 1000
+)
+
+func NewSQLiteRepository(DB *sql.DB) domain.EventRepository {
+	return &sqliteRepository{DB}
+}
+
+// placeholders returns n comma separated placeholders.
+func placeholders(n int) string {
+	return strings.TrimSuffix(strings.Repeat("?, ", n), ", ")
+}
+
+func (s *sqliteRepository) insert(ctx context.Context, tx *sql.Tx, events []domain.Event) error {
+	var (
+		rows []string
+		args []interface{}
+	)
+	for _, e := range events {
+		members := e.Members
+		if members == nil {
+			members = []string{}
+		}
+		// members are stored as a JSON array which is searched with json_each
+		b, err := json.Marshal(members)
+		if err != nil {
+			return err
+		}
+		rows = append(rows, "("+placeholders(10)+")")
+		args = append(args, e.ID, e.ChannelID, sql.NullString{String: e.EventID, Valid: e.EventID != ""}, e.CreatedAt.UnixNano(),
+			e.Timestamp.UnixNano(), string(e.Type), e.UserID, e.GroupID, e.RoomID, string(b))
+	}
+	// the event whose event id has been stored in the channel is ignored
+	query := "INSERT INTO " + tableName + " (" + columns + ") VALUES " + strings.Join(rows, ", ") +
+		" ON CONFLICT (channel_id, event_id) DO NOTHING"
+	_, err := tx.ExecContext(ctx, query, args...)
+	return err
+}
+
+func (s *sqliteRepository) InsertMany(ctx context.Context, events []domain.Event) error {
+	if len(events) == 0 {
+		return nil
+	}
+	now := time.Now().UTC()
+	for i := range events {
+		events[i].ID = primitive.NewObjectID().Hex()
+		events[i].CreatedAt = now
+	}
+	tx, err := s.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	for start := 0; start < len(events); start += insertChunkSize {
+		end := start + insertChunkSize
+		if end > len(events) {
+			end = len(events)
+		}
+		if err = s.insert(ctx, tx, events[start:end]); err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// buildWhere translates the filter into the WHERE clause and its arguments, the clause is empty if the filter is empty.
+func buildWhere(f domain.EventFilter) (string, []interface{}) {
+	var (
+		conditions []string
+		args       []interface{}
+	)
+
+	if f.ChannelID != "" {
+		conditions = append(conditions, "channel_id = ?")
+		args = append(args, f.ChannelID)
+	}
+
+	if len(f.Types) > 0 {
+		conditions = append(conditions, "type IN ("+placeholders(len(f.Types))+")")
+		for _, t := range f.Types {
+			args = append(args, string(t))
+		}
+	}
+
+	if len(f.UserIDs) > 0 {
+		in := placeholders(len(f.UserIDs))
+		conditions = append(conditions, fmt.Sprintf(
+			"(user_id IN (%s) OR EXISTS (SELECT 1 FROM json_each(members) WHERE json_each.value IN (%s)))", in, in))
+		for i := 0; i < 2; i++ {
+			for _, id := range f.UserIDs {
+				args = append(args, id)
+			}
+		}
+	}
+
+	if f.From != nil {
+		conditions = append(conditions, "timestamp >= ?")
+		args = append(args, f.From.UnixNano())
+	}
+	if f.To != nil {
+		conditions = append(conditions, "timestamp <= ?")
+		args = append(args, f.To.UnixNano())
+	}
+
+	if len(conditions) == 0 {
+		return "", nil
+	}
+	return " WHERE " + strings.Join(conditions, " AND "), args
+}
+
+func (s *sqliteRepository) Fetch(ctx context.Context, f domain.EventFilter, offset, limit int64) (*[]domain.Event, int64, error) {
+	where, args := buildWhere(f)
+
+	var totalCount int64
+	err := s.DB.QueryRowContext(ctx, "SELECT COUNT(*) FROM "+tableName+where, args...).Scan(&totalCount)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	query := "SELECT " + columns + " FROM " + tableName + where + " ORDER BY timestamp DESC"
+	// OFFSET of SQLite requires LIMIT, a negative limit means no limit
+	if limit > 0 || offset > 0 {
+		if limit <= 0 {
+			limit = -1
+		}
+		query += fmt.Sprintf(" LIMIT %d OFFSET %d", limit, offset)
+	}
+	rows, err := s.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+	var events []domain.Event
+	for rows.Next() {
+		var (
+			e                    domain.Event
+			eventID              sql.NullString
+			createdAt, timestamp int64
+			members              string
+		)
+		err = rows.Scan(&e.ID, &e.ChannelID, &eventID, &createdAt, &timestamp, &e.Type, &e.UserID, &e.GroupID, &e.RoomID, &members)
+		if err != nil {
+			return nil, 0, err
+		}
+		e.EventID = eventID.String
+		e.CreatedAt = time.Unix(0, createdAt).UTC()
+		e.Timestamp = time.Unix(0, timestamp).UTC()
+		if err = json.Unmarshal([]byte(members), &e.Members); err != nil {
+			return nil, 0, err
+		}
+		if len(e.Members) == 0 {
+			e.Members = nil
+		}
+		events = append(events, e)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, 0, err
+	}
+	return &events, totalCount, nil
+}
@@ -0,0 +1,35 @@
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	"path/filepath"
+	"testing"
+
+	"github.com/kunmingliu/messenger/domain"
+	"github.com/kunmingliu/messenger/event/repository/testsuite"
+	_sqliteMigration "github.com/kunmingliu/messenger/migration/sqlite"
+	_ "modernc.org/sqlite"
+)
+
+// Test_sqliteRepository_suite runs the shared suite against a temporary SQLite database.
+func Test_sqliteRepository_suite(t *testing.T) {
+	ctx := context.Background()
+	db, err := sql.Open("sqlite", filepath.Join(t.TempDir(), "messenger.db")+"?_pragma=busy_timeout(5000)")
+	if err != nil {
+		t.Fatalf("unexpected error:%v", err)
+	}
+	defer db.Close()
+
+	migrator := _sqliteMigration.NewMigrator(db)
+	if err = migrator.Up(ctx, migrator.Latest()); err != nil {
+		t.Fatalf("unexpected error:%v", err)
+	}
+
+	testsuite.Run(t, func(t *testing.T) domain.EventRepository {
+		if _, err := db.ExecContext(ctx, "DELETE FROM "+tableName); err != nil {
+			t.Fatalf("unexpected error:%v", err)
+		}
+		return NewSQLiteRepository(db)
+	})
+}
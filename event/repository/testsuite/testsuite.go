@@ -0,0 +1,159 @@
+// Package testsuite verifies the behavior every implementation of domain.EventRepository should share, it's run by
+// the tests of each backend against a real database.
+package testsuite
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/kunmingliu/messenger/domain"
+)
+
+// Run runs the suite, newRepository should return a repository without any event.
+func Run(t *testing.T, newRepository func(t *testing.T) domain.EventRepository) {
+	t.Run("InsertMany idempotent", func(t *testing.T) { testInsertManyIdempotent(t, newRepository(t)) })
+	t.Run("InsertMany per channel", func(t *testing.T) { testInsertManyPerChannel(t, newRepository(t)) })
+	t.Run("Fetch", func(t *testing.T) { testFetch(t, newRepository(t)) })
+}
+
+func eventIDs(events []domain.Event) []string {
+	result := make([]string, len(events))
+	for i, e := range events {
+		result[i] = e.EventID
+	}
+	return result
+}
+
+func fetch(t *testing.T, repo domain.EventRepository, f domain.EventFilter, offset, limit int64) ([]domain.Event, int64) {
+	t.Helper()
+	events, totalCount, err := repo.Fetch(context.Background(), f, offset, limit)
+	if err != nil {
+		t.Fatalf("Fetch() error = %v", err)
+	}
+	return *events, totalCount
+}
+
+func testInsertManyIdempotent(t *testing.T, repo domain.EventRepository) {
+	ctx := context.Background()
+	now := time.Now().UTC().Truncate(time.Millisecond)
+	if err := repo.InsertMany(ctx, nil); err != nil {
+		t.Fatalf("InsertMany() error = %v", err)
+	}
+	first := []domain.Event{
+		{EventID: "E1", Type: domain.EventTypeFollow, UserID: "U1", Timestamp: now},
+		{EventID: "E2", Type: domain.EventTypeMemberJoined, GroupID: "G1", Members: []string{"U1", "U2"}, Timestamp: now},
+	}
+	if err := repo.InsertMany(ctx, first); err != nil {
+		t.Fatalf("InsertMany() error = %v", err)
+	}
+	redelivered := []domain.Event{
+		{EventID: "E2", Type: domain.EventTypeMemberJoined, GroupID: "G1", Members: []string{"U1", "U2"}, Timestamp: now},
+	}
+	if err := repo.InsertMany(ctx, redelivered); err != nil {
+		t.Fatalf("InsertMany() error = %v", err)
+	}
+
+	events, totalCount := fetch(t, repo, domain.EventFilter{Types: []domain.EventType{domain.EventTypeMemberJoined}}, 0, 0)
+	if totalCount != 1 || len(events) != 1 {
+		t.Fatalf("total count inconsistent, total count:%v, expected total count:%v", totalCount, 1)
+	}
+	got := events[0]
+	if got.ID != first[1].ID || got.GroupID != "G1" || !reflect.DeepEqual(got.Members, first[1].Members) || !got.Timestamp.Equal(now) {
+		t.Errorf("event inconsistent, event:%+v, expected event:%+v", got, first[1])
+	}
+}
+
+func testInsertManyPerChannel(t *testing.T, repo domain.EventRepository) {
+	ctx := context.Background()
+	now := time.Now().UTC().Truncate(time.Millisecond)
+	events := []domain.Event{
+		{ChannelID: "line", EventID: "E1", Type: domain.EventTypeFollow, UserID: "U1", Timestamp: now},
+		{ChannelID: "telegram", EventID: "E1", Type: domain.EventTypeFollow, UserID: "U1", Timestamp: now},
+	}
+	if err := repo.InsertMany(ctx, events); err != nil {
+		t.Fatalf("InsertMany() error = %v", err)
+	}
+	// the event ids are only unique in a channel
+	redelivered := []domain.Event{
+		{ChannelID: "telegram", EventID: "E1", Type: domain.EventTypeFollow, UserID: "U1", Timestamp: now},
+	}
+	if err := repo.InsertMany(ctx, redelivered); err != nil {
+		t.Fatalf("InsertMany() error = %v", err)
+	}
+
+	_, totalCount := fetch(t, repo, domain.EventFilter{}, 0, 0)
+	if totalCount != 2 {
+		t.Errorf("total count inconsistent, total count:%v, expected total count:%v", totalCount, 2)
+	}
+	got, totalCount := fetch(t, repo, domain.EventFilter{ChannelID: "line"}, 0, 0)
+	if totalCount != 1 || len(got) != 1 || got[0].ChannelID != "line" {
+		t.Errorf("events of channel inconsistent, events:%+v, expected channel:%v", got, "line")
+	}
+}
+
+func testFetch(t *testing.T, repo domain.EventRepository) {
+	base := time.Now().UTC().Truncate(time.Second)
+	events := []domain.Event{
+		{EventID: "E1", Type: domain.EventTypeFollow, UserID: "U1", Timestamp: base},
+		{EventID: "E2", Type: domain.EventTypeJoin, GroupID: "G1", Timestamp: base.Add(time.Second)},
+		{EventID: "E3", Type: domain.EventTypeMemberJoined, GroupID: "G1", Members: []string{"U2", "U3"}, Timestamp: base.Add(2 * time.Second)},
+		{EventID: "E4", Type: domain.EventTypeUnfollow, UserID: "U2", Timestamp: base.Add(3 * time.Second)},
+	}
+	if err := repo.InsertMany(context.Background(), events); err != nil {
+		t.Fatalf("InsertMany() error = %v", err)
+	}
+	from, to := base.Add(time.Second), base.Add(2*time.Second)
+
+	tests := []struct {
+		name       string
+		filter     domain.EventFilter
+		offset     int64
+		limit      int64
+		expected   []string
+		totalCount int64
+	}{
+		{
+			name:       "latest first",
+			expected:   []string{"E4", "E3", "E2", "E1"},
+			totalCount: 4,
+		},
+		{
+			name:       "types",
+			filter:     domain.EventFilter{Types: []domain.EventType{domain.EventTypeFollow, domain.EventTypeUnfollow}},
+			expected:   []string{"E4", "E1"},
+			totalCount: 2,
+		},
+		{
+			name:       "users match both user id and members",
+			filter:     domain.EventFilter{UserIDs: []string{"U2"}},
+			expected:   []string{"E4", "E3"},
+			totalCount: 2,
+		},
+		{
+			name:       "time range",
+			filter:     domain.EventFilter{From: &from, To: &to},
+			expected:   []string{"E3", "E2"},
+			totalCount: 2,
+		},
+		{
+			name:       "offset and limit",
+			offset:     1,
+			limit:      2,
+			expected:   []string{"E3", "E2"},
+			totalCount: 4,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, totalCount := fetch(t, repo, tt.filter, tt.offset, tt.limit)
+			if !reflect.DeepEqual(eventIDs(got), tt.expected) {
+				t.Errorf("events inconsistent, events:%v, expected events:%v", eventIDs(got), tt.expected)
+			}
+			if totalCount != tt.totalCount {
+				t.Errorf("total count inconsistent, total count:%v, expected total count:%v", totalCount, tt.totalCount)
+			}
+		})
+	}
+}
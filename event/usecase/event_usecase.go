@@ -0,0 +1,41 @@
+package usecase
+
+import (
+	"context"
+	"time"
+
+	"github.com/kunmingliu/messenger/domain"
+)
+
+type eventUsecase struct {
+	eventRepo      domain.EventRepository
+	contextTimeout time.Duration
+}
+
+func NewEventUsecase(e domain.EventRepository, timeout time.Duration) domain.EventUsecase {
+	return &eventUsecase{
+		eventRepo:      e,
+		contextTimeout: timeout,
+	}
+}
+
+func (e *eventUsecase) InsertMany(c context.Context, events []domain.Event) (err error) {
+	if len(events) == 0 {
+		return
+	}
+	ctx, cancel := context.WithTimeout(c, e.contextTimeout)
+	defer cancel()
+	err = e.eventRepo.InsertMany(ctx, events)
+	return
+}
+
+func (e *eventUsecase) Fetch(c context.Context, filter domain.EventFilter, offset, limit int64) (events *[]domain.Event, totalCount int64, err error) {
+	if filter.From != nil && filter.To != nil && filter.From.After(*filter.To) {
+		err = domain.ErrBadParamInput
+		return
+	}
+	ctx, cancel := context.WithTimeout(c, e.contextTimeout)
+	defer cancel()
+	events, totalCount, err = e.eventRepo.Fetch(ctx, filter, offset, limit)
+	return
+}
@@ -0,0 +1,94 @@
+package usecase
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/golang/mock/gomock"
+	"github.com/kunmingliu/messenger/domain"
+	mockDomain "github.com/kunmingliu/messenger/internal/mocks/domain"
+)
+
+func Test_eventUsecase_InsertMany(t *testing.T) {
+	ctl := gomock.NewController(t)
+	defer ctl.Finish()
+
+	timeout := time.Second * 5
+	backgroundCtx := context.Background()
+
+	mockRepository := mockDomain.NewMockEventRepository(ctl)
+
+	events := []domain.Event{
+		{Type: domain.EventTypeFollow, UserID: "user1"},
+	}
+	fakeError := errors.New("fake error")
+	gomock.InOrder(
+		mockRepository.EXPECT().InsertMany(gomock.Any(), events).Return(nil),
+		mockRepository.EXPECT().InsertMany(gomock.Any(), events).Return(fakeError),
+	)
+
+	usecase := NewEventUsecase(mockRepository, timeout)
+
+	err := usecase.InsertMany(backgroundCtx, events)
+	if err != nil {
+		t.Errorf("unexpected error:%v", err)
+	}
+	err = usecase.InsertMany(backgroundCtx, events)
+	if err == nil || err.Error() != fakeError.Error() {
+		t.Errorf("error inconsistent, caught error:%v, expected error:%v", err, fakeError)
+	}
+	// an empty batch should never reach the repository
+	err = usecase.InsertMany(backgroundCtx, nil)
+	if err != nil {
+		t.Errorf("unexpected error:%v", err)
+	}
+}
+
+func Test_eventUsecase_Fetch(t *testing.T) {
+	ctl := gomock.NewController(t)
+	defer ctl.Finish()
+
+	timeout := time.Second * 5
+	backgroundCtx := context.Background()
+
+	mockRepository := mockDomain.NewMockEventRepository(ctl)
+
+	from := time.Now().UTC().Add(-24 * time.Hour)
+	to := time.Now().UTC()
+	filter := domain.EventFilter{
+		Types: []domain.EventType{domain.EventTypeUnfollow},
+		From:  &from,
+		To:    &to,
+	}
+	fakeEvents := []domain.Event{
+		{Type: domain.EventTypeUnfollow, UserID: "user1"},
+	}
+	fakeError := errors.New("fake error")
+	gomock.InOrder(
+		mockRepository.EXPECT().Fetch(gomock.Any(), filter, int64(0), int64(20)).Return(&fakeEvents, int64(len(fakeEvents)), nil),
+		mockRepository.EXPECT().Fetch(gomock.Any(), filter, int64(0), int64(20)).Return(nil, int64(0), fakeError),
+	)
+
+	usecase := NewEventUsecase(mockRepository, timeout)
+
+	events, totalCount, err := usecase.Fetch(backgroundCtx, filter, 0, 20)
+	if err != nil {
+		t.Errorf("unexpected error:%v", err)
+	}
+	if events == nil || len(*events) != len(fakeEvents) || int(totalCount) != len(fakeEvents) {
+		t.Errorf("data inconsistent, events:%v, expected events:%v", events, fakeEvents)
+	}
+
+	_, _, err = usecase.Fetch(backgroundCtx, filter, 0, 20)
+	if err == nil || err.Error() != fakeError.Error() {
+		t.Errorf("error inconsistent, caught error:%v, expected error:%v", err, fakeError)
+	}
+
+	// the time range is reversed
+	_, _, err = usecase.Fetch(backgroundCtx, domain.EventFilter{From: &to, To: &from}, 0, 20)
+	if err != domain.ErrBadParamInput {
+		t.Errorf("error inconsistent, caught error:%v, expected error:%v", err, domain.ErrBadParamInput)
+	}
+}
@@ -0,0 +1,39 @@
+// Package mongodb has the helpers shared by the repositories on MongoDB.
+package mongodb
+
+import (
+	"errors"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// duplicateKeyCode is the code of the writes rejected by a unique index.
+const duplicateKeyCode = 11000
+
+// EventWriteModel inserts the document directly if it doesn't come from a webhook event, otherwise it's only inserted
+// when no document with the same event id exists in the channel.
+func EventWriteModel(channelID, eventID string, document interface{}) mongo.WriteModel {
+	if eventID == "" {
+		return mongo.NewInsertOneModel().SetDocument(document)
+	}
+	return mongo.NewUpdateOneModel().
+		SetFilter(bson.D{{Key: "channel_id", Value: channelID}, {Key: "event_id", Value: eventID}}).
+		SetUpdate(bson.D{{Key: "$setOnInsert", Value: document}}).
+		SetUpsert(true)
+}
+
+// IsDuplicateOnly reports whether every failed write is rejected by the unique index, which happens when the same
+// event is delivered concurrently and can be ignored.
+func IsDuplicateOnly(err error) bool {
+	var bulkErr mongo.BulkWriteException
+	if !errors.As(err, &bulkErr) || bulkErr.WriteConcernError != nil {
+		return mongo.IsDuplicateKeyError(err)
+	}
+	for _, writeErr := range bulkErr.WriteErrors {
+		if writeErr.Code != duplicateKeyCode {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,99 @@
+// Package providertest has the fakes of the platform APIs and the signing helpers shared by the tests of the providers.
+package providertest
+
+import (
+	"crypto/hmac"
+	"encoding/base64"
+	"encoding/hex"
+	"hash"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+)
+
+// Server is a stand-in of the API of a platform, it answers the requests with the handlers of their paths and 404 to
+// the others. It's closed when the test finishes.
+type Server struct {
+	URL string
+	mux *http.ServeMux
+}
+
+func NewServer(t *testing.T) *Server {
+	s := &Server{mux: http.NewServeMux()}
+	server := httptest.NewServer(s.mux)
+	t.Cleanup(server.Close)
+	s.URL = server.URL
+	return s
+}
+
+// Handle registers the handler of the path, a path ending with a slash handles every path under it.
+func (s *Server) Handle(path string, handler http.HandlerFunc) {
+	s.mux.HandleFunc(path, handler)
+}
+
+// ClosedURL returns the url of a server which is already closed, so the requests to it fail with a network error.
+func ClosedURL() string {
+	server := httptest.NewServer(http.NotFoundHandler())
+	server.Close()
+	return server.URL
+}
+
+// Recorder keeps what the handlers of a fake accept in order, the handlers may run concurrently.
+type Recorder[T any] struct {
+	mu    sync.Mutex
+	items []T
+}
+
+func (r *Recorder[T]) Add(item T) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.items = append(r.items, item)
+}
+
+func (r *Recorder[T]) Items() []T {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]T(nil), r.items...)
+}
+
+// WriteJSON answers with the status and the JSON body.
+func WriteJSON(w http.ResponseWriter, status int, body string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write([]byte(body))
+}
+
+// Content serves the content of a media with its type.
+func Content(contentType, content string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", contentType)
+		w.Write([]byte(content))
+	}
+}
+
+// RequireHeader answers the JSON error with the status unless the header of the request is value, and passes it to
+// the handler otherwise.
+func RequireHeader(name, value string, status int, errorBody string, handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get(name) != value {
+			WriteJSON(w, status, errorBody)
+			return
+		}
+		handler(w, r)
+	}
+}
+
+// HexHMAC returns the HMAC of the message with the secret in hex, e.g. HexHMAC(sha256.New, secret, body).
+func HexHMAC(h func() hash.Hash, secret, message string) string {
+	mac := hmac.New(h, []byte(secret))
+	mac.Write([]byte(message))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// Base64HMAC returns the HMAC of the message with the secret in base64.
+func Base64HMAC(h func() hash.Hash, secret, message string) string {
+	mac := hmac.New(h, []byte(secret))
+	mac.Write([]byte(message))
+	return base64.StdEncoding.EncodeToString(mac.Sum(nil))
+}
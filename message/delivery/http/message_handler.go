@@ -1,8 +1,10 @@
 package http
 
 import (
+	"errors"
 	"net/http"
 	"strconv"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/kunmingliu/messenger/domain"
@@ -14,25 +16,102 @@ type ResponseError struct {
 
 type MessageHandler struct {
 	MessageUsecase domain.MessageUsecase
+	EventUsecase   domain.EventUsecase
 }
 
 func success() gin.H {
 	return gin.H{"status": "OK"}
 }
 
-func NewMessageHandler(e *gin.Engine, ms domain.MessageUsecase) {
+func getStatusCode(err error) int {
+	switch {
+	case errors.Is(err, domain.ErrNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, domain.ErrBadParamInput):
+		return http.StatusBadRequest
+	case errors.Is(err, domain.ErrInvalidSignature):
+		return http.StatusUnauthorized
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+func NewMessageHandler(e *gin.Engine, ms domain.MessageUsecase, es domain.EventUsecase) {
 	handler := &MessageHandler{
 		MessageUsecase: ms,
+		EventUsecase:   es,
 	}
 
 	messageGroup := e.Group("/messages")
 	messageGroup.GET("", handler.GetMessages)
 	messageGroup.POST("", handler.PostMessages)
+	messageGroup.GET("/:id/content", handler.GetMessageContent)
+	messageGroup.POST("/:id/reply", handler.ReplyMessage)
 
+	// the webhook without a channel belongs to the default channel, GET is for the platforms verifying the webhook
+	// before subscribing to it
+	e.GET("/webhook", handler.HandleWebhook)
+	e.GET("/webhook/:channel", handler.HandleWebhook)
 	e.POST("/webhook", handler.HandleWebhook)
+	e.POST("/webhook/:channel", handler.HandleWebhook)
 }
 
 func (m *MessageHandler) PostMessages(c *gin.Context) {
+	var body struct {
+		Message string `json:"message"`
+		// Template is sent instead of the message, e.g. to the users who haven't talked to the channel recently.
+		Template  *domain.Template `json:"template"`
+		UserIDs   []string         `json:"user_ids"`
+		ChannelID string           `json:"channel_id"`
+	}
+
+	if err := c.BindJSON(&body); err != nil {
+		c.JSON(http.StatusBadRequest, ResponseError{Error: err.Error()})
+		return
+	}
+	if (body.Message == "") == (body.Template == nil) {
+		c.JSON(http.StatusBadRequest, ResponseError{Error: "either message or template is required"})
+		return
+	}
+
+	ctx := c.Request.Context()
+	var (
+		results []domain.SendResult
+		err     error
+	)
+	if body.Template != nil {
+		results, err = m.MessageUsecase.SendTemplate(ctx, body.ChannelID, *body.Template, body.UserIDs...)
+	} else {
+		results, err = m.MessageUsecase.Send(ctx, body.ChannelID, body.Message, body.UserIDs...)
+	}
+	if err != nil {
+		c.JSON(getStatusCode(err), ResponseError{Error: err.Error()})
+		return
+	}
+	if len(body.UserIDs) == 0 {
+		c.JSON(http.StatusCreated, success())
+		return
+	}
+
+	failed := 0
+	for _, result := range results {
+		if result.Error != "" {
+			failed++
+		}
+	}
+	switch {
+	case failed == 0:
+		resp := success()
+		resp["results"] = results
+		c.JSON(http.StatusCreated, resp)
+	case failed == len(results):
+		c.JSON(http.StatusInternalServerError, gin.H{"error": results[0].Error, "results": results})
+	default:
+		c.JSON(http.StatusMultiStatus, gin.H{"status": "PARTIAL", "results": results})
+	}
+}
+
+func (m *MessageHandler) ReplyMessage(c *gin.Context) {
 	var body struct {
 		Message string `json:"message" binding:"required"`
 	}
@@ -42,16 +121,50 @@ func (m *MessageHandler) PostMessages(c *gin.Context) {
 		return
 	}
 
-	err := m.MessageUsecase.Send(body.Message)
+	ctx := c.Request.Context()
+	err := m.MessageUsecase.Reply(ctx, c.Param("id"), body.Message)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, ResponseError{Error: err.Error()})
+		c.JSON(getStatusCode(err), ResponseError{Error: err.Error()})
 		return
 	}
 	c.JSON(http.StatusCreated, success())
 }
 
+func parseTime(c *gin.Context, key string) (*time.Time, error) {
+	value := c.Query(key)
+	if value == "" {
+		return nil, nil
+	}
+	t, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return nil, err
+	}
+	return &t, nil
+}
+
 func (m *MessageHandler) GetMessages(c *gin.Context) {
-	ids := c.QueryArray("user_id")
+	query := domain.MessageQuery{
+		ChannelID:  c.Query("channel_id"),
+		UserIDs:    c.QueryArray("user_id"),
+		Search:     c.Query("q"),
+		SearchMode: domain.SearchMode(c.Query("search_mode")),
+		Sort:       domain.SortOrder(c.Query("sort")),
+	}
+	for _, t := range c.QueryArray("type") {
+		query.Types = append(query.Types, domain.MessageType(t))
+	}
+
+	var err error
+	query.From, err = parseTime(c, "from")
+	if err != nil {
+		c.JSON(http.StatusBadRequest, ResponseError{Error: err.Error()})
+		return
+	}
+	query.To, err = parseTime(c, "to")
+	if err != nil {
+		c.JSON(http.StatusBadRequest, ResponseError{Error: err.Error()})
+		return
+	}
 
 	offset, err := strconv.Atoi(c.DefaultQuery("offset", "0"))
 	if err != nil {
@@ -63,25 +176,63 @@ func (m *MessageHandler) GetMessages(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, ResponseError{Error: err.Error()})
 		return
 	}
+	// the limit is checked before fetching one more message below, which would turn -1 into an unbounded 0
+	if limit < 1 {
+		c.JSON(http.StatusBadRequest, ResponseError{Error: domain.ErrBadParamInput.Error()})
+		return
+	}
+	query.Offset = int64(offset)
+	query.Limit = int64(limit)
+
+	cursor := c.Query("cursor")
+	if cursor != "" {
+		query.After, err = domain.ParseMessageCursor(cursor)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, ResponseError{Error: err.Error()})
+			return
+		}
+	}
+	// counting is skipped by default when paginating with cursors since it scans every matching message
+	count, err := strconv.ParseBool(c.DefaultQuery("count", strconv.FormatBool(cursor == "")))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, ResponseError{Error: err.Error()})
+		return
+	}
+	query.SkipCount = !count
+	// the total count can't tell whether there is a next page after a cursor, so fetch one more message to know it
+	probe := query.SkipCount || query.After != nil
+	if probe {
+		query.Limit++
+	}
 
 	ctx := c.Request.Context()
-	messages, totalCount, err := m.MessageUsecase.GetByUserID(ctx, int64(offset), int64(limit), ids...)
+	messages, totalCount, err := m.MessageUsecase.Fetch(ctx, query)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, ResponseError{Error: err.Error()})
+		c.JSON(getStatusCode(err), ResponseError{Error: err.Error()})
 		return
 	}
 
-	hasNext := true
-	nextOffset := limit + offset
-	if (int64)(nextOffset) >= totalCount {
-		hasNext = false
+	var hasNext bool
+	if probe {
+		hasNext = messages != nil && len(*messages) > limit
+		if hasNext {
+			*messages = (*messages)[:limit]
+		}
+	} else {
+		hasNext = int64(limit+offset) < totalCount
 	}
 
 	resp := gin.H{
-		"total_count": totalCount,
-		"offset":      offset,
-		"limit":       limit,
-		"has_next":    hasNext,
+		"offset":   offset,
+		"limit":    limit,
+		"has_next": hasNext,
+	}
+	if count {
+		resp["total_count"] = totalCount
+	}
+	if hasNext && messages != nil && len(*messages) > 0 {
+		last := (*messages)[len(*messages)-1]
+		resp["next_cursor"] = domain.NewMessageCursor(last).String()
 	}
 
 	//return empty array instead
@@ -93,17 +244,47 @@ func (m *MessageHandler) GetMessages(c *gin.Context) {
 	c.JSON(http.StatusOK, resp)
 }
 
+func (m *MessageHandler) GetMessageContent(c *gin.Context) {
+	ctx := c.Request.Context()
+	content, contentType, err := m.MessageUsecase.GetContent(ctx, c.Param("id"))
+	if err != nil {
+		c.JSON(getStatusCode(err), ResponseError{Error: err.Error()})
+		return
+	}
+	defer content.Close()
+
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+	c.DataFromReader(http.StatusOK, -1, contentType, content, nil)
+}
+
 func (m *MessageHandler) HandleWebhook(c *gin.Context) {
-	msg, err := m.MessageUsecase.ParseRequest(c.Request)
+	webhook, err := m.MessageUsecase.ParseRequest(c.Param("channel"), c.Request)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, ResponseError{Error: err.Error()})
+		c.JSON(getStatusCode(err), ResponseError{Error: err.Error()})
 		return
 	}
 	ctx := c.Request.Context()
-	err = m.MessageUsecase.Insert(ctx, &msg)
+	err = m.MessageUsecase.InsertMany(ctx, webhook.Messages)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, ResponseError{Error: err.Error()})
 		return
 	}
+	err = m.EventUsecase.InsertMany(ctx, webhook.Events)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, ResponseError{Error: err.Error()})
+		return
+	}
+	if len(webhook.Statuses) > 0 {
+		if err = m.MessageUsecase.UpdateStatuses(ctx, webhook.Statuses); err != nil {
+			c.JSON(http.StatusInternalServerError, ResponseError{Error: err.Error()})
+			return
+		}
+	}
+	if webhook.Response != nil {
+		c.Data(webhook.Response.StatusCode, webhook.Response.ContentType, webhook.Response.Body)
+		return
+	}
 	c.JSON(http.StatusCreated, success())
 }
@@ -4,9 +4,11 @@ import (
 	"bytes"
 	"encoding/json"
 	"errors"
+	"io"
 	"net/http"
 	"net/http/httptest"
 	"reflect"
+	"strings"
 	"testing"
 	"time"
 
@@ -21,7 +23,6 @@ func TestMessageHandler_GetMessages(t *testing.T) {
 	defer ctl.Finish()
 	now := time.Now().UTC()
 
-	userIDs := []string{}
 	fakeMessages := []domain.Message{
 		{
 			ID:        "1",
@@ -36,12 +37,22 @@ func TestMessageHandler_GetMessages(t *testing.T) {
 			CreatedAt: now,
 			UpdatedAt: &now,
 		},
+		{
+			ID:        "3",
+			UserID:    "user 3",
+			Type:      domain.MessageTypeSticker,
+			CreatedAt: now,
+			Payload: &domain.Payload{
+				Sticker: &domain.Sticker{PackageID: "446", StickerID: "1988"},
+			},
+		},
 	}
 	mockUsecase := mockDomain.NewMockMessageUsecase(ctl)
-	mockUsecase.EXPECT().GetByUserID(gomock.Any(), int64(0), int64(20), userIDs).Return(&fakeMessages, int64(len(fakeMessages)), nil)
+	mockEventUsecase := mockDomain.NewMockEventUsecase(ctl)
+	mockUsecase.EXPECT().Fetch(gomock.Any(), domain.MessageQuery{Limit: 20}).Return(&fakeMessages, int64(len(fakeMessages)), nil)
 
 	e := gin.New()
-	NewMessageHandler(e, mockUsecase)
+	NewMessageHandler(e, mockUsecase, mockEventUsecase)
 
 	req, _ := http.NewRequest("GET", "/messages", nil)
 	w := httptest.NewRecorder()
@@ -88,6 +99,152 @@ func TestMessageHandler_GetMessages(t *testing.T) {
 	}
 }
 
+func TestMessageHandler_GetMessagesWithQuery(t *testing.T) {
+	ctl := gomock.NewController(t)
+	defer ctl.Finish()
+
+	from := time.Date(2022, 11, 1, 0, 0, 0, 0, time.UTC)
+	to := time.Date(2022, 11, 30, 0, 0, 0, 0, time.UTC)
+	query := domain.MessageQuery{
+		ChannelID:  "line",
+		UserIDs:    []string{"user1", "user2"},
+		Types:      []domain.MessageType{domain.MessageTypeText, domain.MessageTypeImage},
+		From:       &from,
+		To:         &to,
+		Search:     "hello",
+		SearchMode: domain.SearchModeText,
+		Sort:       domain.SortOrderAsc,
+		Offset:     20,
+		Limit:      10,
+	}
+	mockUsecase := mockDomain.NewMockMessageUsecase(ctl)
+	mockEventUsecase := mockDomain.NewMockEventUsecase(ctl)
+	gomock.InOrder(
+		mockUsecase.EXPECT().Fetch(gomock.Any(), query).Return(nil, int64(0), nil),
+		mockUsecase.EXPECT().Fetch(gomock.Any(), domain.MessageQuery{Sort: "random", Limit: 20}).Return(nil, int64(0), domain.ErrBadParamInput),
+	)
+
+	e := gin.New()
+	NewMessageHandler(e, mockUsecase, mockEventUsecase)
+
+	cases := []struct {
+		name     string
+		query    string
+		httpCode int
+		err      string
+	}{
+		{
+			name:     "get with every query param",
+			query:    "?channel_id=line&user_id=user1&user_id=user2&type=text&type=image&from=2022-11-01T00:00:00Z&to=2022-11-30T00:00:00Z&q=hello&search_mode=text&sort=asc&offset=20&limit=10",
+			httpCode: http.StatusOK,
+		},
+		{
+			name:     "get failed because query is invalid",
+			query:    "?sort=random",
+			httpCode: http.StatusBadRequest,
+			err:      domain.ErrBadParamInput.Error(),
+		},
+		{
+			name:     "get failed because time is invalid",
+			query:    "?to=tomorrow",
+			httpCode: http.StatusBadRequest,
+			err:      `parsing time "tomorrow" as "2006-01-02T15:04:05Z07:00": cannot parse "tomorrow" as "2006"`,
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			req, _ := http.NewRequest("GET", "/messages"+c.query, nil)
+			w := httptest.NewRecorder()
+			e.ServeHTTP(w, req)
+
+			if w.Code != c.httpCode {
+				t.Errorf("code inconsistent, code:%v, expected code:%v", w.Code, c.httpCode)
+			}
+			var response map[string]interface{}
+			json.Unmarshal(w.Body.Bytes(), &response)
+			if c.err != "" && response["error"] != c.err {
+				t.Errorf("response inconsistent, response:%v, expected response:%v", response["error"], c.err)
+			}
+		})
+	}
+}
+
+func TestMessageHandler_GetMessagesWithCursor(t *testing.T) {
+	ctl := gomock.NewController(t)
+	defer ctl.Finish()
+	now := time.Now().UTC().Truncate(time.Millisecond)
+
+	fakeMessages := []domain.Message{
+		{ID: "3", UserID: "user1", Message: "message 3", CreatedAt: now},
+		{ID: "2", UserID: "user1", Message: "message 2", CreatedAt: now},
+		{ID: "1", UserID: "user1", Message: "message 1", CreatedAt: now},
+	}
+	firstPage := fakeMessages
+	secondPage := fakeMessages[2:]
+	cursor := domain.NewMessageCursor(fakeMessages[1])
+
+	mockUsecase := mockDomain.NewMockMessageUsecase(ctl)
+	mockEventUsecase := mockDomain.NewMockEventUsecase(ctl)
+	gomock.InOrder(
+		mockUsecase.EXPECT().Fetch(gomock.Any(), domain.MessageQuery{Limit: 3, SkipCount: true}).Return(&firstPage, int64(0), nil),
+		mockUsecase.EXPECT().Fetch(gomock.Any(), domain.MessageQuery{After: &cursor, Limit: 3, SkipCount: true}).Return(&secondPage, int64(0), nil),
+	)
+
+	e := gin.New()
+	NewMessageHandler(e, mockUsecase, mockEventUsecase)
+
+	type page struct {
+		TotalCount *int64           `json:"total_count"`
+		HasNext    bool             `json:"has_next"`
+		NextCursor string           `json:"next_cursor"`
+		Data       []domain.Message `json:"data"`
+	}
+
+	req, _ := http.NewRequest("GET", "/messages?limit=2&count=false", nil)
+	w := httptest.NewRecorder()
+	e.ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("code inconsistent, code:%v, expected code:%v", w.Code, http.StatusOK)
+	}
+	var first page
+	json.Unmarshal(w.Body.Bytes(), &first)
+	if first.TotalCount != nil {
+		t.Errorf("total count should be absent, total count:%v", *first.TotalCount)
+	}
+	if !first.HasNext || len(first.Data) != 2 || first.NextCursor != cursor.String() {
+		t.Errorf("page inconsistent, page:%+v", first)
+	}
+
+	req, _ = http.NewRequest("GET", "/messages?limit=2&cursor="+first.NextCursor, nil)
+	w = httptest.NewRecorder()
+	e.ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("code inconsistent, code:%v, expected code:%v", w.Code, http.StatusOK)
+	}
+	var second page
+	json.Unmarshal(w.Body.Bytes(), &second)
+	if second.HasNext || len(second.Data) != 1 || second.NextCursor != "" || second.Data[0].ID != "1" {
+		t.Errorf("page inconsistent, page:%+v", second)
+	}
+
+	// the limits out of range never reach the usecase
+	for _, query := range []string{
+		"?cursor=invalid",
+		"?limit=-1&count=false",
+		"?limit=0&count=false",
+		"?limit=-1&cursor=" + cursor.String(),
+		"?limit=0&cursor=" + cursor.String(),
+	} {
+		req, _ = http.NewRequest("GET", "/messages"+query, nil)
+		w = httptest.NewRecorder()
+		e.ServeHTTP(w, req)
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("code inconsistent, query:%v, code:%v, expected code:%v", query, w.Code, http.StatusBadRequest)
+		}
+	}
+}
+
 func TestMessageHandler_PostMessages(t *testing.T) {
 	ctl := gomock.NewController(t)
 	defer ctl.Finish()
@@ -101,43 +258,216 @@ func TestMessageHandler_PostMessages(t *testing.T) {
 		t.FailNow()
 	}
 	invalidBody, err := json.Marshal(map[string]string{})
+	if err != nil {
+		t.FailNow()
+	}
+	userIDs := []string{"user1", "user2"}
+	targetedBody, err := json.Marshal(map[string]interface{}{
+		"message":  fakeMessage,
+		"user_ids": userIDs,
+	})
+	if err != nil {
+		t.FailNow()
+	}
 
+	channelBody, err := json.Marshal(map[string]interface{}{
+		"message":    fakeMessage,
+		"channel_id": "unknown",
+	})
+	if err != nil {
+		t.FailNow()
+	}
+	template := domain.Template{Name: "order_update", Language: "en_US", Parameters: []string{"A123"}}
+	templateBody, err := json.Marshal(map[string]interface{}{
+		"template": template,
+		"user_ids": userIDs,
+	})
+	if err != nil {
+		t.FailNow()
+	}
+	bothBody, err := json.Marshal(map[string]interface{}{
+		"message":  fakeMessage,
+		"template": template,
+		"user_ids": userIDs,
+	})
 	if err != nil {
 		t.FailNow()
 	}
 
 	mockUsecase := mockDomain.NewMockMessageUsecase(ctl)
+	mockEventUsecase := mockDomain.NewMockEventUsecase(ctl)
 	gomock.InOrder(
-		mockUsecase.EXPECT().Send(fakeMessage).Return(nil),
-		mockUsecase.EXPECT().Send(fakeMessage).Return(fakeError),
+		mockUsecase.EXPECT().Send(gomock.Any(), "", fakeMessage).Return(nil, nil),
+		mockUsecase.EXPECT().Send(gomock.Any(), "", fakeMessage).Return(nil, fakeError),
+		mockUsecase.EXPECT().Send(gomock.Any(), "", fakeMessage, userIDs[0], userIDs[1]).Return([]domain.SendResult{
+			{UserIDs: userIDs},
+		}, nil),
+		mockUsecase.EXPECT().Send(gomock.Any(), "", fakeMessage, userIDs[0], userIDs[1]).Return([]domain.SendResult{
+			{UserIDs: userIDs[:1]},
+			{UserIDs: userIDs[1:], Error: fakeError.Error()},
+		}, nil),
+		mockUsecase.EXPECT().Send(gomock.Any(), "", fakeMessage, userIDs[0], userIDs[1]).Return([]domain.SendResult{
+			{UserIDs: userIDs, Error: fakeError.Error()},
+		}, nil),
+		mockUsecase.EXPECT().Send(gomock.Any(), "unknown", fakeMessage).Return(nil, domain.ErrBadParamInput),
+		mockUsecase.EXPECT().SendTemplate(gomock.Any(), "", template, userIDs[0], userIDs[1]).Return([]domain.SendResult{
+			{UserIDs: userIDs},
+		}, nil),
 	)
 
 	e := gin.New()
-	NewMessageHandler(e, mockUsecase)
+	NewMessageHandler(e, mockUsecase, mockEventUsecase)
 
 	cases := []struct {
 		name     string
 		arg      []byte
-		success  bool
+		status   string
 		httpCode int
+		results  int
 		err      string
 	}{
 		{
 			name:     "post success",
 			arg:      validBody,
-			success:  true,
+			status:   "OK",
 			httpCode: http.StatusCreated,
 		},
 		{
 			name:     "post failed because send message failed",
 			arg:      validBody,
-			success:  false,
 			httpCode: http.StatusInternalServerError,
 			err:      fakeError.Error(),
 		},
+		{
+			name:     "post to users success",
+			arg:      targetedBody,
+			status:   "OK",
+			httpCode: http.StatusCreated,
+			results:  1,
+		},
+		{
+			name:     "post to users partially failed",
+			arg:      targetedBody,
+			status:   "PARTIAL",
+			httpCode: http.StatusMultiStatus,
+			results:  2,
+		},
+		{
+			name:     "post to users failed",
+			arg:      targetedBody,
+			httpCode: http.StatusInternalServerError,
+			results:  1,
+			err:      fakeError.Error(),
+		},
+		{
+			name:     "post failed because channel is unknown",
+			arg:      channelBody,
+			httpCode: http.StatusBadRequest,
+			err:      domain.ErrBadParamInput.Error(),
+		},
+		{
+			name:     "post template success",
+			arg:      templateBody,
+			status:   "OK",
+			httpCode: http.StatusCreated,
+			results:  1,
+		},
 		{
 			name:     "post failed because body is invalid",
 			arg:      invalidBody,
+			httpCode: http.StatusBadRequest,
+			err:      "either message or template is required",
+		},
+		{
+			name:     "post failed because both message and template are set",
+			arg:      bothBody,
+			httpCode: http.StatusBadRequest,
+			err:      "either message or template is required",
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			req, _ := http.NewRequest("POST", "/messages", bytes.NewBuffer(c.arg))
+			req.Header.Set("Content-Type", "application/json")
+			w := httptest.NewRecorder()
+			e.ServeHTTP(w, req)
+
+			if w.Code != c.httpCode {
+				t.Errorf("code inconsistent, code:%v, expected code:%v", w.Code, c.httpCode)
+			}
+			var response struct {
+				Status  string              `json:"status"`
+				Error   string              `json:"error"`
+				Results []domain.SendResult `json:"results"`
+			}
+			json.Unmarshal(w.Body.Bytes(), &response)
+			if response.Status != c.status {
+				t.Errorf("response inconsistent, response:%v, expected response:%v", response.Status, c.status)
+			}
+			if response.Error != c.err {
+				t.Errorf("response inconsistent, response:%v, expected response:%v", response.Error, c.err)
+			}
+			if len(response.Results) != c.results {
+				t.Errorf("results inconsistent, length:%v, expected length:%v", len(response.Results), c.results)
+			}
+		})
+	}
+}
+
+func TestMessageHandler_ReplyMessage(t *testing.T) {
+	ctl := gomock.NewController(t)
+	defer ctl.Finish()
+
+	fakeMessage := "fake message"
+	validBody, err := json.Marshal(map[string]string{
+		"message": fakeMessage,
+	})
+	if err != nil {
+		t.FailNow()
+	}
+	invalidBody, err := json.Marshal(map[string]string{})
+	if err != nil {
+		t.FailNow()
+	}
+
+	mockUsecase := mockDomain.NewMockMessageUsecase(ctl)
+	mockEventUsecase := mockDomain.NewMockEventUsecase(ctl)
+	gomock.InOrder(
+		mockUsecase.EXPECT().Reply(gomock.Any(), "1", fakeMessage).Return(nil),
+		mockUsecase.EXPECT().Reply(gomock.Any(), "2", fakeMessage).Return(domain.ErrNotFound),
+	)
+
+	e := gin.New()
+	NewMessageHandler(e, mockUsecase, mockEventUsecase)
+
+	cases := []struct {
+		name     string
+		id       string
+		arg      []byte
+		success  bool
+		httpCode int
+		err      string
+	}{
+		{
+			name:     "reply success",
+			id:       "1",
+			arg:      validBody,
+			success:  true,
+			httpCode: http.StatusCreated,
+		},
+		{
+			name:     "reply failed because message is not found",
+			id:       "2",
+			arg:      validBody,
+			success:  false,
+			httpCode: http.StatusNotFound,
+			err:      domain.ErrNotFound.Error(),
+		},
+		{
+			name:     "reply failed because body is invalid",
+			id:       "1",
+			arg:      invalidBody,
 			success:  false,
 			httpCode: http.StatusBadRequest,
 			err:      "Key: 'Message' Error:Field validation for 'Message' failed on the 'required' tag",
@@ -146,7 +476,7 @@ func TestMessageHandler_PostMessages(t *testing.T) {
 
 	for _, c := range cases {
 		t.Run(c.name, func(t *testing.T) {
-			req, _ := http.NewRequest("POST", "/messages", bytes.NewBuffer(c.arg))
+			req, _ := http.NewRequest("POST", "/messages/"+c.id+"/reply", bytes.NewBuffer(c.arg))
 			req.Header.Set("Content-Type", "application/json")
 			w := httptest.NewRecorder()
 			e.ServeHTTP(w, req)
@@ -175,42 +505,98 @@ func TestMessageHandler_HandleWebhook(t *testing.T) {
 
 	fakeParseError := errors.New("parse failed")
 	fakeInsertError := errors.New("insert failed")
-	fakeMessage := domain.Message{
-		UserID:  "user1",
-		Message: "message1",
+	fakeEvents := []domain.Event{
+		{
+			Type:   domain.EventTypeFollow,
+			UserID: "user3",
+		},
+	}
+	fakeMessages := []domain.Message{
+		{
+			UserID:  "user1",
+			Message: "message1",
+		},
+		{
+			UserID:  "user2",
+			Message: "message2",
+		},
 	}
 	mockUsecase := mockDomain.NewMockMessageUsecase(ctl)
+	mockEventUsecase := mockDomain.NewMockEventUsecase(ctl)
+	fakeWebhook := domain.Webhook{
+		Messages: fakeMessages,
+		Events:   fakeEvents,
+	}
 	gomock.InOrder(
-		mockUsecase.EXPECT().ParseRequest(gomock.All()).Return(fakeMessage, nil),
-		mockUsecase.EXPECT().Insert(gomock.All(), &fakeMessage).Return(nil),
-		mockUsecase.EXPECT().ParseRequest(gomock.All()).Return(fakeMessage, fakeParseError),
-		mockUsecase.EXPECT().ParseRequest(gomock.All()).Return(fakeMessage, nil),
-		mockUsecase.EXPECT().Insert(gomock.All(), &fakeMessage).Return(fakeInsertError),
+		mockUsecase.EXPECT().ParseRequest("", gomock.All()).Return(fakeWebhook, nil),
+		mockUsecase.EXPECT().InsertMany(gomock.All(), fakeMessages).Return(nil),
+		mockEventUsecase.EXPECT().InsertMany(gomock.All(), fakeEvents).Return(nil),
+		mockUsecase.EXPECT().ParseRequest("line2", gomock.All()).Return(fakeWebhook, nil),
+		mockUsecase.EXPECT().InsertMany(gomock.All(), fakeMessages).Return(nil),
+		mockEventUsecase.EXPECT().InsertMany(gomock.All(), fakeEvents).Return(nil),
+		mockUsecase.EXPECT().ParseRequest("unknown", gomock.All()).Return(domain.Webhook{}, domain.ErrNotFound),
+		mockUsecase.EXPECT().ParseRequest("line2", gomock.All()).Return(domain.Webhook{}, domain.ErrInvalidSignature),
+		mockUsecase.EXPECT().ParseRequest("", gomock.All()).Return(domain.Webhook{}, fakeParseError),
+		mockUsecase.EXPECT().ParseRequest("", gomock.All()).Return(fakeWebhook, nil),
+		mockUsecase.EXPECT().InsertMany(gomock.All(), fakeMessages).Return(fakeInsertError),
+		mockUsecase.EXPECT().ParseRequest("", gomock.All()).Return(fakeWebhook, nil),
+		mockUsecase.EXPECT().InsertMany(gomock.All(), fakeMessages).Return(nil),
+		mockEventUsecase.EXPECT().InsertMany(gomock.All(), fakeEvents).Return(fakeInsertError),
 	)
 
 	e := gin.New()
-	NewMessageHandler(e, mockUsecase)
+	NewMessageHandler(e, mockUsecase, mockEventUsecase)
 
 	cases := []struct {
 		name     string
-		arg      []byte
+		path     string
 		success  bool
 		httpCode int
 		err      string
 	}{
 		{
 			name:     "post success",
+			path:     "/webhook",
 			success:  true,
 			httpCode: http.StatusCreated,
 		},
+		{
+			name:     "post to channel success",
+			path:     "/webhook/line2",
+			success:  true,
+			httpCode: http.StatusCreated,
+		},
+		{
+			name:     "post failed because channel is unknown",
+			path:     "/webhook/unknown",
+			success:  false,
+			httpCode: http.StatusNotFound,
+			err:      domain.ErrNotFound.Error(),
+		},
+		{
+			name:     "post failed because signature is invalid",
+			path:     "/webhook/line2",
+			success:  false,
+			httpCode: http.StatusUnauthorized,
+			err:      domain.ErrInvalidSignature.Error(),
+		},
 		{
 			name:     "post failed because parse request failed",
+			path:     "/webhook",
 			success:  false,
 			httpCode: http.StatusInternalServerError,
 			err:      fakeParseError.Error(),
 		},
 		{
 			name:     "post failed because insert failed",
+			path:     "/webhook",
+			success:  false,
+			httpCode: http.StatusInternalServerError,
+			err:      fakeInsertError.Error(),
+		},
+		{
+			name:     "post failed because insert events failed",
+			path:     "/webhook",
 			success:  false,
 			httpCode: http.StatusInternalServerError,
 			err:      fakeInsertError.Error(),
@@ -219,7 +605,7 @@ func TestMessageHandler_HandleWebhook(t *testing.T) {
 
 	for _, c := range cases {
 		t.Run(c.name, func(t *testing.T) {
-			req, _ := http.NewRequest("POST", "/webhook", nil)
+			req, _ := http.NewRequest("POST", c.path, nil)
 			w := httptest.NewRecorder()
 			e.ServeHTTP(w, req)
 
@@ -241,3 +627,168 @@ func TestMessageHandler_HandleWebhook(t *testing.T) {
 		})
 	}
 }
+
+func TestMessageHandler_HandleWebhookWithResponse(t *testing.T) {
+	ctl := gomock.NewController(t)
+	defer ctl.Finish()
+
+	mockUsecase := mockDomain.NewMockMessageUsecase(ctl)
+	mockEventUsecase := mockDomain.NewMockEventUsecase(ctl)
+	fakeWebhook := domain.Webhook{
+		Response: &domain.WebhookResponse{
+			StatusCode:  http.StatusOK,
+			ContentType: "text/plain",
+			Body:        []byte("challenge"),
+		},
+	}
+	mockUsecase.EXPECT().ParseRequest("slack", gomock.All()).Return(fakeWebhook, nil)
+	mockUsecase.EXPECT().ParseRequest("facebook", gomock.All()).Return(fakeWebhook, nil)
+	mockUsecase.EXPECT().InsertMany(gomock.All(), gomock.Nil()).Return(nil).Times(2)
+	mockEventUsecase.EXPECT().InsertMany(gomock.All(), gomock.Nil()).Return(nil).Times(2)
+
+	e := gin.New()
+	NewMessageHandler(e, mockUsecase, mockEventUsecase)
+
+	cases := []struct {
+		name   string
+		method string
+		path   string
+	}{
+		{
+			name:   "challenge of post",
+			method: "POST",
+			path:   "/webhook/slack",
+		},
+		{
+			name:   "challenge of get",
+			method: "GET",
+			path:   "/webhook/facebook?hub.mode=subscribe&hub.challenge=challenge",
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			req, _ := http.NewRequest(c.method, c.path, nil)
+			w := httptest.NewRecorder()
+			e.ServeHTTP(w, req)
+
+			if w.Code != http.StatusOK {
+				t.Errorf("code inconsistent, code:%v, expected code:%v", w.Code, http.StatusOK)
+			}
+			if w.Body.String() != "challenge" || w.Header().Get("Content-Type") != "text/plain" {
+				t.Errorf("response inconsistent, response:%v, content type:%v", w.Body.String(), w.Header().Get("Content-Type"))
+			}
+		})
+	}
+}
+
+func TestMessageHandler_HandleWebhookWithStatuses(t *testing.T) {
+	ctl := gomock.NewController(t)
+	defer ctl.Finish()
+
+	mockUsecase := mockDomain.NewMockMessageUsecase(ctl)
+	mockEventUsecase := mockDomain.NewMockEventUsecase(ctl)
+	fakeStatuses := []domain.StatusUpdate{
+		{MessageID: "1", ChannelID: "whatsapp", Status: domain.DeliveryStatusDelivered},
+	}
+	fakeWebhook := domain.Webhook{Statuses: fakeStatuses}
+	gomock.InOrder(
+		mockUsecase.EXPECT().ParseRequest("whatsapp", gomock.All()).Return(fakeWebhook, nil),
+		mockUsecase.EXPECT().InsertMany(gomock.All(), gomock.Nil()).Return(nil),
+		mockEventUsecase.EXPECT().InsertMany(gomock.All(), gomock.Nil()).Return(nil),
+		mockUsecase.EXPECT().UpdateStatuses(gomock.All(), fakeStatuses).Return(nil),
+		mockUsecase.EXPECT().ParseRequest("whatsapp", gomock.All()).Return(fakeWebhook, nil),
+		mockUsecase.EXPECT().InsertMany(gomock.All(), gomock.Nil()).Return(nil),
+		mockEventUsecase.EXPECT().InsertMany(gomock.All(), gomock.Nil()).Return(nil),
+		mockUsecase.EXPECT().UpdateStatuses(gomock.All(), fakeStatuses).Return(errors.New("update failed")),
+	)
+
+	e := gin.New()
+	NewMessageHandler(e, mockUsecase, mockEventUsecase)
+
+	for _, httpCode := range []int{http.StatusCreated, http.StatusInternalServerError} {
+		req, _ := http.NewRequest("POST", "/webhook/whatsapp", nil)
+		w := httptest.NewRecorder()
+		e.ServeHTTP(w, req)
+
+		if w.Code != httpCode {
+			t.Errorf("code inconsistent, code:%v, expected code:%v", w.Code, httpCode)
+		}
+	}
+}
+
+func TestMessageHandler_GetMessageContent(t *testing.T) {
+	ctl := gomock.NewController(t)
+	defer ctl.Finish()
+
+	fakeError := errors.New("fake error")
+	mockUsecase := mockDomain.NewMockMessageUsecase(ctl)
+	mockEventUsecase := mockDomain.NewMockEventUsecase(ctl)
+	gomock.InOrder(
+		mockUsecase.EXPECT().GetContent(gomock.Any(), "1").Return(io.NopCloser(strings.NewReader("image")), "image/jpeg", nil),
+		mockUsecase.EXPECT().GetContent(gomock.Any(), "2").Return(nil, "", domain.ErrNotFound),
+		mockUsecase.EXPECT().GetContent(gomock.Any(), "3").Return(nil, "", fakeError),
+	)
+
+	e := gin.New()
+	NewMessageHandler(e, mockUsecase, mockEventUsecase)
+
+	cases := []struct {
+		name        string
+		id          string
+		success     bool
+		httpCode    int
+		contentType string
+		body        string
+		err         string
+	}{
+		{
+			name:        "get content success",
+			id:          "1",
+			success:     true,
+			httpCode:    http.StatusOK,
+			contentType: "image/jpeg",
+			body:        "image",
+		},
+		{
+			name:     "get content failed because content is not found",
+			id:       "2",
+			success:  false,
+			httpCode: http.StatusNotFound,
+			err:      domain.ErrNotFound.Error(),
+		},
+		{
+			name:     "get content failed because of internal error",
+			id:       "3",
+			success:  false,
+			httpCode: http.StatusInternalServerError,
+			err:      fakeError.Error(),
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			req, _ := http.NewRequest("GET", "/messages/"+c.id+"/content", nil)
+			w := httptest.NewRecorder()
+			e.ServeHTTP(w, req)
+
+			if w.Code != c.httpCode {
+				t.Errorf("code inconsistent, code:%v, expected code:%v", w.Code, c.httpCode)
+			}
+			if c.success {
+				if w.Header().Get("Content-Type") != c.contentType {
+					t.Errorf("content type inconsistent, type:%v, expected type:%v", w.Header().Get("Content-Type"), c.contentType)
+				}
+				if w.Body.String() != c.body {
+					t.Errorf("body inconsistent, body:%v, expected body:%v", w.Body.String(), c.body)
+				}
+			} else {
+				var response map[string]interface{}
+				json.Unmarshal(w.Body.Bytes(), &response)
+				if response["error"] != c.err {
+					t.Errorf("response inconsistent, response:%v, expected response:%v", response["error"], c.err)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,299 @@
+package memory
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+
+	"github.com/kunmingliu/messenger/domain"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// memoryRepository keeps the messages in memory, it's for tests and the demo mode only since nothing is persisted.
+type memoryRepository struct {
+	mu       sync.RWMutex
+	messages map[string]domain.Message
+	// eventIDs maps the event id to the id of the message
+	eventIDs map[eventKey]string
+}
+
+// eventKey identifies a webhook event, the event ids are only unique in a channel.
+type eventKey struct {
+	channelID string
+	eventID   string
+}
+
+func NewMemoryRepository() domain.MessageRepository {
+	return &memoryRepository{
+		messages: map[string]domain.Message{},
+		eventIDs: map[eventKey]string{},
+	}
+}
+
+func cloneTime(t *time.Time) *time.Time {
+	if t == nil {
+		return nil
+	}
+	result := *t
+	return &result
+}
+
+// clone copies the message deeply so that neither the caller nor the repository can change the other's copy.
+func clone(msg domain.Message) domain.Message {
+	msg.UpdatedAt = cloneTime(msg.UpdatedAt)
+	msg.ReplyExpiresAt = cloneTime(msg.ReplyExpiresAt)
+	if msg.Payload == nil {
+		return msg
+	}
+	payload := *msg.Payload
+	if payload.Sticker != nil {
+		sticker := *payload.Sticker
+		payload.Sticker = &sticker
+	}
+	if payload.Location != nil {
+		location := *payload.Location
+		payload.Location = &location
+	}
+	if payload.File != nil {
+		file := *payload.File
+		payload.File = &file
+	}
+	if payload.Media != nil {
+		media := *payload.Media
+		payload.Media = &media
+	}
+	if payload.Template != nil {
+		template := *payload.Template
+		template.Parameters = append([]string(nil), template.Parameters...)
+		payload.Template = &template
+	}
+	if payload.Postback != nil {
+		postback := *payload.Postback
+		if postback.Params != nil {
+			params := *postback.Params
+			postback.Params = &params
+		}
+		if postback.Outcome != nil {
+			outcome := *postback.Outcome
+			postback.Outcome = &outcome
+		}
+		payload.Postback = &postback
+	}
+	msg.Payload = &payload
+	return msg
+}
+
+// insert stores the message unless its event id has been stored, the caller should hold the lock.
+func (m *memoryRepository) insert(msg domain.Message) {
+	if msg.EventID != "" {
+		key := eventKey{msg.ChannelID, msg.EventID}
+		if _, ok := m.eventIDs[key]; ok {
+			return
+		}
+		m.eventIDs[key] = msg.ID
+	}
+	m.messages[msg.ID] = clone(msg)
+}
+
+func (m *memoryRepository) Insert(ctx context.Context, msg *domain.Message) error {
+	msg.ID = primitive.NewObjectID().Hex()
+	msg.CreatedAt = time.Now().UTC()
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.insert(*msg)
+	return nil
+}
+
+func (m *memoryRepository) InsertMany(ctx context.Context, msgs []domain.Message) error {
+	now := time.Now().UTC()
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for i := range msgs {
+		msgs[i].ID = primitive.NewObjectID().Hex()
+		msgs[i].CreatedAt = now
+		m.insert(msgs[i])
+	}
+	return nil
+}
+
+func (m *memoryRepository) GetByID(ctx context.Context, id string) (*domain.Message, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	msg, ok := m.messages[id]
+	if !ok {
+		return nil, domain.ErrNotFound
+	}
+	msg = clone(msg)
+	return &msg, nil
+}
+
+func (m *memoryRepository) StoredEventIDs(ctx context.Context, channelID string, eventIDs []string) ([]string, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	var stored []string
+	for _, eventID := range eventIDs {
+		if _, ok := m.eventIDs[eventKey{channelID, eventID}]; ok {
+			stored = append(stored, eventID)
+		}
+	}
+	return stored, nil
+}
+
+func (m *memoryRepository) UpdateStatus(ctx context.Context, ids []string, status domain.DeliveryStatus, errMsg string) error {
+	now := time.Now().UTC()
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, id := range ids {
+		msg, ok := m.messages[id]
+		if !ok {
+			continue
+		}
+		msg.Status = status
+		msg.Error = errMsg
+		msg.UpdatedAt = &now
+		m.messages[id] = msg
+	}
+	return nil
+}
+
+func (m *memoryRepository) AdvanceStatus(ctx context.Context, update domain.StatusUpdate, from []domain.DeliveryStatus) error {
+	now := time.Now().UTC()
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	msg, ok := m.messages[update.MessageID]
+	if !ok || msg.ChannelID != update.ChannelID || msg.Direction != domain.DirectionOutbound {
+		return nil
+	}
+	for _, status := range from {
+		if msg.Status == status {
+			msg.Status = update.Status
+			msg.Error = update.Error
+			msg.UpdatedAt = &now
+			m.messages[update.MessageID] = msg
+			return nil
+		}
+	}
+	return nil
+}
+
+// words splits the text into lowercase words for the full-text search.
+func words(s string) []string {
+	return strings.FieldsFunc(strings.ToLower(s), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsNumber(r)
+	})
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// before reports whether a comes before b in the ascending order of created time and id.
+func before(a, b domain.Message) bool {
+	if !a.CreatedAt.Equal(b.CreatedAt) {
+		return a.CreatedAt.Before(b.CreatedAt)
+	}
+	return a.ID < b.ID
+}
+
+// match reports whether the message satisfies every filter of the query.
+func match(msg domain.Message, q domain.MessageQuery) bool {
+	if q.ChannelID != "" && msg.ChannelID != q.ChannelID {
+		return false
+	}
+	if len(q.UserIDs) > 0 && !contains(q.UserIDs, msg.UserID) {
+		return false
+	}
+	if len(q.Types) > 0 {
+		found := false
+		for _, t := range q.Types {
+			if t == msg.Type {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	if q.From != nil && msg.CreatedAt.Before(*q.From) {
+		return false
+	}
+	if q.To != nil && msg.CreatedAt.After(*q.To) {
+		return false
+	}
+	if q.After != nil {
+		// keyset pagination, the messages after the cursor are either created later(or earlier) or share
+		// the same created time with a greater(or smaller) id
+		cursor := domain.Message{ID: q.After.ID, CreatedAt: q.After.CreatedAt}
+		if q.Sort == domain.SortOrderAsc && !before(cursor, msg) {
+			return false
+		}
+		if q.Sort != domain.SortOrderAsc && !before(msg, cursor) {
+			return false
+		}
+	}
+	if q.Search != "" {
+		switch q.SearchMode {
+		case domain.SearchModeText:
+			// every word of the keywords should appear in the message
+			messageWords := words(msg.Message)
+			for _, w := range words(q.Search) {
+				if !contains(messageWords, w) {
+					return false
+				}
+			}
+		default:
+			if !strings.Contains(strings.ToLower(msg.Message), strings.ToLower(q.Search)) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+func (m *memoryRepository) Fetch(ctx context.Context, q domain.MessageQuery) (*[]domain.Message, int64, error) {
+	m.mu.RLock()
+	var matched []domain.Message
+	for _, msg := range m.messages {
+		if match(msg, q) {
+			matched = append(matched, clone(msg))
+		}
+	}
+	m.mu.RUnlock()
+
+	// the newest comes first by default
+	sort.Slice(matched, func(i, j int) bool {
+		if q.Sort == domain.SortOrderAsc {
+			return before(matched[i], matched[j])
+		}
+		return before(matched[j], matched[i])
+	})
+
+	var totalCount int64
+	if !q.SkipCount {
+		totalCount = int64(len(matched))
+	}
+
+	messages := matched
+	if q.Offset >= int64(len(messages)) {
+		messages = nil
+	} else {
+		messages = messages[q.Offset:]
+	}
+	if q.Limit > 0 && q.Limit < int64(len(messages)) {
+		messages = messages[:q.Limit]
+	}
+	return &messages, totalCount, nil
+}
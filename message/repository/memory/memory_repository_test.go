@@ -0,0 +1,79 @@
+package memory
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+
+	"github.com/kunmingliu/messenger/domain"
+	"github.com/kunmingliu/messenger/message/repository/testsuite"
+)
+
+func Test_memoryRepository_suite(t *testing.T) {
+	testsuite.Run(t, func(t *testing.T) domain.MessageRepository {
+		return NewMemoryRepository()
+	})
+}
+
+func Test_memoryRepository_concurrency(t *testing.T) {
+	ctx := context.Background()
+	m := NewMemoryRepository()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 10; j++ {
+				// every event is delivered twice
+				msgs := []domain.Message{
+					{EventID: fmt.Sprintf("E%d-%d", i, j), UserID: "U1"},
+					{EventID: fmt.Sprintf("E%d-%d", i, j), UserID: "U1"},
+				}
+				if err := m.InsertMany(ctx, msgs); err != nil {
+					t.Errorf("unexpected error:%v", err)
+				}
+				if err := m.UpdateStatus(ctx, []string{msgs[0].ID}, domain.DeliveryStatusSent, ""); err != nil {
+					t.Errorf("unexpected error:%v", err)
+				}
+				if _, _, err := m.Fetch(ctx, domain.MessageQuery{Limit: 5}); err != nil {
+					t.Errorf("unexpected error:%v", err)
+				}
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	_, totalCount, err := m.Fetch(ctx, domain.MessageQuery{})
+	if err != nil {
+		t.Fatalf("unexpected error:%v", err)
+	}
+	if totalCount != 100 {
+		t.Errorf("total count inconsistent, total count:%v, expected total count:%v", totalCount, 100)
+	}
+}
+
+func Test_memoryRepository_isolation(t *testing.T) {
+	ctx := context.Background()
+	m := NewMemoryRepository()
+
+	msg := &domain.Message{UserID: "U1", Payload: &domain.Payload{Sticker: &domain.Sticker{PackageID: "1"}}}
+	if err := m.Insert(ctx, msg); err != nil {
+		t.Fatalf("unexpected error:%v", err)
+	}
+	// changing the copy of the caller doesn't affect the stored one
+	msg.Payload.Sticker.PackageID = "2"
+	got, err := m.GetByID(ctx, msg.ID)
+	if err != nil {
+		t.Fatalf("unexpected error:%v", err)
+	}
+	if got.Payload.Sticker.PackageID != "1" {
+		t.Errorf("package id inconsistent, package id:%v, expected package id:%v", got.Payload.Sticker.PackageID, "1")
+	}
+	got.Payload.Sticker.PackageID = "3"
+	got, _ = m.GetByID(ctx, msg.ID)
+	if got.Payload.Sticker.PackageID != "1" {
+		t.Errorf("package id inconsistent, package id:%v, expected package id:%v", got.Payload.Sticker.PackageID, "1")
+	}
+}
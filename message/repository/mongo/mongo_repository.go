@@ -2,9 +2,12 @@ package mongo
 
 import (
 	"context"
+	"errors"
+	"regexp"
 	"time"
 
 	"github.com/kunmingliu/messenger/domain"
+	"github.com/kunmingliu/messenger/internal/mongodb"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
@@ -25,26 +28,188 @@ func NewMongoRepository(DB *mongo.Database) domain.MessageRepository {
 }
 
 func (m *mongoRepository) Insert(ctx context.Context, msg *domain.Message) error {
-	msg.ID = primitive.NewObjectID().String()
+	msg.ID = primitive.NewObjectID().Hex()
 	msg.CreatedAt = time.Now().UTC()
-	_, err := m.Collection.InsertOne(ctx, msg)
+	_, err := m.Collection.BulkWrite(ctx, []mongo.WriteModel{mongodb.EventWriteModel(msg.ChannelID, msg.EventID, *msg)})
+	if err != nil && !mongodb.IsDuplicateOnly(err) {
+		return err
+	}
+	return nil
+}
+
+func (m *mongoRepository) InsertMany(ctx context.Context, msgs []domain.Message) error {
+	if len(msgs) == 0 {
+		return nil
+	}
+	now := time.Now().UTC()
+	models := make([]mongo.WriteModel, len(msgs))
+	for i := range msgs {
+		msgs[i].ID = primitive.NewObjectID().Hex()
+		msgs[i].CreatedAt = now
+		models[i] = mongodb.EventWriteModel(msgs[i].ChannelID, msgs[i].EventID, msgs[i])
+	}
+	_, err := m.Collection.BulkWrite(ctx, models, options.BulkWrite().SetOrdered(false))
+	if err != nil && !mongodb.IsDuplicateOnly(err) {
+		return err
+	}
+	return nil
+}
+
+func (m *mongoRepository) GetByID(ctx context.Context, id string) (*domain.Message, error) {
+	var msg domain.Message
+	err := m.Collection.FindOne(ctx, bson.D{{Key: "_id", Value: id}}).Decode(&msg)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, domain.ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &msg, nil
+}
+
+func (m *mongoRepository) StoredEventIDs(ctx context.Context, channelID string, eventIDs []string) ([]string, error) {
+	if len(eventIDs) == 0 {
+		return nil, nil
+	}
+	filter := bson.D{
+		{Key: "channel_id", Value: channelID},
+		{Key: "event_id", Value: bson.D{{Key: "$in", Value: eventIDs}}},
+	}
+	cursor, err := m.Collection.Find(ctx, filter, options.Find().SetProjection(bson.D{{Key: "event_id", Value: 1}}))
+	if err != nil {
+		return nil, err
+	}
+	var docs []struct {
+		EventID string `bson:"event_id"`
+	}
+	if err = cursor.All(ctx, &docs); err != nil {
+		return nil, err
+	}
+	stored := make([]string, len(docs))
+	for i, doc := range docs {
+		stored[i] = doc.EventID
+	}
+	return stored, nil
+}
+
+func (m *mongoRepository) UpdateStatus(ctx context.Context, ids []string, status domain.DeliveryStatus, errMsg string) error {
+	if len(ids) == 0 {
+		return nil
+	}
+	filter := bson.D{{Key: "_id", Value: bson.D{{Key: "$in", Value: ids}}}}
+	update := bson.D{{Key: "$set", Value: bson.D{
+		{Key: "status", Value: status},
+		{Key: "error", Value: errMsg},
+		{Key: "updated_at", Value: time.Now().UTC()},
+	}}}
+	_, err := m.Collection.UpdateMany(ctx, filter, update)
 	return err
 }
 
-func (m *mongoRepository) GetByUserID(ctx context.Context, offset, limit int64, userID ...string) (*[]domain.Message, int64, error) {
-	filter := make([]bson.E, 0)
+func (m *mongoRepository) AdvanceStatus(ctx context.Context, update domain.StatusUpdate, from []domain.DeliveryStatus) error {
+	if len(from) == 0 {
+		return nil
+	}
+	filter := bson.D{
+		{Key: "_id", Value: update.MessageID},
+		{Key: "channel_id", Value: update.ChannelID},
+		{Key: "direction", Value: domain.DirectionOutbound},
+		{Key: "status", Value: bson.D{{Key: "$in", Value: from}}},
+	}
+	set := bson.D{{Key: "$set", Value: bson.D{
+		{Key: "status", Value: update.Status},
+		{Key: "error", Value: update.Error},
+		{Key: "updated_at", Value: time.Now().UTC()},
+	}}}
+	_, err := m.Collection.UpdateOne(ctx, filter, set)
+	return err
+}
+
+// buildFilter translates the query into the filter of MongoDB, there is no restriction if the query is empty.
+func buildFilter(q domain.MessageQuery) bson.D {
+	filter := bson.D{}
+
+	if q.ChannelID != "" {
+		filter = append(filter, bson.E{Key: "channel_id", Value: q.ChannelID})
+	}
 
-	for _, id := range userID {
-		filter = append(filter, bson.E{Key: "user_id", Value: id})
+	switch len(q.UserIDs) {
+	case 0:
+	case 1:
+		filter = append(filter, bson.E{Key: "user_id", Value: q.UserIDs[0]})
+	default:
+		filter = append(filter, bson.E{Key: "user_id", Value: bson.D{{Key: "$in", Value: q.UserIDs}}})
+	}
+
+	if len(q.Types) > 0 {
+		filter = append(filter, bson.E{Key: "type", Value: bson.D{{Key: "$in", Value: q.Types}}})
+	}
+
+	createdAt := bson.D{}
+	if q.From != nil {
+		createdAt = append(createdAt, bson.E{Key: "$gte", Value: *q.From})
+	}
+	if q.To != nil {
+		createdAt = append(createdAt, bson.E{Key: "$lte", Value: *q.To})
+	}
+	if len(createdAt) > 0 {
+		filter = append(filter, bson.E{Key: "created_at", Value: createdAt})
 	}
 
+	if q.After != nil {
+		// keyset pagination, the messages after the cursor are either created later(or earlier) or share
+		// the same created time with a greater(or smaller) id
+		op := "$lt"
+		if q.Sort == domain.SortOrderAsc {
+			op = "$gt"
+		}
+		filter = append(filter, bson.E{Key: "$or", Value: bson.A{
+			bson.D{{Key: "created_at", Value: bson.D{{Key: op, Value: q.After.CreatedAt}}}},
+			bson.D{
+				{Key: "created_at", Value: q.After.CreatedAt},
+				{Key: "_id", Value: bson.D{{Key: op, Value: q.After.ID}}},
+			},
+		}})
+	}
+
+	if q.Search != "" {
+		switch q.SearchMode {
+		case domain.SearchModeText:
+			filter = append(filter, bson.E{Key: "$text", Value: bson.D{{Key: "$search", Value: q.Search}}})
+		default:
+			filter = append(filter, bson.E{Key: "message", Value: primitive.Regex{
+				Pattern: regexp.QuoteMeta(q.Search),
+				Options: "i",
+			}})
+		}
+	}
+	return filter
+}
+
+func buildSort(q domain.MessageQuery) bson.D {
+	order := -1
+	if q.Sort == domain.SortOrderAsc {
+		order = 1
+	}
+	// _id breaks the tie of messages created in the same batch
+	return bson.D{{Key: "created_at", Value: order}, {Key: "_id", Value: order}}
+}
+
+func (m *mongoRepository) Fetch(ctx context.Context, q domain.MessageQuery) (*[]domain.Message, int64, error) {
+	filter := buildFilter(q)
+
 	findOptions := options.Find()
-	findOptions.SetSkip(offset)
-	findOptions.SetLimit(limit)
+	findOptions.SetSort(buildSort(q))
+	findOptions.SetSkip(q.Offset)
+	findOptions.SetLimit(q.Limit)
 
-	totalCount, err := m.Collection.CountDocuments(ctx, filter)
-	if err != nil {
-		return nil, 0, err
+	var totalCount int64
+	if !q.SkipCount {
+		var err error
+		totalCount, err = m.Collection.CountDocuments(ctx, filter)
+		if err != nil {
+			return nil, 0, err
+		}
 	}
 	cursor, err := m.Collection.Find(ctx, filter, findOptions)
 	if err != nil {
@@ -2,6 +2,7 @@ package mongo
 
 import (
 	"context"
+	"reflect"
 	"testing"
 	"time"
 
@@ -21,6 +22,12 @@ func compare(s, t domain.Message) bool {
 	if s.Message != t.Message {
 		return false
 	}
+	if s.Type != t.Type {
+		return false
+	}
+	if !reflect.DeepEqual(s.Payload, t.Payload) {
+		return false
+	}
 	// millisecond would be truncated when inserting into database
 	pattern := "2006-01-02 15:04:05"
 	if s.CreatedAt.Format(pattern) != t.CreatedAt.Format(pattern) {
@@ -40,7 +47,7 @@ func Test_mongoRepository_Insert(t *testing.T) {
 	mt.Run("insert new message", func(mt *mtest.T) {
 		messageCollection := mt.Coll
 		ctx := context.Background()
-		id := primitive.NewObjectID().String()
+		id := primitive.NewObjectID().Hex()
 		now := time.Now().UTC()
 
 		messageData := &domain.Message{
@@ -74,13 +81,490 @@ func Test_mongoRepository_Insert(t *testing.T) {
 			t.Errorf("data inconsistent, origin:%+v, new:%+v", messageData, messageFromDB)
 		}
 	})
+
+	mt.Run("insert new message with payload", func(mt *mtest.T) {
+		messageCollection := mt.Coll
+		ctx := context.Background()
+		id := primitive.NewObjectID().Hex()
+		now := time.Now().UTC()
+
+		messageData := &domain.Message{
+			UserID: "U12345",
+			Type:   domain.MessageTypeLocation,
+			Payload: &domain.Payload{
+				Location: &domain.Location{
+					Title:     "Taipei 101",
+					Address:   "No. 7, Section 5, Xinyi Road, Taipei",
+					Latitude:  25.033964,
+					Longitude: 121.564468,
+				},
+			},
+		}
+		rawData := bson.D{
+			{Key: "_id", Value: id},
+			{Key: "user_id", Value: messageData.UserID},
+			{Key: "type", Value: messageData.Type},
+			{Key: "message", Value: ""},
+			{Key: "payload", Value: bson.D{
+				{Key: "location", Value: bson.D{
+					{Key: "title", Value: messageData.Payload.Location.Title},
+					{Key: "address", Value: messageData.Payload.Location.Address},
+					{Key: "latitude", Value: messageData.Payload.Location.Latitude},
+					{Key: "longitude", Value: messageData.Payload.Location.Longitude},
+				}},
+			}},
+			{Key: "created_at", Value: now},
+			{Key: "updated_at", Value: nil},
+		}
+		mt.AddMockResponses(mtest.CreateSuccessResponse(), mtest.CreateCursorResponse(1, "db.message", mtest.FirstBatch, rawData))
+
+		m := &mongoRepository{
+			DB:         mt.DB,
+			Collection: messageCollection,
+		}
+		err := m.Insert(ctx, messageData)
+		if err != nil {
+			t.Errorf("insert failed, err: %v", err)
+		}
+		res := mt.Coll.FindOne(ctx, bson.D{})
+		var messageFromDB domain.Message
+		res.Decode(&messageFromDB)
+
+		messageData.ID = id
+		messageData.CreatedAt = now
+		if !compare(*messageData, messageFromDB) {
+			t.Errorf("data inconsistent, origin:%+v, new:%+v", messageData, messageFromDB)
+		}
+	})
+}
+
+func Test_mongoRepository_InsertMany(t *testing.T) {
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+	defer mt.Close()
+
+	mt.Run("insert multiple messages", func(mt *mtest.T) {
+		ctx := context.Background()
+		messages := []domain.Message{
+			{Message: "test message1", UserID: "U12345"},
+			{Message: "test message2", UserID: "U67890"},
+		}
+		mt.AddMockResponses(mtest.CreateSuccessResponse())
+
+		m := &mongoRepository{
+			DB:         mt.DB,
+			Collection: mt.Coll,
+		}
+		err := m.InsertMany(ctx, messages)
+		if err != nil {
+			t.Errorf("insert failed, err: %v", err)
+		}
+		for _, msg := range messages {
+			if msg.ID == "" || msg.CreatedAt.IsZero() {
+				t.Errorf("id and created time should be assigned, message:%+v", msg)
+			}
+		}
+		if messages[0].ID == messages[1].ID {
+			t.Errorf("id should be unique, id:%v", messages[0].ID)
+		}
+	})
+
+	mt.Run("insert empty batch", func(mt *mtest.T) {
+		m := &mongoRepository{
+			DB:         mt.DB,
+			Collection: mt.Coll,
+		}
+		err := m.InsertMany(context.Background(), nil)
+		if err != nil {
+			t.Errorf("unexpected error: %v", err)
+		}
+	})
+}
+
+func Test_mongoRepository_InsertMany_idempotent(t *testing.T) {
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+	defer mt.Close()
+
+	mt.Run("upsert messages from webhook events", func(mt *mtest.T) {
+		mt.AddMockResponses(mtest.CreateSuccessResponse())
+
+		m := &mongoRepository{
+			DB:         mt.DB,
+			Collection: mt.Coll,
+		}
+		err := m.InsertMany(context.Background(), []domain.Message{
+			{EventID: "event1", UserID: "user1", Message: "test message"},
+		})
+		if err != nil {
+			t.Fatalf("insert failed, err: %v", err)
+		}
+
+		started := mt.GetStartedEvent()
+		if started == nil || started.CommandName != "update" {
+			t.Fatalf("command inconsistent, command:%v, expected command:%v", started, "update")
+		}
+		update := started.Command.Lookup("updates", "0")
+		if eventID := update.Document().Lookup("q", "event_id").StringValue(); eventID != "event1" {
+			t.Errorf("filter inconsistent, event id:%v, expected event id:%v", eventID, "event1")
+		}
+		if upsert := update.Document().Lookup("upsert").Boolean(); !upsert {
+			t.Errorf("upsert should be enabled")
+		}
+		if _, err := update.Document().LookupErr("u", "$setOnInsert", "user_id"); err != nil {
+			t.Errorf("message should only be set on insert, err: %v", err)
+		}
+	})
+
+	mt.Run("ignore duplicated events", func(mt *mtest.T) {
+		mt.AddMockResponses(mtest.CreateWriteErrorsResponse(mtest.WriteError{
+			Index:   0,
+			Code:    11000,
+			Message: "E11000 duplicate key error",
+		}))
+
+		m := &mongoRepository{
+			DB:         mt.DB,
+			Collection: mt.Coll,
+		}
+		err := m.InsertMany(context.Background(), []domain.Message{
+			{EventID: "event1", UserID: "user1", Message: "test message"},
+		})
+		if err != nil {
+			t.Errorf("duplicated events should be ignored, err: %v", err)
+		}
+	})
+
+	mt.Run("report other write errors", func(mt *mtest.T) {
+		mt.AddMockResponses(mtest.CreateWriteErrorsResponse(mtest.WriteError{
+			Index:   0,
+			Code:    121,
+			Message: "document failed validation",
+		}))
+
+		m := &mongoRepository{
+			DB:         mt.DB,
+			Collection: mt.Coll,
+		}
+		err := m.InsertMany(context.Background(), []domain.Message{
+			{EventID: "event1", UserID: "user1", Message: "test message"},
+		})
+		if err == nil {
+			t.Errorf("write errors other than duplicated key should be reported")
+		}
+	})
+}
+
+func Test_mongoRepository_GetByID(t *testing.T) {
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+	defer mt.Close()
+
+	mt.Run("GetByID success", func(mt *mtest.T) {
+		now := time.Now().UTC()
+		messageData := domain.Message{
+			ID:        primitive.NewObjectID().Hex(),
+			Message:   "test message",
+			UserID:    "user1",
+			Type:      domain.MessageTypeText,
+			CreatedAt: now,
+		}
+		mt.AddMockResponses(mtest.CreateCursorResponse(1, "db.message", mtest.FirstBatch, bson.D{
+			{Key: "_id", Value: messageData.ID},
+			{Key: "user_id", Value: messageData.UserID},
+			{Key: "type", Value: messageData.Type},
+			{Key: "message", Value: messageData.Message},
+			{Key: "created_at", Value: messageData.CreatedAt},
+			{Key: "updated_at", Value: nil},
+		}))
+
+		m := &mongoRepository{
+			DB:         mt.DB,
+			Collection: mt.Coll,
+		}
+		msg, err := m.GetByID(context.Background(), messageData.ID)
+		if err != nil {
+			t.Fatalf("get message failed, err: %v", err)
+		}
+		if !compare(*msg, messageData) {
+			t.Errorf("data inconsistent, origin:%+v, new:%+v", *msg, messageData)
+		}
+	})
+
+	mt.Run("GetByID not found", func(mt *mtest.T) {
+		mt.AddMockResponses(mtest.CreateCursorResponse(0, "db.message", mtest.FirstBatch))
+
+		m := &mongoRepository{
+			DB:         mt.DB,
+			Collection: mt.Coll,
+		}
+		_, err := m.GetByID(context.Background(), "not-exist")
+		if err != domain.ErrNotFound {
+			t.Errorf("error inconsistent, caught error:%v, expected error:%v", err, domain.ErrNotFound)
+		}
+	})
+}
+
+func Test_mongoRepository_StoredEventIDs(t *testing.T) {
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+	defer mt.Close()
+
+	mt.Run("StoredEventIDs success", func(mt *mtest.T) {
+		mt.AddMockResponses(mtest.CreateCursorResponse(0, "db.message", mtest.FirstBatch, bson.D{
+			{Key: "_id", Value: "1"},
+			{Key: "event_id", Value: "E2"},
+		}))
+
+		m := &mongoRepository{
+			DB:         mt.DB,
+			Collection: mt.Coll,
+		}
+		stored, err := m.StoredEventIDs(context.Background(), "C1", []string{"E1", "E2"})
+		if err != nil {
+			t.Fatalf("lookup failed, err: %v", err)
+		}
+		if !reflect.DeepEqual(stored, []string{"E2"}) {
+			t.Errorf("event ids inconsistent, event ids:%v, expected event ids:%v", stored, []string{"E2"})
+		}
+		started := mt.GetStartedEvent()
+		if channelID := started.Command.Lookup("filter", "channel_id").StringValue(); channelID != "C1" {
+			t.Errorf("filter inconsistent, channel id:%v, expected channel id:%v", channelID, "C1")
+		}
+	})
+}
+
+func Test_mongoRepository_UpdateStatus(t *testing.T) {
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+	defer mt.Close()
+
+	mt.Run("UpdateStatus success", func(mt *mtest.T) {
+		mt.AddMockResponses(bson.D{
+			{Key: "ok", Value: 1},
+			{Key: "n", Value: 2},
+			{Key: "nModified", Value: 2},
+		})
+
+		m := &mongoRepository{
+			DB:         mt.DB,
+			Collection: mt.Coll,
+		}
+		err := m.UpdateStatus(context.Background(), []string{"1", "2"}, domain.DeliveryStatusFailed, "fake error")
+		if err != nil {
+			t.Errorf("update failed, err: %v", err)
+		}
+
+		started := mt.GetStartedEvent()
+		if started == nil || started.CommandName != "update" {
+			t.Fatalf("command inconsistent, command:%v, expected command:%v", started, "update")
+		}
+		status := started.Command.Lookup("updates", "0", "u", "$set", "status").StringValue()
+		if status != string(domain.DeliveryStatusFailed) {
+			t.Errorf("status inconsistent, status:%v, expected status:%v", status, domain.DeliveryStatusFailed)
+		}
+	})
+}
+
+func Test_mongoRepository_AdvanceStatus(t *testing.T) {
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+	defer mt.Close()
+
+	mt.Run("AdvanceStatus success", func(mt *mtest.T) {
+		mt.AddMockResponses(bson.D{
+			{Key: "ok", Value: 1},
+			{Key: "n", Value: 1},
+			{Key: "nModified", Value: 1},
+		})
+
+		m := &mongoRepository{
+			DB:         mt.DB,
+			Collection: mt.Coll,
+		}
+		update := domain.StatusUpdate{MessageID: "1", ChannelID: "C1", Status: domain.DeliveryStatusDelivered}
+		err := m.AdvanceStatus(context.Background(), update, []domain.DeliveryStatus{domain.DeliveryStatusPending, domain.DeliveryStatusSent})
+		if err != nil {
+			t.Errorf("update failed, err: %v", err)
+		}
+
+		started := mt.GetStartedEvent()
+		if started == nil || started.CommandName != "update" {
+			t.Fatalf("command inconsistent, command:%v, expected command:%v", started, "update")
+		}
+		// the stored status is compared in the filter, so the update can't move it back
+		in := started.Command.Lookup("updates", "0", "q", "status", "$in").Array()
+		if in.Index(0).Value().StringValue() != "pending" || in.Index(1).Value().StringValue() != "sent" {
+			t.Errorf("filter inconsistent, statuses:%v, expected statuses:%v", in, []string{"pending", "sent"})
+		}
+		if direction := started.Command.Lookup("updates", "0", "q", "direction").StringValue(); direction != string(domain.DirectionOutbound) {
+			t.Errorf("filter inconsistent, direction:%v, expected direction:%v", direction, domain.DirectionOutbound)
+		}
+	})
+}
+
+func Test_buildFilter(t *testing.T) {
+	from := time.Date(2022, 11, 1, 0, 0, 0, 0, time.UTC)
+	to := time.Date(2022, 11, 30, 0, 0, 0, 0, time.UTC)
+
+	cases := []struct {
+		name     string
+		query    domain.MessageQuery
+		expected bson.D
+	}{
+		{
+			name:     "empty query",
+			query:    domain.MessageQuery{},
+			expected: bson.D{},
+		},
+		{
+			name:     "single user",
+			query:    domain.MessageQuery{UserIDs: []string{"user1"}},
+			expected: bson.D{{Key: "user_id", Value: "user1"}},
+		},
+		{
+			name:  "multiple users",
+			query: domain.MessageQuery{UserIDs: []string{"user1", "user2"}},
+			expected: bson.D{
+				{Key: "user_id", Value: bson.D{{Key: "$in", Value: []string{"user1", "user2"}}}},
+			},
+		},
+		{
+			name:  "types and time range",
+			query: domain.MessageQuery{Types: []domain.MessageType{domain.MessageTypeImage}, From: &from, To: &to},
+			expected: bson.D{
+				{Key: "type", Value: bson.D{{Key: "$in", Value: []domain.MessageType{domain.MessageTypeImage}}}},
+				{Key: "created_at", Value: bson.D{{Key: "$gte", Value: from}, {Key: "$lte", Value: to}}},
+			},
+		},
+		{
+			name:  "after the cursor in descending order",
+			query: domain.MessageQuery{After: &domain.MessageCursor{CreatedAt: to, ID: "2"}},
+			expected: bson.D{
+				{Key: "$or", Value: bson.A{
+					bson.D{{Key: "created_at", Value: bson.D{{Key: "$lt", Value: to}}}},
+					bson.D{
+						{Key: "created_at", Value: to},
+						{Key: "_id", Value: bson.D{{Key: "$lt", Value: "2"}}},
+					},
+				}},
+			},
+		},
+		{
+			name:  "after the cursor in ascending order",
+			query: domain.MessageQuery{Sort: domain.SortOrderAsc, After: &domain.MessageCursor{CreatedAt: from, ID: "1"}},
+			expected: bson.D{
+				{Key: "$or", Value: bson.A{
+					bson.D{{Key: "created_at", Value: bson.D{{Key: "$gt", Value: from}}}},
+					bson.D{
+						{Key: "created_at", Value: from},
+						{Key: "_id", Value: bson.D{{Key: "$gt", Value: "1"}}},
+					},
+				}},
+			},
+		},
+		{
+			name:  "substring search escapes the keyword",
+			query: domain.MessageQuery{Search: "a+b"},
+			expected: bson.D{
+				{Key: "message", Value: primitive.Regex{Pattern: `a\+b`, Options: "i"}},
+			},
+		},
+		{
+			name:  "full-text search",
+			query: domain.MessageQuery{Search: "hello world", SearchMode: domain.SearchModeText},
+			expected: bson.D{
+				{Key: "$text", Value: bson.D{{Key: "$search", Value: "hello world"}}},
+			},
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			got := buildFilter(c.query)
+			if !reflect.DeepEqual(got, c.expected) {
+				t.Errorf("filter inconsistent, filter:%v, expected filter:%v", got, c.expected)
+			}
+		})
+	}
+}
+
+func Test_buildSort(t *testing.T) {
+	asc := buildSort(domain.MessageQuery{Sort: domain.SortOrderAsc})
+	if !reflect.DeepEqual(asc, bson.D{{Key: "created_at", Value: 1}, {Key: "_id", Value: 1}}) {
+		t.Errorf("sort inconsistent, sort:%v", asc)
+	}
+	desc := buildSort(domain.MessageQuery{})
+	if !reflect.DeepEqual(desc, bson.D{{Key: "created_at", Value: -1}, {Key: "_id", Value: -1}}) {
+		t.Errorf("sort inconsistent, sort:%v", desc)
+	}
+}
+
+func Test_mongoRepository_Fetch_filter(t *testing.T) {
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+	defer mt.Close()
+
+	mt.Run("Fetch with multiple users", func(mt *mtest.T) {
+		mt.AddMockResponses(
+			mtest.CreateCursorResponse(1, "db.message", mtest.FirstBatch, bson.D{
+				{Key: "n", Value: 0},
+			}),
+			mtest.CreateCursorResponse(0, "db.message", mtest.FirstBatch),
+		)
+
+		m := &mongoRepository{
+			DB:         mt.DB,
+			Collection: mt.Coll,
+		}
+		_, _, err := m.Fetch(context.Background(), domain.MessageQuery{UserIDs: []string{"user1", "user2"}, Limit: 20})
+		if err != nil {
+			t.Fatalf("get messages failed, err: %v", err)
+		}
+
+		// the count is an aggregation and the find command carries the filter directly
+		mt.GetStartedEvent()
+		started := mt.GetStartedEvent()
+		if started == nil || started.CommandName != "find" {
+			t.Fatalf("command inconsistent, command:%v, expected command:%v", started, "find")
+		}
+		values, err := started.Command.Lookup("filter", "user_id", "$in").Array().Values()
+		if err != nil {
+			t.Fatalf("filter should use $in, err: %v", err)
+		}
+		if len(values) != 2 || values[0].StringValue() != "user1" || values[1].StringValue() != "user2" {
+			t.Errorf("filter inconsistent, values:%v", values)
+		}
+	})
+}
+
+func Test_mongoRepository_Fetch_skipCount(t *testing.T) {
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+	defer mt.Close()
+
+	mt.Run("Fetch without counting", func(mt *mtest.T) {
+		mt.AddMockResponses(mtest.CreateCursorResponse(0, "db.message", mtest.FirstBatch))
+
+		m := &mongoRepository{
+			DB:         mt.DB,
+			Collection: mt.Coll,
+		}
+		_, totalCount, err := m.Fetch(context.Background(), domain.MessageQuery{Limit: 20, SkipCount: true})
+		if err != nil {
+			t.Fatalf("get messages failed, err: %v", err)
+		}
+		if totalCount != 0 {
+			t.Errorf("total count should be zero, total count:%v", totalCount)
+		}
+
+		started := mt.GetStartedEvent()
+		if started == nil || started.CommandName != "find" {
+			t.Errorf("command inconsistent, command:%v, expected command:%v", started, "find")
+		}
+		if next := mt.GetStartedEvent(); next != nil {
+			t.Errorf("unexpected command:%v", next.CommandName)
+		}
+	})
 }
 
-func Test_mongoRepository_GetByUserID(t *testing.T) {
+func Test_mongoRepository_Fetch(t *testing.T) {
 	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
 	defer mt.Close()
 
-	mt.Run("GetByUserID success", func(mt *mtest.T) {
+	mt.Run("Fetch success", func(mt *mtest.T) {
 		messageCollection := mt.Coll
 		ctx := context.Background()
 
@@ -88,13 +572,13 @@ func Test_mongoRepository_GetByUserID(t *testing.T) {
 		now := time.Now().UTC()
 
 		messageData1 := &domain.Message{
-			ID:        primitive.NewObjectID().String(),
+			ID:        primitive.NewObjectID().Hex(),
 			Message:   "test message1",
 			UserID:    "user1",
 			CreatedAt: now,
 		}
 		messageData2 := &domain.Message{
-			ID:        primitive.NewObjectID().String(),
+			ID:        primitive.NewObjectID().Hex(),
 			Message:   "test message2",
 			UserID:    "user2",
 			CreatedAt: yesterday,
@@ -143,7 +627,7 @@ func Test_mongoRepository_GetByUserID(t *testing.T) {
 			Collection: messageCollection,
 		}
 
-		messages, totalCount, err := m.GetByUserID(ctx, 0, 20)
+		messages, totalCount, err := m.Fetch(ctx, domain.MessageQuery{Limit: 20})
 		if err != nil {
 			t.Errorf("get messages failed, err: %v", err)
 		}
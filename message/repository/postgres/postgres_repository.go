@@ -0,0 +1,345 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/kunmingliu/messenger/domain"
+	"github.com/lib/pq"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+type postgresRepository struct {
+	DB *sql.DB
+}
+
+const (
+	tableName = "message"
+	columns   = "id, channel_id, event_id, created_at, updated_at, user_id, direction, type, message, payload, reply_token, " +
+		"reply_expires_at, status, error"
+	// insertChunkSize keeps the number of parameters of a statement under the limit of PostgreSQL.
+	insertChunkSize = 1000
+)
+
+func NewPostgresRepository(DB *sql.DB) domain.MessageRepository {
+	return &postgresRepository{DB}
+}
+
+// now is truncated to microseconds which is the precision of PostgreSQL, so that the time stored is the same as
+// the one returned.
+func now() time.Time {
+	return time.Now().UTC().Truncate(time.Microsecond)
+}
+
+func nullString(s string) sql.NullString {
+	return sql.NullString{String: s, Valid: s != ""}
+}
+
+func nullTime(t *time.Time) sql.NullTime {
+	if t == nil {
+		return sql.NullTime{}
+	}
+	return sql.NullTime{Time: *t, Valid: true}
+}
+
+func values(msg domain.Message) ([]interface{}, error) {
+	// the payload is passed as a string since []byte would be encoded as bytea rather than JSON
+	var payload sql.NullString
+	if msg.Payload != nil {
+		b, err := json.Marshal(msg.Payload)
+		if err != nil {
+			return nil, err
+		}
+		payload = sql.NullString{String: string(b), Valid: true}
+	}
+	return []interface{}{
+		msg.ID, msg.ChannelID, nullString(msg.EventID), msg.CreatedAt, nullTime(msg.UpdatedAt), msg.UserID, msg.Direction, msg.Type,
+		msg.Message, payload, msg.ReplyToken, nullTime(msg.ReplyExpiresAt), msg.Status, msg.Error,
+	}, nil
+}
+
+type scanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scan(row scanner) (domain.Message, error) {
+	var (
+		msg                       domain.Message
+		eventID                   sql.NullString
+		updatedAt, replyExpiresAt sql.NullTime
+		payload                   []byte
+	)
+	err := row.Scan(&msg.ID, &msg.ChannelID, &eventID, &msg.CreatedAt, &updatedAt, &msg.UserID, &msg.Direction, &msg.Type,
+		&msg.Message, &payload, &msg.ReplyToken, &replyExpiresAt, &msg.Status, &msg.Error)
+	if err != nil {
+		return msg, err
+	}
+	msg.EventID = eventID.String
+	msg.CreatedAt = msg.CreatedAt.UTC()
+	if updatedAt.Valid {
+		t := updatedAt.Time.UTC()
+		msg.UpdatedAt = &t
+	}
+	if replyExpiresAt.Valid {
+		t := replyExpiresAt.Time.UTC()
+		msg.ReplyExpiresAt = &t
+	}
+	if payload != nil {
+		msg.Payload = &domain.Payload{}
+		if err = json.Unmarshal(payload, msg.Payload); err != nil {
+			return msg, err
+		}
+	}
+	return msg, nil
+}
+
+// insert stores the messages in a single statement, the message whose event id has been stored in the channel is ignored.
+func (p *postgresRepository) insert(ctx context.Context, tx *sql.Tx, msgs []domain.Message) error {
+	var (
+		sb   strings.Builder
+		args []interface{}
+	)
+	sb.WriteString("INSERT INTO " + tableName + " (" + columns + ") VALUES ")
+	for i, msg := range msgs {
+		v, err := values(msg)
+		if err != nil {
+			return err
+		}
+		if i > 0 {
+			sb.WriteString(", ")
+		}
+		sb.WriteString("(")
+		for j := range v {
+			if j > 0 {
+				sb.WriteString(", ")
+			}
+			fmt.Fprintf(&sb, "$%d", len(args)+j+1)
+		}
+		sb.WriteString(")")
+		args = append(args, v...)
+	}
+	sb.WriteString(" ON CONFLICT (channel_id, event_id) DO NOTHING")
+	_, err := tx.ExecContext(ctx, sb.String(), args...)
+	return err
+}
+
+func (p *postgresRepository) Insert(ctx context.Context, msg *domain.Message) error {
+	msg.ID = primitive.NewObjectID().Hex()
+	msg.CreatedAt = now()
+	msgs := []domain.Message{*msg}
+	return p.withTx(ctx, func(tx *sql.Tx) error {
+		return p.insert(ctx, tx, msgs)
+	})
+}
+
+func (p *postgresRepository) InsertMany(ctx context.Context, msgs []domain.Message) error {
+	if len(msgs) == 0 {
+		return nil
+	}
+	createdAt := now()
+	for i := range msgs {
+		msgs[i].ID = primitive.NewObjectID().Hex()
+		msgs[i].CreatedAt = createdAt
+	}
+	return p.withTx(ctx, func(tx *sql.Tx) error {
+		for start := 0; start < len(msgs); start += insertChunkSize {
+			end := start + insertChunkSize
+			if end > len(msgs) {
+				end = len(msgs)
+			}
+			if err := p.insert(ctx, tx, msgs[start:end]); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (p *postgresRepository) withTx(ctx context.Context, fn func(tx *sql.Tx) error) error {
+	tx, err := p.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	if err = fn(tx); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+func (p *postgresRepository) GetByID(ctx context.Context, id string) (*domain.Message, error) {
+	row := p.DB.QueryRowContext(ctx, "SELECT "+columns+" FROM "+tableName+" WHERE id = $1", id)
+	msg, err := scan(row)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, domain.ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &msg, nil
+}
+
+func (p *postgresRepository) StoredEventIDs(ctx context.Context, channelID string, eventIDs []string) ([]string, error) {
+	if len(eventIDs) == 0 {
+		return nil, nil
+	}
+	rows, err := p.DB.QueryContext(ctx,
+		"SELECT event_id FROM "+tableName+" WHERE channel_id = $1 AND event_id = ANY($2)", channelID, pq.Array(eventIDs))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var stored []string
+	for rows.Next() {
+		var eventID string
+		if err = rows.Scan(&eventID); err != nil {
+			return nil, err
+		}
+		stored = append(stored, eventID)
+	}
+	return stored, rows.Err()
+}
+
+func (p *postgresRepository) UpdateStatus(ctx context.Context, ids []string, status domain.DeliveryStatus, errMsg string) error {
+	if len(ids) == 0 {
+		return nil
+	}
+	_, err := p.DB.ExecContext(ctx,
+		"UPDATE "+tableName+" SET status = $1, error = $2, updated_at = $3 WHERE id = ANY($4)",
+		status, errMsg, now(), pq.Array(ids))
+	return err
+}
+
+func (p *postgresRepository) AdvanceStatus(ctx context.Context, update domain.StatusUpdate, from []domain.DeliveryStatus) error {
+	if len(from) == 0 {
+		return nil
+	}
+	statuses := make([]string, len(from))
+	for i, status := range from {
+		statuses[i] = string(status)
+	}
+	_, err := p.DB.ExecContext(ctx,
+		"UPDATE "+tableName+" SET status = $1, error = $2, updated_at = $3"+
+			" WHERE id = $4 AND channel_id = $5 AND direction = $6 AND status = ANY($7)",
+		update.Status, update.Error, now(), update.MessageID, update.ChannelID, domain.DirectionOutbound, pq.Array(statuses))
+	return err
+}
+
+// escapeLike escapes the wildcards of LIKE so that the keyword is matched literally.
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(s)
+}
+
+// buildWhere translates the query into the WHERE clause and its arguments, the clause is empty if the query is empty.
+func buildWhere(q domain.MessageQuery) (string, []interface{}) {
+	var (
+		conditions []string
+		args       []interface{}
+	)
+	arg := func(v interface{}) string {
+		args = append(args, v)
+		return fmt.Sprintf("$%d", len(args))
+	}
+
+	if q.ChannelID != "" {
+		conditions = append(conditions, "channel_id = "+arg(q.ChannelID))
+	}
+
+	switch len(q.UserIDs) {
+	case 0:
+	case 1:
+		conditions = append(conditions, "user_id = "+arg(q.UserIDs[0]))
+	default:
+		conditions = append(conditions, "user_id = ANY("+arg(pq.Array(q.UserIDs))+")")
+	}
+
+	if len(q.Types) > 0 {
+		types := make([]string, len(q.Types))
+		for i, t := range q.Types {
+			types[i] = string(t)
+		}
+		conditions = append(conditions, "type = ANY("+arg(pq.Array(types))+")")
+	}
+
+	if q.From != nil {
+		conditions = append(conditions, "created_at >= "+arg(*q.From))
+	}
+	if q.To != nil {
+		conditions = append(conditions, "created_at <= "+arg(*q.To))
+	}
+
+	if q.After != nil {
+		// keyset pagination, the row comparison matches the messages created later(or earlier) or sharing the same
+		// created time with a greater(or smaller) id
+		op := "<"
+		if q.Sort == domain.SortOrderAsc {
+			op = ">"
+		}
+		conditions = append(conditions, fmt.Sprintf("(created_at, id) %s (%s, %s)", op, arg(q.After.CreatedAt), arg(q.After.ID)))
+	}
+
+	if q.Search != "" {
+		switch q.SearchMode {
+		case domain.SearchModeText:
+			conditions = append(conditions, "to_tsvector('simple', message) @@ plainto_tsquery('simple', "+arg(q.Search)+")")
+		default:
+			conditions = append(conditions, "message ILIKE "+arg("%"+escapeLike(q.Search)+"%"))
+		}
+	}
+
+	if len(conditions) == 0 {
+		return "", nil
+	}
+	return " WHERE " + strings.Join(conditions, " AND "), args
+}
+
+func buildOrderBy(q domain.MessageQuery) string {
+	order := "DESC"
+	if q.Sort == domain.SortOrderAsc {
+		order = "ASC"
+	}
+	// id breaks the tie of messages created in the same batch
+	return fmt.Sprintf(" ORDER BY created_at %s, id %s", order, order)
+}
+
+func (p *postgresRepository) Fetch(ctx context.Context, q domain.MessageQuery) (*[]domain.Message, int64, error) {
+	where, args := buildWhere(q)
+
+	var totalCount int64
+	if !q.SkipCount {
+		err := p.DB.QueryRowContext(ctx, "SELECT COUNT(*) FROM "+tableName+where, args...).Scan(&totalCount)
+		if err != nil {
+			return nil, 0, err
+		}
+	}
+
+	query := "SELECT " + columns + " FROM " + tableName + where + buildOrderBy(q)
+	if q.Limit > 0 {
+		query += fmt.Sprintf(" LIMIT %d", q.Limit)
+	}
+	if q.Offset > 0 {
+		query += fmt.Sprintf(" OFFSET %d", q.Offset)
+	}
+	rows, err := p.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+	var messages []domain.Message
+	for rows.Next() {
+		msg, err := scan(rows)
+		if err != nil {
+			return nil, 0, err
+		}
+		messages = append(messages, msg)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, 0, err
+	}
+	return &messages, totalCount, nil
+}
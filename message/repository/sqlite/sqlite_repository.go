@@ -0,0 +1,359 @@
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/kunmingliu/messenger/domain"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+type sqliteRepository struct {
+	DB *sql.DB
+}
+
+const (
+	tableName = "message"
+	columns   = "id, channel_id, event_id, created_at, updated_at, user_id, direction, type, message, payload, reply_token, " +
+		"reply_expires_at, status, error"
+	// insertChunkSize keeps the number of parameters of a statement under the limit of SQLite.
+	insertChunkSize = 1000
+)
+
+func NewSQLiteRepository(DB *sql.DB) domain.MessageRepository {
+	return &sqliteRepository{DB}
+}
+
+// times are stored as nanoseconds since the Unix epoch
+func nullTime(t *time.Time) sql.NullInt64 {
+	if t == nil {
+		return sql.NullInt64{}
+	}
+	return sql.NullInt64{Int64: t.UnixNano(), Valid: true}
+}
+
+func toTime(t sql.NullInt64) *time.Time {
+	if !t.Valid {
+		return nil
+	}
+	result := time.Unix(0, t.Int64).UTC()
+	return &result
+}
+
+func values(msg domain.Message) ([]interface{}, error) {
+	var payload sql.NullString
+	if msg.Payload != nil {
+		b, err := json.Marshal(msg.Payload)
+		if err != nil {
+			return nil, err
+		}
+		payload = sql.NullString{String: string(b), Valid: true}
+	}
+	return []interface{}{
+		msg.ID, msg.ChannelID, sql.NullString{String: msg.EventID, Valid: msg.EventID != ""}, msg.CreatedAt.UnixNano(),
+		nullTime(msg.UpdatedAt), msg.UserID, string(msg.Direction), string(msg.Type), msg.Message, payload,
+		msg.ReplyToken, nullTime(msg.ReplyExpiresAt), string(msg.Status), msg.Error,
+	}, nil
+}
+
+type scanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scan(row scanner) (domain.Message, error) {
+	var (
+		msg                       domain.Message
+		eventID, payload          sql.NullString
+		createdAt                 int64
+		updatedAt, replyExpiresAt sql.NullInt64
+	)
+	err := row.Scan(&msg.ID, &msg.ChannelID, &eventID, &createdAt, &updatedAt, &msg.UserID, &msg.Direction, &msg.Type,
+		&msg.Message, &payload, &msg.ReplyToken, &replyExpiresAt, &msg.Status, &msg.Error)
+	if err != nil {
+		return msg, err
+	}
+	msg.EventID = eventID.String
+	msg.CreatedAt = time.Unix(0, createdAt).UTC()
+	msg.UpdatedAt = toTime(updatedAt)
+	msg.ReplyExpiresAt = toTime(replyExpiresAt)
+	if payload.Valid {
+		msg.Payload = &domain.Payload{}
+		if err = json.Unmarshal([]byte(payload.String), msg.Payload); err != nil {
+			return msg, err
+		}
+	}
+	return msg, nil
+}
+
+// placeholders returns n comma separated placeholders.
+func placeholders(n int) string {
+	return strings.TrimSuffix(strings.Repeat("?, ", n), ", ")
+}
+
+// insert stores the messages in a single statement, the message whose event id has been stored in the channel is ignored.
+func (s *sqliteRepository) insert(ctx context.Context, tx *sql.Tx, msgs []domain.Message) error {
+	var (
+		rows []string
+		args []interface{}
+	)
+	for _, msg := range msgs {
+		v, err := values(msg)
+		if err != nil {
+			return err
+		}
+		rows = append(rows, "("+placeholders(len(v))+")")
+		args = append(args, v...)
+	}
+	query := "INSERT INTO " + tableName + " (" + columns + ") VALUES " + strings.Join(rows, ", ") +
+		" ON CONFLICT (channel_id, event_id) DO NOTHING"
+	_, err := tx.ExecContext(ctx, query, args...)
+	return err
+}
+
+func (s *sqliteRepository) withTx(ctx context.Context, fn func(tx *sql.Tx) error) error {
+	tx, err := s.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	if err = fn(tx); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+func (s *sqliteRepository) Insert(ctx context.Context, msg *domain.Message) error {
+	msg.ID = primitive.NewObjectID().Hex()
+	msg.CreatedAt = time.Now().UTC()
+	msgs := []domain.Message{*msg}
+	return s.withTx(ctx, func(tx *sql.Tx) error {
+		return s.insert(ctx, tx, msgs)
+	})
+}
+
+func (s *sqliteRepository) InsertMany(ctx context.Context, msgs []domain.Message) error {
+	if len(msgs) == 0 {
+		return nil
+	}
+	now := time.Now().UTC()
+	for i := range msgs {
+		msgs[i].ID = primitive.NewObjectID().Hex()
+		msgs[i].CreatedAt = now
+	}
+	return s.withTx(ctx, func(tx *sql.Tx) error {
+		for start := 0; start < len(msgs); start += insertChunkSize {
+			end := start + insertChunkSize
+			if end > len(msgs) {
+				end = len(msgs)
+			}
+			if err := s.insert(ctx, tx, msgs[start:end]); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (s *sqliteRepository) GetByID(ctx context.Context, id string) (*domain.Message, error) {
+	row := s.DB.QueryRowContext(ctx, "SELECT "+columns+" FROM "+tableName+" WHERE id = ?", id)
+	msg, err := scan(row)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, domain.ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &msg, nil
+}
+
+func (s *sqliteRepository) StoredEventIDs(ctx context.Context, channelID string, eventIDs []string) ([]string, error) {
+	if len(eventIDs) == 0 {
+		return nil, nil
+	}
+	args := []interface{}{channelID}
+	for _, eventID := range eventIDs {
+		args = append(args, eventID)
+	}
+	rows, err := s.DB.QueryContext(ctx,
+		"SELECT event_id FROM "+tableName+" WHERE channel_id = ? AND event_id IN ("+placeholders(len(eventIDs))+")", args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var stored []string
+	for rows.Next() {
+		var eventID string
+		if err = rows.Scan(&eventID); err != nil {
+			return nil, err
+		}
+		stored = append(stored, eventID)
+	}
+	return stored, rows.Err()
+}
+
+func (s *sqliteRepository) UpdateStatus(ctx context.Context, ids []string, status domain.DeliveryStatus, errMsg string) error {
+	if len(ids) == 0 {
+		return nil
+	}
+	args := []interface{}{string(status), errMsg, time.Now().UTC().UnixNano()}
+	for _, id := range ids {
+		args = append(args, id)
+	}
+	_, err := s.DB.ExecContext(ctx,
+		"UPDATE "+tableName+" SET status = ?, error = ?, updated_at = ? WHERE id IN ("+placeholders(len(ids))+")",
+		args...)
+	return err
+}
+
+func (s *sqliteRepository) AdvanceStatus(ctx context.Context, update domain.StatusUpdate, from []domain.DeliveryStatus) error {
+	if len(from) == 0 {
+		return nil
+	}
+	args := []interface{}{string(update.Status), update.Error, time.Now().UTC().UnixNano(),
+		update.MessageID, update.ChannelID, string(domain.DirectionOutbound)}
+	for _, status := range from {
+		args = append(args, string(status))
+	}
+	_, err := s.DB.ExecContext(ctx,
+		"UPDATE "+tableName+" SET status = ?, error = ?, updated_at = ?"+
+			" WHERE id = ? AND channel_id = ? AND direction = ? AND status IN ("+placeholders(len(from))+")",
+		args...)
+	return err
+}
+
+// escapeLike escapes the wildcards of LIKE so that the keyword is matched literally.
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(s)
+}
+
+// matchQuery turns the keywords into a FTS5 query matching all of them, each keyword is quoted so that the syntax of
+// FTS5 isn't interpreted.
+func matchQuery(s string) string {
+	words := strings.Fields(s)
+	for i, w := range words {
+		words[i] = `"` + strings.ReplaceAll(w, `"`, `""`) + `"`
+	}
+	return strings.Join(words, " ")
+}
+
+// buildWhere translates the query into the WHERE clause and its arguments, the clause is empty if the query is empty.
+func buildWhere(q domain.MessageQuery) (string, []interface{}) {
+	var (
+		conditions []string
+		args       []interface{}
+	)
+
+	if q.ChannelID != "" {
+		conditions = append(conditions, "channel_id = ?")
+		args = append(args, q.ChannelID)
+	}
+
+	switch len(q.UserIDs) {
+	case 0:
+	case 1:
+		conditions = append(conditions, "user_id = ?")
+		args = append(args, q.UserIDs[0])
+	default:
+		conditions = append(conditions, "user_id IN ("+placeholders(len(q.UserIDs))+")")
+		for _, id := range q.UserIDs {
+			args = append(args, id)
+		}
+	}
+
+	if len(q.Types) > 0 {
+		conditions = append(conditions, "type IN ("+placeholders(len(q.Types))+")")
+		for _, t := range q.Types {
+			args = append(args, string(t))
+		}
+	}
+
+	if q.From != nil {
+		conditions = append(conditions, "created_at >= ?")
+		args = append(args, q.From.UnixNano())
+	}
+	if q.To != nil {
+		conditions = append(conditions, "created_at <= ?")
+		args = append(args, q.To.UnixNano())
+	}
+
+	if q.After != nil {
+		// keyset pagination, the row comparison matches the messages created later(or earlier) or sharing the same
+		// created time with a greater(or smaller) id
+		op := "<"
+		if q.Sort == domain.SortOrderAsc {
+			op = ">"
+		}
+		conditions = append(conditions, fmt.Sprintf("(created_at, id) %s (?, ?)", op))
+		args = append(args, q.After.CreatedAt.UnixNano(), q.After.ID)
+	}
+
+	if q.Search != "" {
+		switch q.SearchMode {
+		case domain.SearchModeText:
+			conditions = append(conditions, "rowid IN (SELECT rowid FROM message_fts WHERE message_fts MATCH ?)")
+			args = append(args, matchQuery(q.Search))
+		default:
+			// LIKE of SQLite is case-insensitive for ASCII characters
+			conditions = append(conditions, `message LIKE ? ESCAPE '\'`)
+			args = append(args, "%"+escapeLike(q.Search)+"%")
+		}
+	}
+
+	if len(conditions) == 0 {
+		return "", nil
+	}
+	return " WHERE " + strings.Join(conditions, " AND "), args
+}
+
+func buildOrderBy(q domain.MessageQuery) string {
+	order := "DESC"
+	if q.Sort == domain.SortOrderAsc {
+		order = "ASC"
+	}
+	// id breaks the tie of messages created in the same batch
+	return fmt.Sprintf(" ORDER BY created_at %s, id %s", order, order)
+}
+
+func (s *sqliteRepository) Fetch(ctx context.Context, q domain.MessageQuery) (*[]domain.Message, int64, error) {
+	where, args := buildWhere(q)
+
+	var totalCount int64
+	if !q.SkipCount {
+		err := s.DB.QueryRowContext(ctx, "SELECT COUNT(*) FROM "+tableName+where, args...).Scan(&totalCount)
+		if err != nil {
+			return nil, 0, err
+		}
+	}
+
+	query := "SELECT " + columns + " FROM " + tableName + where + buildOrderBy(q)
+	// OFFSET of SQLite requires LIMIT, a negative limit means no limit
+	if q.Limit > 0 || q.Offset > 0 {
+		limit := q.Limit
+		if limit <= 0 {
+			limit = -1
+		}
+		query += fmt.Sprintf(" LIMIT %d OFFSET %d", limit, q.Offset)
+	}
+	rows, err := s.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+	var messages []domain.Message
+	for rows.Next() {
+		msg, err := scan(rows)
+		if err != nil {
+			return nil, 0, err
+		}
+		messages = append(messages, msg)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, 0, err
+	}
+	return &messages, totalCount, nil
+}
@@ -0,0 +1,95 @@
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/kunmingliu/messenger/domain"
+	"github.com/kunmingliu/messenger/message/repository/testsuite"
+	_sqliteMigration "github.com/kunmingliu/messenger/migration/sqlite"
+	_ "modernc.org/sqlite"
+)
+
+// Test_sqliteRepository_suite runs the shared suite against a temporary SQLite database.
+func Test_sqliteRepository_suite(t *testing.T) {
+	ctx := context.Background()
+	db, err := sql.Open("sqlite", filepath.Join(t.TempDir(), "messenger.db")+"?_pragma=busy_timeout(5000)")
+	if err != nil {
+		t.Fatalf("unexpected error:%v", err)
+	}
+	defer db.Close()
+
+	migrator := _sqliteMigration.NewMigrator(db)
+	if err = migrator.Up(ctx, migrator.Latest()); err != nil {
+		t.Fatalf("unexpected error:%v", err)
+	}
+
+	testsuite.Run(t, func(t *testing.T) domain.MessageRepository {
+		if _, err := db.ExecContext(ctx, "DELETE FROM "+tableName); err != nil {
+			t.Fatalf("unexpected error:%v", err)
+		}
+		return NewSQLiteRepository(db)
+	})
+}
+
+func Test_buildWhere(t *testing.T) {
+	from := time.Date(2022, 11, 1, 0, 0, 0, 0, time.UTC)
+
+	cases := []struct {
+		name  string
+		query domain.MessageQuery
+		where string
+		args  []interface{}
+	}{
+		{
+			name:  "empty query",
+			query: domain.MessageQuery{},
+			where: "",
+		},
+		{
+			name:  "multiple users and types",
+			query: domain.MessageQuery{UserIDs: []string{"user1", "user2"}, Types: []domain.MessageType{domain.MessageTypeImage}},
+			where: " WHERE user_id IN (?, ?) AND type IN (?)",
+			args:  []interface{}{"user1", "user2", "image"},
+		},
+		{
+			name:  "channel",
+			query: domain.MessageQuery{ChannelID: "line"},
+			where: " WHERE channel_id = ?",
+			args:  []interface{}{"line"},
+		},
+		{
+			name:  "after the cursor in ascending order",
+			query: domain.MessageQuery{From: &from, After: &domain.MessageCursor{CreatedAt: from, ID: "2"}, Sort: domain.SortOrderAsc},
+			where: " WHERE created_at >= ? AND (created_at, id) > (?, ?)",
+			args:  []interface{}{from.UnixNano(), from.UnixNano(), "2"},
+		},
+		{
+			name:  "substring search",
+			query: domain.MessageQuery{Search: "50%_off"},
+			where: ` WHERE message LIKE ? ESCAPE '\'`,
+			args:  []interface{}{`%50\%\_off%`},
+		},
+		{
+			name:  "full-text search quotes the keywords",
+			query: domain.MessageQuery{Search: `hello "world" OR`, SearchMode: domain.SearchModeText},
+			where: " WHERE rowid IN (SELECT rowid FROM message_fts WHERE message_fts MATCH ?)",
+			args:  []interface{}{`"hello" """world""" "OR"`},
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			where, args := buildWhere(c.query)
+			if where != c.where {
+				t.Errorf("where inconsistent, where:%v, expected where:%v", where, c.where)
+			}
+			if !reflect.DeepEqual(args, c.args) {
+				t.Errorf("args inconsistent, args:%v, expected args:%v", args, c.args)
+			}
+		})
+	}
+}
@@ -0,0 +1,452 @@
+// Package testsuite verifies the behavior every implementation of domain.MessageRepository should share, it's run by
+// the tests of each backend against a real database.
+package testsuite
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/kunmingliu/messenger/domain"
+)
+
+// Run runs the suite, newRepository should return a repository without any message.
+func Run(t *testing.T, newRepository func(t *testing.T) domain.MessageRepository) {
+	t.Run("Insert", func(t *testing.T) { testInsert(t, newRepository(t)) })
+	t.Run("InsertMany", func(t *testing.T) { testInsertMany(t, newRepository(t)) })
+	t.Run("InsertMany idempotent", func(t *testing.T) { testInsertManyIdempotent(t, newRepository(t)) })
+	t.Run("InsertMany per channel", func(t *testing.T) { testInsertManyPerChannel(t, newRepository(t)) })
+	t.Run("GetByID not found", func(t *testing.T) { testGetByIDNotFound(t, newRepository(t)) })
+	t.Run("StoredEventIDs", func(t *testing.T) { testStoredEventIDs(t, newRepository(t)) })
+	t.Run("UpdateStatus", func(t *testing.T) { testUpdateStatus(t, newRepository(t)) })
+	t.Run("AdvanceStatus", func(t *testing.T) { testAdvanceStatus(t, newRepository(t)) })
+	t.Run("Fetch", func(t *testing.T) { testFetch(t, newRepository(t)) })
+	t.Run("Fetch with cursor", func(t *testing.T) { testFetchCursor(t, newRepository(t)) })
+}
+
+func ids(msgs []domain.Message) []string {
+	result := make([]string, len(msgs))
+	for i, m := range msgs {
+		result[i] = m.ID
+	}
+	return result
+}
+
+func fetch(t *testing.T, repo domain.MessageRepository, q domain.MessageQuery) ([]domain.Message, int64) {
+	t.Helper()
+	msgs, totalCount, err := repo.Fetch(context.Background(), q)
+	if err != nil {
+		t.Fatalf("Fetch() error = %v", err)
+	}
+	return *msgs, totalCount
+}
+
+func testInsert(t *testing.T, repo domain.MessageRepository) {
+	ctx := context.Background()
+	expiresAt := time.Now().UTC().Add(time.Minute).Truncate(time.Millisecond)
+	msg := &domain.Message{
+		ChannelID: "line",
+		EventID:   "E1",
+		UserID:    "U1",
+		Direction: domain.DirectionInbound,
+		Type:      domain.MessageTypeLocation,
+		Payload: &domain.Payload{Location: &domain.Location{
+			Title: "title", Address: "address", Latitude: 25.03, Longitude: 121.56,
+		}},
+		ReplyToken:     "token",
+		ReplyExpiresAt: &expiresAt,
+	}
+	if err := repo.Insert(ctx, msg); err != nil {
+		t.Fatalf("Insert() error = %v", err)
+	}
+	if msg.ID == "" || msg.CreatedAt.IsZero() {
+		t.Fatalf("Insert() should set id and created time, id:%v, created time:%v", msg.ID, msg.CreatedAt)
+	}
+
+	got, err := repo.GetByID(ctx, msg.ID)
+	if err != nil {
+		t.Fatalf("GetByID() error = %v", err)
+	}
+	if got.ChannelID != msg.ChannelID || got.EventID != msg.EventID || got.UserID != msg.UserID || got.Direction != msg.Direction || got.Type != msg.Type {
+		t.Errorf("message inconsistent, message:%+v, expected message:%+v", got, msg)
+	}
+	if !reflect.DeepEqual(got.Payload, msg.Payload) {
+		t.Errorf("payload inconsistent, payload:%+v, expected payload:%+v", got.Payload, msg.Payload)
+	}
+	if got.ReplyToken != msg.ReplyToken || got.ReplyExpiresAt == nil || !got.ReplyExpiresAt.Equal(expiresAt) {
+		t.Errorf("reply token inconsistent, reply token:%v %v, expected reply token:%v %v",
+			got.ReplyToken, got.ReplyExpiresAt, msg.ReplyToken, expiresAt)
+	}
+	// databases may keep the time in a lower precision
+	if got.CreatedAt.Sub(msg.CreatedAt).Abs() >= time.Millisecond {
+		t.Errorf("created time inconsistent, created time:%v, expected created time:%v", got.CreatedAt, msg.CreatedAt)
+	}
+	if got.UpdatedAt != nil {
+		t.Errorf("updated time inconsistent, updated time:%v, expected updated time:%v", got.UpdatedAt, nil)
+	}
+}
+
+func testInsertMany(t *testing.T, repo domain.MessageRepository) {
+	ctx := context.Background()
+	if err := repo.InsertMany(ctx, nil); err != nil {
+		t.Fatalf("InsertMany() error = %v", err)
+	}
+	msgs := []domain.Message{
+		{UserID: "U1", Type: domain.MessageTypeText, Message: "first"},
+		{UserID: "U2", Type: domain.MessageTypeText, Message: "second"},
+	}
+	if err := repo.InsertMany(ctx, msgs); err != nil {
+		t.Fatalf("InsertMany() error = %v", err)
+	}
+	for _, msg := range msgs {
+		got, err := repo.GetByID(ctx, msg.ID)
+		if err != nil {
+			t.Fatalf("GetByID() error = %v", err)
+		}
+		if got.Message != msg.Message || got.UserID != msg.UserID {
+			t.Errorf("message inconsistent, message:%+v, expected message:%+v", got, msg)
+		}
+	}
+}
+
+func testInsertManyIdempotent(t *testing.T, repo domain.MessageRepository) {
+	ctx := context.Background()
+	first := []domain.Message{
+		{EventID: "E1", UserID: "U1", Message: "first"},
+		{EventID: "E2", UserID: "U1", Message: "second"},
+		{UserID: "U1", Message: "no event id"},
+	}
+	if err := repo.InsertMany(ctx, first); err != nil {
+		t.Fatalf("InsertMany() error = %v", err)
+	}
+	// the redelivered events are ignored while the messages without event id are always inserted
+	redelivered := []domain.Message{
+		{EventID: "E2", UserID: "U1", Message: "second"},
+		{EventID: "E3", UserID: "U1", Message: "third"},
+		{UserID: "U1", Message: "no event id"},
+	}
+	if err := repo.InsertMany(ctx, redelivered); err != nil {
+		t.Fatalf("InsertMany() error = %v", err)
+	}
+	if err := repo.Insert(ctx, &domain.Message{EventID: "E1", UserID: "U1", Message: "first"}); err != nil {
+		t.Fatalf("Insert() error = %v", err)
+	}
+
+	_, totalCount := fetch(t, repo, domain.MessageQuery{})
+	if totalCount != 5 {
+		t.Errorf("total count inconsistent, total count:%v, expected total count:%v", totalCount, 5)
+	}
+}
+
+func testInsertManyPerChannel(t *testing.T, repo domain.MessageRepository) {
+	ctx := context.Background()
+	msgs := []domain.Message{
+		{ChannelID: "line", EventID: "E1", UserID: "U1", Message: "line"},
+		{ChannelID: "telegram", EventID: "E1", UserID: "U1", Message: "telegram"},
+	}
+	if err := repo.InsertMany(ctx, msgs); err != nil {
+		t.Fatalf("InsertMany() error = %v", err)
+	}
+	// the event ids are only unique in a channel
+	redelivered := []domain.Message{{ChannelID: "line", EventID: "E1", UserID: "U1", Message: "line"}}
+	if err := repo.InsertMany(ctx, redelivered); err != nil {
+		t.Fatalf("InsertMany() error = %v", err)
+	}
+
+	_, totalCount := fetch(t, repo, domain.MessageQuery{})
+	if totalCount != 2 {
+		t.Errorf("total count inconsistent, total count:%v, expected total count:%v", totalCount, 2)
+	}
+	got, totalCount := fetch(t, repo, domain.MessageQuery{ChannelID: "telegram"})
+	if totalCount != 1 || len(got) != 1 || got[0].Message != "telegram" {
+		t.Errorf("messages of channel inconsistent, messages:%+v, expected message:%v", got, "telegram")
+	}
+}
+
+func testGetByIDNotFound(t *testing.T, repo domain.MessageRepository) {
+	_, err := repo.GetByID(context.Background(), "not-found")
+	if !errors.Is(err, domain.ErrNotFound) {
+		t.Errorf("error inconsistent, error:%v, expected error:%v", err, domain.ErrNotFound)
+	}
+}
+
+func testStoredEventIDs(t *testing.T, repo domain.MessageRepository) {
+	ctx := context.Background()
+	msgs := []domain.Message{
+		{ChannelID: "C1", EventID: "E1", UserID: "U1"},
+		{ChannelID: "C1", EventID: "E2", UserID: "U1"},
+		{ChannelID: "C2", EventID: "E3", UserID: "U1"},
+		{ChannelID: "C1", UserID: "U1"},
+	}
+	if err := repo.InsertMany(ctx, msgs); err != nil {
+		t.Fatalf("InsertMany() error = %v", err)
+	}
+
+	stored, err := repo.StoredEventIDs(ctx, "C1", []string{"E1", "E3", "E4", "E2"})
+	if err != nil {
+		t.Fatalf("StoredEventIDs() error = %v", err)
+	}
+	sort.Strings(stored)
+	if expected := []string{"E1", "E2"}; !reflect.DeepEqual(stored, expected) {
+		t.Errorf("event ids inconsistent, event ids:%v, expected event ids:%v", stored, expected)
+	}
+	stored, err = repo.StoredEventIDs(ctx, "C1", nil)
+	if err != nil || len(stored) != 0 {
+		t.Errorf("StoredEventIDs() = %v, %v, expected no event id", stored, err)
+	}
+}
+
+func testUpdateStatus(t *testing.T, repo domain.MessageRepository) {
+	ctx := context.Background()
+	msgs := []domain.Message{
+		{UserID: "U1", Direction: domain.DirectionOutbound, Status: domain.DeliveryStatusPending},
+		{UserID: "U2", Direction: domain.DirectionOutbound, Status: domain.DeliveryStatusPending},
+		{UserID: "U3", Direction: domain.DirectionOutbound, Status: domain.DeliveryStatusPending},
+	}
+	if err := repo.InsertMany(ctx, msgs); err != nil {
+		t.Fatalf("InsertMany() error = %v", err)
+	}
+	if err := repo.UpdateStatus(ctx, nil, domain.DeliveryStatusSent, ""); err != nil {
+		t.Fatalf("UpdateStatus() error = %v", err)
+	}
+	if err := repo.UpdateStatus(ctx, []string{msgs[0].ID, msgs[1].ID}, domain.DeliveryStatusFailed, "timeout"); err != nil {
+		t.Fatalf("UpdateStatus() error = %v", err)
+	}
+
+	for i, msg := range msgs {
+		got, err := repo.GetByID(ctx, msg.ID)
+		if err != nil {
+			t.Fatalf("GetByID() error = %v", err)
+		}
+		status, errMsg := domain.DeliveryStatusFailed, "timeout"
+		if i == 2 {
+			status, errMsg = domain.DeliveryStatusPending, ""
+		}
+		if got.Status != status || got.Error != errMsg {
+			t.Errorf("status inconsistent, status:%v %v, expected status:%v %v", got.Status, got.Error, status, errMsg)
+		}
+		if (got.UpdatedAt != nil) != (i != 2) {
+			t.Errorf("updated time inconsistent, updated time:%v", got.UpdatedAt)
+		}
+	}
+}
+
+func testAdvanceStatus(t *testing.T, repo domain.MessageRepository) {
+	ctx := context.Background()
+	msgs := []domain.Message{
+		{ChannelID: "C1", UserID: "U1", Direction: domain.DirectionOutbound, Status: domain.DeliveryStatusPending},
+		{ChannelID: "C1", UserID: "U2", Direction: domain.DirectionOutbound, Status: domain.DeliveryStatusRead},
+		{ChannelID: "C1", UserID: "U3", Direction: domain.DirectionInbound},
+		{ChannelID: "C2", UserID: "U4", Direction: domain.DirectionOutbound, Status: domain.DeliveryStatusPending},
+	}
+	if err := repo.InsertMany(ctx, msgs); err != nil {
+		t.Fatalf("InsertMany() error = %v", err)
+	}
+	from := []domain.DeliveryStatus{domain.DeliveryStatusPending, domain.DeliveryStatusSent}
+	for _, msg := range msgs {
+		update := domain.StatusUpdate{MessageID: msg.ID, ChannelID: "C1", Status: domain.DeliveryStatusDelivered, Error: "late"}
+		if err := repo.AdvanceStatus(ctx, update, from); err != nil {
+			t.Fatalf("AdvanceStatus() error = %v", err)
+		}
+	}
+	unknown := domain.StatusUpdate{MessageID: "unknown", ChannelID: "C1", Status: domain.DeliveryStatusDelivered}
+	if err := repo.AdvanceStatus(ctx, unknown, from); err != nil {
+		t.Fatalf("AdvanceStatus() error = %v", err)
+	}
+
+	// only the outbound message of the channel in one of the statuses given is updated
+	expected := []domain.DeliveryStatus{
+		domain.DeliveryStatusDelivered,
+		domain.DeliveryStatusRead,
+		"",
+		domain.DeliveryStatusPending,
+	}
+	for i, msg := range msgs {
+		got, err := repo.GetByID(ctx, msg.ID)
+		if err != nil {
+			t.Fatalf("GetByID() error = %v", err)
+		}
+		if got.Status != expected[i] {
+			t.Errorf("status inconsistent, status:%v, expected status:%v", got.Status, expected[i])
+		}
+		if (got.UpdatedAt != nil) != (i == 0) || (got.Error == "late") != (i == 0) {
+			t.Errorf("message inconsistent, message:%+v", got)
+		}
+	}
+}
+
+// seed inserts the messages one by one so that each of them has a distinct created time, the stored messages are
+// returned in the order of insertion.
+func seed(t *testing.T, repo domain.MessageRepository, msgs []domain.Message) []domain.Message {
+	t.Helper()
+	ctx := context.Background()
+	for i := range msgs {
+		if err := repo.Insert(ctx, &msgs[i]); err != nil {
+			t.Fatalf("Insert() error = %v", err)
+		}
+		// the precision of some databases is millisecond
+		time.Sleep(2 * time.Millisecond)
+	}
+	stored, _ := fetch(t, repo, domain.MessageQuery{Sort: domain.SortOrderAsc})
+	if !reflect.DeepEqual(ids(stored), ids(msgs)) {
+		t.Fatalf("order inconsistent, ids:%v, expected ids:%v", ids(stored), ids(msgs))
+	}
+	return stored
+}
+
+func testFetch(t *testing.T, repo domain.MessageRepository) {
+	m := seed(t, repo, []domain.Message{
+		{UserID: "U1", Type: domain.MessageTypeText, Message: "Hello world"},
+		{UserID: "U2", Type: domain.MessageTypeText, Message: "hello there"},
+		{UserID: "U1", Type: domain.MessageTypeImage},
+		{UserID: "U3", Type: domain.MessageTypeText, Message: "100% sure"},
+		{UserID: "U2", Type: domain.MessageTypeSticker},
+	})
+
+	tests := []struct {
+		name       string
+		query      domain.MessageQuery
+		expected   []domain.Message
+		totalCount int64
+	}{
+		{
+			name:       "newest first by default",
+			query:      domain.MessageQuery{},
+			expected:   []domain.Message{m[4], m[3], m[2], m[1], m[0]},
+			totalCount: 5,
+		},
+		{
+			name:       "oldest first",
+			query:      domain.MessageQuery{Sort: domain.SortOrderAsc},
+			expected:   m,
+			totalCount: 5,
+		},
+		{
+			name:       "single user",
+			query:      domain.MessageQuery{UserIDs: []string{"U1"}},
+			expected:   []domain.Message{m[2], m[0]},
+			totalCount: 2,
+		},
+		{
+			name:       "multiple users",
+			query:      domain.MessageQuery{UserIDs: []string{"U1", "U3"}},
+			expected:   []domain.Message{m[3], m[2], m[0]},
+			totalCount: 3,
+		},
+		{
+			name:       "types",
+			query:      domain.MessageQuery{Types: []domain.MessageType{domain.MessageTypeImage, domain.MessageTypeSticker}},
+			expected:   []domain.Message{m[4], m[2]},
+			totalCount: 2,
+		},
+		{
+			name:       "time range",
+			query:      domain.MessageQuery{From: &m[1].CreatedAt, To: &m[3].CreatedAt},
+			expected:   []domain.Message{m[3], m[2], m[1]},
+			totalCount: 3,
+		},
+		{
+			name:       "substring search ignores case",
+			query:      domain.MessageQuery{Search: "HELLO"},
+			expected:   []domain.Message{m[1], m[0]},
+			totalCount: 2,
+		},
+		{
+			name:       "substring search matches special characters literally",
+			query:      domain.MessageQuery{Search: "0% s"},
+			expected:   []domain.Message{m[3]},
+			totalCount: 1,
+		},
+		{
+			name:       "full-text search",
+			query:      domain.MessageQuery{Search: "hello", SearchMode: domain.SearchModeText},
+			expected:   []domain.Message{m[1], m[0]},
+			totalCount: 2,
+		},
+		{
+			name:       "offset and limit",
+			query:      domain.MessageQuery{Offset: 1, Limit: 2},
+			expected:   []domain.Message{m[3], m[2]},
+			totalCount: 5,
+		},
+		{
+			name:       "skip count",
+			query:      domain.MessageQuery{Limit: 1, SkipCount: true},
+			expected:   []domain.Message{m[4]},
+			totalCount: 0,
+		},
+		{
+			name:       "combined filters",
+			query:      domain.MessageQuery{UserIDs: []string{"U1", "U2"}, Types: []domain.MessageType{domain.MessageTypeText}, Sort: domain.SortOrderAsc},
+			expected:   []domain.Message{m[0], m[1]},
+			totalCount: 2,
+		},
+		{
+			name:       "no match",
+			query:      domain.MessageQuery{UserIDs: []string{"U4"}},
+			expected:   []domain.Message{},
+			totalCount: 0,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			msgs, totalCount := fetch(t, repo, tt.query)
+			if !reflect.DeepEqual(ids(msgs), ids(tt.expected)) {
+				t.Errorf("messages inconsistent, ids:%v, expected ids:%v", ids(msgs), ids(tt.expected))
+			}
+			if totalCount != tt.totalCount {
+				t.Errorf("total count inconsistent, total count:%v, expected total count:%v", totalCount, tt.totalCount)
+			}
+		})
+	}
+}
+
+func testFetchCursor(t *testing.T, repo domain.MessageRepository) {
+	ctx := context.Background()
+	m := seed(t, repo, []domain.Message{{UserID: "U1"}, {UserID: "U1"}})
+	// the messages inserted in the same batch share the same created time and are ordered by id
+	batch := make([]domain.Message, 5)
+	for i := range batch {
+		batch[i] = domain.Message{UserID: "U1"}
+	}
+	if err := repo.InsertMany(ctx, batch); err != nil {
+		t.Fatalf("InsertMany() error = %v", err)
+	}
+	all, _ := fetch(t, repo, domain.MessageQuery{Sort: domain.SortOrderAsc})
+	if len(all) != 7 || all[0].ID != m[0].ID || all[1].ID != m[1].ID {
+		t.Fatalf("messages inconsistent, ids:%v", ids(all))
+	}
+
+	for _, sort := range []domain.SortOrder{domain.SortOrderAsc, domain.SortOrderDesc} {
+		t.Run(string(sort), func(t *testing.T) {
+			expected := make([]string, len(all))
+			for i := range all {
+				if sort == domain.SortOrderAsc {
+					expected[i] = all[i].ID
+				} else {
+					expected[len(all)-1-i] = all[i].ID
+				}
+			}
+
+			var (
+				got   []string
+				after *domain.MessageCursor
+			)
+			for page := 0; page < 10; page++ {
+				msgs, _ := fetch(t, repo, domain.MessageQuery{Sort: sort, After: after, Limit: 2, SkipCount: true})
+				if len(msgs) == 0 {
+					break
+				}
+				got = append(got, ids(msgs)...)
+				cursor := domain.NewMessageCursor(msgs[len(msgs)-1])
+				after = &cursor
+			}
+			if !reflect.DeepEqual(got, expected) {
+				t.Errorf("pages inconsistent, ids:%v, expected ids:%v", got, expected)
+			}
+		})
+	}
+}
@@ -2,24 +2,74 @@ package usecase
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log"
 	"net/http"
+	"sort"
+	"strings"
 	"time"
 
 	"github.com/kunmingliu/messenger/domain"
 )
 
+// DefaultContentTimeout is how long the content of a media can take to be downloaded and stored.
+const DefaultContentTimeout = time.Minute
+
 type messageUsecase struct {
-	messageRepo     domain.MessageRepository
-	contextTimeout  time.Duration
-	messageProvider domain.Provider
+	messageRepo    domain.MessageRepository
+	contextTimeout time.Duration
+	contentTimeout time.Duration
+	providers      domain.ProviderRegistry
+	blobStore      domain.BlobStore
+	postbacks      postbackRouter
+}
+
+type Option func(*messageUsecase)
+
+// WithContentTimeout bounds the download and the storage of the content of a media together, which usually takes
+// longer than the queries bounded by the timeout of the usecase.
+func WithContentTimeout(timeout time.Duration) Option {
+	return func(m *messageUsecase) {
+		m.contentTimeout = timeout
+	}
+}
+
+func NewMessageUsecase(m domain.MessageRepository, p domain.ProviderRegistry, b domain.BlobStore, timeout time.Duration, options ...Option) domain.MessageUsecase {
+	usecase := &messageUsecase{
+		messageRepo:    m,
+		contextTimeout: timeout,
+		contentTimeout: DefaultContentTimeout,
+		providers:      p,
+		blobStore:      b,
+	}
+	for _, option := range options {
+		option(usecase)
+	}
+	if usecase.contentTimeout <= 0 {
+		usecase.contentTimeout = DefaultContentTimeout
+	}
+	return usecase
 }
 
-func NewMessageUsecase(m domain.MessageRepository, p domain.Provider, timeout time.Duration) domain.MessageUsecase {
-	return &messageUsecase{
-		messageRepo:     m,
-		contextTimeout:  timeout,
-		messageProvider: p,
+// channel resolves an empty channel id to the default channel.
+func (m *messageUsecase) channel(channelID string) string {
+	if channelID == "" {
+		return m.providers.DefaultChannel()
 	}
+	return channelID
+}
+
+// provider returns the provider of the channel, an unknown channel is a bad input.
+func (m *messageUsecase) provider(channelID string) (domain.Provider, error) {
+	p, err := m.providers.Provider(m.channel(channelID))
+	if errors.Is(err, domain.ErrNotFound) {
+		return nil, domain.ErrBadParamInput
+	}
+	return p, err
 }
 
 func (m *messageUsecase) Insert(c context.Context, msg *domain.Message) (err error) {
@@ -29,19 +79,498 @@ func (m *messageUsecase) Insert(c context.Context, msg *domain.Message) (err err
 	return
 }
 
-func (m *messageUsecase) ParseRequest(r *http.Request) (msg domain.Message, err error) {
-	msg, err = m.messageProvider.ParseRequest(r)
+func (m *messageUsecase) InsertMany(c context.Context, msgs []domain.Message) (err error) {
+	if len(msgs) == 0 {
+		return
+	}
+	stored, err := m.storedEvents(c, msgs)
+	if err != nil {
+		return
+	}
+	for i := range msgs {
+		if msgs[i].Direction == "" {
+			msgs[i].Direction = domain.DirectionInbound
+		}
+		// the event is delivered again, its postback has been handled and its content has been stored, the repository
+		// ignores it
+		if _, ok := stored[eventKey{msgs[i].ChannelID, msgs[i].EventID}]; ok {
+			continue
+		}
+		m.routePostback(c, &msgs[i])
+		if err = m.storeContent(c, &msgs[i]); err != nil {
+			return fmt.Errorf("store content of message failed, user:%s, err:%w", msgs[i].UserID, err)
+		}
+	}
+	ctx, cancel := context.WithTimeout(c, m.contextTimeout)
+	defer cancel()
+	err = m.messageRepo.InsertMany(ctx, msgs)
+	return
+}
+
+// eventKey identifies a webhook event, the event ids are only unique in a channel.
+type eventKey struct {
+	channelID string
+	eventID   string
+}
+
+// storedEvents looks up the events of the messages which have been stored.
+func (m *messageUsecase) storedEvents(c context.Context, msgs []domain.Message) (map[eventKey]struct{}, error) {
+	eventIDs := map[string][]string{}
+	for _, msg := range msgs {
+		if msg.EventID != "" {
+			eventIDs[msg.ChannelID] = append(eventIDs[msg.ChannelID], msg.EventID)
+		}
+	}
+	ctx, cancel := context.WithTimeout(c, m.contextTimeout)
+	defer cancel()
+	stored := map[eventKey]struct{}{}
+	for channelID, ids := range eventIDs {
+		found, err := m.messageRepo.StoredEventIDs(ctx, channelID, ids)
+		if err != nil {
+			return nil, err
+		}
+		for _, eventID := range found {
+			stored[eventKey{channelID, eventID}] = struct{}{}
+		}
+	}
+	return stored, nil
+}
+
+func (m *messageUsecase) HandlePostback(prefix string, h domain.PostbackHandler) {
+	m.postbacks.handle(prefix, h)
+}
+
+// routePostback runs the handler matching the postback data and keeps its outcome in the message.
+func (m *messageUsecase) routePostback(c context.Context, msg *domain.Message) {
+	if msg.Type != domain.MessageTypePostback || msg.Payload == nil || msg.Payload.Postback == nil {
+		return
+	}
+	postback := msg.Payload.Postback
+	route, ok := m.postbacks.match(postback.Data)
+	if !ok {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(c, m.contextTimeout)
+	defer cancel()
+	result, err := route.handler(ctx, *msg)
+	postback.Outcome = &domain.PostbackOutcome{
+		Prefix: route.prefix,
+		Result: result,
+	}
+	if err != nil {
+		postback.Outcome.Error = err.Error()
+	}
+}
+
+// storeContent downloads the content of a media message from the provider and saves it into the blob store. The
+// content is only kept by the provider for a limited time, so a failed download shouldn't block the message from being
+// stored and is only logged. A failed save is returned instead, so the messages are rejected and the platform can
+// deliver them again while it still has the content.
+func (m *messageUsecase) storeContent(c context.Context, msg *domain.Message) error {
+	if msg.Payload == nil || msg.Payload.Media == nil {
+		return nil
+	}
+	media := msg.Payload.Media
+	if media.ContentID == "" || media.BlobKey != "" {
+		return nil
+	}
+
+	provider, err := m.provider(msg.ChannelID)
+	if err != nil {
+		log.Printf("download content of message failed, user:%s, err:%v", msg.UserID, err)
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(c, m.contentTimeout)
+	defer cancel()
+	content, contentType, err := provider.GetContent(ctx, media.ContentID)
+	if err != nil {
+		log.Printf("download content of message failed, user:%s, err:%v", msg.UserID, err)
+		return nil
+	}
+	defer content.Close()
+
+	key := blobKey(msg.ChannelID, media.ContentID)
+	if err = m.blobStore.Put(ctx, key, content); err != nil {
+		return err
+	}
+	media.BlobKey = key
+	media.ContentType = contentType
+	return nil
+}
+
+// blobKey is where the content is kept in the blob store. Content ids are only unique in a platform, so the contents of
+// each channel are kept apart, and the ids which can't be keys, e.g. urls, are hashed.
+func blobKey(channelID, contentID string) string {
+	if strings.ContainsAny(contentID, `/\`) {
+		sum := sha256.Sum256([]byte(contentID))
+		contentID = hex.EncodeToString(sum[:])
+	}
+	if channelID == "" {
+		return contentID
+	}
+	return channelID + "_" + contentID
+}
+
+func (m *messageUsecase) GetContent(c context.Context, id string) (content io.ReadCloser, contentType string, err error) {
+	ctx, cancel := context.WithTimeout(c, m.contextTimeout)
+	defer cancel()
+	msg, err := m.messageRepo.GetByID(ctx, id)
+	if err != nil {
+		return
+	}
+	if msg.Payload == nil || msg.Payload.Media == nil || msg.Payload.Media.BlobKey == "" {
+		err = domain.ErrNotFound
+		return
+	}
+
+	// the reader outlives this call, so it must not be bound to the timeout context above
+	content, err = m.blobStore.Get(c, msg.Payload.Media.BlobKey)
+	if err != nil {
+		return
+	}
+	contentType = msg.Payload.Media.ContentType
 	return
 }
 
-func (m *messageUsecase) Send(msg string) (err error) {
-	err = m.messageProvider.SendMessage(msg)
+func (m *messageUsecase) ParseRequest(channelID string, r *http.Request) (webhook domain.Webhook, err error) {
+	channelID = m.channel(channelID)
+	provider, err := m.providers.Provider(channelID)
+	if err != nil {
+		return
+	}
+	webhook, err = provider.ParseRequest(r)
+	if err != nil {
+		return
+	}
+	for i := range webhook.Messages {
+		webhook.Messages[i].ChannelID = channelID
+	}
+	for i := range webhook.Events {
+		webhook.Events[i].ChannelID = channelID
+	}
+	for i := range webhook.Statuses {
+		webhook.Statuses[i].ChannelID = channelID
+	}
 	return
 }
 
-func (m *messageUsecase) GetByUserID(c context.Context, offset, limit int64, userID ...string) (messages *[]domain.Message, totalCount int64, err error) {
+// multicastChunkSize is the maximum number of recipients of a multicast.
+const multicastChunkSize = 500
+
+func (m *messageUsecase) Send(c context.Context, channelID string, msg string, userIDs ...string) (results []domain.SendResult, err error) {
+	channelID = m.channel(channelID)
+	provider, err := m.provider(channelID)
+	if err != nil {
+		return
+	}
+	text := domain.Message{Type: domain.MessageTypeText, Message: msg}
+
+	if len(userIDs) == 0 {
+		if b, ok := provider.(domain.Broadcaster); ok && !b.CanBroadcast() {
+			err = fmt.Errorf("%w: channel %s can't broadcast", domain.ErrBadParamInput, channelID)
+			return
+		}
+		outbound, err := m.insertOutbound(c, channelID, text, "")
+		if err != nil {
+			return nil, err
+		}
+		if sender, ok := provider.(domain.OutboundSender); ok {
+			return nil, m.pushEach(c, sender.SendOutbound, outbound)
+		}
+		err = provider.SendMessage(c, msg)
+		m.updateStatus(c, outbound, err)
+		return nil, err
+	}
+
+	recipients := unique(userIDs)
+	if len(recipients) == 0 {
+		err = domain.ErrBadParamInput
+		return
+	}
+	// every recipient is recorded before sending so nothing we told users is missing from the history
+	outbound, err := m.insertOutbound(c, channelID, text, recipients...)
+	if err != nil {
+		return
+	}
+
+	push, pushed := pushOne(provider)
+	results = sendChunks(outbound, func(chunk []domain.Message, userIDs []string) error {
+		if pushed {
+			return m.pushEach(c, push, chunk)
+		}
+		var sendErr error
+		if len(chunk) == 1 {
+			sendErr = provider.PushMessage(c, userIDs[0], msg)
+		} else {
+			sendErr = provider.Multicast(c, userIDs, msg)
+		}
+		m.updateStatus(c, chunk, sendErr)
+		return sendErr
+	})
+	return
+}
+
+func (m *messageUsecase) SendTemplate(c context.Context, channelID string, template domain.Template, userIDs ...string) (results []domain.SendResult, err error) {
+	if template.Name == "" || template.Language == "" {
+		err = fmt.Errorf("%w: name and language of the template shouldn't be empty", domain.ErrBadParamInput)
+		return
+	}
+	channelID = m.channel(channelID)
+	provider, err := m.provider(channelID)
+	if err != nil {
+		return
+	}
+	tracker, tracked := provider.(domain.TrackingProvider)
+	sender, ok := provider.(domain.TemplateSender)
+	if !tracked || !ok || !sender.CanSendTemplates() {
+		err = fmt.Errorf("%w: channel %s can't send templates", domain.ErrBadParamInput, channelID)
+		return
+	}
+	recipients := unique(userIDs)
+	if len(recipients) == 0 {
+		err = domain.ErrBadParamInput
+		return
+	}
+	outbound, err := m.insertOutbound(c, channelID, domain.Message{
+		Type:    domain.MessageTypeTemplate,
+		Message: template.Name,
+		Payload: &domain.Payload{Template: &template},
+	}, recipients...)
+	if err != nil {
+		return
+	}
+
+	results = sendChunks(outbound, func(chunk []domain.Message, userIDs []string) error {
+		return m.pushEach(c, tracker.PushTracked, chunk)
+	})
+	return
+}
+
+// sendChunks sends the outbound messages in chunks of multicastChunkSize and reports the result of each chunk.
+func sendChunks(outbound []domain.Message, send func(chunk []domain.Message, userIDs []string) error) (results []domain.SendResult) {
+	for start := 0; start < len(outbound); start += multicastChunkSize {
+		end := start + multicastChunkSize
+		if end > len(outbound) {
+			end = len(outbound)
+		}
+		chunk := outbound[start:end]
+		userIDs := make([]string, len(chunk))
+		for i := range chunk {
+			userIDs[i] = chunk[i].UserID
+		}
+
+		result := domain.SendResult{UserIDs: userIDs}
+		if err := send(chunk, userIDs); err != nil {
+			result.Error = err.Error()
+		}
+		results = append(results, result)
+	}
+	return
+}
+
+// pushOne returns how the provider pushes a stored message by itself, if it tracks the delivery of each message or
+// sends them with their ids.
+func pushOne(provider domain.Provider) (push func(ctx context.Context, msg domain.Message) error, ok bool) {
+	switch p := provider.(type) {
+	case domain.TrackingProvider:
+		return p.PushTracked, true
+	case domain.OutboundSender:
+		return p.SendOutbound, true
+	}
+	return nil, false
+}
+
+// pushEach pushes the messages one by one, a message pushed is marked as sent unless the platform has already reported
+// a later status.
+func (m *messageUsecase) pushEach(c context.Context, push func(ctx context.Context, msg domain.Message) error, outbound []domain.Message) error {
+	var (
+		failed  []string
+		lastErr error
+	)
+	for i := range outbound {
+		if err := push(c, outbound[i]); err != nil {
+			m.updateStatus(c, outbound[i:i+1], err)
+			failed = append(failed, outbound[i].UserID)
+			lastErr = err
+			continue
+		}
+		sent := domain.StatusUpdate{MessageID: outbound[i].ID, ChannelID: outbound[i].ChannelID, Status: domain.DeliveryStatusSent}
+		if err := m.applyStatus(c, sent); err != nil {
+			log.Printf("update status of outbound message failed, id:%s, err:%v", outbound[i].ID, err)
+		}
+	}
+	switch {
+	case len(failed) == 0:
+		return nil
+	case len(outbound) == 1:
+		return lastErr
+	default:
+		return fmt.Errorf("send to %d of %d users failed, users:%s, err:%w",
+			len(failed), len(outbound), strings.Join(failed, ","), lastErr)
+	}
+}
+
+// insertOutbound records the content for each recipient as pending, an empty recipient means a broadcast. Only the
+// type, the message and the payload of the content are used.
+func (m *messageUsecase) insertOutbound(c context.Context, channelID string, content domain.Message, userIDs ...string) ([]domain.Message, error) {
+	outbound := make([]domain.Message, len(userIDs))
+	for i, userID := range userIDs {
+		outbound[i] = domain.Message{
+			ChannelID: channelID,
+			UserID:    userID,
+			Direction: domain.DirectionOutbound,
+			Type:      content.Type,
+			Message:   content.Message,
+			Payload:   content.Payload,
+			Status:    domain.DeliveryStatusPending,
+		}
+	}
+	ctx, cancel := context.WithTimeout(c, m.contextTimeout)
+	defer cancel()
+	err := m.messageRepo.InsertMany(ctx, outbound)
+	return outbound, err
+}
+
+// updateStatus marks the outbound messages as sent or failed according to the error returned by the provider.
+func (m *messageUsecase) updateStatus(c context.Context, outbound []domain.Message, sendErr error) {
+	ids := make([]string, len(outbound))
+	for i := range outbound {
+		ids[i] = outbound[i].ID
+	}
+	status, errMsg := domain.DeliveryStatusSent, ""
+	if sendErr != nil {
+		status, errMsg = domain.DeliveryStatusFailed, sendErr.Error()
+	}
+
+	ctx, cancel := context.WithTimeout(c, m.contextTimeout)
+	defer cancel()
+	if err := m.messageRepo.UpdateStatus(ctx, ids, status, errMsg); err != nil {
+		log.Printf("update status of outbound messages failed, ids:%v, err:%v", ids, err)
+	}
+}
+
+// statusOrder ranks the statuses by progress, failed and read are both final.
+var statusOrder = map[domain.DeliveryStatus]int{
+	domain.DeliveryStatusPending:   0,
+	domain.DeliveryStatusSent:      1,
+	domain.DeliveryStatusDelivered: 2,
+	domain.DeliveryStatusRead:      3,
+	domain.DeliveryStatusFailed:    3,
+}
+
+func (m *messageUsecase) UpdateStatuses(c context.Context, statuses []domain.StatusUpdate) error {
+	for _, status := range statuses {
+		if err := m.applyStatus(c, status); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// applyStatus updates the outbound message if the status is later than the stored one, since the platforms don't
+// report the statuses in order. The statuses of messages not sent by the channel are ignored, e.g. the ones sent by
+// other tools of the account.
+func (m *messageUsecase) applyStatus(c context.Context, status domain.StatusUpdate) error {
+	next, ok := statusOrder[status.Status]
+	if !ok {
+		return fmt.Errorf("%w: unknown delivery status %q", domain.ErrBadParamInput, status.Status)
+	}
+	// the repository compares the stored status, so a callback racing with the send can't be overwritten
+	var from []domain.DeliveryStatus
+	for s, order := range statusOrder {
+		if order < next {
+			from = append(from, s)
+		}
+	}
+	sort.Slice(from, func(i, j int) bool {
+		return statusOrder[from[i]] < statusOrder[from[j]]
+	})
+	ctx, cancel := context.WithTimeout(c, m.contextTimeout)
+	defer cancel()
+	return m.messageRepo.AdvanceStatus(ctx, status, from)
+}
+
+// unique removes the duplicated and empty ids but keeps the order.
+func unique(ids []string) []string {
+	seen := make(map[string]struct{}, len(ids))
+	result := make([]string, 0, len(ids))
+	for _, id := range ids {
+		if _, ok := seen[id]; ok || id == "" {
+			continue
+		}
+		seen[id] = struct{}{}
+		result = append(result, id)
+	}
+	return result
+}
+
+func (m *messageUsecase) Reply(c context.Context, id string, msg string) (err error) {
+	ctx, cancel := context.WithTimeout(c, m.contextTimeout)
+	defer cancel()
+	origin, err := m.messageRepo.GetByID(ctx, id)
+	if err != nil {
+		return
+	}
+	// the reply goes through the channel the message is received by
+	channelID := m.channel(origin.ChannelID)
+	provider, err := m.provider(channelID)
+	if err != nil {
+		return
+	}
+	outbound, err := m.insertOutbound(c, channelID, domain.Message{Type: domain.MessageTypeText, Message: msg}, origin.UserID)
+	if err != nil {
+		return
+	}
+	// the providers pushing the stored messages by themselves have no reply tokens
+	if push, ok := pushOne(provider); ok {
+		return m.pushEach(c, push, outbound)
+	}
+	defer func() {
+		m.updateStatus(c, outbound, err)
+	}()
+
+	if origin.ReplyToken != "" && origin.ReplyExpiresAt != nil && time.Now().Before(*origin.ReplyExpiresAt) {
+		// a reply token can only be used once, so push the message if it has been consumed
+		if err = provider.ReplyMessage(c, origin.ReplyToken, msg); err == nil {
+			return
+		}
+		log.Printf("reply message failed and fall back to push, id:%s, err:%v", id, err)
+	}
+	err = provider.PushMessage(c, origin.UserID, msg)
+	return
+}
+
+func validateQuery(q domain.MessageQuery) error {
+	// a query is always bounded, the repositories would fetch every message without a limit
+	if q.Offset < 0 || q.Limit < 1 {
+		return domain.ErrBadParamInput
+	}
+	if q.After != nil && q.Offset > 0 {
+		return domain.ErrBadParamInput
+	}
+	if q.From != nil && q.To != nil && q.From.After(*q.To) {
+		return domain.ErrBadParamInput
+	}
+	switch q.Sort {
+	case "", domain.SortOrderAsc, domain.SortOrderDesc:
+	default:
+		return domain.ErrBadParamInput
+	}
+	switch q.SearchMode {
+	case "", domain.SearchModeSubstring, domain.SearchModeText:
+	default:
+		return domain.ErrBadParamInput
+	}
+	return nil
+}
+
+func (m *messageUsecase) Fetch(c context.Context, query domain.MessageQuery) (messages *[]domain.Message, totalCount int64, err error) {
+	if err = validateQuery(query); err != nil {
+		return
+	}
 	ctx, cancel := context.WithTimeout(c, m.contextTimeout)
 	defer cancel()
-	messages, totalCount, err = m.messageRepo.GetByUserID(ctx, offset, limit, userID...)
+	messages, totalCount, err = m.messageRepo.Fetch(ctx, query)
 	return
 }
@@ -3,15 +3,33 @@ package usecase
 import (
 	"context"
 	"errors"
+	"fmt"
+	"io"
 	"net/http"
+	"reflect"
+	"strings"
 	"testing"
 	"time"
 
 	"github.com/golang/mock/gomock"
 	"github.com/kunmingliu/messenger/domain"
 	mockDomain "github.com/kunmingliu/messenger/internal/mocks/domain"
+	_messageMemoryRepo "github.com/kunmingliu/messenger/message/repository/memory"
+	"github.com/kunmingliu/messenger/provider"
 )
 
+// newRegistry registers the providers as the channels named "channel0", "channel1" and so on, the first one is the
+// default channel.
+func newRegistry(t *testing.T, providers ...domain.Provider) domain.ProviderRegistry {
+	registry := provider.NewRegistry()
+	for i, p := range providers {
+		if err := registry.Register(fmt.Sprintf("channel%d", i), p); err != nil {
+			t.Fatalf("register provider failed, err:%v", err)
+		}
+	}
+	return registry
+}
+
 func Test_messageUsecase_Insert(t *testing.T) {
 
 	ctl := gomock.NewController(t)
@@ -22,6 +40,7 @@ func Test_messageUsecase_Insert(t *testing.T) {
 
 	mockRepository := mockDomain.NewMockMessageRepository(ctl)
 	mockProvider := mockDomain.NewMockProvider(ctl)
+	mockBlobStore := mockDomain.NewMockBlobStore(ctl)
 
 	m := &domain.Message{}
 	fakeError := errors.New("fake error")
@@ -31,7 +50,7 @@ func Test_messageUsecase_Insert(t *testing.T) {
 		mockRepository.EXPECT().Insert(gomock.Any(), m).Return(fakeError),
 	)
 
-	usecase := NewMessageUsecase(mockRepository, mockProvider, timeout)
+	usecase := NewMessageUsecase(mockRepository, newRegistry(t, mockProvider), mockBlobStore, timeout)
 
 	err := usecase.Insert(backgroundCtx, m)
 	if err != nil {
@@ -43,6 +62,774 @@ func Test_messageUsecase_Insert(t *testing.T) {
 	}
 }
 
+func Test_messageUsecase_InsertMany(t *testing.T) {
+	ctl := gomock.NewController(t)
+	defer ctl.Finish()
+
+	timeout := time.Second * 5
+	backgroundCtx := context.Background()
+
+	mockRepository := mockDomain.NewMockMessageRepository(ctl)
+	mockProvider := mockDomain.NewMockProvider(ctl)
+	mockBlobStore := mockDomain.NewMockBlobStore(ctl)
+
+	msgs := []domain.Message{
+		{UserID: "123", Message: "test message1"},
+		{UserID: "456", Message: "test message2"},
+	}
+	fakeError := errors.New("fake error")
+	gomock.InOrder(
+		mockRepository.EXPECT().InsertMany(gomock.Any(), msgs).Return(nil),
+		mockRepository.EXPECT().InsertMany(gomock.Any(), msgs).Return(fakeError),
+	)
+
+	usecase := NewMessageUsecase(mockRepository, newRegistry(t, mockProvider), mockBlobStore, timeout)
+
+	err := usecase.InsertMany(backgroundCtx, msgs)
+	if err != nil {
+		t.Errorf("unexpected error:%v", err)
+	}
+	for _, msg := range msgs {
+		if msg.Direction != domain.DirectionInbound {
+			t.Errorf("direction inconsistent, direction:%v, expected direction:%v", msg.Direction, domain.DirectionInbound)
+		}
+	}
+	err = usecase.InsertMany(backgroundCtx, msgs)
+	if err == nil || err.Error() != fakeError.Error() {
+		t.Errorf("error inconsistent, caught error:%v, expected error:%v", err, fakeError)
+	}
+	// an empty batch should never reach the repository
+	err = usecase.InsertMany(backgroundCtx, nil)
+	if err != nil {
+		t.Errorf("unexpected error:%v", err)
+	}
+}
+
+func Test_messageUsecase_InsertManyWithContent(t *testing.T) {
+	ctl := gomock.NewController(t)
+	defer ctl.Finish()
+
+	timeout := time.Second * 5
+	backgroundCtx := context.Background()
+
+	mockRepository := mockDomain.NewMockMessageRepository(ctl)
+	mockProvider := mockDomain.NewMockProvider(ctl)
+	mockBlobStore := mockDomain.NewMockBlobStore(ctl)
+
+	msgs := []domain.Message{
+		{
+			UserID: "123",
+			Type:   domain.MessageTypeImage,
+			Payload: &domain.Payload{
+				Media: &domain.Media{ContentID: "c1"},
+			},
+		},
+		{
+			UserID: "456",
+			Type:   domain.MessageTypeAudio,
+			Payload: &domain.Payload{
+				Media: &domain.Media{ContentID: "c2"},
+			},
+		},
+		{UserID: "789", Type: domain.MessageTypeText, Message: "test message"},
+	}
+	fakeError := errors.New("fake error")
+	gomock.InOrder(
+		mockProvider.EXPECT().GetContent(gomock.Any(), "c1").Return(io.NopCloser(strings.NewReader("image")), "image/jpeg", nil),
+		mockBlobStore.EXPECT().Put(gomock.Any(), "c1", gomock.Any()).Return(nil),
+		mockProvider.EXPECT().GetContent(gomock.Any(), "c2").Return(nil, "", fakeError),
+		mockRepository.EXPECT().InsertMany(gomock.Any(), msgs).Return(nil),
+	)
+
+	usecase := NewMessageUsecase(mockRepository, newRegistry(t, mockProvider), mockBlobStore, timeout)
+
+	err := usecase.InsertMany(backgroundCtx, msgs)
+	if err != nil {
+		t.Errorf("unexpected error:%v", err)
+	}
+	media := msgs[0].Payload.Media
+	if media.BlobKey != "c1" || media.ContentType != "image/jpeg" {
+		t.Errorf("media inconsistent, media:%+v", media)
+	}
+	// a failed download should still keep the message
+	media = msgs[1].Payload.Media
+	if media.BlobKey != "" {
+		t.Errorf("blob key should be empty, media:%+v", media)
+	}
+}
+
+func Test_messageUsecase_InsertManyWithContentNotSaved(t *testing.T) {
+	ctl := gomock.NewController(t)
+	defer ctl.Finish()
+
+	timeout := time.Second * 5
+	backgroundCtx := context.Background()
+
+	mockRepository := mockDomain.NewMockMessageRepository(ctl)
+	mockProvider := mockDomain.NewMockProvider(ctl)
+	mockBlobStore := mockDomain.NewMockBlobStore(ctl)
+
+	msgs := []domain.Message{
+		{UserID: "789", Type: domain.MessageTypeText, Message: "test message"},
+		{
+			UserID: "123",
+			Type:   domain.MessageTypeImage,
+			Payload: &domain.Payload{
+				Media: &domain.Media{ContentID: "c1"},
+			},
+		},
+	}
+	fakeError := errors.New("fake error")
+	gomock.InOrder(
+		mockProvider.EXPECT().GetContent(gomock.Any(), "c1").Return(io.NopCloser(strings.NewReader("image")), "image/jpeg", nil),
+		mockBlobStore.EXPECT().Put(gomock.Any(), "c1", gomock.Any()).Return(fakeError),
+	)
+
+	usecase := NewMessageUsecase(mockRepository, newRegistry(t, mockProvider), mockBlobStore, timeout)
+
+	// the messages are rejected so the platform delivers them again, none of them should be stored
+	err := usecase.InsertMany(backgroundCtx, msgs)
+	if !errors.Is(err, fakeError) {
+		t.Errorf("error inconsistent, err:%v, expected err:%v", err, fakeError)
+	}
+}
+
+func Test_messageUsecase_InsertManyWithContentTimeout(t *testing.T) {
+	ctl := gomock.NewController(t)
+	defer ctl.Finish()
+
+	backgroundCtx := context.Background()
+	mockRepository := mockDomain.NewMockMessageRepository(ctl)
+	mockProvider := mockDomain.NewMockProvider(ctl)
+	mockBlobStore := mockDomain.NewMockBlobStore(ctl)
+
+	msgs := []domain.Message{
+		{UserID: "123", Type: domain.MessageTypeVideo, Payload: &domain.Payload{Media: &domain.Media{ContentID: "c1"}}},
+	}
+	// the content is bounded by its own timeout instead of the one of the queries
+	withinContentTimeout := func(ctx context.Context) error {
+		if deadline, ok := ctx.Deadline(); !ok || time.Until(deadline) < time.Second {
+			return fmt.Errorf("deadline inconsistent, deadline:%v, expected the content timeout", deadline)
+		}
+		return nil
+	}
+	gomock.InOrder(
+		mockProvider.EXPECT().GetContent(gomock.Any(), "c1").DoAndReturn(func(ctx context.Context, contentID string) (io.ReadCloser, string, error) {
+			return io.NopCloser(strings.NewReader("video")), "video/mp4", withinContentTimeout(ctx)
+		}),
+		mockBlobStore.EXPECT().Put(gomock.Any(), "c1", gomock.Any()).DoAndReturn(func(ctx context.Context, key string, content io.Reader) error {
+			return withinContentTimeout(ctx)
+		}),
+		mockRepository.EXPECT().InsertMany(gomock.Any(), msgs).Return(nil),
+	)
+
+	usecase := NewMessageUsecase(mockRepository, newRegistry(t, mockProvider), mockBlobStore, time.Millisecond, WithContentTimeout(time.Minute))
+	if err := usecase.InsertMany(backgroundCtx, msgs); err != nil {
+		t.Fatalf("unexpected error:%v", err)
+	}
+	if media := msgs[0].Payload.Media; media.BlobKey != "c1" {
+		t.Errorf("media inconsistent, media:%+v", media)
+	}
+}
+
+func Test_messageUsecase_InsertManyRedelivered(t *testing.T) {
+	ctl := gomock.NewController(t)
+	defer ctl.Finish()
+
+	backgroundCtx := context.Background()
+	repository := _messageMemoryRepo.NewMemoryRepository()
+	mockProvider := mockDomain.NewMockProvider(ctl)
+	mockBlobStore := mockDomain.NewMockBlobStore(ctl)
+	usecase := NewMessageUsecase(repository, newRegistry(t, mockProvider), mockBlobStore, time.Second*5)
+
+	handled := 0
+	usecase.HandlePostback("action=", func(ctx context.Context, msg domain.Message) (string, error) {
+		handled++
+		return "done", nil
+	})
+	// the content is downloaded and saved only once
+	gomock.InOrder(
+		mockProvider.EXPECT().GetContent(gomock.Any(), "c1").Return(io.NopCloser(strings.NewReader("image")), "image/jpeg", nil),
+		mockBlobStore.EXPECT().Put(gomock.Any(), "channel0_c1", gomock.Any()).Return(nil),
+	)
+
+	newMsgs := func() []domain.Message {
+		return []domain.Message{
+			{
+				ChannelID: "channel0", EventID: "e1", UserID: "123", Type: domain.MessageTypePostback,
+				Payload: &domain.Payload{Postback: &domain.Postback{Data: "action=buy"}},
+			},
+			{
+				ChannelID: "channel0", EventID: "e2", UserID: "123", Type: domain.MessageTypeImage,
+				Payload: &domain.Payload{Media: &domain.Media{ContentID: "c1"}},
+			},
+		}
+	}
+	for i := 0; i < 2; i++ {
+		if err := usecase.InsertMany(backgroundCtx, newMsgs()); err != nil {
+			t.Fatalf("unexpected error:%v", err)
+		}
+	}
+	if handled != 1 {
+		t.Errorf("handled inconsistent, handled:%d, expected the postback to be handled once", handled)
+	}
+	msgs, totalCount, err := repository.Fetch(backgroundCtx, domain.MessageQuery{Limit: 10})
+	if err != nil || totalCount != 2 {
+		t.Fatalf("messages inconsistent, messages:%+v, err:%v", msgs, err)
+	}
+}
+
+func Test_messageUsecase_InsertManyWithPostback(t *testing.T) {
+	ctl := gomock.NewController(t)
+	defer ctl.Finish()
+
+	timeout := time.Second * 5
+	backgroundCtx := context.Background()
+
+	mockRepository := mockDomain.NewMockMessageRepository(ctl)
+	mockProvider := mockDomain.NewMockProvider(ctl)
+	mockBlobStore := mockDomain.NewMockBlobStore(ctl)
+
+	newPostback := func(data string) domain.Message {
+		return domain.Message{
+			UserID: "123",
+			Type:   domain.MessageTypePostback,
+			Payload: &domain.Payload{
+				Postback: &domain.Postback{Data: data},
+			},
+		}
+	}
+	msgs := []domain.Message{
+		newPostback("action=buy&item=1"),
+		newPostback("action=cancel"),
+		newPostback("unknown"),
+	}
+	fakeError := errors.New("fake error")
+	mockRepository.EXPECT().InsertMany(gomock.Any(), msgs).Return(nil)
+
+	usecase := NewMessageUsecase(mockRepository, newRegistry(t, mockProvider), mockBlobStore, timeout)
+	usecase.HandlePostback("action=", func(ctx context.Context, msg domain.Message) (string, error) {
+		return "", fakeError
+	})
+	usecase.HandlePostback("action=buy", func(ctx context.Context, msg domain.Message) (string, error) {
+		return "bought by " + msg.UserID, nil
+	})
+
+	err := usecase.InsertMany(backgroundCtx, msgs)
+	if err != nil {
+		t.Errorf("unexpected error:%v", err)
+	}
+
+	expected := []*domain.PostbackOutcome{
+		{Prefix: "action=buy", Result: "bought by 123"},
+		{Prefix: "action=", Error: fakeError.Error()},
+		nil,
+	}
+	for i, msg := range msgs {
+		outcome := msg.Payload.Postback.Outcome
+		if !reflect.DeepEqual(outcome, expected[i]) {
+			t.Errorf("outcome inconsistent, outcome:%+v, expected outcome:%+v", outcome, expected[i])
+		}
+	}
+}
+
+func Test_messageUsecase_GetContent(t *testing.T) {
+	ctl := gomock.NewController(t)
+	defer ctl.Finish()
+
+	timeout := time.Second * 5
+	backgroundCtx := context.Background()
+
+	mockRepository := mockDomain.NewMockMessageRepository(ctl)
+	mockProvider := mockDomain.NewMockProvider(ctl)
+	mockBlobStore := mockDomain.NewMockBlobStore(ctl)
+
+	mediaMsg := &domain.Message{
+		ID:   "1",
+		Type: domain.MessageTypeImage,
+		Payload: &domain.Payload{
+			Media: &domain.Media{ContentID: "c1", BlobKey: "c1", ContentType: "image/jpeg"},
+		},
+	}
+	textMsg := &domain.Message{ID: "2", Type: domain.MessageTypeText, Message: "test message"}
+	gomock.InOrder(
+		mockRepository.EXPECT().GetByID(gomock.Any(), "1").Return(mediaMsg, nil),
+		mockBlobStore.EXPECT().Get(gomock.Any(), "c1").Return(io.NopCloser(strings.NewReader("image")), nil),
+		mockRepository.EXPECT().GetByID(gomock.Any(), "2").Return(textMsg, nil),
+		mockRepository.EXPECT().GetByID(gomock.Any(), "3").Return(nil, domain.ErrNotFound),
+	)
+
+	usecase := NewMessageUsecase(mockRepository, newRegistry(t, mockProvider), mockBlobStore, timeout)
+
+	content, contentType, err := usecase.GetContent(backgroundCtx, "1")
+	if err != nil {
+		t.Fatalf("unexpected error:%v", err)
+	}
+	defer content.Close()
+	b, _ := io.ReadAll(content)
+	if string(b) != "image" || contentType != "image/jpeg" {
+		t.Errorf("content inconsistent, content:%s, content type:%s", b, contentType)
+	}
+
+	_, _, err = usecase.GetContent(backgroundCtx, "2")
+	if err != domain.ErrNotFound {
+		t.Errorf("error inconsistent, caught error:%v, expected error:%v", err, domain.ErrNotFound)
+	}
+
+	_, _, err = usecase.GetContent(backgroundCtx, "3")
+	if err != domain.ErrNotFound {
+		t.Errorf("error inconsistent, caught error:%v, expected error:%v", err, domain.ErrNotFound)
+	}
+}
+
+// assignIDs mimics the repository which assigns an id to every inserted message.
+func assignIDs(ctx context.Context, msgs []domain.Message) error {
+	for i := range msgs {
+		msgs[i].ID = msgs[i].UserID + "-id"
+	}
+	return nil
+}
+
+func Test_messageUsecase_Send(t *testing.T) {
+	ctl := gomock.NewController(t)
+	defer ctl.Finish()
+
+	timeout := time.Second * 5
+	backgroundCtx := context.Background()
+
+	mockRepository := mockDomain.NewMockMessageRepository(ctl)
+	mockProvider := mockDomain.NewMockProvider(ctl)
+	mockBlobStore := mockDomain.NewMockBlobStore(ctl)
+
+	userIDs := make([]string, 0, 1001)
+	ids := make([]string, 0, 1001)
+	for i := 0; i < 1001; i++ {
+		userIDs = append(userIDs, fmt.Sprintf("user%d", i))
+		ids = append(ids, fmt.Sprintf("user%d-id", i))
+	}
+	fakeError := errors.New("fake error")
+	gomock.InOrder(
+		mockRepository.EXPECT().InsertMany(gomock.Any(), gomock.Len(1)).DoAndReturn(assignIDs),
+		mockProvider.EXPECT().SendMessage(gomock.Any(), "broadcast").Return(nil),
+		mockRepository.EXPECT().UpdateStatus(gomock.Any(), []string{"-id"}, domain.DeliveryStatusSent, "").Return(nil),
+
+		mockRepository.EXPECT().InsertMany(gomock.Any(), gomock.Len(1)).DoAndReturn(assignIDs),
+		mockProvider.EXPECT().PushMessage(gomock.Any(), "user0", "push").Return(nil),
+		mockRepository.EXPECT().UpdateStatus(gomock.Any(), []string{"user0-id"}, domain.DeliveryStatusSent, "").Return(nil),
+
+		mockRepository.EXPECT().InsertMany(gomock.Any(), gomock.Len(1001)).DoAndReturn(assignIDs),
+		mockProvider.EXPECT().Multicast(gomock.Any(), userIDs[:500], "multicast").Return(nil),
+		mockRepository.EXPECT().UpdateStatus(gomock.Any(), ids[:500], domain.DeliveryStatusSent, "").Return(nil),
+		mockProvider.EXPECT().Multicast(gomock.Any(), userIDs[500:1000], "multicast").Return(fakeError),
+		mockRepository.EXPECT().UpdateStatus(gomock.Any(), ids[500:1000], domain.DeliveryStatusFailed, fakeError.Error()).Return(nil),
+		mockProvider.EXPECT().PushMessage(gomock.Any(), "user1000", "multicast").Return(nil),
+		mockRepository.EXPECT().UpdateStatus(gomock.Any(), ids[1000:], domain.DeliveryStatusSent, "").Return(nil),
+
+		// nothing is sent if the outbound messages can't be recorded
+		mockRepository.EXPECT().InsertMany(gomock.Any(), gomock.Len(1)).Return(fakeError),
+	)
+
+	usecase := NewMessageUsecase(mockRepository, newRegistry(t, mockProvider), mockBlobStore, timeout)
+
+	results, err := usecase.Send(backgroundCtx, "", "broadcast")
+	if err != nil || results != nil {
+		t.Errorf("unexpected result:%v, error:%v", results, err)
+	}
+
+	// duplicated and empty ids are dropped
+	results, err = usecase.Send(backgroundCtx, "", "push", "user0", "user0", "")
+	if err != nil {
+		t.Errorf("unexpected error:%v", err)
+	}
+	if !reflect.DeepEqual(results, []domain.SendResult{{UserIDs: []string{"user0"}}}) {
+		t.Errorf("results inconsistent, results:%v", results)
+	}
+
+	results, err = usecase.Send(backgroundCtx, "", "multicast", userIDs...)
+	if err != nil {
+		t.Errorf("unexpected error:%v", err)
+	}
+	if len(results) != 3 {
+		t.Fatalf("results inconsistent, length:%v, expected length:%v", len(results), 3)
+	}
+	if results[0].Error != "" || results[1].Error != fakeError.Error() || results[2].Error != "" {
+		t.Errorf("results inconsistent, results:%v", results)
+	}
+	if len(results[0].UserIDs) != 500 || len(results[1].UserIDs) != 500 || len(results[2].UserIDs) != 1 {
+		t.Errorf("chunks inconsistent, lengths:%v, %v, %v", len(results[0].UserIDs), len(results[1].UserIDs), len(results[2].UserIDs))
+	}
+
+	_, err = usecase.Send(backgroundCtx, "", "not recorded", "user0")
+	if err != fakeError {
+		t.Errorf("error inconsistent, caught error:%v, expected error:%v", err, fakeError)
+	}
+
+	_, err = usecase.Send(backgroundCtx, "", "invalid", "")
+	if err != domain.ErrBadParamInput {
+		t.Errorf("error inconsistent, caught error:%v, expected error:%v", err, domain.ErrBadParamInput)
+	}
+}
+
+// unicastProvider is a provider which can't broadcast.
+type unicastProvider struct {
+	*mockDomain.MockProvider
+}
+
+func (unicastProvider) CanBroadcast() bool {
+	return false
+}
+
+func Test_messageUsecase_SendWithoutBroadcast(t *testing.T) {
+	ctl := gomock.NewController(t)
+	defer ctl.Finish()
+
+	timeout := time.Second * 5
+	backgroundCtx := context.Background()
+
+	repository := _messageMemoryRepo.NewMemoryRepository()
+	mockProvider := unicastProvider{mockDomain.NewMockProvider(ctl)}
+	mockBlobStore := mockDomain.NewMockBlobStore(ctl)
+	mockProvider.EXPECT().PushMessage(gomock.Any(), "user0", "push").Return(nil)
+
+	usecase := NewMessageUsecase(repository, newRegistry(t, mockProvider), mockBlobStore, timeout)
+
+	// nothing is recorded for a broadcast which can't be sent
+	if _, err := usecase.Send(backgroundCtx, "", "broadcast"); !errors.Is(err, domain.ErrBadParamInput) {
+		t.Errorf("error inconsistent, caught error:%v, expected error:%v", err, domain.ErrBadParamInput)
+	}
+	if _, err := usecase.Send(backgroundCtx, "", "push", "user0"); err != nil {
+		t.Errorf("unexpected error:%v", err)
+	}
+	msgs, _, err := repository.Fetch(backgroundCtx, domain.MessageQuery{})
+	if err != nil {
+		t.Fatalf("unexpected error:%v", err)
+	}
+	if len(*msgs) != 1 || (*msgs)[0].UserID != "user0" {
+		t.Errorf("messages inconsistent, messages:%v", *msgs)
+	}
+}
+
+func Test_messageUsecase_Reply(t *testing.T) {
+	ctl := gomock.NewController(t)
+	defer ctl.Finish()
+
+	timeout := time.Second * 5
+	backgroundCtx := context.Background()
+
+	mockRepository := mockDomain.NewMockMessageRepository(ctl)
+	mockProvider := mockDomain.NewMockProvider(ctl)
+	mockBlobStore := mockDomain.NewMockBlobStore(ctl)
+
+	future := time.Now().Add(time.Minute)
+	past := time.Now().Add(-time.Minute)
+	validMsg := &domain.Message{ID: "1", UserID: "user1", ReplyToken: "token1", ReplyExpiresAt: &future}
+	expiredMsg := &domain.Message{ID: "2", UserID: "user2", ReplyToken: "token2", ReplyExpiresAt: &past}
+	consumedMsg := &domain.Message{ID: "3", UserID: "user3", ReplyToken: "token3", ReplyExpiresAt: &future}
+	fakeError := errors.New("fake error")
+	gomock.InOrder(
+		mockRepository.EXPECT().GetByID(gomock.Any(), "1").Return(validMsg, nil),
+		mockRepository.EXPECT().InsertMany(gomock.Any(), gomock.Len(1)).DoAndReturn(assignIDs),
+		mockProvider.EXPECT().ReplyMessage(gomock.Any(), "token1", "reply").Return(nil),
+		mockRepository.EXPECT().UpdateStatus(gomock.Any(), []string{"user1-id"}, domain.DeliveryStatusSent, "").Return(nil),
+
+		mockRepository.EXPECT().GetByID(gomock.Any(), "2").Return(expiredMsg, nil),
+		mockRepository.EXPECT().InsertMany(gomock.Any(), gomock.Len(1)).DoAndReturn(assignIDs),
+		mockProvider.EXPECT().PushMessage(gomock.Any(), "user2", "reply").Return(nil),
+		mockRepository.EXPECT().UpdateStatus(gomock.Any(), []string{"user2-id"}, domain.DeliveryStatusSent, "").Return(nil),
+
+		mockRepository.EXPECT().GetByID(gomock.Any(), "3").Return(consumedMsg, nil),
+		mockRepository.EXPECT().InsertMany(gomock.Any(), gomock.Len(1)).DoAndReturn(assignIDs),
+		mockProvider.EXPECT().ReplyMessage(gomock.Any(), "token3", "reply").Return(fakeError),
+		mockProvider.EXPECT().PushMessage(gomock.Any(), "user3", "reply").Return(fakeError),
+		mockRepository.EXPECT().UpdateStatus(gomock.Any(), []string{"user3-id"}, domain.DeliveryStatusFailed, fakeError.Error()).Return(nil),
+
+		mockRepository.EXPECT().GetByID(gomock.Any(), "4").Return(nil, domain.ErrNotFound),
+	)
+
+	usecase := NewMessageUsecase(mockRepository, newRegistry(t, mockProvider), mockBlobStore, timeout)
+
+	err := usecase.Reply(backgroundCtx, "1", "reply")
+	if err != nil {
+		t.Errorf("unexpected error:%v", err)
+	}
+	err = usecase.Reply(backgroundCtx, "2", "reply")
+	if err != nil {
+		t.Errorf("unexpected error:%v", err)
+	}
+	err = usecase.Reply(backgroundCtx, "3", "reply")
+	if err != fakeError {
+		t.Errorf("error inconsistent, caught error:%v, expected error:%v", err, fakeError)
+	}
+	err = usecase.Reply(backgroundCtx, "4", "reply")
+	if err != domain.ErrNotFound {
+		t.Errorf("error inconsistent, caught error:%v, expected error:%v", err, domain.ErrNotFound)
+	}
+}
+
+// Test_messageUsecase_SendWithMemoryRepository checks what is actually recorded rather than how the repository is
+// called.
+func Test_messageUsecase_SendWithMemoryRepository(t *testing.T) {
+	ctl := gomock.NewController(t)
+	defer ctl.Finish()
+
+	timeout := time.Second * 5
+	backgroundCtx := context.Background()
+
+	repository := _messageMemoryRepo.NewMemoryRepository()
+	mockProvider := mockDomain.NewMockProvider(ctl)
+	mockBlobStore := mockDomain.NewMockBlobStore(ctl)
+
+	fakeError := errors.New("fake error")
+	mockProvider.EXPECT().PushMessage(gomock.Any(), "user1", "hello").Return(nil)
+	mockProvider.EXPECT().PushMessage(gomock.Any(), "user2", "hello").Return(fakeError)
+
+	usecase := NewMessageUsecase(repository, newRegistry(t, mockProvider), mockBlobStore, timeout)
+	if _, err := usecase.Send(backgroundCtx, "", "hello", "user1"); err != nil {
+		t.Fatalf("unexpected error:%v", err)
+	}
+	if _, err := usecase.Send(backgroundCtx, "", "hello", "user2"); err != nil {
+		t.Fatalf("unexpected error:%v", err)
+	}
+
+	msgs, totalCount, err := usecase.Fetch(backgroundCtx, domain.MessageQuery{Sort: domain.SortOrderAsc, Limit: 20})
+	if err != nil {
+		t.Fatalf("unexpected error:%v", err)
+	}
+	if totalCount != 2 {
+		t.Fatalf("total count inconsistent, total count:%v, expected total count:%v", totalCount, 2)
+	}
+	expected := []struct {
+		userID string
+		status domain.DeliveryStatus
+		err    string
+	}{
+		{"user1", domain.DeliveryStatusSent, ""},
+		{"user2", domain.DeliveryStatusFailed, fakeError.Error()},
+	}
+	for i, msg := range *msgs {
+		if msg.UserID != expected[i].userID || msg.Direction != domain.DirectionOutbound ||
+			msg.Status != expected[i].status || msg.Error != expected[i].err || msg.Message != "hello" {
+			t.Errorf("message inconsistent, message:%+v, expected:%+v", msg, expected[i])
+		}
+	}
+}
+
+// trackingProvider is a provider reporting the delivery of the messages sent.
+type trackingProvider struct {
+	*mockDomain.MockProvider
+	*mockDomain.MockTrackingProvider
+}
+
+func (trackingProvider) CanSendTemplates() bool {
+	return true
+}
+
+func Test_messageUsecase_SendTracked(t *testing.T) {
+	ctl := gomock.NewController(t)
+	defer ctl.Finish()
+
+	timeout := time.Second * 5
+	backgroundCtx := context.Background()
+
+	repository := _messageMemoryRepo.NewMemoryRepository()
+	mockProvider := trackingProvider{mockDomain.NewMockProvider(ctl), mockDomain.NewMockTrackingProvider(ctl)}
+	mockBlobStore := mockDomain.NewMockBlobStore(ctl)
+
+	fakeError := errors.New("fake error")
+	template := domain.Template{Name: "order_update", Language: "en_US", Parameters: []string{"A123"}}
+	var pushed []domain.Message
+	mockProvider.MockTrackingProvider.EXPECT().PushTracked(gomock.Any(), gomock.Any()).DoAndReturn(func(_ context.Context, msg domain.Message) error {
+		pushed = append(pushed, msg)
+		if msg.UserID == "user2" {
+			return fakeError
+		}
+		return nil
+	}).Times(4)
+
+	usecase := NewMessageUsecase(repository, newRegistry(t, mockProvider), mockBlobStore, timeout)
+	results, err := usecase.Send(backgroundCtx, "", "hello", "user1", "user2")
+	if err != nil {
+		t.Fatalf("unexpected error:%v", err)
+	}
+	if len(results) != 1 || !strings.Contains(results[0].Error, "send to 1 of 2 users failed, users:user2") {
+		t.Errorf("results inconsistent, results:%+v", results)
+	}
+	results, err = usecase.SendTemplate(backgroundCtx, "", template, "user1", "user3")
+	if err != nil || len(results) != 1 || results[0].Error != "" {
+		t.Fatalf("results inconsistent, results:%+v, err:%v", results, err)
+	}
+	if _, err = usecase.SendTemplate(backgroundCtx, "", domain.Template{Name: "order_update"}, "user1"); !errors.Is(err, domain.ErrBadParamInput) {
+		t.Errorf("error inconsistent, error:%v, expected error:%v", err, domain.ErrBadParamInput)
+	}
+
+	for _, msg := range pushed {
+		if msg.ID == "" || msg.ChannelID != "channel0" {
+			t.Errorf("message pushed should be stored before, message:%+v", msg)
+		}
+	}
+	if pushed[2].Type != domain.MessageTypeTemplate || !reflect.DeepEqual(pushed[2].Payload.Template, &template) {
+		t.Errorf("template pushed inconsistent, message:%+v", pushed[2])
+	}
+
+	// the platforms report the statuses out of order, and the ones of other channels or unknown messages are ignored
+	err = usecase.UpdateStatuses(backgroundCtx, []domain.StatusUpdate{
+		{MessageID: pushed[0].ID, ChannelID: "channel0", Status: domain.DeliveryStatusRead},
+		{MessageID: pushed[0].ID, ChannelID: "channel0", Status: domain.DeliveryStatusDelivered},
+		{MessageID: pushed[2].ID, ChannelID: "channel0", Status: domain.DeliveryStatusDelivered},
+		{MessageID: pushed[3].ID, ChannelID: "channel1", Status: domain.DeliveryStatusRead},
+		{MessageID: "unknown", ChannelID: "channel0", Status: domain.DeliveryStatusRead},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error:%v", err)
+	}
+	err = usecase.UpdateStatuses(backgroundCtx, []domain.StatusUpdate{{MessageID: pushed[0].ID, ChannelID: "channel0", Status: "bounced"}})
+	if !errors.Is(err, domain.ErrBadParamInput) {
+		t.Errorf("error inconsistent, error:%v, expected error:%v", err, domain.ErrBadParamInput)
+	}
+
+	expected := map[string]domain.DeliveryStatus{
+		pushed[0].ID: domain.DeliveryStatusRead,
+		pushed[1].ID: domain.DeliveryStatusFailed,
+		pushed[2].ID: domain.DeliveryStatusDelivered,
+		pushed[3].ID: domain.DeliveryStatusSent,
+	}
+	for id, status := range expected {
+		msg, err := repository.GetByID(backgroundCtx, id)
+		if err != nil {
+			t.Fatalf("unexpected error:%v", err)
+		}
+		if msg.Status != status {
+			t.Errorf("status inconsistent, message:%+v, expected status:%v", msg, status)
+		}
+	}
+}
+
+func Test_messageUsecase_SendTrackedWithEarlyStatus(t *testing.T) {
+	ctl := gomock.NewController(t)
+	defer ctl.Finish()
+
+	backgroundCtx := context.Background()
+	repository := _messageMemoryRepo.NewMemoryRepository()
+	mockProvider := trackingProvider{mockDomain.NewMockProvider(ctl), mockDomain.NewMockTrackingProvider(ctl)}
+	usecase := NewMessageUsecase(repository, newRegistry(t, mockProvider), mockDomain.NewMockBlobStore(ctl), time.Second*5)
+
+	// the platform reports the delivery before the push returns, marking the message sent shouldn't take it back
+	var id string
+	mockProvider.MockTrackingProvider.EXPECT().PushTracked(gomock.Any(), gomock.Any()).DoAndReturn(func(_ context.Context, msg domain.Message) error {
+		id = msg.ID
+		return usecase.UpdateStatuses(backgroundCtx, []domain.StatusUpdate{
+			{MessageID: msg.ID, ChannelID: msg.ChannelID, Status: domain.DeliveryStatusDelivered},
+		})
+	})
+
+	results, err := usecase.Send(backgroundCtx, "", "hello", "user1")
+	if err != nil || len(results) != 1 || results[0].Error != "" {
+		t.Fatalf("results inconsistent, results:%+v, err:%v", results, err)
+	}
+	msg, err := repository.GetByID(backgroundCtx, id)
+	if err != nil {
+		t.Fatalf("unexpected error:%v", err)
+	}
+	if msg.Status != domain.DeliveryStatusDelivered {
+		t.Errorf("status inconsistent, message:%+v, expected status:%v", msg, domain.DeliveryStatusDelivered)
+	}
+}
+
+// outboundProvider is a provider sending the stored messages with their ids.
+type outboundProvider struct {
+	*mockDomain.MockProvider
+	*mockDomain.MockOutboundSender
+}
+
+// untemplatedProvider is a provider reporting the delivery of the messages sent which can't send templates.
+type untemplatedProvider struct {
+	trackingProvider
+}
+
+func (untemplatedProvider) CanSendTemplates() bool {
+	return false
+}
+
+func Test_messageUsecase_SendOutbound(t *testing.T) {
+	ctl := gomock.NewController(t)
+	defer ctl.Finish()
+
+	backgroundCtx := context.Background()
+	repository := _messageMemoryRepo.NewMemoryRepository()
+	mockProvider := outboundProvider{mockDomain.NewMockProvider(ctl), mockDomain.NewMockOutboundSender(ctl)}
+	usecase := NewMessageUsecase(repository, newRegistry(t, mockProvider), mockDomain.NewMockBlobStore(ctl), time.Second*5)
+
+	fakeError := errors.New("fake error")
+	var sent []domain.Message
+	mockProvider.MockOutboundSender.EXPECT().SendOutbound(gomock.Any(), gomock.Any()).DoAndReturn(func(_ context.Context, msg domain.Message) error {
+		sent = append(sent, msg)
+		if msg.UserID == "user2" {
+			return fakeError
+		}
+		return nil
+	}).Times(3)
+
+	results, err := usecase.Send(backgroundCtx, "", "hello", "user1", "user2")
+	if err != nil {
+		t.Fatalf("unexpected error:%v", err)
+	}
+	if len(results) != 1 || !strings.Contains(results[0].Error, "send to 1 of 2 users failed, users:user2") {
+		t.Errorf("results inconsistent, results:%+v", results)
+	}
+	// a broadcast is sent with its record and no user
+	if results, err = usecase.Send(backgroundCtx, "", "news"); err != nil || results != nil {
+		t.Fatalf("results inconsistent, results:%+v, err:%v", results, err)
+	}
+	if sent[2].UserID != "" || sent[2].Message != "news" {
+		t.Errorf("broadcast sent inconsistent, message:%+v", sent[2])
+	}
+
+	expected := []domain.DeliveryStatus{domain.DeliveryStatusSent, domain.DeliveryStatusFailed, domain.DeliveryStatusSent}
+	for i, msg := range sent {
+		stored, err := repository.GetByID(backgroundCtx, msg.ID)
+		if err != nil {
+			t.Fatalf("unexpected error:%v", err)
+		}
+		if stored.Status != expected[i] {
+			t.Errorf("status inconsistent, message:%+v, expected status:%v", stored, expected[i])
+		}
+	}
+}
+
+func Test_messageUsecase_SendTemplateUnsupported(t *testing.T) {
+	ctl := gomock.NewController(t)
+	defer ctl.Finish()
+
+	cases := []struct {
+		name     string
+		provider domain.Provider
+	}{
+		{
+			name:     "without tracking",
+			provider: mockDomain.NewMockProvider(ctl),
+		},
+		{
+			name:     "tracking without templates",
+			provider: untemplatedProvider{trackingProvider{mockDomain.NewMockProvider(ctl), mockDomain.NewMockTrackingProvider(ctl)}},
+		},
+		{
+			name:     "outbound sender",
+			provider: outboundProvider{mockDomain.NewMockProvider(ctl), mockDomain.NewMockOutboundSender(ctl)},
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			// nothing is stored or sent, the mocks expect no call
+			mockRepo := mockDomain.NewMockMessageRepository(ctl)
+			usecase := NewMessageUsecase(mockRepo, newRegistry(t, c.provider), mockDomain.NewMockBlobStore(ctl), time.Second)
+			_, err := usecase.SendTemplate(context.Background(), "", domain.Template{Name: "hello", Language: "en"}, "user1")
+			if !errors.Is(err, domain.ErrBadParamInput) {
+				t.Errorf("error inconsistent, error:%v, expected error:%v", err, domain.ErrBadParamInput)
+			}
+		})
+	}
+}
+
 func Test_messageUsecase_Parse(t *testing.T) {
 	ctl := gomock.NewController(t)
 	defer ctl.Finish()
@@ -51,35 +838,48 @@ func Test_messageUsecase_Parse(t *testing.T) {
 
 	mockRepository := mockDomain.NewMockMessageRepository(ctl)
 	mockProvider := mockDomain.NewMockProvider(ctl)
+	mockBlobStore := mockDomain.NewMockBlobStore(ctl)
 
 	req, _ := http.NewRequest("post", "google.com", nil)
-	fakeMsg := domain.Message{
-		UserID:  "123",
-		Message: "test message",
+	fakeMsgs := []domain.Message{
+		{
+			UserID:  "123",
+			Message: "test message1",
+		},
+		{
+			UserID:  "456",
+			Message: "test message2",
+		},
 	}
 	fakeError := errors.New("fake error")
 	gomock.InOrder(
-		mockProvider.EXPECT().ParseRequest(req).Return(fakeMsg, nil),
-		mockProvider.EXPECT().ParseRequest(req).Return(domain.Message{}, fakeError),
+		mockProvider.EXPECT().ParseRequest(req).Return(domain.Webhook{Messages: fakeMsgs}, nil),
+		mockProvider.EXPECT().ParseRequest(req).Return(domain.Webhook{}, fakeError),
 	)
 
-	usecase := NewMessageUsecase(mockRepository, mockProvider, timeout)
+	usecase := NewMessageUsecase(mockRepository, newRegistry(t, mockProvider), mockBlobStore, timeout)
 
-	msg, err := usecase.ParseRequest(req)
+	webhook, err := usecase.ParseRequest("", req)
 	if err != nil {
 		t.Errorf("unexpected error:%v", err)
 	}
-	if msg.UserID != fakeMsg.UserID || msg.Message != fakeMsg.Message {
-		t.Errorf("data inconsistent, msg:%v, expected message:%v", msg, fakeMsg)
+	msgs := webhook.Messages
+	if len(msgs) != len(fakeMsgs) {
+		t.Errorf("data length inconsistent, length:%v, expected length:%v", len(msgs), len(fakeMsgs))
+	}
+	for i, msg := range msgs {
+		if msg.UserID != fakeMsgs[i].UserID || msg.Message != fakeMsgs[i].Message {
+			t.Errorf("data inconsistent, msg:%v, expected message:%v", msg, fakeMsgs[i])
+		}
 	}
 
-	msg, err = usecase.ParseRequest(req)
+	_, err = usecase.ParseRequest("", req)
 	if err.Error() != fakeError.Error() {
 		t.Errorf("error inconsistent, caught error:%v, expected error:%v", err, fakeError)
 	}
 }
 
-func Test_messageUsecase_GetByUserID(t *testing.T) {
+func Test_messageUsecase_Fetch(t *testing.T) {
 	ctl := gomock.NewController(t)
 	defer ctl.Finish()
 
@@ -88,10 +888,15 @@ func Test_messageUsecase_GetByUserID(t *testing.T) {
 
 	mockRepository := mockDomain.NewMockMessageRepository(ctl)
 	mockProvider := mockDomain.NewMockProvider(ctl)
+	mockBlobStore := mockDomain.NewMockBlobStore(ctl)
 
-	userIDs := []string{
-		"123",
-		"456",
+	query := domain.MessageQuery{
+		UserIDs: []string{
+			"123",
+			"456",
+		},
+		Sort:  domain.SortOrderAsc,
+		Limit: 20,
 	}
 	fakeMessages := []domain.Message{
 		{
@@ -106,13 +911,13 @@ func Test_messageUsecase_GetByUserID(t *testing.T) {
 	fakeError := errors.New("fake error")
 	gomock.InOrder(
 		//context arguments would be treated as different even if they are the same type.
-		mockRepository.EXPECT().GetByUserID(gomock.Any(), int64(0), int64(20), userIDs).Return(&fakeMessages, int64(len(fakeMessages)), nil),
-		mockRepository.EXPECT().GetByUserID(gomock.Any(), int64(0), int64(20), userIDs).Return(nil, int64(0), fakeError),
+		mockRepository.EXPECT().Fetch(gomock.Any(), query).Return(&fakeMessages, int64(len(fakeMessages)), nil),
+		mockRepository.EXPECT().Fetch(gomock.Any(), query).Return(nil, int64(0), fakeError),
 	)
 
-	usecase := NewMessageUsecase(mockRepository, mockProvider, timeout)
+	usecase := NewMessageUsecase(mockRepository, newRegistry(t, mockProvider), mockBlobStore, timeout)
 
-	messages, totalCount, err := usecase.GetByUserID(backgroundCtx, 0, 20, userIDs...)
+	messages, totalCount, err := usecase.Fetch(backgroundCtx, query)
 	if err != nil {
 		t.Errorf("unexpected error:%v", err)
 	}
@@ -131,8 +936,133 @@ func Test_messageUsecase_GetByUserID(t *testing.T) {
 		}
 	}
 
-	_, _, err = usecase.GetByUserID(backgroundCtx, 0, 20, userIDs...)
+	_, _, err = usecase.Fetch(backgroundCtx, query)
 	if err.Error() != fakeError.Error() {
 		t.Errorf("error inconsistent, caught error:%v, expected error:%v", err, fakeError)
 	}
+
+	// invalid queries never reach the repository
+	yesterday := time.Now().Add(-24 * time.Hour)
+	now := time.Now()
+	invalidQueries := []domain.MessageQuery{
+		{Sort: "random", Limit: 20},
+		{SearchMode: "regex", Search: "hello", Limit: 20},
+		{From: &now, To: &yesterday, Limit: 20},
+		{Offset: -1, Limit: 20},
+		{Limit: 0},
+		{Limit: -1},
+		{After: &domain.MessageCursor{ID: "1", CreatedAt: now}, Offset: 20, Limit: 20},
+	}
+	for _, q := range invalidQueries {
+		_, _, err = usecase.Fetch(backgroundCtx, q)
+		if err != domain.ErrBadParamInput {
+			t.Errorf("error inconsistent, query:%+v, caught error:%v, expected error:%v", q, err, domain.ErrBadParamInput)
+		}
+	}
+}
+
+func Test_messageUsecase_Channels(t *testing.T) {
+	ctl := gomock.NewController(t)
+	defer ctl.Finish()
+
+	timeout := time.Second * 5
+	backgroundCtx := context.Background()
+
+	repository := _messageMemoryRepo.NewMemoryRepository()
+	defaultProvider := mockDomain.NewMockProvider(ctl)
+	otherProvider := mockDomain.NewMockProvider(ctl)
+	mockBlobStore := mockDomain.NewMockBlobStore(ctl)
+
+	req, _ := http.NewRequest("post", "google.com", nil)
+	future := time.Now().Add(time.Minute)
+	inbound := []domain.Message{
+		{
+			EventID:        "e1",
+			UserID:         "user1",
+			Type:           domain.MessageTypeImage,
+			Payload:        &domain.Payload{Media: &domain.Media{ContentID: "c1"}},
+			ReplyToken:     "token1",
+			ReplyExpiresAt: &future,
+		},
+	}
+	gomock.InOrder(
+		otherProvider.EXPECT().ParseRequest(req).Return(domain.Webhook{
+			Messages: inbound,
+			Events:   []domain.Event{{EventID: "e2", UserID: "user1"}},
+		}, nil),
+		otherProvider.EXPECT().GetContent(gomock.Any(), "c1").Return(io.NopCloser(strings.NewReader("image")), "image/jpeg", nil),
+		mockBlobStore.EXPECT().Put(gomock.Any(), "channel1_c1", gomock.Any()).Return(nil),
+		otherProvider.EXPECT().ReplyMessage(gomock.Any(), "token1", "reply").Return(nil),
+		defaultProvider.EXPECT().PushMessage(gomock.Any(), "user1", "push").Return(nil),
+	)
+
+	usecase := NewMessageUsecase(repository, newRegistry(t, defaultProvider, otherProvider), mockBlobStore, timeout)
+
+	webhook, err := usecase.ParseRequest("channel1", req)
+	if err != nil {
+		t.Fatalf("unexpected error:%v", err)
+	}
+	if webhook.Messages[0].ChannelID != "channel1" || webhook.Events[0].ChannelID != "channel1" {
+		t.Errorf("channel inconsistent, webhook:%+v, expected channel:%v", webhook, "channel1")
+	}
+	if err = usecase.InsertMany(backgroundCtx, webhook.Messages); err != nil {
+		t.Fatalf("unexpected error:%v", err)
+	}
+
+	// the reply goes through the channel the message is received by
+	if err = usecase.Reply(backgroundCtx, webhook.Messages[0].ID, "reply"); err != nil {
+		t.Errorf("unexpected error:%v", err)
+	}
+	if _, err = usecase.Send(backgroundCtx, "", "push", "user1"); err != nil {
+		t.Errorf("unexpected error:%v", err)
+	}
+
+	msgs, _, err := usecase.Fetch(backgroundCtx, domain.MessageQuery{ChannelID: "channel1", Sort: domain.SortOrderAsc, Limit: 20})
+	if err != nil {
+		t.Fatalf("unexpected error:%v", err)
+	}
+	if len(*msgs) != 2 || (*msgs)[1].Message != "reply" || (*msgs)[1].ChannelID != "channel1" {
+		t.Errorf("messages of channel inconsistent, messages:%+v", *msgs)
+	}
+	msgs, _, err = usecase.Fetch(backgroundCtx, domain.MessageQuery{ChannelID: "channel0", Limit: 20})
+	if err != nil {
+		t.Fatalf("unexpected error:%v", err)
+	}
+	if len(*msgs) != 1 || (*msgs)[0].Message != "push" {
+		t.Errorf("messages of channel inconsistent, messages:%+v", *msgs)
+	}
+
+	_, err = usecase.ParseRequest("unknown", req)
+	if err != domain.ErrNotFound {
+		t.Errorf("error inconsistent, caught error:%v, expected error:%v", err, domain.ErrNotFound)
+	}
+	_, err = usecase.Send(backgroundCtx, "unknown", "push", "user1")
+	if err != domain.ErrBadParamInput {
+		t.Errorf("error inconsistent, caught error:%v, expected error:%v", err, domain.ErrBadParamInput)
+	}
+}
+
+func Test_blobKey(t *testing.T) {
+	cases := []struct {
+		name      string
+		channelID string
+		contentID string
+		expected  string
+	}{
+		{name: "without channel", contentID: "c1", expected: "c1"},
+		{name: "with channel", channelID: "line", contentID: "c1", expected: "line_c1"},
+		{
+			name:      "url is hashed",
+			channelID: "facebook",
+			contentID: "https://cdn/1.jpg",
+			expected:  "facebook_b3f7b40b856a921e97c98789c79c33e8037cdc0b133f13f0cea68b2c77345f0a",
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			if got := blobKey(c.channelID, c.contentID); got != c.expected {
+				t.Errorf("key inconsistent, key:%v, expected key:%v", got, c.expected)
+			}
+		})
+	}
 }
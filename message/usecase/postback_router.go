@@ -0,0 +1,46 @@
+package usecase
+
+import (
+	"strings"
+	"sync"
+
+	"github.com/kunmingliu/messenger/domain"
+)
+
+type postbackRoute struct {
+	prefix  string
+	handler domain.PostbackHandler
+}
+
+// postbackRouter dispatches postback data to the handler registered with the longest matching prefix.
+type postbackRouter struct {
+	mu     sync.RWMutex
+	routes []postbackRoute
+}
+
+func (r *postbackRouter) handle(prefix string, h domain.PostbackHandler) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for i := range r.routes {
+		if r.routes[i].prefix == prefix {
+			r.routes[i].handler = h
+			return
+		}
+	}
+	r.routes = append(r.routes, postbackRoute{prefix, h})
+}
+
+func (r *postbackRouter) match(data string) (route postbackRoute, ok bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	for _, candidate := range r.routes {
+		if !strings.HasPrefix(data, candidate.prefix) {
+			continue
+		}
+		if !ok || len(candidate.prefix) > len(route.prefix) {
+			route = candidate
+			ok = true
+		}
+	}
+	return
+}
@@ -0,0 +1,68 @@
+package usecase
+
+import (
+	"context"
+	"testing"
+
+	"github.com/kunmingliu/messenger/domain"
+)
+
+func Test_postbackRouter_match(t *testing.T) {
+	noop := func(ctx context.Context, msg domain.Message) (string, error) {
+		return "", nil
+	}
+
+	var router postbackRouter
+	router.handle("action=", noop)
+	router.handle("action=buy", noop)
+	router.handle("menu", noop)
+	// registering the same prefix again replaces the handler instead of adding a new route
+	router.handle("menu", noop)
+
+	if len(router.routes) != 3 {
+		t.Errorf("routes inconsistent, length:%v, expected length:%v", len(router.routes), 3)
+	}
+
+	cases := []struct {
+		name   string
+		data   string
+		ok     bool
+		prefix string
+	}{
+		{
+			name:   "match the longest prefix",
+			data:   "action=buy&item=1",
+			ok:     true,
+			prefix: "action=buy",
+		},
+		{
+			name:   "match the shorter prefix",
+			data:   "action=cancel",
+			ok:     true,
+			prefix: "action=",
+		},
+		{
+			name:   "match the exact data",
+			data:   "menu",
+			ok:     true,
+			prefix: "menu",
+		},
+		{
+			name: "match nothing",
+			data: "unknown",
+			ok:   false,
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			route, ok := router.match(c.data)
+			if ok != c.ok {
+				t.Errorf("match inconsistent, ok:%v, expected ok:%v", ok, c.ok)
+			}
+			if route.prefix != c.prefix {
+				t.Errorf("prefix inconsistent, prefix:%v, expected prefix:%v", route.prefix, c.prefix)
+			}
+		})
+	}
+}
@@ -0,0 +1,140 @@
+package migration
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+)
+
+// Migration is a versioned change of the database schema, Down reverts what Up does.
+type Migration struct {
+	Version     int
+	Description string
+	Up          func(ctx context.Context) error
+	Down        func(ctx context.Context) error
+}
+
+// Record is a migration applied to the database.
+type Record struct {
+	Version     int       `bson:"_id"`
+	Description string    `bson:"description"`
+	AppliedAt   time.Time `bson:"applied_at"`
+}
+
+// Store keeps the records of the applied migrations in the database.
+type Store interface {
+	Records(ctx context.Context) ([]Record, error)
+	Insert(ctx context.Context, r Record) error
+	Delete(ctx context.Context, version int) error
+}
+
+type Migrator struct {
+	store      Store
+	migrations []Migration
+}
+
+// NewMigrator sorts the migrations by version and panics if there are duplicated versions.
+func NewMigrator(store Store, migrations ...Migration) *Migrator {
+	sorted := make([]Migration, len(migrations))
+	copy(sorted, migrations)
+	sort.Slice(sorted, func(i, j int) bool {
+		return sorted[i].Version < sorted[j].Version
+	})
+	for i := range sorted {
+		if sorted[i].Version <= 0 {
+			panic(fmt.Sprintf("migration version should be positive: %d", sorted[i].Version))
+		}
+		if i > 0 && sorted[i].Version == sorted[i-1].Version {
+			panic(fmt.Sprintf("duplicated migration version: %d", sorted[i].Version))
+		}
+	}
+	return &Migrator{store, sorted}
+}
+
+// Latest returns the version of the newest migration, it's zero if there is no migration.
+func (m *Migrator) Latest() int {
+	if len(m.migrations) == 0 {
+		return 0
+	}
+	return m.migrations[len(m.migrations)-1].Version
+}
+
+// Version returns the newest version applied to the database, it's zero if nothing has been applied.
+func (m *Migrator) Version(ctx context.Context) (int, error) {
+	records, err := m.store.Records(ctx)
+	if err != nil {
+		return 0, err
+	}
+	version := 0
+	for _, r := range records {
+		if r.Version > version {
+			version = r.Version
+		}
+	}
+	return version, nil
+}
+
+func (m *Migrator) applied(ctx context.Context) (map[int]bool, error) {
+	records, err := m.store.Records(ctx)
+	if err != nil {
+		return nil, err
+	}
+	applied := make(map[int]bool, len(records))
+	for _, r := range records {
+		applied[r.Version] = true
+	}
+	return applied, nil
+}
+
+// Up applies the pending migrations whose version is not greater than target in order.
+func (m *Migrator) Up(ctx context.Context, target int) error {
+	applied, err := m.applied(ctx)
+	if err != nil {
+		return err
+	}
+	for _, migration := range m.migrations {
+		if migration.Version > target {
+			break
+		}
+		if applied[migration.Version] {
+			continue
+		}
+		if err = migration.Up(ctx); err != nil {
+			return fmt.Errorf("migrate up to version %d failed: %w", migration.Version, err)
+		}
+		err = m.store.Insert(ctx, Record{
+			Version:     migration.Version,
+			Description: migration.Description,
+			AppliedAt:   time.Now().UTC(),
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Down reverts the applied migrations whose version is greater than target in reverse order.
+func (m *Migrator) Down(ctx context.Context, target int) error {
+	applied, err := m.applied(ctx)
+	if err != nil {
+		return err
+	}
+	for i := len(m.migrations) - 1; i >= 0; i-- {
+		migration := m.migrations[i]
+		if migration.Version <= target {
+			break
+		}
+		if !applied[migration.Version] {
+			continue
+		}
+		if err = migration.Down(ctx); err != nil {
+			return fmt.Errorf("migrate down from version %d failed: %w", migration.Version, err)
+		}
+		if err = m.store.Delete(ctx, migration.Version); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,199 @@
+package migration
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+type memoryStore struct {
+	records map[int]Record
+}
+
+func (s *memoryStore) Records(ctx context.Context) ([]Record, error) {
+	var records []Record
+	for _, r := range s.records {
+		records = append(records, r)
+	}
+	sort.Slice(records, func(i, j int) bool {
+		return records[i].Version < records[j].Version
+	})
+	return records, nil
+}
+
+func (s *memoryStore) Insert(ctx context.Context, r Record) error {
+	s.records[r.Version] = r
+	return nil
+}
+
+func (s *memoryStore) Delete(ctx context.Context, version int) error {
+	delete(s.records, version)
+	return nil
+}
+
+func newMigrations(steps *[]string, versions ...int) []Migration {
+	var migrations []Migration
+	for _, v := range versions {
+		v := v
+		migrations = append(migrations, Migration{
+			Version: v,
+			Up: func(ctx context.Context) error {
+				*steps = append(*steps, fmt.Sprintf("up%d", v))
+				return nil
+			},
+			Down: func(ctx context.Context) error {
+				*steps = append(*steps, fmt.Sprintf("down%d", v))
+				return nil
+			},
+		})
+	}
+	return migrations
+}
+
+func TestMigrator_Up(t *testing.T) {
+	cases := []struct {
+		name    string
+		applied []int
+		target  int
+		steps   []string
+		version int
+	}{
+		{
+			name:    "up to latest",
+			target:  3,
+			steps:   []string{"up1", "up2", "up3"},
+			version: 3,
+		},
+		{
+			name:    "up to target",
+			target:  2,
+			steps:   []string{"up1", "up2"},
+			version: 2,
+		},
+		{
+			name:    "skip applied migrations",
+			applied: []int{1, 2},
+			target:  3,
+			steps:   []string{"up3"},
+			version: 3,
+		},
+		{
+			name:    "nothing to apply",
+			applied: []int{1, 2, 3},
+			target:  3,
+			version: 3,
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			ctx := context.Background()
+			store := &memoryStore{map[int]Record{}}
+			for _, v := range c.applied {
+				store.records[v] = Record{Version: v}
+			}
+			var steps []string
+			// versions are given out of order on purpose
+			m := NewMigrator(store, newMigrations(&steps, 3, 1, 2)...)
+			if err := m.Up(ctx, c.target); err != nil {
+				t.Fatalf("unexpected error:%v", err)
+			}
+			if !reflect.DeepEqual(steps, c.steps) {
+				t.Errorf("steps inconsistent, steps:%v, expected steps:%v", steps, c.steps)
+			}
+			version, err := m.Version(ctx)
+			if err != nil {
+				t.Fatalf("unexpected error:%v", err)
+			}
+			if version != c.version {
+				t.Errorf("version inconsistent, version:%v, expected version:%v", version, c.version)
+			}
+		})
+	}
+}
+
+func TestMigrator_Down(t *testing.T) {
+	cases := []struct {
+		name    string
+		applied []int
+		target  int
+		steps   []string
+		version int
+	}{
+		{
+			name:    "down to zero",
+			applied: []int{1, 2, 3},
+			target:  0,
+			steps:   []string{"down3", "down2", "down1"},
+			version: 0,
+		},
+		{
+			name:    "down to target",
+			applied: []int{1, 2, 3},
+			target:  2,
+			steps:   []string{"down3"},
+			version: 2,
+		},
+		{
+			name:    "skip unapplied migrations",
+			applied: []int{1},
+			target:  0,
+			steps:   []string{"down1"},
+			version: 0,
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			ctx := context.Background()
+			store := &memoryStore{map[int]Record{}}
+			for _, v := range c.applied {
+				store.records[v] = Record{Version: v}
+			}
+			var steps []string
+			m := NewMigrator(store, newMigrations(&steps, 1, 2, 3)...)
+			if err := m.Down(ctx, c.target); err != nil {
+				t.Fatalf("unexpected error:%v", err)
+			}
+			if !reflect.DeepEqual(steps, c.steps) {
+				t.Errorf("steps inconsistent, steps:%v, expected steps:%v", steps, c.steps)
+			}
+			version, err := m.Version(ctx)
+			if err != nil {
+				t.Fatalf("unexpected error:%v", err)
+			}
+			if version != c.version {
+				t.Errorf("version inconsistent, version:%v, expected version:%v", version, c.version)
+			}
+		})
+	}
+}
+
+func TestMigrator_UpFailed(t *testing.T) {
+	ctx := context.Background()
+	store := &memoryStore{map[int]Record{}}
+	failure := errors.New("failed")
+	m := NewMigrator(store,
+		Migration{Version: 1, Up: func(ctx context.Context) error { return nil }},
+		Migration{Version: 2, Up: func(ctx context.Context) error { return failure }},
+	)
+	err := m.Up(ctx, m.Latest())
+	if !errors.Is(err, failure) {
+		t.Errorf("error inconsistent, error:%v, expected error:%v", err, failure)
+	}
+	// the failed migration shouldn't be recorded so that it is applied again next time
+	version, _ := m.Version(ctx)
+	if version != 1 {
+		t.Errorf("version inconsistent, version:%v, expected version:%v", version, 1)
+	}
+}
+
+func TestNewMigrator_DuplicatedVersion(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Errorf("creating the migrator should panic with duplicated versions")
+		}
+	}()
+	NewMigrator(&memoryStore{map[int]Record{}}, Migration{Version: 1}, Migration{Version: 1})
+}
@@ -0,0 +1,203 @@
+package mongo
+
+import (
+	"context"
+
+	"github.com/kunmingliu/messenger/migration"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	collectionName        = "migrations"
+	messageCollectionName = "message"
+	eventCollectionName   = "events"
+)
+
+type mongoStore struct {
+	Collection *mongo.Collection
+}
+
+func NewMongoStore(DB *mongo.Database) migration.Store {
+	return &mongoStore{DB.Collection(collectionName)}
+}
+
+func (s *mongoStore) Records(ctx context.Context) ([]migration.Record, error) {
+	cursor, err := s.Collection.Find(ctx, bson.D{}, options.Find().SetSort(bson.D{{Key: "_id", Value: 1}}))
+	if err != nil {
+		return nil, err
+	}
+	var records []migration.Record
+	err = cursor.All(ctx, &records)
+	return records, err
+}
+
+func (s *mongoStore) Insert(ctx context.Context, r migration.Record) error {
+	_, err := s.Collection.InsertOne(ctx, r)
+	return err
+}
+
+func (s *mongoStore) Delete(ctx context.Context, version int) error {
+	_, err := s.Collection.DeleteOne(ctx, bson.D{{Key: "_id", Value: version}})
+	return err
+}
+
+func createIndexes(DB *mongo.Database, collection string, models ...mongo.IndexModel) func(ctx context.Context) error {
+	return func(ctx context.Context) error {
+		_, err := DB.Collection(collection).Indexes().CreateMany(ctx, models)
+		return err
+	}
+}
+
+func dropIndexes(DB *mongo.Database, collection string, names ...string) func(ctx context.Context) error {
+	return func(ctx context.Context) error {
+		for _, name := range names {
+			if _, err := DB.Collection(collection).Indexes().DropOne(ctx, name); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
+
+// setDefaultChannel sets an empty channel id to the documents stored before there were channels, so that they're
+// found by the filter of the empty channel id.
+func setDefaultChannel(DB *mongo.Database, collection string) func(ctx context.Context) error {
+	return func(ctx context.Context) error {
+		_, err := DB.Collection(collection).UpdateMany(ctx,
+			bson.D{{Key: "channel_id", Value: bson.D{{Key: "$exists", Value: false}}}},
+			bson.D{{Key: "$set", Value: bson.D{{Key: "channel_id", Value: ""}}}})
+		return err
+	}
+}
+
+var (
+	eventIDIndex = mongo.IndexModel{
+		Keys:    bson.D{{Key: "event_id", Value: 1}},
+		Options: options.Index().SetName("event_id_1").SetUnique(true).SetSparse(true),
+	}
+	// channelEventIDIndex only covers the documents having an event id, a compound sparse index would still cover the
+	// ones having a channel id.
+	channelEventIDIndex = mongo.IndexModel{
+		Keys: bson.D{{Key: "channel_id", Value: 1}, {Key: "event_id", Value: 1}},
+		Options: options.Index().SetName("channel_id_event_id").SetUnique(true).
+			SetPartialFilterExpression(bson.D{{Key: "event_id", Value: bson.D{{Key: "$exists", Value: true}}}}),
+	}
+)
+
+// Migrations returns every migration of MongoDB, a new migration should be appended with a greater version and the
+// released ones should never be changed.
+func Migrations(DB *mongo.Database) []migration.Migration {
+	return []migration.Migration{
+		{
+			Version:     1,
+			Description: "create unique indexes of event_id",
+			Up: func(ctx context.Context) error {
+				if err := createIndexes(DB, messageCollectionName, eventIDIndex)(ctx); err != nil {
+					return err
+				}
+				return createIndexes(DB, eventCollectionName, eventIDIndex)(ctx)
+			},
+			Down: func(ctx context.Context) error {
+				if err := dropIndexes(DB, messageCollectionName, "event_id_1")(ctx); err != nil {
+					return err
+				}
+				return dropIndexes(DB, eventCollectionName, "event_id_1")(ctx)
+			},
+		},
+		{
+			Version:     2,
+			Description: "create indexes for message queries",
+			Up: createIndexes(DB, messageCollectionName,
+				mongo.IndexModel{
+					Keys:    bson.D{{Key: "created_at", Value: -1}, {Key: "_id", Value: -1}},
+					Options: options.Index().SetName("created_at_id"),
+				},
+				mongo.IndexModel{
+					Keys:    bson.D{{Key: "user_id", Value: 1}, {Key: "created_at", Value: -1}, {Key: "_id", Value: -1}},
+					Options: options.Index().SetName("user_id_created_at_id"),
+				},
+				mongo.IndexModel{
+					Keys:    bson.D{{Key: "type", Value: 1}, {Key: "created_at", Value: -1}},
+					Options: options.Index().SetName("type_created_at"),
+				},
+			),
+			Down: dropIndexes(DB, messageCollectionName, "created_at_id", "user_id_created_at_id", "type_created_at"),
+		},
+		{
+			Version:     3,
+			Description: "create text index for message search",
+			Up: createIndexes(DB, messageCollectionName, mongo.IndexModel{
+				Keys:    bson.D{{Key: "message", Value: "text"}},
+				Options: options.Index().SetName("message_text"),
+			}),
+			Down: dropIndexes(DB, messageCollectionName, "message_text"),
+		},
+		{
+			Version:     4,
+			Description: "create indexes for event queries",
+			Up: createIndexes(DB, eventCollectionName,
+				mongo.IndexModel{
+					Keys:    bson.D{{Key: "timestamp", Value: -1}},
+					Options: options.Index().SetName("timestamp"),
+				},
+				mongo.IndexModel{
+					Keys:    bson.D{{Key: "user_id", Value: 1}, {Key: "timestamp", Value: -1}},
+					Options: options.Index().SetName("user_id_timestamp"),
+				},
+				mongo.IndexModel{
+					Keys:    bson.D{{Key: "members", Value: 1}, {Key: "timestamp", Value: -1}},
+					Options: options.Index().SetName("members_timestamp"),
+				},
+				mongo.IndexModel{
+					Keys:    bson.D{{Key: "type", Value: 1}, {Key: "timestamp", Value: -1}},
+					Options: options.Index().SetName("type_timestamp"),
+				},
+			),
+			Down: dropIndexes(DB, eventCollectionName, "timestamp", "user_id_timestamp", "members_timestamp", "type_timestamp"),
+		},
+		{
+			Version:     5,
+			Description: "make event_id unique per channel",
+			Up: func(ctx context.Context) error {
+				for _, collection := range []string{messageCollectionName, eventCollectionName} {
+					if err := setDefaultChannel(DB, collection)(ctx); err != nil {
+						return err
+					}
+					if err := dropIndexes(DB, collection, "event_id_1")(ctx); err != nil {
+						return err
+					}
+				}
+				if err := createIndexes(DB, messageCollectionName, channelEventIDIndex, mongo.IndexModel{
+					Keys:    bson.D{{Key: "channel_id", Value: 1}, {Key: "created_at", Value: -1}, {Key: "_id", Value: -1}},
+					Options: options.Index().SetName("channel_id_created_at_id"),
+				})(ctx); err != nil {
+					return err
+				}
+				return createIndexes(DB, eventCollectionName, channelEventIDIndex, mongo.IndexModel{
+					Keys:    bson.D{{Key: "channel_id", Value: 1}, {Key: "timestamp", Value: -1}},
+					Options: options.Index().SetName("channel_id_timestamp"),
+				})(ctx)
+			},
+			// it fails if different channels share an event id, which should be cleaned up before reverting
+			Down: func(ctx context.Context) error {
+				err := dropIndexes(DB, messageCollectionName, "channel_id_event_id", "channel_id_created_at_id")(ctx)
+				if err != nil {
+					return err
+				}
+				if err = createIndexes(DB, messageCollectionName, eventIDIndex)(ctx); err != nil {
+					return err
+				}
+				if err = dropIndexes(DB, eventCollectionName, "channel_id_event_id", "channel_id_timestamp")(ctx); err != nil {
+					return err
+				}
+				return createIndexes(DB, eventCollectionName, eventIDIndex)(ctx)
+			},
+		},
+	}
+}
+
+func NewMigrator(DB *mongo.Database) *migration.Migrator {
+	return migration.NewMigrator(NewMongoStore(DB), Migrations(DB)...)
+}
@@ -0,0 +1,105 @@
+package mongo
+
+import (
+	"context"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/integration/mtest"
+)
+
+func TestMigrations_Up(t *testing.T) {
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+	defer mt.Close()
+
+	mt.Run("create unique indexes of event_id", func(mt *mtest.T) {
+		ctx := context.Background()
+		mt.AddMockResponses(
+			mtest.CreateCursorResponse(0, "db.migrations", mtest.FirstBatch),
+			mtest.CreateSuccessResponse(),
+			mtest.CreateSuccessResponse(),
+			mtest.CreateSuccessResponse(),
+		)
+
+		err := NewMigrator(mt.DB).Up(ctx, 1)
+		if err != nil {
+			t.Fatalf("unexpected error:%v", err)
+		}
+
+		mt.GetStartedEvent()
+		for _, collection := range []string{messageCollectionName, eventCollectionName} {
+			started := mt.GetStartedEvent()
+			if started.CommandName != "createIndexes" {
+				t.Fatalf("command inconsistent, command:%v, expected command:%v", started.CommandName, "createIndexes")
+			}
+			if got := started.Command.Lookup("createIndexes").StringValue(); got != collection {
+				t.Errorf("collection inconsistent, collection:%v, expected collection:%v", got, collection)
+			}
+			index := started.Command.Lookup("indexes", "0").Document()
+			if !index.Lookup("unique").Boolean() || !index.Lookup("sparse").Boolean() {
+				t.Errorf("index inconsistent, index:%v, expected a unique sparse index", index)
+			}
+		}
+		started := mt.GetStartedEvent()
+		if started.CommandName != "insert" {
+			t.Fatalf("command inconsistent, command:%v, expected command:%v", started.CommandName, "insert")
+		}
+		if got := started.Command.Lookup("documents", "0", "_id").Int32(); got != 1 {
+			t.Errorf("version inconsistent, version:%v, expected version:%v", got, 1)
+		}
+	})
+
+	mt.Run("skip applied migrations", func(mt *mtest.T) {
+		ctx := context.Background()
+		mt.AddMockResponses(
+			mtest.CreateCursorResponse(0, "db.migrations", mtest.FirstBatch,
+				bson.D{{Key: "_id", Value: 1}, {Key: "description", Value: "create unique indexes of event_id"}},
+			),
+		)
+
+		err := NewMigrator(mt.DB).Up(ctx, 1)
+		if err != nil {
+			t.Fatalf("unexpected error:%v", err)
+		}
+		mt.GetStartedEvent()
+		if started := mt.GetStartedEvent(); started != nil {
+			t.Errorf("command inconsistent, command:%v, expected no command", started.CommandName)
+		}
+	})
+}
+
+func TestMigrations_Down(t *testing.T) {
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+	defer mt.Close()
+
+	mt.Run("drop text index of message", func(mt *mtest.T) {
+		ctx := context.Background()
+		mt.AddMockResponses(
+			mtest.CreateCursorResponse(0, "db.migrations", mtest.FirstBatch,
+				bson.D{{Key: "_id", Value: 1}},
+				bson.D{{Key: "_id", Value: 2}},
+				bson.D{{Key: "_id", Value: 3}},
+			),
+			mtest.CreateSuccessResponse(),
+			mtest.CreateSuccessResponse(),
+		)
+
+		err := NewMigrator(mt.DB).Down(ctx, 2)
+		if err != nil {
+			t.Fatalf("unexpected error:%v", err)
+		}
+
+		mt.GetStartedEvent()
+		started := mt.GetStartedEvent()
+		if started.CommandName != "dropIndexes" {
+			t.Fatalf("command inconsistent, command:%v, expected command:%v", started.CommandName, "dropIndexes")
+		}
+		if got := started.Command.Lookup("index").StringValue(); got != "message_text" {
+			t.Errorf("index inconsistent, index:%v, expected index:%v", got, "message_text")
+		}
+		started = mt.GetStartedEvent()
+		if started.CommandName != "delete" {
+			t.Fatalf("command inconsistent, command:%v, expected command:%v", started.CommandName, "delete")
+		}
+	})
+}
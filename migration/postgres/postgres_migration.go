@@ -0,0 +1,154 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+
+	"github.com/kunmingliu/messenger/migration"
+)
+
+const tableName = "schema_migrations"
+
+type postgresStore struct {
+	DB *sql.DB
+}
+
+func NewPostgresStore(DB *sql.DB) migration.Store {
+	return &postgresStore{DB}
+}
+
+func (s *postgresStore) Records(ctx context.Context) ([]migration.Record, error) {
+	// the table of records is created on demand because it's needed before any migration is applied
+	_, err := s.DB.ExecContext(ctx, `CREATE TABLE IF NOT EXISTS `+tableName+` (
+		version INTEGER PRIMARY KEY,
+		description TEXT NOT NULL,
+		applied_at TIMESTAMPTZ NOT NULL
+	)`)
+	if err != nil {
+		return nil, err
+	}
+	rows, err := s.DB.QueryContext(ctx, `SELECT version, description, applied_at FROM `+tableName+` ORDER BY version`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var records []migration.Record
+	for rows.Next() {
+		var r migration.Record
+		if err = rows.Scan(&r.Version, &r.Description, &r.AppliedAt); err != nil {
+			return nil, err
+		}
+		records = append(records, r)
+	}
+	return records, rows.Err()
+}
+
+func (s *postgresStore) Insert(ctx context.Context, r migration.Record) error {
+	_, err := s.DB.ExecContext(ctx, `INSERT INTO `+tableName+` (version, description, applied_at) VALUES ($1, $2, $3)`,
+		r.Version, r.Description, r.AppliedAt)
+	return err
+}
+
+func (s *postgresStore) Delete(ctx context.Context, version int) error {
+	_, err := s.DB.ExecContext(ctx, `DELETE FROM `+tableName+` WHERE version = $1`, version)
+	return err
+}
+
+func exec(DB *sql.DB, query string) func(ctx context.Context) error {
+	return func(ctx context.Context) error {
+		_, err := DB.ExecContext(ctx, query)
+		return err
+	}
+}
+
+// Migrations returns every migration of PostgreSQL, a new migration should be appended with a greater version and the
+// released ones should never be changed.
+func Migrations(DB *sql.DB) []migration.Migration {
+	return []migration.Migration{
+		{
+			Version:     1,
+			Description: "create message and events tables",
+			Up: exec(DB, `
+				CREATE TABLE message (
+					id TEXT PRIMARY KEY,
+					event_id TEXT UNIQUE,
+					created_at TIMESTAMPTZ NOT NULL,
+					updated_at TIMESTAMPTZ,
+					user_id TEXT NOT NULL DEFAULT '',
+					direction TEXT NOT NULL DEFAULT '',
+					type TEXT NOT NULL DEFAULT '',
+					message TEXT NOT NULL DEFAULT '',
+					payload JSONB,
+					reply_token TEXT NOT NULL DEFAULT '',
+					reply_expires_at TIMESTAMPTZ,
+					status TEXT NOT NULL DEFAULT '',
+					error TEXT NOT NULL DEFAULT ''
+				);
+				CREATE TABLE events (
+					id TEXT PRIMARY KEY,
+					event_id TEXT UNIQUE,
+					created_at TIMESTAMPTZ NOT NULL,
+					timestamp TIMESTAMPTZ NOT NULL,
+					type TEXT NOT NULL,
+					user_id TEXT NOT NULL DEFAULT '',
+					group_id TEXT NOT NULL DEFAULT '',
+					room_id TEXT NOT NULL DEFAULT '',
+					members TEXT[] NOT NULL DEFAULT '{}'
+				);`),
+			Down: exec(DB, `DROP TABLE message; DROP TABLE events;`),
+		},
+		{
+			Version:     2,
+			Description: "create indexes for message and event queries",
+			Up: exec(DB, `
+				CREATE INDEX message_created_at_id ON message (created_at, id);
+				CREATE INDEX message_user_id_created_at_id ON message (user_id, created_at, id);
+				CREATE INDEX message_type_created_at ON message (type, created_at);
+				CREATE INDEX events_timestamp ON events (timestamp);
+				CREATE INDEX events_user_id_timestamp ON events (user_id, timestamp);
+				CREATE INDEX events_members ON events USING GIN (members);
+				CREATE INDEX events_type_timestamp ON events (type, timestamp);`),
+			Down: exec(DB, `
+				DROP INDEX message_created_at_id;
+				DROP INDEX message_user_id_created_at_id;
+				DROP INDEX message_type_created_at;
+				DROP INDEX events_timestamp;
+				DROP INDEX events_user_id_timestamp;
+				DROP INDEX events_members;
+				DROP INDEX events_type_timestamp;`),
+		},
+		{
+			Version:     3,
+			Description: "create full-text index for message search",
+			Up:          exec(DB, `CREATE INDEX message_text ON message USING GIN (to_tsvector('simple', message));`),
+			Down:        exec(DB, `DROP INDEX message_text;`),
+		},
+		{
+			Version:     4,
+			Description: "add channel_id and make event_id unique per channel",
+			Up: exec(DB, `
+				ALTER TABLE message ADD COLUMN channel_id TEXT NOT NULL DEFAULT '';
+				ALTER TABLE message DROP CONSTRAINT message_event_id_key;
+				ALTER TABLE message ADD CONSTRAINT message_channel_id_event_id_key UNIQUE (channel_id, event_id);
+				CREATE INDEX message_channel_id_created_at_id ON message (channel_id, created_at, id);
+				ALTER TABLE events ADD COLUMN channel_id TEXT NOT NULL DEFAULT '';
+				ALTER TABLE events DROP CONSTRAINT events_event_id_key;
+				ALTER TABLE events ADD CONSTRAINT events_channel_id_event_id_key UNIQUE (channel_id, event_id);
+				CREATE INDEX events_channel_id_timestamp ON events (channel_id, timestamp);`),
+			// it fails if different channels share an event id, which should be cleaned up before reverting
+			Down: exec(DB, `
+				DROP INDEX message_channel_id_created_at_id;
+				ALTER TABLE message DROP CONSTRAINT message_channel_id_event_id_key;
+				ALTER TABLE message ADD CONSTRAINT message_event_id_key UNIQUE (event_id);
+				ALTER TABLE message DROP COLUMN channel_id;
+				DROP INDEX events_channel_id_timestamp;
+				ALTER TABLE events DROP CONSTRAINT events_channel_id_event_id_key;
+				ALTER TABLE events ADD CONSTRAINT events_event_id_key UNIQUE (event_id);
+				ALTER TABLE events DROP COLUMN channel_id;`),
+		},
+	}
+}
+
+func NewMigrator(DB *sql.DB) *migration.Migrator {
+	return migration.NewMigrator(NewPostgresStore(DB), Migrations(DB)...)
+}
@@ -0,0 +1,62 @@
+package postgres
+
+import (
+	"context"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+func TestMigrations_Up(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	mock.ExpectExec(regexp.QuoteMeta("CREATE TABLE IF NOT EXISTS schema_migrations")).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT version, description, applied_at FROM schema_migrations ORDER BY version")).
+		WillReturnRows(sqlmock.NewRows([]string{"version", "description", "applied_at"}))
+	mock.ExpectExec(regexp.QuoteMeta("CREATE TABLE message")).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec(regexp.QuoteMeta("INSERT INTO schema_migrations (version, description, applied_at) VALUES ($1, $2, $3)")).
+		WithArgs(1, "create message and events tables", sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	if err = NewMigrator(db).Up(context.Background(), 1); err != nil {
+		t.Fatalf("unexpected error:%v", err)
+	}
+	if err = mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+func TestMigrations_Down(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	mock.ExpectExec(regexp.QuoteMeta("CREATE TABLE IF NOT EXISTS schema_migrations")).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT version, description, applied_at FROM schema_migrations ORDER BY version")).
+		WillReturnRows(sqlmock.NewRows([]string{"version", "description", "applied_at"}).
+			AddRow(1, "create message and events tables", time.Now()).
+			AddRow(2, "create indexes for message and event queries", time.Now()))
+	mock.ExpectExec(regexp.QuoteMeta("DROP INDEX message_created_at_id")).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec(regexp.QuoteMeta("DELETE FROM schema_migrations WHERE version = $1")).
+		WithArgs(2).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	if err = NewMigrator(db).Down(context.Background(), 1); err != nil {
+		t.Fatalf("unexpected error:%v", err)
+	}
+	if err = mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
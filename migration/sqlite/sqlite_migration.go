@@ -0,0 +1,274 @@
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/kunmingliu/messenger/migration"
+)
+
+const tableName = "schema_migrations"
+
+type sqliteStore struct {
+	DB *sql.DB
+}
+
+func NewSQLiteStore(DB *sql.DB) migration.Store {
+	return &sqliteStore{DB}
+}
+
+func (s *sqliteStore) Records(ctx context.Context) ([]migration.Record, error) {
+	// the table of records is created on demand because it's needed before any migration is applied
+	_, err := s.DB.ExecContext(ctx, `CREATE TABLE IF NOT EXISTS `+tableName+` (
+		version INTEGER PRIMARY KEY,
+		description TEXT NOT NULL,
+		applied_at INTEGER NOT NULL
+	)`)
+	if err != nil {
+		return nil, err
+	}
+	rows, err := s.DB.QueryContext(ctx, `SELECT version, description, applied_at FROM `+tableName+` ORDER BY version`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var records []migration.Record
+	for rows.Next() {
+		var (
+			r         migration.Record
+			appliedAt int64
+		)
+		if err = rows.Scan(&r.Version, &r.Description, &appliedAt); err != nil {
+			return nil, err
+		}
+		r.AppliedAt = time.Unix(0, appliedAt).UTC()
+		records = append(records, r)
+	}
+	return records, rows.Err()
+}
+
+func (s *sqliteStore) Insert(ctx context.Context, r migration.Record) error {
+	_, err := s.DB.ExecContext(ctx, `INSERT INTO `+tableName+` (version, description, applied_at) VALUES (?, ?, ?)`,
+		r.Version, r.Description, r.AppliedAt.UnixNano())
+	return err
+}
+
+func (s *sqliteStore) Delete(ctx context.Context, version int) error {
+	_, err := s.DB.ExecContext(ctx, `DELETE FROM `+tableName+` WHERE version = ?`, version)
+	return err
+}
+
+// messageColumns and eventColumns are the columns kept when the tables are rebuilt.
+const (
+	messageColumns = "id, event_id, created_at, updated_at, user_id, direction, type, message, payload, reply_token, " +
+		"reply_expires_at, status, error"
+	eventColumns = "id, event_id, created_at, timestamp, type, user_id, group_id, room_id, members"
+)
+
+// indexes and ftsTriggers are created again after the tables are rebuilt since they're dropped with the old tables.
+const (
+	indexes = `
+		CREATE INDEX message_created_at_id ON message (created_at, id);
+		CREATE INDEX message_user_id_created_at_id ON message (user_id, created_at, id);
+		CREATE INDEX message_type_created_at ON message (type, created_at);
+		CREATE INDEX events_timestamp ON events (timestamp);
+		CREATE INDEX events_user_id_timestamp ON events (user_id, timestamp);
+		CREATE INDEX events_type_timestamp ON events (type, timestamp);`
+	ftsTriggers = `
+		CREATE TRIGGER message_fts_insert AFTER INSERT ON message BEGIN
+			INSERT INTO message_fts (rowid, message) VALUES (new.rowid, new.message);
+		END;
+		CREATE TRIGGER message_fts_delete AFTER DELETE ON message BEGIN
+			INSERT INTO message_fts (message_fts, rowid, message) VALUES ('delete', old.rowid, old.message);
+		END;
+		CREATE TRIGGER message_fts_update AFTER UPDATE OF message ON message BEGIN
+			INSERT INTO message_fts (message_fts, rowid, message) VALUES ('delete', old.rowid, old.message);
+			INSERT INTO message_fts (rowid, message) VALUES (new.rowid, new.message);
+		END;`
+)
+
+// exec runs the statements of a migration in a transaction, so a migration failing halfway leaves the schema as it was.
+func exec(DB *sql.DB, query string) func(ctx context.Context) error {
+	return func(ctx context.Context) error {
+		tx, err := DB.BeginTx(ctx, nil)
+		if err != nil {
+			return err
+		}
+		defer tx.Rollback()
+		if _, err = tx.ExecContext(ctx, query); err != nil {
+			return err
+		}
+		return tx.Commit()
+	}
+}
+
+// Migrations returns every migration of SQLite, a new migration should be appended with a greater version and the
+// released ones should never be changed.
+//
+// Times are stored as nanoseconds since the Unix epoch so that they are compared and ordered as numbers.
+func Migrations(DB *sql.DB) []migration.Migration {
+	return []migration.Migration{
+		{
+			Version:     1,
+			Description: "create message and events tables",
+			Up: exec(DB, `
+				CREATE TABLE message (
+					id TEXT PRIMARY KEY,
+					event_id TEXT UNIQUE,
+					created_at INTEGER NOT NULL,
+					updated_at INTEGER,
+					user_id TEXT NOT NULL DEFAULT '',
+					direction TEXT NOT NULL DEFAULT '',
+					type TEXT NOT NULL DEFAULT '',
+					message TEXT NOT NULL DEFAULT '',
+					payload TEXT,
+					reply_token TEXT NOT NULL DEFAULT '',
+					reply_expires_at INTEGER,
+					status TEXT NOT NULL DEFAULT '',
+					error TEXT NOT NULL DEFAULT ''
+				);
+				CREATE TABLE events (
+					id TEXT PRIMARY KEY,
+					event_id TEXT UNIQUE,
+					created_at INTEGER NOT NULL,
+					timestamp INTEGER NOT NULL,
+					type TEXT NOT NULL,
+					user_id TEXT NOT NULL DEFAULT '',
+					group_id TEXT NOT NULL DEFAULT '',
+					room_id TEXT NOT NULL DEFAULT '',
+					members TEXT NOT NULL DEFAULT '[]'
+				);`),
+			Down: exec(DB, `DROP TABLE message; DROP TABLE events;`),
+		},
+		{
+			Version:     2,
+			Description: "create indexes for message and event queries",
+			Up: exec(DB, `
+				CREATE INDEX message_created_at_id ON message (created_at, id);
+				CREATE INDEX message_user_id_created_at_id ON message (user_id, created_at, id);
+				CREATE INDEX message_type_created_at ON message (type, created_at);
+				CREATE INDEX events_timestamp ON events (timestamp);
+				CREATE INDEX events_user_id_timestamp ON events (user_id, timestamp);
+				CREATE INDEX events_type_timestamp ON events (type, timestamp);`),
+			Down: exec(DB, `
+				DROP INDEX message_created_at_id;
+				DROP INDEX message_user_id_created_at_id;
+				DROP INDEX message_type_created_at;
+				DROP INDEX events_timestamp;
+				DROP INDEX events_user_id_timestamp;
+				DROP INDEX events_type_timestamp;`),
+		},
+		{
+			Version:     3,
+			Description: "create full-text index for message search",
+			// the external content table is kept in sync with message by the triggers
+			Up: exec(DB, `
+				CREATE VIRTUAL TABLE message_fts USING fts5(message, content='message', content_rowid='rowid');
+				INSERT INTO message_fts (rowid, message) SELECT rowid, message FROM message;
+				CREATE TRIGGER message_fts_insert AFTER INSERT ON message BEGIN
+					INSERT INTO message_fts (rowid, message) VALUES (new.rowid, new.message);
+				END;
+				CREATE TRIGGER message_fts_delete AFTER DELETE ON message BEGIN
+					INSERT INTO message_fts (message_fts, rowid, message) VALUES ('delete', old.rowid, old.message);
+				END;
+				CREATE TRIGGER message_fts_update AFTER UPDATE OF message ON message BEGIN
+					INSERT INTO message_fts (message_fts, rowid, message) VALUES ('delete', old.rowid, old.message);
+					INSERT INTO message_fts (rowid, message) VALUES (new.rowid, new.message);
+				END;`),
+			Down: exec(DB, `
+				DROP TRIGGER message_fts_insert;
+				DROP TRIGGER message_fts_delete;
+				DROP TRIGGER message_fts_update;
+				DROP TABLE message_fts;`),
+		},
+		{
+			Version:     4,
+			Description: "add channel_id and make event_id unique per channel",
+			// SQLite can't drop a constraint, so the tables are rebuilt with their rowids which message_fts refers to,
+			// and the indexes and triggers dropped with the old tables are created again.
+			Up: exec(DB, `
+				CREATE TABLE message_new (
+					id TEXT PRIMARY KEY,
+					channel_id TEXT NOT NULL DEFAULT '',
+					event_id TEXT,
+					created_at INTEGER NOT NULL,
+					updated_at INTEGER,
+					user_id TEXT NOT NULL DEFAULT '',
+					direction TEXT NOT NULL DEFAULT '',
+					type TEXT NOT NULL DEFAULT '',
+					message TEXT NOT NULL DEFAULT '',
+					payload TEXT,
+					reply_token TEXT NOT NULL DEFAULT '',
+					reply_expires_at INTEGER,
+					status TEXT NOT NULL DEFAULT '',
+					error TEXT NOT NULL DEFAULT '',
+					UNIQUE (channel_id, event_id)
+				);
+				INSERT INTO message_new (rowid, `+messageColumns+`) SELECT rowid, `+messageColumns+` FROM message;
+				DROP TABLE message;
+				ALTER TABLE message_new RENAME TO message;
+				CREATE TABLE events_new (
+					id TEXT PRIMARY KEY,
+					channel_id TEXT NOT NULL DEFAULT '',
+					event_id TEXT,
+					created_at INTEGER NOT NULL,
+					timestamp INTEGER NOT NULL,
+					type TEXT NOT NULL,
+					user_id TEXT NOT NULL DEFAULT '',
+					group_id TEXT NOT NULL DEFAULT '',
+					room_id TEXT NOT NULL DEFAULT '',
+					members TEXT NOT NULL DEFAULT '[]',
+					UNIQUE (channel_id, event_id)
+				);
+				INSERT INTO events_new (`+eventColumns+`) SELECT `+eventColumns+` FROM events;
+				DROP TABLE events;
+				ALTER TABLE events_new RENAME TO events;
+				`+indexes+`
+				CREATE INDEX message_channel_id_created_at_id ON message (channel_id, created_at, id);
+				CREATE INDEX events_channel_id_timestamp ON events (channel_id, timestamp);
+				`+ftsTriggers),
+			// the messages and events sharing an event id in different channels can't be kept after reverting, so it fails
+			// on the unique event id instead of dropping them
+			Down: exec(DB, `
+				CREATE TABLE message_old (
+					id TEXT PRIMARY KEY,
+					event_id TEXT UNIQUE,
+					created_at INTEGER NOT NULL,
+					updated_at INTEGER,
+					user_id TEXT NOT NULL DEFAULT '',
+					direction TEXT NOT NULL DEFAULT '',
+					type TEXT NOT NULL DEFAULT '',
+					message TEXT NOT NULL DEFAULT '',
+					payload TEXT,
+					reply_token TEXT NOT NULL DEFAULT '',
+					reply_expires_at INTEGER,
+					status TEXT NOT NULL DEFAULT '',
+					error TEXT NOT NULL DEFAULT ''
+				);
+				INSERT INTO message_old (rowid, `+messageColumns+`) SELECT rowid, `+messageColumns+` FROM message;
+				DROP TABLE message;
+				ALTER TABLE message_old RENAME TO message;
+				CREATE TABLE events_old (
+					id TEXT PRIMARY KEY,
+					event_id TEXT UNIQUE,
+					created_at INTEGER NOT NULL,
+					timestamp INTEGER NOT NULL,
+					type TEXT NOT NULL,
+					user_id TEXT NOT NULL DEFAULT '',
+					group_id TEXT NOT NULL DEFAULT '',
+					room_id TEXT NOT NULL DEFAULT '',
+					members TEXT NOT NULL DEFAULT '[]'
+				);
+				INSERT INTO events_old (`+eventColumns+`) SELECT `+eventColumns+` FROM events;
+				DROP TABLE events;
+				ALTER TABLE events_old RENAME TO events;
+				INSERT INTO message_fts (message_fts) VALUES ('rebuild');
+				`+indexes+ftsTriggers),
+		},
+	}
+}
+
+func NewMigrator(DB *sql.DB) *migration.Migrator {
+	return migration.NewMigrator(NewSQLiteStore(DB), Migrations(DB)...)
+}
@@ -0,0 +1,110 @@
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	"path/filepath"
+	"testing"
+
+	_ "modernc.org/sqlite"
+)
+
+func tables(t *testing.T, db *sql.DB) map[string]bool {
+	rows, err := db.Query("SELECT name FROM sqlite_master WHERE type IN ('table', 'index', 'trigger') AND name NOT LIKE 'sqlite_%'")
+	if err != nil {
+		t.Fatalf("unexpected error:%v", err)
+	}
+	defer rows.Close()
+	result := map[string]bool{}
+	for rows.Next() {
+		var name string
+		if err = rows.Scan(&name); err != nil {
+			t.Fatalf("unexpected error:%v", err)
+		}
+		result[name] = true
+	}
+	return result
+}
+
+func TestMigrations(t *testing.T) {
+	ctx := context.Background()
+	db, err := sql.Open("sqlite", filepath.Join(t.TempDir(), "messenger.db"))
+	if err != nil {
+		t.Fatalf("unexpected error:%v", err)
+	}
+	defer db.Close()
+
+	m := NewMigrator(db)
+	if err = m.Up(ctx, m.Latest()); err != nil {
+		t.Fatalf("unexpected error:%v", err)
+	}
+	version, err := m.Version(ctx)
+	if err != nil {
+		t.Fatalf("unexpected error:%v", err)
+	}
+	if version != m.Latest() {
+		t.Errorf("version inconsistent, version:%v, expected version:%v", version, m.Latest())
+	}
+	for _, name := range []string{"message", "events", "message_created_at_id", "message_fts", "message_fts_insert"} {
+		if !tables(t, db)[name] {
+			t.Errorf("%s should be created", name)
+		}
+	}
+
+	// the full-text index is kept in sync with the message table
+	_, err = db.Exec(`INSERT INTO message (id, created_at, message) VALUES ('1', 0, 'hello world')`)
+	if err != nil {
+		t.Fatalf("unexpected error:%v", err)
+	}
+	_, err = db.Exec(`UPDATE message SET message = 'goodbye world' WHERE id = '1'`)
+	if err != nil {
+		t.Fatalf("unexpected error:%v", err)
+	}
+	var count int
+	err = db.QueryRow(`SELECT COUNT(*) FROM message_fts WHERE message_fts MATCH 'goodbye NOT hello'`).Scan(&count)
+	if err != nil {
+		t.Fatalf("unexpected error:%v", err)
+	}
+	if count != 1 {
+		t.Errorf("count inconsistent, count:%v, expected count:%v", count, 1)
+	}
+
+	// the event ids are only unique in a channel
+	_, err = db.Exec(`INSERT INTO message (id, channel_id, event_id, created_at) VALUES ('2', 'line', 'E1', 0), ('3', 'telegram', 'E1', 0)`)
+	if err != nil {
+		t.Fatalf("unexpected error:%v", err)
+	}
+	_, err = db.Exec(`INSERT INTO message (id, channel_id, event_id, created_at) VALUES ('4', 'line', 'E1', 0)`)
+	if err == nil {
+		t.Errorf("inserting a duplicated event id in the same channel should fail")
+	}
+
+	// reverting fails and keeps the messages which would share an event id without their channels
+	if err = m.Down(ctx, 0); err == nil {
+		t.Fatalf("reverting should fail for an event id shared by channels")
+	}
+	if version, err = m.Version(ctx); err != nil || version != m.Latest() {
+		t.Errorf("version inconsistent, version:%v, expected version:%v, err:%v", version, m.Latest(), err)
+	}
+	err = db.QueryRow(`SELECT COUNT(*) FROM message WHERE event_id = 'E1'`).Scan(&count)
+	if err != nil {
+		t.Fatalf("unexpected error:%v", err)
+	}
+	if count != 2 {
+		t.Errorf("count inconsistent, count:%v, expected count:%v", count, 2)
+	}
+
+	if _, err = db.Exec(`DELETE FROM message WHERE id = '3'`); err != nil {
+		t.Fatalf("unexpected error:%v", err)
+	}
+	if err = m.Down(ctx, 0); err != nil {
+		t.Fatalf("unexpected error:%v", err)
+	}
+	if remains := tables(t, db); len(remains) != 1 || !remains[tableName] {
+		t.Errorf("only %s should remain, tables:%v", tableName, remains)
+	}
+	// the migrations can be applied again after reverting
+	if err = m.Up(ctx, m.Latest()); err != nil {
+		t.Fatalf("unexpected error:%v", err)
+	}
+}
@@ -0,0 +1,257 @@
+package discord
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/kunmingliu/messenger/domain"
+	"github.com/kunmingliu/messenger/provider"
+)
+
+const (
+	// DefaultBaseURL is the endpoint of the REST API.
+	DefaultBaseURL = "https://discord.com/api/v10"
+	// InteractionTokenTTL is how long the token of an interaction can be used to send follow-up messages.
+	InteractionTokenTTL = 15 * time.Minute
+)
+
+// the types of interactions and their responses
+const (
+	interactionTypePing             = 1
+	interactionTypeCommand          = 2
+	interactionTypeMessageComponent = 3
+
+	responseTypePong                   = 1
+	responseTypeDeferredChannelMessage = 5
+	responseTypeDeferredUpdateMessage  = 6
+)
+
+// DiscordProvider talks to a Discord application with the interactions endpoint and the REST API of its bot. The user
+// id of messages is the id of the channel where the interaction happens, so that the messages sent to it reach the
+// same channel.
+type DiscordProvider struct {
+	applicationID string
+	publicKey     ed25519.PublicKey
+	token         string
+	baseURL       string
+	client        *http.Client
+	now           func() time.Time
+}
+
+type Option func(*DiscordProvider)
+
+// WithBaseURL points the provider at another REST API server, e.g. a fake for tests.
+func WithBaseURL(baseURL string) Option {
+	return func(d *DiscordProvider) {
+		d.baseURL = strings.TrimSuffix(baseURL, "/")
+	}
+}
+
+func WithHTTPClient(client *http.Client) Option {
+	return func(d *DiscordProvider) {
+		d.client = client
+	}
+}
+
+// NewDiscordProvider creates the provider of the application with its id, the public key in hex and the bot token.
+func NewDiscordProvider(applicationID, publicKey, token string, options ...Option) (*DiscordProvider, error) {
+	if applicationID == "" || token == "" {
+		return nil, errors.New("application id and token of discord shouldn't be empty")
+	}
+	key, err := hex.DecodeString(publicKey)
+	if err != nil || len(key) != ed25519.PublicKeySize {
+		return nil, errors.New("public key of discord should be an ed25519 public key in hex")
+	}
+	d := &DiscordProvider{
+		applicationID: applicationID,
+		publicKey:     key,
+		token:         token,
+		baseURL:       DefaultBaseURL,
+		client:        provider.NewHTTPClient(),
+		now:           time.Now,
+	}
+	for _, option := range options {
+		option(d)
+	}
+	return d, nil
+}
+
+// verify checks X-Signature-Ed25519, which is the signature of the timestamp and the body.
+func (d *DiscordProvider) verify(r *http.Request, body []byte) error {
+	signature, err := hex.DecodeString(r.Header.Get("X-Signature-Ed25519"))
+	if err != nil || len(signature) != ed25519.SignatureSize {
+		return domain.ErrInvalidSignature
+	}
+	message := append([]byte(r.Header.Get("X-Signature-Timestamp")), body...)
+	if !ed25519.Verify(d.publicKey, message, signature) {
+		return domain.ErrInvalidSignature
+	}
+	return nil
+}
+
+type option struct {
+	Name    string          `json:"name"`
+	Value   json.RawMessage `json:"value"`
+	Options []option        `json:"options"`
+}
+
+type interaction struct {
+	ID        string `json:"id"`
+	Type      int    `json:"type"`
+	ChannelID string `json:"channel_id"`
+	Token     string `json:"token"`
+	Data      struct {
+		// Name and Options are of commands, CustomID and Values are of components.
+		Name     string   `json:"name"`
+		Options  []option `json:"options"`
+		CustomID string   `json:"custom_id"`
+		Values   []string `json:"values"`
+	} `json:"data"`
+}
+
+func jsonResponse(responseType int) *domain.WebhookResponse {
+	return &domain.WebhookResponse{
+		StatusCode:  http.StatusOK,
+		ContentType: "application/json",
+		Body:        []byte(fmt.Sprintf(`{"type":%d}`, responseType)),
+	}
+}
+
+// ParseRequest answers PING and stores commands and components as messages. The interactions are deferred since the
+// response is due in 3 seconds, their tokens are kept as reply tokens to send follow-up messages.
+func (d *DiscordProvider) ParseRequest(r *http.Request) (webhook domain.Webhook, err error) {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		return
+	}
+	if err = d.verify(r, body); err != nil {
+		return
+	}
+
+	var i interaction
+	if err = json.Unmarshal(body, &i); err != nil {
+		return
+	}
+
+	msg := domain.Message{
+		EventID:    i.ID,
+		UserID:     i.ChannelID,
+		ReplyToken: i.Token,
+	}
+	switch i.Type {
+	case interactionTypePing:
+		webhook.Response = jsonResponse(responseTypePong)
+		return
+	case interactionTypeCommand:
+		msg.Type = domain.MessageTypeText
+		msg.Message = commandText(i.Data.Name, i.Data.Options)
+		webhook.Response = jsonResponse(responseTypeDeferredChannelMessage)
+	case interactionTypeMessageComponent:
+		msg.Type = domain.MessageTypePostback
+		msg.Message = strings.Join(i.Data.Values, ",")
+		msg.Payload = &domain.Payload{Postback: &domain.Postback{Data: i.Data.CustomID}}
+		webhook.Response = jsonResponse(responseTypeDeferredUpdateMessage)
+	default:
+		return
+	}
+	expiresAt := d.now().Add(InteractionTokenTTL).UTC()
+	msg.ReplyExpiresAt = &expiresAt
+	webhook.Messages = append(webhook.Messages, msg)
+	return
+}
+
+// commandText writes the command the way Discord shows it, e.g. "/order item:apple count:2".
+func commandText(name string, options []option) string {
+	var b strings.Builder
+	b.WriteString("/" + name)
+	for _, o := range options {
+		if len(o.Value) == 0 {
+			// a subcommand or a group of them
+			b.WriteString(" " + strings.TrimPrefix(commandText(o.Name, o.Options), "/"))
+			continue
+		}
+		var value interface{}
+		if err := json.Unmarshal(o.Value, &value); err != nil {
+			value = string(o.Value)
+		}
+		b.WriteString(fmt.Sprintf(" %s:%v", o.Name, value))
+	}
+	return b.String()
+}
+
+// call sends the request of the action to the REST API with the bot token, an error is returned if the status isn't 2xx.
+// The errors name the action instead of the path since the path of follow-up messages has the interaction token.
+func (d *DiscordProvider) call(ctx context.Context, action, method, path string, body interface{}) error {
+	b, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, method, d.baseURL+path, bytes.NewReader(b))
+	if err != nil {
+		return fmt.Errorf("discord %s failed, err:%w", action, provider.RedactURL(err))
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bot "+d.token)
+	res, err := d.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("discord %s failed, err:%w", action, provider.RedactURL(err))
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode/100 != 2 {
+		var resp struct {
+			Code    int    `json:"code"`
+			Message string `json:"message"`
+		}
+		if err = json.NewDecoder(res.Body).Decode(&resp); err != nil {
+			return fmt.Errorf("discord %s failed, status:%d, err:%w", action, res.StatusCode, err)
+		}
+		return fmt.Errorf("discord %s failed, status:%d, code:%d, err:%s", action, res.StatusCode, resp.Code, resp.Message)
+	}
+	return nil
+}
+
+// SendMessage fails since a bot can only send to a single channel at a time.
+func (d *DiscordProvider) SendMessage(ctx context.Context, msg string) error {
+	return provider.ErrBroadcastNotSupported
+}
+
+// CanBroadcast is false since a bot has no channel list to broadcast to.
+func (d *DiscordProvider) CanBroadcast() bool {
+	return false
+}
+
+// ReplyMessage sends a follow-up message of the interaction, the first one replaces the loading message of a deferred
+// command.
+func (d *DiscordProvider) ReplyMessage(ctx context.Context, replyToken, msg string) error {
+	path := "/webhooks/" + url.PathEscape(d.applicationID) + "/" + url.PathEscape(replyToken)
+	return d.call(ctx, "reply", http.MethodPost, path, map[string]string{"content": msg})
+}
+
+func (d *DiscordProvider) PushMessage(ctx context.Context, userID, msg string) error {
+	path := "/channels/" + url.PathEscape(userID) + "/messages"
+	return d.call(ctx, "send", http.MethodPost, path, map[string]string{"content": msg})
+}
+
+// Multicast sends the message to every channel one by one since the REST API has no multicast.
+func (d *DiscordProvider) Multicast(ctx context.Context, userIDs []string, msg string) error {
+	return provider.MulticastEach(userIDs, func(userID string) error {
+		return d.PushMessage(ctx, userID, msg)
+	})
+}
+
+// GetContent fails since the interactions stored have no media.
+func (d *DiscordProvider) GetContent(ctx context.Context, contentID string) (content io.ReadCloser, contentType string, err error) {
+	err = fmt.Errorf("%w: discord messages have no content", domain.ErrBadParamInput)
+	return
+}
@@ -0,0 +1,199 @@
+package discord
+
+import (
+	"context"
+	"crypto/ed25519"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/kunmingliu/messenger/domain"
+	"github.com/kunmingliu/messenger/internal/providertest"
+)
+
+// newProvider creates the provider on a fake of the REST API, which records the messages sent by path, and returns
+// the key the interactions are signed with.
+func newProvider(t *testing.T, now time.Time) (*DiscordProvider, *providertest.Recorder[string], ed25519.PrivateKey) {
+	server := providertest.NewServer(t)
+	sent := &providertest.Recorder[string]{}
+	send := func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			providertest.WriteJSON(w, http.StatusMethodNotAllowed, `{"code":0,"message":"405: Method Not Allowed"}`)
+			return
+		}
+		var body map[string]string
+		json.NewDecoder(r.Body).Decode(&body)
+		sent.Add(r.URL.Path + " " + body["content"])
+		providertest.WriteJSON(w, http.StatusOK, `{"id":"1"}`)
+	}
+	authorized := func(handler http.HandlerFunc) http.HandlerFunc {
+		return providertest.RequireHeader("Authorization", "Bot token", http.StatusUnauthorized, `{"code":0,"message":"401: Unauthorized"}`, handler)
+	}
+	server.Handle("/channels/", authorized(send))
+	server.Handle("/webhooks/app/", authorized(send))
+	server.Handle("/webhooks/app/expired-token", func(w http.ResponseWriter, r *http.Request) {
+		providertest.WriteJSON(w, http.StatusNotFound, `{"code":10015,"message":"Unknown Webhook"}`)
+	})
+	server.Handle("/channels/403/messages", func(w http.ResponseWriter, r *http.Request) {
+		providertest.WriteJSON(w, http.StatusForbidden, `{"code":50001,"message":"Missing Access"}`)
+	})
+
+	key := ed25519.NewKeyFromSeed(make([]byte, ed25519.SeedSize))
+	publicKey := hex.EncodeToString(key.Public().(ed25519.PublicKey))
+	p, err := NewDiscordProvider("app", publicKey, "token", WithBaseURL(server.URL))
+	if err != nil {
+		t.Fatalf("unexpected error:%v", err)
+	}
+	p.now = func() time.Time { return now }
+	return p, sent, key
+}
+
+func TestDiscordProvider_ParseRequest(t *testing.T) {
+	now := time.Date(2022, 11, 1, 0, 0, 0, 0, time.UTC)
+	expiresAt := now.Add(InteractionTokenTTL)
+	p, _, key := newProvider(t, now)
+	_, otherKey, _ := ed25519.GenerateKey(nil)
+
+	cases := []struct {
+		name        string
+		key         ed25519.PrivateKey
+		body        string
+		expected    domain.Webhook
+		expectedErr error
+	}{
+		{
+			name: "ping",
+			key:  key,
+			body: `{"id":"1","application_id":"app","type":1,"token":"t1","version":1}`,
+			expected: domain.Webhook{Response: &domain.WebhookResponse{
+				StatusCode: http.StatusOK, ContentType: "application/json", Body: []byte(`{"type":1}`),
+			}},
+		},
+		{
+			name: "slash command",
+			key:  key,
+			body: `{"id":"2","type":2,"channel_id":"C1","token":"t2","member":{"user":{"id":"U1"}},` +
+				`"data":{"name":"order","options":[{"name":"item","type":3,"value":"apple"},{"name":"count","type":4,"value":2}]}}`,
+			expected: domain.Webhook{
+				Messages: []domain.Message{{
+					EventID: "2", UserID: "C1", Type: domain.MessageTypeText, Message: "/order item:apple count:2",
+					ReplyToken: "t2", ReplyExpiresAt: &expiresAt,
+				}},
+				Response: &domain.WebhookResponse{
+					StatusCode: http.StatusOK, ContentType: "application/json", Body: []byte(`{"type":5}`),
+				},
+			},
+		},
+		{
+			name: "subcommand",
+			key:  key,
+			body: `{"id":"3","type":2,"channel_id":"C1","token":"t3","data":{"name":"ticket","options":[{"name":"close","type":1,"options":[{"name":"id","type":4,"value":7}]}]}}`,
+			expected: domain.Webhook{
+				Messages: []domain.Message{{
+					EventID: "3", UserID: "C1", Type: domain.MessageTypeText, Message: "/ticket close id:7",
+					ReplyToken: "t3", ReplyExpiresAt: &expiresAt,
+				}},
+				Response: &domain.WebhookResponse{
+					StatusCode: http.StatusOK, ContentType: "application/json", Body: []byte(`{"type":5}`),
+				},
+			},
+		},
+		{
+			name: "select menu",
+			key:  key,
+			body: `{"id":"4","type":3,"channel_id":"C1","token":"t4","data":{"custom_id":"color","component_type":3,"values":["red","blue"]}}`,
+			expected: domain.Webhook{
+				Messages: []domain.Message{{
+					EventID: "4", UserID: "C1", Type: domain.MessageTypePostback, Message: "red,blue",
+					Payload:    &domain.Payload{Postback: &domain.Postback{Data: "color"}},
+					ReplyToken: "t4", ReplyExpiresAt: &expiresAt,
+				}},
+				Response: &domain.WebhookResponse{
+					StatusCode: http.StatusOK, ContentType: "application/json", Body: []byte(`{"type":6}`),
+				},
+			},
+		},
+		{
+			name:        "invalid signature",
+			key:         otherKey,
+			body:        `{"id":"5","type":1}`,
+			expectedErr: domain.ErrInvalidSignature,
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			timestamp := "1667260800"
+			req := httptest.NewRequest(http.MethodPost, "/webhook/discord", strings.NewReader(c.body))
+			req.Header.Set("X-Signature-Timestamp", timestamp)
+			req.Header.Set("X-Signature-Ed25519", hex.EncodeToString(ed25519.Sign(c.key, []byte(timestamp+c.body))))
+			got, err := p.ParseRequest(req)
+			if !errors.Is(err, c.expectedErr) {
+				t.Fatalf("error inconsistent, caught error:%v, expected error:%v", err, c.expectedErr)
+			}
+			if !reflect.DeepEqual(got, c.expected) {
+				t.Errorf("data inconsistent, data:%+v, expected data:%+v", got, c.expected)
+			}
+		})
+	}
+}
+
+func TestDiscordProvider_Send(t *testing.T) {
+	p, sent, _ := newProvider(t, time.Now())
+
+	if err := p.PushMessage(context.Background(), "C1", "hello"); err != nil {
+		t.Fatalf("unexpected error:%v", err)
+	}
+	if err := p.ReplyMessage(context.Background(), "t2", "done"); err != nil {
+		t.Fatalf("unexpected error:%v", err)
+	}
+	err := p.Multicast(context.Background(), []string{"C2", "403", "C3"}, "hi")
+	if err == nil || !strings.Contains(err.Error(), "Missing Access") {
+		t.Errorf("error inconsistent, caught error:%v, expected the error of the inaccessible channel", err)
+	}
+	if err = p.SendMessage(context.Background(), "broadcast"); !errors.Is(err, domain.ErrBadParamInput) {
+		t.Errorf("error inconsistent, caught error:%v, expected error:%v", err, domain.ErrBadParamInput)
+	}
+	if p.CanBroadcast() {
+		t.Errorf("broadcast should be unsupported")
+	}
+	if _, _, err = p.GetContent(context.Background(), "F1"); !errors.Is(err, domain.ErrBadParamInput) {
+		t.Errorf("error inconsistent, caught error:%v, expected error:%v", err, domain.ErrBadParamInput)
+	}
+
+	expected := []string{
+		"/channels/C1/messages hello",
+		"/webhooks/app/t2 done",
+		"/channels/C2/messages hi",
+		"/channels/C3/messages hi",
+	}
+	if got := sent.Items(); !reflect.DeepEqual(got, expected) {
+		t.Errorf("messages sent inconsistent, sent:%v, expected sent:%v", got, expected)
+	}
+}
+
+func TestDiscordProvider_ReplyRedactsToken(t *testing.T) {
+	p, _, _ := newProvider(t, time.Now())
+
+	err := p.ReplyMessage(context.Background(), "expired-token", "done")
+	if err == nil || !strings.Contains(err.Error(), "Unknown Webhook") || strings.Contains(err.Error(), "expired-token") {
+		t.Errorf("error inconsistent, caught error:%v, expected the error of the expired interaction without its token", err)
+	}
+
+	p.baseURL = providertest.ClosedURL()
+	err = p.ReplyMessage(context.Background(), "expired-token", "done")
+	if err == nil || strings.Contains(err.Error(), "expired-token") {
+		t.Errorf("error inconsistent, caught error:%v, expected an error without the token", err)
+	}
+}
+
+func TestNewDiscordProvider(t *testing.T) {
+	if _, err := NewDiscordProvider("app", "not a key", "token"); err == nil {
+		t.Errorf("creating the provider should fail for an invalid public key")
+	}
+}
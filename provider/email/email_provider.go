@@ -0,0 +1,326 @@
+package email
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"mime/quotedprintable"
+	"net"
+	"net/http"
+	"net/mail"
+	"net/smtp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/kunmingliu/messenger/domain"
+	"github.com/kunmingliu/messenger/provider"
+)
+
+// DefaultSubject is the subject of the mails sent if it isn't set.
+const DefaultSubject = "New message"
+
+// EmailProvider receives the mails sent to an address with an embedded SMTP server and sends mails through an SMTP
+// relay. The user id of messages is the address of the sender in lower case.
+//
+// The SMTP server doesn't check SPF or DKIM, so the sender can be forged by anyone reaching it. It should only be
+// reachable from a mail service which verifies the mails before forwarding them.
+type EmailProvider struct {
+	address string
+	domain  string
+	relay   string
+	auth    smtp.Auth
+	subject string
+
+	mu sync.Mutex
+	// contents keeps the attachments of the mails being handled for GetContent.
+	contents map[string]attachment
+}
+
+type Option func(*EmailProvider)
+
+// WithAuth logs in to the relay with PLAIN authentication, which is only allowed with TLS or on localhost.
+func WithAuth(username, password string) Option {
+	return func(e *EmailProvider) {
+		host, _, _ := net.SplitHostPort(e.relay)
+		e.auth = smtp.PlainAuth("", username, password, host)
+	}
+}
+
+func WithSubject(subject string) Option {
+	return func(e *EmailProvider) {
+		e.subject = subject
+	}
+}
+
+// NewEmailProvider creates the provider of the address, which receives mails and is the sender of the mails sent
+// through the relay at host:port.
+func NewEmailProvider(address, relay string, options ...Option) (*EmailProvider, error) {
+	if address == "" || relay == "" {
+		return nil, errors.New("address and relay of email shouldn't be empty")
+	}
+	addr, err := mail.ParseAddress(address)
+	if err != nil {
+		return nil, fmt.Errorf("invalid email address: %w", err)
+	}
+	if _, _, err = net.SplitHostPort(relay); err != nil {
+		return nil, fmt.Errorf("invalid email relay: %w", err)
+	}
+	e := &EmailProvider{
+		address:  strings.ToLower(addr.Address),
+		relay:    relay,
+		subject:  DefaultSubject,
+		contents: make(map[string]attachment),
+	}
+	e.domain = e.address[strings.LastIndexByte(e.address, '@')+1:]
+	for _, option := range options {
+		option(e)
+	}
+	return e, nil
+}
+
+// ParseRequest fails since mails don't come from webhooks.
+func (e *EmailProvider) ParseRequest(r *http.Request) (webhook domain.Webhook, err error) {
+	err = fmt.Errorf("%w: email is received by the SMTP server", domain.ErrNotFound)
+	return
+}
+
+// Serve receives the mails sent to the address on the listener until it's closed, and passes the messages of each
+// mail to handle. A mail is rejected with a temporary error if handle fails, so the sender will retry it, and an
+// invalid one is rejected permanently.
+func (e *EmailProvider) Serve(l net.Listener, handle func(webhook domain.Webhook) error) error {
+	server := &smtpServer{
+		domain: e.domain,
+		accept: func(rcpt string) bool {
+			return strings.EqualFold(rcpt, e.address)
+		},
+		handle: func(from string, data []byte) error {
+			webhook, err := e.convert(from, data)
+			if err != nil {
+				return err
+			}
+			// the attachments are stored by handle, or the mail is retried and they're received again
+			defer e.dropContents(webhook.Messages)
+			return handle(webhook)
+		},
+	}
+	return server.serve(l)
+}
+
+// convert turns the mail into a text message of the subject and the body, and a message for each attachment. The
+// sender is the address in the From header, or the one of the envelope if the header is missing.
+func (e *EmailProvider) convert(from string, data []byte) (webhook domain.Webhook, err error) {
+	p, err := parseMail(data)
+	if err != nil {
+		err = fmt.Errorf("%w: %v", domain.ErrBadParamInput, err)
+		return
+	}
+	sender := p.from
+	if sender == "" {
+		sender = strings.ToLower(from)
+	}
+	if sender == "" {
+		err = fmt.Errorf("%w: the mail has no sender", domain.ErrBadParamInput)
+		return
+	}
+
+	body := p.text
+	if body == "" {
+		body = p.html
+	}
+	if p.subject != "" || body != "" {
+		text := strings.TrimSpace(body)
+		if p.subject != "" {
+			text = strings.TrimSpace(p.subject + "\n\n" + text)
+		}
+		webhook.Messages = append(webhook.Messages, domain.Message{
+			EventID: p.messageID,
+			UserID:  sender,
+			Type:    domain.MessageTypeText,
+			Message: text,
+		})
+	}
+
+	for i, a := range p.attachments {
+		contentID, err := newID()
+		if err != nil {
+			return webhook, err
+		}
+		msg := domain.Message{
+			UserID:  sender,
+			Type:    mediaType(a.contentType),
+			Payload: &domain.Payload{Media: &domain.Media{ContentID: contentID, ContentType: a.contentType}},
+		}
+		if msg.Type == domain.MessageTypeFile {
+			msg.Payload.File = &domain.File{Name: a.name, Size: len(a.data)}
+		}
+		if p.messageID != "" {
+			// the attachments are parts of the same mail, so they share its id
+			msg.EventID = fmt.Sprintf("%s#%d", p.messageID, i+1)
+		}
+		e.mu.Lock()
+		e.contents[contentID] = a
+		e.mu.Unlock()
+		webhook.Messages = append(webhook.Messages, msg)
+	}
+	return
+}
+
+func mediaType(contentType string) domain.MessageType {
+	switch {
+	case strings.HasPrefix(contentType, "image/"):
+		return domain.MessageTypeImage
+	case strings.HasPrefix(contentType, "video/"):
+		return domain.MessageTypeVideo
+	case strings.HasPrefix(contentType, "audio/"):
+		return domain.MessageTypeAudio
+	default:
+		return domain.MessageTypeFile
+	}
+}
+
+func (e *EmailProvider) dropContents(msgs []domain.Message) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	for _, msg := range msgs {
+		if msg.Payload != nil && msg.Payload.Media != nil {
+			delete(e.contents, msg.Payload.Media.ContentID)
+		}
+	}
+}
+
+func newID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// SendMessage fails since there is no list of the users to mail.
+func (e *EmailProvider) SendMessage(ctx context.Context, msg string) error {
+	return provider.ErrBroadcastNotSupported
+}
+
+// CanBroadcast is false since a broadcast has no address to mail.
+func (e *EmailProvider) CanBroadcast() bool {
+	return false
+}
+
+// ReplyMessage isn't used since there is no reply token in mails, the usecase mails the reply to the sender instead.
+func (e *EmailProvider) ReplyMessage(ctx context.Context, replyToken, msg string) error {
+	return fmt.Errorf("%w: email has no reply token", domain.ErrBadParamInput)
+}
+
+// PushMessage mails the message in plain text to the address.
+func (e *EmailProvider) PushMessage(ctx context.Context, userID, msg string) error {
+	to, err := mail.ParseAddress(userID)
+	if err != nil {
+		return fmt.Errorf("%w: invalid email address %q", domain.ErrBadParamInput, userID)
+	}
+	id, err := newID()
+	if err != nil {
+		return err
+	}
+
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "From: %s\r\n", e.address)
+	fmt.Fprintf(&b, "To: %s\r\n", to.Address)
+	fmt.Fprintf(&b, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", e.subject))
+	fmt.Fprintf(&b, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	fmt.Fprintf(&b, "Message-ID: <%s@%s>\r\n", id, e.domain)
+	b.WriteString("MIME-Version: 1.0\r\n")
+	b.WriteString("Content-Type: text/plain; charset=utf-8\r\n")
+	b.WriteString("Content-Transfer-Encoding: quoted-printable\r\n\r\n")
+	w := quotedprintable.NewWriter(&b)
+	if _, err = w.Write([]byte(msg)); err != nil {
+		return err
+	}
+	if err = w.Close(); err != nil {
+		return err
+	}
+	return e.sendMail(ctx, to.Address, b.Bytes())
+}
+
+// sendMail relays the mail like smtp.SendMail, but the connection is dialed with ctx and gives up at its deadline.
+func (e *EmailProvider) sendMail(ctx context.Context, to string, data []byte) error {
+	conn, err := (&net.Dialer{}).DialContext(ctx, "tcp", e.relay)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+
+	err = e.relayMail(conn, to, data)
+	if err != nil && ctx.Err() != nil {
+		return fmt.Errorf("email send failed, err:%w, last err:%v", ctx.Err(), err)
+	}
+	return err
+}
+
+func (e *EmailProvider) relayMail(conn net.Conn, to string, data []byte) error {
+	host, _, _ := net.SplitHostPort(e.relay)
+	c, err := smtp.NewClient(conn, host)
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+	if ok, _ := c.Extension("STARTTLS"); ok {
+		if err = c.StartTLS(&tls.Config{ServerName: host}); err != nil {
+			return err
+		}
+	}
+	if e.auth != nil {
+		if ok, _ := c.Extension("AUTH"); !ok {
+			return errors.New("email relay doesn't support AUTH")
+		}
+		if err = c.Auth(e.auth); err != nil {
+			return err
+		}
+	}
+	if err = c.Mail(e.address); err != nil {
+		return err
+	}
+	if err = c.Rcpt(to); err != nil {
+		return err
+	}
+	w, err := c.Data()
+	if err != nil {
+		return err
+	}
+	if _, err = w.Write(data); err != nil {
+		return err
+	}
+	if err = w.Close(); err != nil {
+		return err
+	}
+	return c.Quit()
+}
+
+// Multicast mails the message to every address one by one, so the recipients don't see each other.
+func (e *EmailProvider) Multicast(ctx context.Context, userIDs []string, msg string) error {
+	return provider.MulticastEach(userIDs, func(userID string) error {
+		return e.PushMessage(ctx, userID, msg)
+	})
+}
+
+// GetContent returns the attachment received, it's only available while the mail is handled since the usecase keeps
+// it in the blob store.
+func (e *EmailProvider) GetContent(ctx context.Context, contentID string) (content io.ReadCloser, contentType string, err error) {
+	e.mu.Lock()
+	a, ok := e.contents[contentID]
+	e.mu.Unlock()
+	if !ok {
+		err = domain.ErrNotFound
+		return
+	}
+	return io.NopCloser(bytes.NewReader(a.data)), a.contentType, nil
+}
@@ -0,0 +1,231 @@
+package email
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/smtp"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/kunmingliu/messenger/domain"
+)
+
+// fakeRelay is an in-process SMTP server which records the mails relayed.
+type fakeRelay struct {
+	mu    sync.Mutex
+	mails []parsedMail
+}
+
+func newRelay(t *testing.T) (*fakeRelay, string) {
+	relay := &fakeRelay{}
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("unexpected error:%v", err)
+	}
+	t.Cleanup(func() { l.Close() })
+	server := &smtpServer{
+		domain: "relay.test",
+		accept: func(rcpt string) bool { return rcpt != "unknown@example.com" },
+		handle: func(from string, data []byte) error {
+			mail, err := parseMail(data)
+			if err != nil {
+				return err
+			}
+			relay.mu.Lock()
+			relay.mails = append(relay.mails, mail)
+			relay.mu.Unlock()
+			return nil
+		},
+	}
+	go server.serve(l)
+	return relay, l.Addr().String()
+}
+
+// handled is a mail handled by the SMTP server with the contents of its attachments fetched while it's handled, like
+// the usecase does.
+type handled struct {
+	webhook  domain.Webhook
+	contents []string
+}
+
+// serve starts the SMTP server of the provider and returns its address, the mails handled are sent to the channel.
+func serve(t *testing.T, p *EmailProvider, handleErr error) (string, chan handled) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("unexpected error:%v", err)
+	}
+	t.Cleanup(func() { l.Close() })
+	mails := make(chan handled, 10)
+	go p.Serve(l, func(webhook domain.Webhook) error {
+		if handleErr != nil {
+			return handleErr
+		}
+		h := handled{webhook: webhook}
+		for _, msg := range webhook.Messages {
+			if msg.Payload == nil || msg.Payload.Media == nil {
+				continue
+			}
+			content, contentType, err := p.GetContent(context.Background(), msg.Payload.Media.ContentID)
+			if err != nil {
+				return err
+			}
+			b, _ := io.ReadAll(content)
+			h.contents = append(h.contents, contentType+":"+string(b))
+		}
+		mails <- h
+		return nil
+	})
+	return l.Addr().String(), mails
+}
+
+const multipartMail = "From: Alice <Alice@Example.com>\r\n" +
+	"To: support@shop.test\r\n" +
+	"Subject: =?utf-8?q?Broken_=E2=98=95_machine?=\r\n" +
+	"Message-ID: <m1@example.com>\r\n" +
+	"MIME-Version: 1.0\r\n" +
+	"Content-Type: multipart/mixed; boundary=b1\r\n" +
+	"\r\n" +
+	"--b1\r\n" +
+	"Content-Type: text/plain; charset=utf-8\r\n" +
+	"\r\n" +
+	"It doesn't work.\r\n" +
+	"--b1\r\n" +
+	"Content-Type: image/png\r\n" +
+	"Content-Disposition: attachment; filename=\"photo.png\"\r\n" +
+	"Content-Transfer-Encoding: base64\r\n" +
+	"\r\n" +
+	"aW1h\r\n" +
+	"Z2U=\r\n" +
+	"--b1\r\n" +
+	"Content-Type: application/pdf; name=\"receipt.pdf\"\r\n" +
+	"Content-Transfer-Encoding: base64\r\n" +
+	"\r\n" +
+	"cGRm\r\n" +
+	"--b1--\r\n"
+
+func TestEmailProvider_Serve(t *testing.T) {
+	p, err := NewEmailProvider("Support@Shop.test", "127.0.0.1:25")
+	if err != nil {
+		t.Fatalf("unexpected error:%v", err)
+	}
+	addr, mails := serve(t, p, nil)
+
+	if err = smtp.SendMail(addr, nil, "bounce@example.com", []string{"support@shop.test"}, []byte(multipartMail)); err != nil {
+		t.Fatalf("unexpected error:%v", err)
+	}
+	mail := <-mails
+	webhook := mail.webhook
+	if len(webhook.Messages) != 3 {
+		t.Fatalf("messages inconsistent, messages:%+v, expected 3 messages", webhook.Messages)
+	}
+
+	expected := []domain.Message{
+		{EventID: "m1@example.com", UserID: "alice@example.com", Type: domain.MessageTypeText, Message: "Broken ☕ machine\n\nIt doesn't work."},
+		{EventID: "m1@example.com#1", UserID: "alice@example.com", Type: domain.MessageTypeImage, Payload: &domain.Payload{
+			Media: &domain.Media{ContentID: webhook.Messages[1].Payload.Media.ContentID, ContentType: "image/png"},
+		}},
+		{EventID: "m1@example.com#2", UserID: "alice@example.com", Type: domain.MessageTypeFile, Payload: &domain.Payload{
+			Media: &domain.Media{ContentID: webhook.Messages[2].Payload.Media.ContentID, ContentType: "application/pdf"},
+			File:  &domain.File{Name: "receipt.pdf", Size: 3},
+		}},
+	}
+	if !reflect.DeepEqual(webhook.Messages, expected) {
+		t.Errorf("messages inconsistent, messages:%+v, expected messages:%+v", webhook.Messages, expected)
+	}
+
+	expectedContents := []string{"image/png:image", "application/pdf:pdf"}
+	if !reflect.DeepEqual(mail.contents, expectedContents) {
+		t.Errorf("contents inconsistent, contents:%v, expected contents:%v", mail.contents, expectedContents)
+	}
+	// the attachments are dropped once the mail is handled
+	if _, _, err = p.GetContent(context.Background(), webhook.Messages[1].Payload.Media.ContentID); !errors.Is(err, domain.ErrNotFound) {
+		t.Errorf("error inconsistent, caught error:%v, expected error:%v", err, domain.ErrNotFound)
+	}
+
+	err = smtp.SendMail(addr, nil, "alice@example.com", []string{"other@shop.test"}, []byte(multipartMail))
+	if err == nil || !strings.Contains(err.Error(), "550") {
+		t.Errorf("error inconsistent, caught error:%v, expected the mail to another address to be rejected", err)
+	}
+	err = smtp.SendMail(addr, nil, "", []string{"support@shop.test"}, []byte("Subject: no sender\r\n\r\nhi\r\n"))
+	if err == nil || !strings.Contains(err.Error(), "554") {
+		t.Errorf("error inconsistent, caught error:%v, expected the mail without sender to be rejected permanently", err)
+	}
+}
+
+func TestEmailProvider_ServeHandleFailed(t *testing.T) {
+	p, _ := NewEmailProvider("support@shop.test", "127.0.0.1:25")
+	addr, _ := serve(t, p, errors.New("insert failed"))
+
+	err := smtp.SendMail(addr, nil, "alice@example.com", []string{"support@shop.test"}, []byte(multipartMail))
+	if err == nil || !strings.Contains(err.Error(), "451") {
+		t.Errorf("error inconsistent, caught error:%v, expected the mail to be rejected temporarily", err)
+	}
+	if len(p.contents) != 0 {
+		t.Errorf("contents inconsistent, contents:%d, expected the contents of the rejected mail to be dropped", len(p.contents))
+	}
+}
+
+func TestEmailProvider_Send(t *testing.T) {
+	relay, addr := newRelay(t)
+	p, err := NewEmailProvider("support@shop.test", addr, WithSubject("Re: your request"))
+	if err != nil {
+		t.Fatalf("unexpected error:%v", err)
+	}
+
+	if err = p.PushMessage(context.Background(), "alice@example.com", "We're on it ☕"); err != nil {
+		t.Fatalf("unexpected error:%v", err)
+	}
+	err = p.Multicast(context.Background(), []string{"bob@example.com", "unknown@example.com", "not an address"}, "hi")
+	if err == nil || !strings.Contains(err.Error(), "2 of 3") {
+		t.Errorf("error inconsistent, caught error:%v, expected 2 failed recipients", err)
+	}
+	if err = p.SendMessage(context.Background(), "broadcast"); !errors.Is(err, domain.ErrBadParamInput) {
+		t.Errorf("error inconsistent, caught error:%v, expected error:%v", err, domain.ErrBadParamInput)
+	}
+	if p.CanBroadcast() {
+		t.Errorf("broadcast should be unsupported")
+	}
+
+	var sent []string
+	for _, mail := range relay.mails {
+		sent = append(sent, mail.from+"|"+mail.subject+"|"+strings.TrimSpace(mail.text))
+	}
+	expected := []string{
+		"support@shop.test|Re: your request|We're on it ☕",
+		"support@shop.test|Re: your request|hi",
+	}
+	if !reflect.DeepEqual(sent, expected) {
+		t.Errorf("mails sent inconsistent, sent:%v, expected sent:%v", sent, expected)
+	}
+}
+
+func TestEmailProvider_SendTimeout(t *testing.T) {
+	// the relay accepts the connection but never greets
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("unexpected error:%v", err)
+	}
+	defer l.Close()
+	p, err := NewEmailProvider("support@shop.test", l.Addr().String())
+	if err != nil {
+		t.Fatalf("unexpected error:%v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if err = p.PushMessage(ctx, "alice@example.com", "hello"); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("error inconsistent, caught error:%v, expected error:%v", err, context.DeadlineExceeded)
+	}
+}
+
+func TestEmailProvider_ParseRequest(t *testing.T) {
+	p, _ := NewEmailProvider("support@shop.test", "127.0.0.1:25")
+	if _, err := p.ParseRequest(nil); !errors.Is(err, domain.ErrNotFound) {
+		t.Errorf("error inconsistent, caught error:%v, expected error:%v", err, domain.ErrNotFound)
+	}
+}
@@ -0,0 +1,116 @@
+package email
+
+import (
+	"bytes"
+	"encoding/base64"
+	"io"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net/mail"
+	"net/textproto"
+	"strings"
+)
+
+type attachment struct {
+	name        string
+	contentType string
+	data        []byte
+}
+
+// parsedMail is the content of a mail that matters to the messages.
+type parsedMail struct {
+	messageID string
+	// from is the address in the From header in lower case, it's empty if the header is missing or invalid.
+	from        string
+	subject     string
+	text        string
+	html        string
+	attachments []attachment
+}
+
+var wordDecoder = &mime.WordDecoder{}
+
+func decodeHeader(s string) string {
+	if decoded, err := wordDecoder.DecodeHeader(s); err == nil {
+		return decoded
+	}
+	return s
+}
+
+// parseMail walks through the MIME parts of the mail, the first plain and html texts are the body and the other parts
+// are attachments. The charsets other than UTF-8 aren't converted.
+func parseMail(data []byte) (p parsedMail, err error) {
+	m, err := mail.ReadMessage(bytes.NewReader(data))
+	if err != nil {
+		return
+	}
+	p.messageID = strings.Trim(m.Header.Get("Message-Id"), "<> ")
+	p.subject = decodeHeader(m.Header.Get("Subject"))
+	if from, err := mail.ParseAddress(m.Header.Get("From")); err == nil {
+		p.from = strings.ToLower(from.Address)
+	}
+	err = p.walk(textproto.MIMEHeader(m.Header), m.Body)
+	return
+}
+
+func (p *parsedMail) walk(header textproto.MIMEHeader, body io.Reader) error {
+	mediaType, params, err := mime.ParseMediaType(header.Get("Content-Type"))
+	if err != nil {
+		// the default of RFC 2045
+		mediaType, params = "text/plain", nil
+	}
+
+	if strings.HasPrefix(mediaType, "multipart/") {
+		r := multipart.NewReader(body, params["boundary"])
+		for {
+			part, err := r.NextRawPart()
+			if err == io.EOF {
+				return nil
+			}
+			if err != nil {
+				return err
+			}
+			if err = p.walk(part.Header, part); err != nil {
+				return err
+			}
+		}
+	}
+
+	content, err := io.ReadAll(decodeTransfer(header.Get("Content-Transfer-Encoding"), body))
+	if err != nil {
+		return err
+	}
+	disposition, dispositionParams, _ := mime.ParseMediaType(header.Get("Content-Disposition"))
+	name := dispositionParams["filename"]
+	if name == "" {
+		name = params["name"]
+	}
+	if disposition != "attachment" && name == "" {
+		switch {
+		case mediaType == "text/plain" && p.text == "":
+			p.text = string(content)
+			return nil
+		case mediaType == "text/html" && p.html == "":
+			p.html = string(content)
+			return nil
+		}
+	}
+	p.attachments = append(p.attachments, attachment{
+		name:        decodeHeader(name),
+		contentType: mediaType,
+		data:        content,
+	})
+	return nil
+}
+
+func decodeTransfer(encoding string, r io.Reader) io.Reader {
+	switch strings.ToLower(strings.TrimSpace(encoding)) {
+	case "base64":
+		return base64.NewDecoder(base64.StdEncoding, r)
+	case "quoted-printable":
+		return quotedprintable.NewReader(r)
+	default:
+		return r
+	}
+}
@@ -0,0 +1,72 @@
+package email
+
+import (
+	"reflect"
+	"testing"
+)
+
+func Test_parseMail(t *testing.T) {
+	cases := []struct {
+		name        string
+		mail        string
+		expected    parsedMail
+		expectedErr bool
+	}{
+		{
+			name:     "plain text",
+			mail:     "From: bob@example.com\r\nSubject: hi\r\nMessage-Id: <m1@example.com>\r\n\r\nhello\r\n",
+			expected: parsedMail{messageID: "m1@example.com", from: "bob@example.com", subject: "hi", text: "hello\r\n"},
+		},
+		{
+			name: "quoted-printable",
+			mail: "From: bob@example.com\r\nContent-Type: text/plain; charset=utf-8\r\nContent-Transfer-Encoding: quoted-printable\r\n\r\n" +
+				"caf=C3=A9 au =\r\nlait",
+			expected: parsedMail{from: "bob@example.com", text: "café au lait"},
+		},
+		{
+			name: "alternative keeps both texts",
+			mail: "From: bob@example.com\r\nContent-Type: multipart/alternative; boundary=b\r\n\r\n" +
+				"--b\r\nContent-Type: text/plain\r\n\r\nplain\r\n" +
+				"--b\r\nContent-Type: text/html\r\n\r\n<p>html</p>\r\n" +
+				"--b--\r\n",
+			expected: parsedMail{from: "bob@example.com", text: "plain", html: "<p>html</p>"},
+		},
+		{
+			name: "nested parts and inline text attachment",
+			mail: "From: bob@example.com\r\nContent-Type: multipart/mixed; boundary=outer\r\n\r\n" +
+				"--outer\r\nContent-Type: multipart/alternative; boundary=inner\r\n\r\n" +
+				"--inner\r\nContent-Type: text/plain\r\n\r\nbody\r\n" +
+				"--inner--\r\n" +
+				"--outer\r\nContent-Type: text/plain\r\nContent-Disposition: attachment; filename=\"=?utf-8?q?notes_=E2=9C=93.txt?=\"\r\n\r\nnotes\r\n" +
+				"--outer--\r\n",
+			expected: parsedMail{
+				from: "bob@example.com",
+				text: "body",
+				attachments: []attachment{
+					{name: "notes ✓.txt", contentType: "text/plain", data: []byte("notes")},
+				},
+			},
+		},
+		{
+			name:     "invalid from is left empty",
+			mail:     "From: not an address\r\n\r\nhello",
+			expected: parsedMail{text: "hello"},
+		},
+		{
+			name:        "no header",
+			mail:        "hello",
+			expectedErr: true,
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			got, err := parseMail([]byte(c.mail))
+			if (err != nil) != c.expectedErr {
+				t.Fatalf("error inconsistent, caught error:%v, expected error:%v", err, c.expectedErr)
+			}
+			if !reflect.DeepEqual(got, c.expected) {
+				t.Errorf("data inconsistent, data:%+v, expected data:%+v", got, c.expected)
+			}
+		})
+	}
+}
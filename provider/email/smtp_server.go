@@ -0,0 +1,160 @@
+package email
+
+import (
+	"errors"
+	"io"
+	"log"
+	"net"
+	"net/textproto"
+	"strings"
+	"time"
+
+	"github.com/kunmingliu/messenger/domain"
+)
+
+const (
+	// maxMailSize is the size of the largest mail accepted, the larger ones are rejected after they're received.
+	maxMailSize = 10 << 20
+	// commandTimeout is how long the server waits for the next command before closing the connection.
+	commandTimeout = 5 * time.Minute
+)
+
+// smtpServer receives mails with the minimal set of SMTP commands, it doesn't relay mails to other servers.
+type smtpServer struct {
+	domain string
+	// accept tells if the mails to the recipient are received.
+	accept func(rcpt string) bool
+	// handle is called with the sender of the envelope and the mail. The mail is rejected permanently if it fails with
+	// ErrBadParamInput, or with a temporary error otherwise so that the sender retries it later.
+	handle func(from string, data []byte) error
+}
+
+func (s *smtpServer) serve(l net.Listener) error {
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			return err
+		}
+		go s.serveConn(conn)
+	}
+}
+
+func (s *smtpServer) serveConn(conn net.Conn) {
+	defer conn.Close()
+	c := textproto.NewConn(conn)
+	c.PrintfLine("220 %s ESMTP messenger", s.domain)
+
+	var (
+		inTransaction bool
+		from          string
+		rcpts         []string
+	)
+	reset := func() {
+		inTransaction, from, rcpts = false, "", nil
+	}
+	for {
+		conn.SetDeadline(time.Now().Add(commandTimeout))
+		line, err := c.ReadLine()
+		if err != nil {
+			return
+		}
+		verb, arg := line, ""
+		if i := strings.IndexByte(line, ' '); i >= 0 {
+			verb, arg = line[:i], strings.TrimSpace(line[i+1:])
+		}
+
+		switch strings.ToUpper(verb) {
+		case "HELO":
+			reset()
+			c.PrintfLine("250 %s", s.domain)
+		case "EHLO":
+			reset()
+			c.PrintfLine("250-%s", s.domain)
+			c.PrintfLine("250-8BITMIME")
+			c.PrintfLine("250 SIZE %d", maxMailSize)
+		case "MAIL":
+			path, ok := parsePath(arg, "FROM:")
+			if !ok {
+				c.PrintfLine("501 5.5.4 syntax: MAIL FROM:<address>")
+				continue
+			}
+			reset()
+			inTransaction, from = true, path
+			c.PrintfLine("250 2.1.0 OK")
+		case "RCPT":
+			if !inTransaction {
+				c.PrintfLine("503 5.5.1 MAIL first")
+				continue
+			}
+			path, ok := parsePath(arg, "TO:")
+			if !ok {
+				c.PrintfLine("501 5.5.4 syntax: RCPT TO:<address>")
+				continue
+			}
+			if !s.accept(path) {
+				c.PrintfLine("550 5.1.1 mailbox unavailable")
+				continue
+			}
+			rcpts = append(rcpts, path)
+			c.PrintfLine("250 2.1.5 OK")
+		case "DATA":
+			if len(rcpts) == 0 {
+				c.PrintfLine("503 5.5.1 RCPT first")
+				continue
+			}
+			c.PrintfLine("354 end data with <CR><LF>.<CR><LF>")
+			r := c.DotReader()
+			data, err := io.ReadAll(io.LimitReader(r, maxMailSize+1))
+			if err != nil {
+				return
+			}
+			// the rest of a large mail must be consumed before the next command
+			if _, err = io.Copy(io.Discard, r); err != nil {
+				return
+			}
+
+			if len(data) > maxMailSize {
+				c.PrintfLine("552 5.3.4 message too big")
+				reset()
+				continue
+			}
+			switch err = s.handle(from, data); {
+			case err == nil:
+				c.PrintfLine("250 2.0.0 OK")
+			case errors.Is(err, domain.ErrBadParamInput):
+				// retrying an invalid mail won't help
+				c.PrintfLine("554 5.6.0 %s", strings.ReplaceAll(err.Error(), "\n", " "))
+			default:
+				log.Printf("handle mail failed, from:%s, err:%v", from, err)
+				c.PrintfLine("451 4.3.0 mail not stored, try again later")
+			}
+			reset()
+		case "RSET":
+			reset()
+			c.PrintfLine("250 2.0.0 OK")
+		case "NOOP":
+			c.PrintfLine("250 2.0.0 OK")
+		case "QUIT":
+			c.PrintfLine("221 2.0.0 bye")
+			return
+		default:
+			c.PrintfLine("502 5.5.2 command not implemented")
+		}
+	}
+}
+
+// parsePath returns the address of "FROM:<address>" or "TO:<address>", the parameters after it are ignored. The
+// address of a bounce is empty.
+func parsePath(arg, prefix string) (string, bool) {
+	if len(arg) < len(prefix) || !strings.EqualFold(arg[:len(prefix)], prefix) {
+		return "", false
+	}
+	path := strings.TrimSpace(arg[len(prefix):])
+	if i := strings.IndexByte(path, ' '); i >= 0 {
+		path = path[:i]
+	}
+	if !strings.HasPrefix(path, "<") || !strings.HasSuffix(path, ">") {
+		return "", false
+	}
+	return path[1 : len(path)-1], true
+}
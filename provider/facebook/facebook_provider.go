@@ -0,0 +1,326 @@
+package facebook
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/kunmingliu/messenger/domain"
+	"github.com/kunmingliu/messenger/provider"
+)
+
+const (
+	// DefaultBaseURL is the endpoint of the Graph API.
+	DefaultBaseURL = "https://graph.facebook.com/v15.0"
+	// signatureHeader carries the HMAC-SHA256 of the body signed with the app secret.
+	signatureHeader = "X-Hub-Signature-256"
+)
+
+// FacebookProvider talks to a Facebook page or an Instagram professional account with the Messenger Platform. The
+// user id of messages is the page-scoped id of the sender, which is the recipient of the Send API as well.
+type FacebookProvider struct {
+	appSecret   string
+	accessToken string
+	verifyToken string
+	baseURL     string
+	client      *http.Client
+}
+
+type Option func(*FacebookProvider)
+
+// WithBaseURL points the provider at another Graph API server, e.g. a fake for tests.
+func WithBaseURL(baseURL string) Option {
+	return func(f *FacebookProvider) {
+		f.baseURL = strings.TrimSuffix(baseURL, "/")
+	}
+}
+
+func WithHTTPClient(client *http.Client) Option {
+	return func(f *FacebookProvider) {
+		f.client = client
+	}
+}
+
+// NewFacebookProvider creates the provider of the page with the secret of the app, the page access token and the
+// verify token given to the webhook subscription.
+func NewFacebookProvider(appSecret, accessToken, verifyToken string, options ...Option) (*FacebookProvider, error) {
+	if appSecret == "" || accessToken == "" {
+		return nil, errors.New("app secret and access token of facebook shouldn't be empty")
+	}
+	f := &FacebookProvider{
+		appSecret:   appSecret,
+		accessToken: accessToken,
+		verifyToken: verifyToken,
+		baseURL:     DefaultBaseURL,
+		client:      provider.NewHTTPClient(),
+	}
+	for _, option := range options {
+		option(f)
+	}
+	return f, nil
+}
+
+// verifySubscription answers the GET request sent when the webhook is subscribed, it echoes hub.challenge if
+// hub.verify_token is the one configured.
+func (f *FacebookProvider) verifySubscription(r *http.Request) (webhook domain.Webhook, err error) {
+	query := r.URL.Query()
+	if f.verifyToken == "" || query.Get("hub.mode") != "subscribe" ||
+		subtle.ConstantTimeCompare([]byte(query.Get("hub.verify_token")), []byte(f.verifyToken)) != 1 {
+		return webhook, domain.ErrInvalidSignature
+	}
+	webhook.Response = &domain.WebhookResponse{
+		StatusCode:  http.StatusOK,
+		ContentType: "text/plain",
+		Body:        []byte(query.Get("hub.challenge")),
+	}
+	return webhook, nil
+}
+
+func (f *FacebookProvider) verify(r *http.Request, body []byte) error {
+	mac := hmac.New(sha256.New, []byte(f.appSecret))
+	mac.Write(body)
+	expected := "sha256=" + hex.EncodeToString(mac.Sum(nil))
+	if !hmac.Equal([]byte(r.Header.Get(signatureHeader)), []byte(expected)) {
+		return domain.ErrInvalidSignature
+	}
+	return nil
+}
+
+type attachment struct {
+	Type    string `json:"type"`
+	Payload struct {
+		URL         string `json:"url"`
+		Title       string `json:"title"`
+		StickerID   int64  `json:"sticker_id"`
+		Coordinates *struct {
+			Lat  float64 `json:"lat"`
+			Long float64 `json:"long"`
+		} `json:"coordinates"`
+	} `json:"payload"`
+}
+
+type message struct {
+	MID         string       `json:"mid"`
+	Text        string       `json:"text"`
+	IsEcho      bool         `json:"is_echo"`
+	Attachments []attachment `json:"attachments"`
+	QuickReply  *struct {
+		Payload string `json:"payload"`
+	} `json:"quick_reply"`
+}
+
+type postback struct {
+	MID     string `json:"mid"`
+	Title   string `json:"title"`
+	Payload string `json:"payload"`
+}
+
+type messaging struct {
+	Sender struct {
+		ID string `json:"id"`
+	} `json:"sender"`
+	Message  *message  `json:"message"`
+	Postback *postback `json:"postback"`
+}
+
+type callback struct {
+	// Object is page for Messenger and instagram for Instagram, the entries of both are the same.
+	Object string `json:"object"`
+	Entry  []struct {
+		ID        string      `json:"id"`
+		Messaging []messaging `json:"messaging"`
+	} `json:"entry"`
+}
+
+func (f *FacebookProvider) ParseRequest(r *http.Request) (webhook domain.Webhook, err error) {
+	if r.Method == http.MethodGet {
+		return f.verifySubscription(r)
+	}
+
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		return
+	}
+	if err = f.verify(r, body); err != nil {
+		return
+	}
+
+	var c callback
+	if err = json.Unmarshal(body, &c); err != nil {
+		return
+	}
+	for _, entry := range c.Entry {
+		for _, m := range entry.Messaging {
+			webhook.Messages = append(webhook.Messages, convertMessaging(m)...)
+		}
+	}
+	return
+}
+
+// convertMessaging maps a messaging event into domain.Message, the echoes of the messages sent by the page and the
+// events other than messages and postbacks are ignored. The text and each attachment of a message become a message of
+// their own.
+func convertMessaging(m messaging) (msgs []domain.Message) {
+	switch {
+	case m.Postback != nil:
+		return []domain.Message{{
+			EventID: m.Postback.MID,
+			UserID:  m.Sender.ID,
+			Type:    domain.MessageTypePostback,
+			Message: m.Postback.Title,
+			Payload: &domain.Payload{Postback: &domain.Postback{Data: m.Postback.Payload}},
+		}}
+	case m.Message == nil || m.Message.IsEcho:
+		return nil
+	}
+
+	if m.Message.QuickReply != nil {
+		return []domain.Message{{
+			EventID: m.Message.MID,
+			UserID:  m.Sender.ID,
+			Type:    domain.MessageTypePostback,
+			Message: m.Message.Text,
+			Payload: &domain.Payload{Postback: &domain.Postback{Data: m.Message.QuickReply.Payload}},
+		}}
+	}
+	if m.Message.Text != "" || len(m.Message.Attachments) == 0 {
+		msgs = append(msgs, domain.Message{
+			EventID: m.Message.MID,
+			UserID:  m.Sender.ID,
+			Type:    domain.MessageTypeText,
+			Message: m.Message.Text,
+		})
+	}
+	for i, a := range m.Message.Attachments {
+		msg := domain.Message{
+			// the attachments are parts of the same message, so they share its mid
+			EventID: fmt.Sprintf("%s#%d", m.Message.MID, i+1),
+			UserID:  m.Sender.ID,
+		}
+		switch {
+		case a.Payload.StickerID != 0:
+			msg.Type = domain.MessageTypeSticker
+			msg.Payload = &domain.Payload{Sticker: &domain.Sticker{StickerID: fmt.Sprint(a.Payload.StickerID)}}
+		case a.Type == "location" && a.Payload.Coordinates != nil:
+			msg.Type = domain.MessageTypeLocation
+			msg.Payload = &domain.Payload{Location: &domain.Location{
+				Title:     a.Payload.Title,
+				Latitude:  a.Payload.Coordinates.Lat,
+				Longitude: a.Payload.Coordinates.Long,
+			}}
+		case a.Type == "image" || a.Type == "video" || a.Type == "audio" || a.Type == "file":
+			// the url of the attachment is the content id since there is no other way to get the content
+			msg.Type = domain.MessageType(a.Type)
+			msg.Payload = &domain.Payload{Media: &domain.Media{ContentID: a.Payload.URL}}
+		default:
+			continue
+		}
+		msgs = append(msgs, msg)
+	}
+	return msgs
+}
+
+// SendMessage fails since the Send API only sends to a single user.
+func (f *FacebookProvider) SendMessage(ctx context.Context, msg string) error {
+	return provider.ErrBroadcastNotSupported
+}
+
+// CanBroadcast is false since the page can only message the users who wrote to it.
+func (f *FacebookProvider) CanBroadcast() bool {
+	return false
+}
+
+// ReplyMessage isn't used since there is no reply token in the Messenger Platform, the usecase pushes the reply to the
+// user instead.
+func (f *FacebookProvider) ReplyMessage(ctx context.Context, replyToken, msg string) error {
+	return fmt.Errorf("%w: facebook has no reply token", domain.ErrBadParamInput)
+}
+
+type sendRequest struct {
+	Recipient struct {
+		ID string `json:"id"`
+	} `json:"recipient"`
+	MessagingType string `json:"messaging_type"`
+	Message       struct {
+		Text string `json:"text"`
+	} `json:"message"`
+}
+
+// PushMessage sends the message with the Send API, which only reaches the users who messaged the page within 24 hours.
+func (f *FacebookProvider) PushMessage(ctx context.Context, userID, msg string) error {
+	var s sendRequest
+	s.Recipient.ID = userID
+	s.MessagingType = "RESPONSE"
+	s.Message.Text = msg
+	body, err := json.Marshal(s)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost,
+		f.baseURL+"/me/messages", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+f.accessToken)
+	res, err := f.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		var resp struct {
+			Error struct {
+				Message string `json:"message"`
+				Code    int    `json:"code"`
+			} `json:"error"`
+		}
+		if err = json.NewDecoder(res.Body).Decode(&resp); err != nil {
+			return fmt.Errorf("facebook send failed, status:%d, err:%w", res.StatusCode, err)
+		}
+		return fmt.Errorf("facebook send failed, code:%d, err:%s", resp.Error.Code, resp.Error.Message)
+	}
+	return nil
+}
+
+// Multicast sends the message to every user one by one since the Send API has no multicast.
+func (f *FacebookProvider) Multicast(ctx context.Context, userIDs []string, msg string) error {
+	return provider.MulticastEach(userIDs, func(userID string) error {
+		return f.PushMessage(ctx, userID, msg)
+	})
+}
+
+// GetContent downloads the attachment from its url, which expires after a while.
+func (f *FacebookProvider) GetContent(ctx context.Context, contentID string) (content io.ReadCloser, contentType string, err error) {
+	u, err := url.Parse(contentID)
+	if err != nil || (u.Scheme != "https" && u.Scheme != "http") {
+		err = fmt.Errorf("%w: the content id of facebook should be the url of the attachment", domain.ErrBadParamInput)
+		return
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, contentID, nil)
+	if err != nil {
+		return
+	}
+	res, err := f.client.Do(req)
+	if err != nil {
+		return
+	}
+	if res.StatusCode != http.StatusOK {
+		res.Body.Close()
+		err = fmt.Errorf("facebook download attachment failed, status:%d", res.StatusCode)
+		return
+	}
+	return res.Body, res.Header.Get("Content-Type"), nil
+}
@@ -0,0 +1,237 @@
+package facebook
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/kunmingliu/messenger/domain"
+	"github.com/kunmingliu/messenger/internal/providertest"
+)
+
+// newProvider creates the provider on a fake of the Graph API, which records the messages sent and serves a single
+// attachment, and returns the url of the fake.
+func newProvider(t *testing.T) (*FacebookProvider, *providertest.Recorder[sendRequest], string) {
+	server := providertest.NewServer(t)
+	sent := &providertest.Recorder[sendRequest]{}
+	server.Handle("/me/messages", func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer token" || r.URL.Query().Has("access_token") {
+			providertest.WriteJSON(w, http.StatusBadRequest, `{"error":{"message":"Invalid OAuth access token.","type":"OAuthException","code":190}}`)
+			return
+		}
+		var s sendRequest
+		json.NewDecoder(r.Body).Decode(&s)
+		if s.Recipient.ID == "403" {
+			providertest.WriteJSON(w, http.StatusBadRequest, `{"error":{"message":"This message is sent outside of allowed window.","type":"OAuthException","code":10}}`)
+			return
+		}
+		sent.Add(s)
+		providertest.WriteJSON(w, http.StatusOK, `{"recipient_id":"`+s.Recipient.ID+`","message_id":"m_1"}`)
+	})
+	server.Handle("/attachments/1.jpg", providertest.Content("image/jpeg", "image"))
+
+	p, err := NewFacebookProvider("secret", "token", "verify", WithBaseURL(server.URL))
+	if err != nil {
+		t.Fatalf("unexpected error:%v", err)
+	}
+	return p, sent, server.URL
+}
+
+func sign(secret, body string) string {
+	return "sha256=" + providertest.HexHMAC(sha256.New, secret, body)
+}
+
+func TestFacebookProvider_ParseRequest(t *testing.T) {
+	p, _, _ := newProvider(t)
+
+	cases := []struct {
+		name        string
+		secret      string
+		body        string
+		expected    domain.Webhook
+		expectedErr error
+	}{
+		{
+			name:   "text",
+			secret: "secret",
+			body:   `{"object":"page","entry":[{"id":"P1","time":1667260800000,"messaging":[{"sender":{"id":"U1"},"recipient":{"id":"P1"},"timestamp":1667260800000,"message":{"mid":"m1","text":"hello"}}]}]}`,
+			expected: domain.Webhook{Messages: []domain.Message{
+				{EventID: "m1", UserID: "U1", Type: domain.MessageTypeText, Message: "hello"},
+			}},
+		},
+		{
+			name:   "instagram image",
+			secret: "secret",
+			body:   `{"object":"instagram","entry":[{"id":"I1","messaging":[{"sender":{"id":"U2"},"message":{"mid":"m2","attachments":[{"type":"image","payload":{"url":"https://cdn/1.jpg"}}]}}]}]}`,
+			expected: domain.Webhook{Messages: []domain.Message{{
+				EventID: "m2#1", UserID: "U2", Type: domain.MessageTypeImage,
+				Payload: &domain.Payload{Media: &domain.Media{ContentID: "https://cdn/1.jpg"}},
+			}}},
+		},
+		{
+			name:   "sticker",
+			secret: "secret",
+			body:   `{"object":"page","entry":[{"id":"P1","messaging":[{"sender":{"id":"U1"},"message":{"mid":"m3","attachments":[{"type":"image","payload":{"url":"https://cdn/s.png","sticker_id":369239263222822}}]}}]}]}`,
+			expected: domain.Webhook{Messages: []domain.Message{{
+				EventID: "m3#1", UserID: "U1", Type: domain.MessageTypeSticker,
+				Payload: &domain.Payload{Sticker: &domain.Sticker{StickerID: "369239263222822"}},
+			}}},
+		},
+		{
+			name:   "text with attachments",
+			secret: "secret",
+			body: `{"object":"page","entry":[{"id":"P1","messaging":[{"sender":{"id":"U1"},"message":{"mid":"m7","text":"here","attachments":[` +
+				`{"type":"image","payload":{"url":"https://cdn/1.jpg"}},` +
+				`{"type":"fallback","payload":{"url":"https://example.com"}},` +
+				`{"type":"location","payload":{"title":"Office","coordinates":{"lat":25.03,"long":121.56}}}]}}]}]}`,
+			expected: domain.Webhook{Messages: []domain.Message{
+				{EventID: "m7", UserID: "U1", Type: domain.MessageTypeText, Message: "here"},
+				{
+					EventID: "m7#1", UserID: "U1", Type: domain.MessageTypeImage,
+					Payload: &domain.Payload{Media: &domain.Media{ContentID: "https://cdn/1.jpg"}},
+				},
+				{
+					EventID: "m7#3", UserID: "U1", Type: domain.MessageTypeLocation,
+					Payload: &domain.Payload{Location: &domain.Location{Title: "Office", Latitude: 25.03, Longitude: 121.56}},
+				},
+			}},
+		},
+		{
+			name:   "quick reply",
+			secret: "secret",
+			body:   `{"object":"page","entry":[{"id":"P1","messaging":[{"sender":{"id":"U1"},"message":{"mid":"m4","text":"Red","quick_reply":{"payload":"color=red"}}}]}]}`,
+			expected: domain.Webhook{Messages: []domain.Message{{
+				EventID: "m4", UserID: "U1", Type: domain.MessageTypePostback, Message: "Red",
+				Payload: &domain.Payload{Postback: &domain.Postback{Data: "color=red"}},
+			}}},
+		},
+		{
+			name:   "postback",
+			secret: "secret",
+			body:   `{"object":"page","entry":[{"id":"P1","messaging":[{"sender":{"id":"U1"},"postback":{"mid":"m5","title":"Buy","payload":"action=buy"}}]}]}`,
+			expected: domain.Webhook{Messages: []domain.Message{{
+				EventID: "m5", UserID: "U1", Type: domain.MessageTypePostback, Message: "Buy",
+				Payload: &domain.Payload{Postback: &domain.Postback{Data: "action=buy"}},
+			}}},
+		},
+		{
+			name:   "echo and read are ignored",
+			secret: "secret",
+			body: `{"object":"page","entry":[{"id":"P1","messaging":[` +
+				`{"sender":{"id":"P1"},"recipient":{"id":"U1"},"message":{"mid":"m6","text":"hi","is_echo":true}},` +
+				`{"sender":{"id":"U1"},"read":{"watermark":1667260800000}}]}]}`,
+		},
+		{
+			name:        "invalid signature",
+			secret:      "wrong",
+			body:        `{"object":"page","entry":[]}`,
+			expectedErr: domain.ErrInvalidSignature,
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, "/webhook/facebook", strings.NewReader(c.body))
+			req.Header.Set(signatureHeader, sign(c.secret, c.body))
+			got, err := p.ParseRequest(req)
+			if !errors.Is(err, c.expectedErr) {
+				t.Fatalf("error inconsistent, caught error:%v, expected error:%v", err, c.expectedErr)
+			}
+			if !reflect.DeepEqual(got, c.expected) {
+				t.Errorf("data inconsistent, data:%+v, expected data:%+v", got, c.expected)
+			}
+		})
+	}
+}
+
+func TestFacebookProvider_VerifySubscription(t *testing.T) {
+	p, _, _ := newProvider(t)
+
+	cases := []struct {
+		name        string
+		query       string
+		expected    domain.Webhook
+		expectedErr error
+	}{
+		{
+			name:  "subscribe",
+			query: "hub.mode=subscribe&hub.verify_token=verify&hub.challenge=1158201444",
+			expected: domain.Webhook{Response: &domain.WebhookResponse{
+				StatusCode:  http.StatusOK,
+				ContentType: "text/plain",
+				Body:        []byte("1158201444"),
+			}},
+		},
+		{
+			name:        "invalid verify token",
+			query:       "hub.mode=subscribe&hub.verify_token=wrong&hub.challenge=1158201444",
+			expectedErr: domain.ErrInvalidSignature,
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/webhook/facebook?"+c.query, nil)
+			got, err := p.ParseRequest(req)
+			if !errors.Is(err, c.expectedErr) {
+				t.Fatalf("error inconsistent, caught error:%v, expected error:%v", err, c.expectedErr)
+			}
+			if !reflect.DeepEqual(got, c.expected) {
+				t.Errorf("data inconsistent, data:%+v, expected data:%+v", got, c.expected)
+			}
+		})
+	}
+}
+
+func TestFacebookProvider_Send(t *testing.T) {
+	p, sent, _ := newProvider(t)
+
+	if err := p.PushMessage(context.Background(), "U1", "hello"); err != nil {
+		t.Fatalf("unexpected error:%v", err)
+	}
+	err := p.Multicast(context.Background(), []string{"U2", "403", "U3"}, "hi")
+	if err == nil || !strings.Contains(err.Error(), "outside of allowed window") {
+		t.Errorf("error inconsistent, caught error:%v, expected the error of the user outside the window", err)
+	}
+	if err = p.SendMessage(context.Background(), "broadcast"); !errors.Is(err, domain.ErrBadParamInput) {
+		t.Errorf("error inconsistent, caught error:%v, expected error:%v", err, domain.ErrBadParamInput)
+	}
+	if p.CanBroadcast() {
+		t.Errorf("broadcast should be unsupported")
+	}
+
+	var recipients []string
+	for _, s := range sent.Items() {
+		recipients = append(recipients, s.Recipient.ID+":"+s.Message.Text)
+	}
+	expected := []string{"U1:hello", "U2:hi", "U3:hi"}
+	if !reflect.DeepEqual(recipients, expected) {
+		t.Errorf("messages sent inconsistent, sent:%v, expected sent:%v", recipients, expected)
+	}
+}
+
+func TestFacebookProvider_GetContent(t *testing.T) {
+	p, _, baseURL := newProvider(t)
+
+	content, contentType, err := p.GetContent(context.Background(), baseURL+"/attachments/1.jpg")
+	if err != nil {
+		t.Fatalf("unexpected error:%v", err)
+	}
+	defer content.Close()
+	b, _ := io.ReadAll(content)
+	if string(b) != "image" || contentType != "image/jpeg" {
+		t.Errorf("content inconsistent, content:%s, content type:%s", b, contentType)
+	}
+
+	if _, _, err = p.GetContent(context.Background(), baseURL+"/attachments/2.jpg"); err == nil {
+		t.Errorf("getting an unknown attachment should fail")
+	}
+	if _, _, err = p.GetContent(context.Background(), "F1"); !errors.Is(err, domain.ErrBadParamInput) {
+		t.Errorf("error inconsistent, caught error:%v, expected error:%v", err, domain.ErrBadParamInput)
+	}
+}
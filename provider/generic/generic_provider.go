@@ -0,0 +1,375 @@
+package generic
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/kunmingliu/messenger/domain"
+	"github.com/kunmingliu/messenger/provider"
+)
+
+const (
+	// TimestampHeader carries the unix time in seconds when the request is signed.
+	TimestampHeader = "X-Messenger-Timestamp"
+	// SignatureHeader carries "sha256=" and the hex HMAC-SHA256 of the timestamp, a dot and the body signed with the
+	// secret.
+	SignatureHeader = "X-Messenger-Signature"
+	// signatureTolerance is how old a webhook request can be, the older ones are rejected to prevent replay attacks.
+	signatureTolerance = 5 * time.Minute
+
+	// DefaultAttempts is how many times an outbound message is posted before giving up.
+	DefaultAttempts = 3
+	// DefaultBackoff is the wait before the first retry, it's doubled for each retry.
+	DefaultBackoff = time.Second
+)
+
+// GenericProvider lets in-house systems exchange messages with a documented JSON schema instead of pretending to be a
+// platform. Both directions are signed with the shared secret in the same way.
+//
+// The webhook accepts the messages and events of the systems:
+//
+//	{
+//	  "messages": [{"id": "m1", "user_id": "u1", "type": "text", "message": "hello"}],
+//	  "events": [{"id": "e1", "user_id": "u1", "type": "follow"}]
+//	}
+//
+// and the messages sent are posted to the outbound url:
+//
+//	{"id": "d1", "type": "text", "message": "hi", "user_ids": ["u1"]}
+//
+// where id is the id of the message stored and stays the same across the retries, and user_ids is absent for
+// broadcasts.
+type GenericProvider struct {
+	secret      string
+	outboundURL string
+	attempts    int
+	backoff     time.Duration
+	client      *http.Client
+	now         func() time.Time
+}
+
+type Option func(*GenericProvider)
+
+// WithOutboundURL is where the messages sent are posted, sending fails if it isn't set.
+func WithOutboundURL(outboundURL string) Option {
+	return func(g *GenericProvider) {
+		g.outboundURL = outboundURL
+	}
+}
+
+// WithRetry posts an outbound message up to attempts times, waiting backoff before the first retry and twice as long
+// before each next one.
+func WithRetry(attempts int, backoff time.Duration) Option {
+	return func(g *GenericProvider) {
+		g.attempts = attempts
+		g.backoff = backoff
+	}
+}
+
+func WithHTTPClient(client *http.Client) Option {
+	return func(g *GenericProvider) {
+		g.client = client
+	}
+}
+
+// NewGenericProvider creates the provider with the secret shared with the systems.
+func NewGenericProvider(secret string, options ...Option) (*GenericProvider, error) {
+	if secret == "" {
+		return nil, errors.New("secret of generic shouldn't be empty")
+	}
+	g := &GenericProvider{
+		secret:   secret,
+		attempts: DefaultAttempts,
+		backoff:  DefaultBackoff,
+		client:   provider.NewHTTPClient(),
+		now:      time.Now,
+	}
+	for _, option := range options {
+		option(g)
+	}
+	if g.attempts < 1 {
+		g.attempts = 1
+	}
+	if g.outboundURL != "" {
+		u, err := url.Parse(g.outboundURL)
+		if err != nil || (u.Scheme != "https" && u.Scheme != "http") {
+			return nil, fmt.Errorf("invalid outbound url of generic: %q", g.outboundURL)
+		}
+	}
+	return g, nil
+}
+
+// Sign returns the signature of the body at the timestamp, which is the value of SignatureHeader.
+func Sign(secret, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp + "."))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+func (g *GenericProvider) verify(r *http.Request, body []byte) error {
+	timestamp := r.Header.Get(TimestampHeader)
+	seconds, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return domain.ErrInvalidSignature
+	}
+	if age := g.now().Sub(time.Unix(seconds, 0)); age > signatureTolerance || age < -signatureTolerance {
+		return domain.ErrInvalidSignature
+	}
+	if !hmac.Equal([]byte(r.Header.Get(SignatureHeader)), []byte(Sign(g.secret, timestamp, body))) {
+		return domain.ErrInvalidSignature
+	}
+	return nil
+}
+
+type message struct {
+	// ID is the id of the message in the system, the messages with the same id are stored once.
+	ID      string             `json:"id"`
+	UserID  string             `json:"user_id"`
+	Type    domain.MessageType `json:"type"`
+	Message string             `json:"message"`
+	Payload *domain.Payload    `json:"payload"`
+}
+
+type event struct {
+	ID        string           `json:"id"`
+	Type      domain.EventType `json:"type"`
+	Timestamp *time.Time       `json:"timestamp"`
+	UserID    string           `json:"user_id"`
+	GroupID   string           `json:"group_id"`
+	RoomID    string           `json:"room_id"`
+	Members   []string         `json:"members"`
+}
+
+type webhookBody struct {
+	Messages []message `json:"messages"`
+	Events   []event   `json:"events"`
+}
+
+var messageTypes = map[domain.MessageType]struct{}{
+	domain.MessageTypeText:     {},
+	domain.MessageTypeImage:    {},
+	domain.MessageTypeVideo:    {},
+	domain.MessageTypeAudio:    {},
+	domain.MessageTypeFile:     {},
+	domain.MessageTypeSticker:  {},
+	domain.MessageTypeLocation: {},
+	domain.MessageTypePostback: {},
+}
+
+var eventTypes = map[domain.EventType]struct{}{
+	domain.EventTypeFollow:       {},
+	domain.EventTypeUnfollow:     {},
+	domain.EventTypeJoin:         {},
+	domain.EventTypeLeave:        {},
+	domain.EventTypeMemberJoined: {},
+	domain.EventTypeMemberLeft:   {},
+}
+
+// ParseRequest converts the messages and events of the body, the whole request is rejected if any of them is invalid
+// so the system can fix and resend it.
+func (g *GenericProvider) ParseRequest(r *http.Request) (webhook domain.Webhook, err error) {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		return
+	}
+	if err = g.verify(r, body); err != nil {
+		return
+	}
+
+	var b webhookBody
+	if err = json.Unmarshal(body, &b); err != nil {
+		err = fmt.Errorf("%w: %v", domain.ErrBadParamInput, err)
+		return
+	}
+	for i, m := range b.Messages {
+		msg, err := convertMessage(m)
+		if err != nil {
+			return domain.Webhook{}, fmt.Errorf("%w: messages[%d] %v", domain.ErrBadParamInput, i, err)
+		}
+		webhook.Messages = append(webhook.Messages, msg)
+	}
+	for i, e := range b.Events {
+		evt, err := g.convertEvent(e)
+		if err != nil {
+			return domain.Webhook{}, fmt.Errorf("%w: events[%d] %v", domain.ErrBadParamInput, i, err)
+		}
+		webhook.Events = append(webhook.Events, evt)
+	}
+	return
+}
+
+// convertMessage keeps the fields the systems may set, the blob key and the outcome of postbacks are filled by the
+// usecase. A media is downloaded from its content id, which should be a url.
+func convertMessage(m message) (msg domain.Message, err error) {
+	if m.Type == "" {
+		m.Type = domain.MessageTypeText
+	}
+	if _, ok := messageTypes[m.Type]; !ok {
+		return msg, fmt.Errorf("has unknown type %q", m.Type)
+	}
+	if m.UserID == "" {
+		return msg, errors.New("has no user id")
+	}
+	if m.Payload != nil {
+		if m.Payload.Media != nil {
+			m.Payload.Media.BlobKey = ""
+		}
+		if m.Payload.Postback != nil {
+			m.Payload.Postback.Outcome = nil
+		}
+		m.Payload.Template = nil
+	}
+	return domain.Message{
+		EventID: m.ID,
+		UserID:  m.UserID,
+		Type:    m.Type,
+		Message: m.Message,
+		Payload: m.Payload,
+	}, nil
+}
+
+func (g *GenericProvider) convertEvent(e event) (evt domain.Event, err error) {
+	if _, ok := eventTypes[e.Type]; !ok {
+		return evt, fmt.Errorf("has unknown type %q", e.Type)
+	}
+	timestamp := g.now().UTC()
+	if e.Timestamp != nil {
+		timestamp = e.Timestamp.UTC()
+	}
+	return domain.Event{
+		EventID:   e.ID,
+		Timestamp: timestamp,
+		Type:      e.Type,
+		UserID:    e.UserID,
+		GroupID:   e.GroupID,
+		RoomID:    e.RoomID,
+		Members:   e.Members,
+	}, nil
+}
+
+type outbound struct {
+	ID      string             `json:"id"`
+	Type    domain.MessageType `json:"type"`
+	Message string             `json:"message"`
+	UserIDs []string           `json:"user_ids,omitempty"`
+}
+
+// SendMessage isn't used since the usecase sends the stored messages with SendOutbound, so they keep their ids.
+func (g *GenericProvider) SendMessage(ctx context.Context, msg string) error {
+	return fmt.Errorf("%w: generic sends the stored messages only", domain.ErrBadParamInput)
+}
+
+// ReplyMessage isn't used since there is no reply token in the schema, the usecase pushes the reply to the user
+// instead.
+func (g *GenericProvider) ReplyMessage(ctx context.Context, replyToken, msg string) error {
+	return fmt.Errorf("%w: generic has no reply token", domain.ErrBadParamInput)
+}
+
+// PushMessage isn't used since the usecase sends the stored messages with SendOutbound.
+func (g *GenericProvider) PushMessage(ctx context.Context, userID, msg string) error {
+	return fmt.Errorf("%w: generic sends the stored messages only", domain.ErrBadParamInput)
+}
+
+// Multicast isn't used since the usecase sends the stored messages with SendOutbound.
+func (g *GenericProvider) Multicast(ctx context.Context, userIDs []string, msg string) error {
+	return fmt.Errorf("%w: generic sends the stored messages only", domain.ErrBadParamInput)
+}
+
+// SendOutbound posts the outbound message with its id, so the system can tell the messages of the history apart. It's
+// a broadcast if msg.UserID is empty.
+func (g *GenericProvider) SendOutbound(ctx context.Context, msg domain.Message) error {
+	if msg.Type != domain.MessageTypeText {
+		return fmt.Errorf("%w: generic can only send text", domain.ErrBadParamInput)
+	}
+	var userIDs []string
+	if msg.UserID != "" {
+		userIDs = []string{msg.UserID}
+	}
+	return g.post(ctx, msg.ID, msg.Message, userIDs...)
+}
+
+// post delivers the message to the outbound url, it retries on network errors, 429 and 5xx with exponential backoff
+// until ctx is done. Each attempt is signed again so the timestamp stays fresh.
+func (g *GenericProvider) post(ctx context.Context, id, msg string, userIDs ...string) error {
+	if g.outboundURL == "" {
+		return fmt.Errorf("%w: generic has no outbound url", domain.ErrBadParamInput)
+	}
+	body, err := json.Marshal(outbound{ID: id, Type: domain.MessageTypeText, Message: msg, UserIDs: userIDs})
+	if err != nil {
+		return err
+	}
+
+	backoff := g.backoff
+	for attempt := 1; ; attempt++ {
+		retry, err := g.postOnce(ctx, body)
+		if err == nil || !retry || attempt >= g.attempts {
+			return err
+		}
+		timer := time.NewTimer(backoff)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return fmt.Errorf("generic send failed, err:%w, last err:%v", ctx.Err(), err)
+		case <-timer.C:
+		}
+		backoff *= 2
+	}
+}
+
+func (g *GenericProvider) postOnce(ctx context.Context, body []byte) (retry bool, err error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, g.outboundURL, bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+	timestamp := strconv.FormatInt(g.now().Unix(), 10)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(TimestampHeader, timestamp)
+	req.Header.Set(SignatureHeader, Sign(g.secret, timestamp, body))
+	res, err := g.client.Do(req)
+	if err != nil {
+		return true, err
+	}
+	defer res.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(res.Body, 4096))
+
+	if res.StatusCode/100 != 2 {
+		retry = res.StatusCode == http.StatusTooManyRequests || res.StatusCode >= 500
+		return retry, fmt.Errorf("generic send failed, status:%d", res.StatusCode)
+	}
+	return false, nil
+}
+
+// GetContent downloads the media from its content id, which is a url given by the system.
+func (g *GenericProvider) GetContent(ctx context.Context, contentID string) (content io.ReadCloser, contentType string, err error) {
+	u, err := url.Parse(contentID)
+	if err != nil || (u.Scheme != "https" && u.Scheme != "http") {
+		err = fmt.Errorf("%w: the content id of generic should be the url of the media", domain.ErrBadParamInput)
+		return
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, contentID, nil)
+	if err != nil {
+		return
+	}
+	res, err := g.client.Do(req)
+	if err != nil {
+		return
+	}
+	if res.StatusCode != http.StatusOK {
+		res.Body.Close()
+		err = fmt.Errorf("generic download media failed, status:%d", res.StatusCode)
+		return
+	}
+	return res.Body, res.Header.Get("Content-Type"), nil
+}
@@ -0,0 +1,254 @@
+package generic
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/kunmingliu/messenger/domain"
+	"github.com/kunmingliu/messenger/internal/providertest"
+)
+
+// fakeSystem is a stand-in of an in-house system which checks the signature of the messages posted, fails the
+// requests with the statuses queued, and records the messages accepted.
+type fakeSystem struct {
+	mu       sync.Mutex
+	statuses []int
+	attempts int
+	sent     providertest.Recorder[outbound]
+}
+
+func (f *fakeSystem) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, _ := io.ReadAll(r.Body)
+	if r.Header.Get(SignatureHeader) != Sign("secret", r.Header.Get(TimestampHeader), body) {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.attempts++
+	if len(f.statuses) > 0 {
+		status := f.statuses[0]
+		f.statuses = f.statuses[1:]
+		w.WriteHeader(status)
+		return
+	}
+	var o outbound
+	json.Unmarshal(body, &o)
+	f.sent.Add(o)
+	w.WriteHeader(http.StatusAccepted)
+}
+
+func newProvider(t *testing.T, now time.Time) (*GenericProvider, *fakeSystem) {
+	system := &fakeSystem{}
+	server := providertest.NewServer(t)
+	server.Handle("/messages", system.ServeHTTP)
+	p, err := NewGenericProvider("secret", WithOutboundURL(server.URL+"/messages"), WithRetry(3, time.Millisecond))
+	if err != nil {
+		t.Fatalf("unexpected error:%v", err)
+	}
+	p.now = func() time.Time { return now }
+	return p, system
+}
+
+func TestGenericProvider_ParseRequest(t *testing.T) {
+	now := time.Date(2022, 11, 1, 0, 0, 0, 0, time.UTC)
+	p, _ := newProvider(t, now)
+	timestamp := strconv.FormatInt(now.Unix(), 10)
+
+	cases := []struct {
+		name        string
+		body        string
+		timestamp   string
+		signature   string
+		expected    domain.Webhook
+		expectedErr error
+	}{
+		{
+			name: "messages and events",
+			body: `{"messages":[` +
+				`{"id":"m1","user_id":"u1","message":"hello"},` +
+				`{"id":"m2","user_id":"u1","type":"image","payload":{"media":{"content_id":"https://files.example.com/1.png","blob_key":"other"}}},` +
+				`{"id":"m3","user_id":"u1","type":"postback","message":"Buy","payload":{"postback":{"data":"action=buy","outcome":{"prefix":"action"}}}}],` +
+				`"events":[` +
+				`{"id":"e1","user_id":"u1","type":"follow","timestamp":"2022-10-31T23:59:00+08:00"},` +
+				`{"id":"e2","group_id":"g1","type":"memberJoined","members":["u2"]}]}`,
+			expected: domain.Webhook{
+				Messages: []domain.Message{
+					{EventID: "m1", UserID: "u1", Type: domain.MessageTypeText, Message: "hello"},
+					{EventID: "m2", UserID: "u1", Type: domain.MessageTypeImage, Payload: &domain.Payload{
+						Media: &domain.Media{ContentID: "https://files.example.com/1.png"},
+					}},
+					{EventID: "m3", UserID: "u1", Type: domain.MessageTypePostback, Message: "Buy", Payload: &domain.Payload{
+						Postback: &domain.Postback{Data: "action=buy"},
+					}},
+				},
+				Events: []domain.Event{
+					{EventID: "e1", UserID: "u1", Type: domain.EventTypeFollow, Timestamp: time.Date(2022, 10, 31, 15, 59, 0, 0, time.UTC)},
+					{EventID: "e2", GroupID: "g1", Type: domain.EventTypeMemberJoined, Members: []string{"u2"}, Timestamp: now},
+				},
+			},
+		},
+		{
+			name:        "unknown message type",
+			body:        `{"messages":[{"id":"m1","user_id":"u1","type":"gif"}]}`,
+			expectedErr: domain.ErrBadParamInput,
+		},
+		{
+			name:        "message without user",
+			body:        `{"messages":[{"id":"m1","message":"hello"}]}`,
+			expectedErr: domain.ErrBadParamInput,
+		},
+		{
+			name:        "unknown event type",
+			body:        `{"events":[{"id":"e1","user_id":"u1","type":"block"}]}`,
+			expectedErr: domain.ErrBadParamInput,
+		},
+		{
+			name:        "invalid signature",
+			body:        `{"messages":[{"id":"m1","user_id":"u1","message":"hello"}]}`,
+			signature:   "sha256=00",
+			expectedErr: domain.ErrInvalidSignature,
+		},
+		{
+			name:        "expired timestamp",
+			body:        `{"messages":[{"id":"m1","user_id":"u1","message":"hello"}]}`,
+			timestamp:   strconv.FormatInt(now.Add(-10*time.Minute).Unix(), 10),
+			expectedErr: domain.ErrInvalidSignature,
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			ts := c.timestamp
+			if ts == "" {
+				ts = timestamp
+			}
+			signature := c.signature
+			if signature == "" {
+				signature = Sign("secret", ts, []byte(c.body))
+			}
+			req := httptest.NewRequest(http.MethodPost, "/webhook/generic", strings.NewReader(c.body))
+			req.Header.Set(TimestampHeader, ts)
+			req.Header.Set(SignatureHeader, signature)
+			got, err := p.ParseRequest(req)
+			if !errors.Is(err, c.expectedErr) {
+				t.Fatalf("error inconsistent, caught error:%v, expected error:%v", err, c.expectedErr)
+			}
+			if !reflect.DeepEqual(got, c.expected) {
+				t.Errorf("data inconsistent, data:%+v, expected data:%+v", got, c.expected)
+			}
+		})
+	}
+}
+
+func TestGenericProvider_SendOutbound(t *testing.T) {
+	p, system := newProvider(t, time.Now())
+
+	system.statuses = []int{http.StatusServiceUnavailable, http.StatusTooManyRequests}
+	msg := domain.Message{ID: "d1", UserID: "u1", Type: domain.MessageTypeText, Message: "hello"}
+	if err := p.SendOutbound(context.Background(), msg); err != nil {
+		t.Fatalf("unexpected error:%v", err)
+	}
+	if system.attempts != 3 {
+		t.Errorf("attempts inconsistent, attempts:%d, expected attempts:%d", system.attempts, 3)
+	}
+	broadcast := domain.Message{ID: "d2", Type: domain.MessageTypeText, Message: "news"}
+	if err := p.SendOutbound(context.Background(), broadcast); err != nil {
+		t.Fatalf("unexpected error:%v", err)
+	}
+	template := domain.Message{ID: "d3", UserID: "u1", Type: domain.MessageTypeTemplate, Message: "order_update"}
+	if err := p.SendOutbound(context.Background(), template); !errors.Is(err, domain.ErrBadParamInput) {
+		t.Errorf("error inconsistent, caught error:%v, expected error:%v", err, domain.ErrBadParamInput)
+	}
+
+	// the id of the stored message is kept across the retries
+	expected := []outbound{
+		{ID: "d1", Type: domain.MessageTypeText, Message: "hello", UserIDs: []string{"u1"}},
+		{ID: "d2", Type: domain.MessageTypeText, Message: "news"},
+	}
+	if got := system.sent.Items(); !reflect.DeepEqual(got, expected) {
+		t.Errorf("messages sent inconsistent, sent:%+v, expected sent:%+v", got, expected)
+	}
+}
+
+func TestGenericProvider_SendCanceled(t *testing.T) {
+	p, system := newProvider(t, time.Now())
+	p.backoff = time.Hour
+
+	system.statuses = []int{http.StatusServiceUnavailable}
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	// the backoff is cut short once the sender gives up
+	msg := domain.Message{ID: "d1", UserID: "u1", Type: domain.MessageTypeText, Message: "hello"}
+	if err := p.SendOutbound(ctx, msg); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("error inconsistent, caught error:%v, expected error:%v", err, context.DeadlineExceeded)
+	}
+	if system.attempts != 1 {
+		t.Errorf("attempts inconsistent, attempts:%d, expected attempts:%d", system.attempts, 1)
+	}
+}
+
+func TestGenericProvider_SendFailed(t *testing.T) {
+	p, system := newProvider(t, time.Now())
+	msg := domain.Message{ID: "d1", UserID: "u1", Type: domain.MessageTypeText, Message: "hello"}
+
+	system.statuses = []int{http.StatusBadGateway, http.StatusBadGateway, http.StatusBadGateway, http.StatusBadGateway}
+	if err := p.SendOutbound(context.Background(), msg); err == nil || !strings.Contains(err.Error(), "status:502") {
+		t.Errorf("error inconsistent, caught error:%v, expected the error after the last retry", err)
+	}
+	if system.attempts != 3 {
+		t.Errorf("attempts inconsistent, attempts:%d, expected attempts:%d", system.attempts, 3)
+	}
+
+	system.attempts = 0
+	system.statuses = []int{http.StatusBadRequest}
+	if err := p.SendOutbound(context.Background(), msg); err == nil || !strings.Contains(err.Error(), "status:400") {
+		t.Errorf("error inconsistent, caught error:%v, expected the error of the bad request", err)
+	}
+	if system.attempts != 1 {
+		t.Errorf("attempts inconsistent, attempts:%d, expected the bad request not to be retried", system.attempts)
+	}
+
+	p.outboundURL = ""
+	if err := p.SendOutbound(context.Background(), msg); !errors.Is(err, domain.ErrBadParamInput) {
+		t.Errorf("error inconsistent, caught error:%v, expected error:%v", err, domain.ErrBadParamInput)
+	}
+	// the messages which aren't stored have no id to send
+	if err := p.PushMessage(context.Background(), "u1", "hello"); !errors.Is(err, domain.ErrBadParamInput) {
+		t.Errorf("error inconsistent, caught error:%v, expected error:%v", err, domain.ErrBadParamInput)
+	}
+	if err := p.ReplyMessage(context.Background(), "token", "hello"); !errors.Is(err, domain.ErrBadParamInput) {
+		t.Errorf("error inconsistent, caught error:%v, expected error:%v", err, domain.ErrBadParamInput)
+	}
+}
+
+func TestGenericProvider_GetContent(t *testing.T) {
+	server := providertest.NewServer(t)
+	server.Handle("/1.png", providertest.Content("image/png", "image"))
+	p, _ := NewGenericProvider("secret")
+
+	content, contentType, err := p.GetContent(context.Background(), server.URL+"/1.png")
+	if err != nil {
+		t.Fatalf("unexpected error:%v", err)
+	}
+	defer content.Close()
+	b, _ := io.ReadAll(content)
+	if string(b) != "image" || contentType != "image/png" {
+		t.Errorf("content inconsistent, content:%s, content type:%s", b, contentType)
+	}
+	if _, _, err = p.GetContent(context.Background(), server.URL+"/2.png"); err == nil {
+		t.Errorf("getting an unknown media should fail")
+	}
+	if _, _, err = p.GetContent(context.Background(), "1.png"); !errors.Is(err, domain.ErrBadParamInput) {
+		t.Errorf("error inconsistent, caught error:%v, expected error:%v", err, domain.ErrBadParamInput)
+	}
+}
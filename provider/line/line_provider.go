@@ -0,0 +1,199 @@
+package line
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/kunmingliu/messenger/domain"
+	"github.com/line/line-bot-sdk-go/v7/linebot"
+)
+
+// LineProvider talks to a channel of Line with the Messaging API.
+type LineProvider struct {
+	linebot.Client
+}
+
+// ReplyTokenTTL is how long a reply token of Line can be used after the event happens.
+const ReplyTokenTTL = time.Minute
+
+func NewLineProvider(secret, token string, options ...linebot.ClientOption) (*LineProvider, error) {
+	if secret == "" || token == "" {
+		return nil, errors.New("secret and token of line shouldn't be empty")
+	}
+	bot, err := linebot.New(secret, token, options...)
+	if err != nil {
+		return nil, err
+	}
+	return &LineProvider{*bot}, nil
+}
+
+func (l *LineProvider) ParseRequest(r *http.Request) (webhook domain.Webhook, err error) {
+	events, err := l.Client.ParseRequest(r)
+	if errors.Is(err, linebot.ErrInvalidSignature) {
+		err = domain.ErrInvalidSignature
+	}
+	if err != nil {
+		return
+	}
+
+	for _, event := range events {
+		switch event.Type {
+		case linebot.EventTypeMessage:
+			msg, ok := convertLineMessage(event.Message)
+			if !ok {
+				continue
+			}
+			msg.EventID = event.WebhookEventID
+			msg.UserID = event.Source.UserID
+			setLineReplyToken(&msg, event)
+			webhook.Messages = append(webhook.Messages, msg)
+		case linebot.EventTypePostback:
+			msg := convertLinePostback(event.Postback)
+			msg.EventID = event.WebhookEventID
+			msg.UserID = event.Source.UserID
+			setLineReplyToken(&msg, event)
+			webhook.Messages = append(webhook.Messages, msg)
+		case linebot.EventTypeFollow, linebot.EventTypeUnfollow, linebot.EventTypeJoin,
+			linebot.EventTypeLeave, linebot.EventTypeMemberJoined, linebot.EventTypeMemberLeft:
+			webhook.Events = append(webhook.Events, convertLineEvent(event))
+		}
+	}
+	return
+}
+
+func setLineReplyToken(msg *domain.Message, event *linebot.Event) {
+	if event.ReplyToken == "" {
+		return
+	}
+	expiresAt := event.Timestamp.Add(ReplyTokenTTL).UTC()
+	msg.ReplyToken = event.ReplyToken
+	msg.ReplyExpiresAt = &expiresAt
+}
+
+// convertLineEvent maps a lifecycle event of Line into domain.Event.
+func convertLineEvent(event *linebot.Event) domain.Event {
+	e := domain.Event{
+		EventID:   event.WebhookEventID,
+		Type:      domain.EventType(event.Type),
+		Timestamp: event.Timestamp.UTC(),
+	}
+	if event.Source != nil {
+		e.UserID = event.Source.UserID
+		e.GroupID = event.Source.GroupID
+		e.RoomID = event.Source.RoomID
+	}
+
+	var members *linebot.Members
+	switch event.Type {
+	case linebot.EventTypeMemberJoined:
+		members = event.Joined
+	case linebot.EventTypeMemberLeft:
+		members = event.Left
+	}
+	if members != nil {
+		for _, member := range members.Members {
+			e.Members = append(e.Members, member.UserID)
+		}
+	}
+	return e
+}
+
+// convertLinePostback maps a postback of Line into domain.Message so it's kept with the user's messages.
+func convertLinePostback(p *linebot.Postback) domain.Message {
+	postback := &domain.Postback{Data: p.Data}
+	if p.Params != nil {
+		postback.Params = &domain.PostbackParams{
+			Date:               p.Params.Date,
+			Time:               p.Params.Time,
+			Datetime:           p.Params.Datetime,
+			NewRichMenuAliasID: p.Params.NewRichMenuAliasID,
+			Status:             p.Params.Status,
+		}
+	}
+	return domain.Message{
+		Type:    domain.MessageTypePostback,
+		Payload: &domain.Payload{Postback: postback},
+	}
+}
+
+// convertLineMessage maps a message of Line into domain.Message, the unsupported types would be ignored.
+func convertLineMessage(m linebot.Message) (msg domain.Message, ok bool) {
+	ok = true
+	switch message := m.(type) {
+	case *linebot.TextMessage:
+		msg.Type = domain.MessageTypeText
+		msg.Message = message.Text
+	case *linebot.ImageMessage:
+		msg.Type = domain.MessageTypeImage
+		msg.Payload = &domain.Payload{
+			Media: &domain.Media{ContentID: message.ID},
+		}
+	case *linebot.VideoMessage:
+		msg.Type = domain.MessageTypeVideo
+		msg.Payload = &domain.Payload{
+			Media: &domain.Media{ContentID: message.ID, Duration: message.Duration},
+		}
+	case *linebot.AudioMessage:
+		msg.Type = domain.MessageTypeAudio
+		msg.Payload = &domain.Payload{
+			Media: &domain.Media{ContentID: message.ID, Duration: message.Duration},
+		}
+	case *linebot.FileMessage:
+		msg.Type = domain.MessageTypeFile
+		msg.Payload = &domain.Payload{
+			Media: &domain.Media{ContentID: message.ID},
+			File:  &domain.File{Name: message.FileName, Size: message.FileSize},
+		}
+	case *linebot.StickerMessage:
+		msg.Type = domain.MessageTypeSticker
+		msg.Payload = &domain.Payload{
+			Sticker: &domain.Sticker{PackageID: message.PackageID, StickerID: message.StickerID},
+		}
+	case *linebot.LocationMessage:
+		msg.Type = domain.MessageTypeLocation
+		msg.Payload = &domain.Payload{
+			Location: &domain.Location{
+				Title:     message.Title,
+				Address:   message.Address,
+				Latitude:  message.Latitude,
+				Longitude: message.Longitude,
+			},
+		}
+	default:
+		ok = false
+	}
+	return
+}
+
+func (l *LineProvider) SendMessage(ctx context.Context, msg string) (err error) {
+	_, err = l.Client.BroadcastMessage(linebot.NewTextMessage(msg)).WithContext(ctx).Do()
+	return
+}
+
+func (l *LineProvider) ReplyMessage(ctx context.Context, replyToken, msg string) (err error) {
+	_, err = l.Client.ReplyMessage(replyToken, linebot.NewTextMessage(msg)).WithContext(ctx).Do()
+	return
+}
+
+func (l *LineProvider) PushMessage(ctx context.Context, userID, msg string) (err error) {
+	_, err = l.Client.PushMessage(userID, linebot.NewTextMessage(msg)).WithContext(ctx).Do()
+	return
+}
+
+func (l *LineProvider) Multicast(ctx context.Context, userIDs []string, msg string) (err error) {
+	_, err = l.Client.Multicast(userIDs, linebot.NewTextMessage(msg)).WithContext(ctx).Do()
+	return
+}
+
+func (l *LineProvider) GetContent(ctx context.Context, contentID string) (content io.ReadCloser, contentType string, err error) {
+	res, err := l.Client.GetMessageContent(contentID).WithContext(ctx).Do()
+	if err != nil {
+		return
+	}
+	content = res.Content
+	contentType = res.ContentType
+	return
+}
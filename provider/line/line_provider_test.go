@@ -0,0 +1,229 @@
+package line
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/kunmingliu/messenger/domain"
+	"github.com/line/line-bot-sdk-go/v7/linebot"
+)
+
+func Test_convertLineMessage(t *testing.T) {
+	cases := []struct {
+		name       string
+		m          linebot.Message
+		expected   domain.Message
+		expectedOk bool
+	}{
+		{
+			name:       "text",
+			m:          &linebot.TextMessage{ID: "1", Text: "hello"},
+			expected:   domain.Message{Type: domain.MessageTypeText, Message: "hello"},
+			expectedOk: true,
+		},
+		{
+			name: "image",
+			m:    &linebot.ImageMessage{ID: "2"},
+			expected: domain.Message{
+				Type:    domain.MessageTypeImage,
+				Payload: &domain.Payload{Media: &domain.Media{ContentID: "2"}},
+			},
+			expectedOk: true,
+		},
+		{
+			name: "video",
+			m:    &linebot.VideoMessage{ID: "3", Duration: 60000},
+			expected: domain.Message{
+				Type:    domain.MessageTypeVideo,
+				Payload: &domain.Payload{Media: &domain.Media{ContentID: "3", Duration: 60000}},
+			},
+			expectedOk: true,
+		},
+		{
+			name: "audio",
+			m:    &linebot.AudioMessage{ID: "4", Duration: 3000},
+			expected: domain.Message{
+				Type:    domain.MessageTypeAudio,
+				Payload: &domain.Payload{Media: &domain.Media{ContentID: "4", Duration: 3000}},
+			},
+			expectedOk: true,
+		},
+		{
+			name: "file",
+			m:    &linebot.FileMessage{ID: "5", FileName: "a.pdf", FileSize: 1024},
+			expected: domain.Message{
+				Type: domain.MessageTypeFile,
+				Payload: &domain.Payload{
+					Media: &domain.Media{ContentID: "5"},
+					File:  &domain.File{Name: "a.pdf", Size: 1024},
+				},
+			},
+			expectedOk: true,
+		},
+		{
+			name: "sticker",
+			m:    &linebot.StickerMessage{ID: "6", PackageID: "446", StickerID: "1988"},
+			expected: domain.Message{
+				Type:    domain.MessageTypeSticker,
+				Payload: &domain.Payload{Sticker: &domain.Sticker{PackageID: "446", StickerID: "1988"}},
+			},
+			expectedOk: true,
+		},
+		{
+			name: "location",
+			m:    &linebot.LocationMessage{ID: "7", Title: "Office", Address: "Taipei", Latitude: 25.03, Longitude: 121.56},
+			expected: domain.Message{
+				Type: domain.MessageTypeLocation,
+				Payload: &domain.Payload{Location: &domain.Location{
+					Title: "Office", Address: "Taipei", Latitude: 25.03, Longitude: 121.56,
+				}},
+			},
+			expectedOk: true,
+		},
+		{
+			name: "unsupported type is ignored",
+			m:    &linebot.ImagemapMessage{BaseURL: "https://example.com/imagemap"},
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			got, ok := convertLineMessage(c.m)
+			if ok != c.expectedOk {
+				t.Fatalf("ok inconsistent, ok:%v, expected ok:%v", ok, c.expectedOk)
+			}
+			if !reflect.DeepEqual(got, c.expected) {
+				t.Errorf("data inconsistent, data:%+v, expected data:%+v", got, c.expected)
+			}
+		})
+	}
+}
+
+func Test_convertLinePostback(t *testing.T) {
+	cases := []struct {
+		name     string
+		p        *linebot.Postback
+		expected domain.Message
+	}{
+		{
+			name: "data",
+			p:    &linebot.Postback{Data: "action=buy&item=1"},
+			expected: domain.Message{
+				Type:    domain.MessageTypePostback,
+				Payload: &domain.Payload{Postback: &domain.Postback{Data: "action=buy&item=1"}},
+			},
+		},
+		{
+			name: "datetime picker",
+			p: &linebot.Postback{Data: "action=book", Params: &linebot.Params{
+				Date: "2022-11-01", Time: "10:00", Datetime: "2022-11-01T10:00",
+			}},
+			expected: domain.Message{
+				Type: domain.MessageTypePostback,
+				Payload: &domain.Payload{Postback: &domain.Postback{
+					Data:   "action=book",
+					Params: &domain.PostbackParams{Date: "2022-11-01", Time: "10:00", Datetime: "2022-11-01T10:00"},
+				}},
+			},
+		},
+		{
+			name: "rich menu switch",
+			p: &linebot.Postback{Data: "menu=b", Params: &linebot.Params{
+				NewRichMenuAliasID: "richmenu-b", Status: "SUCCESS",
+			}},
+			expected: domain.Message{
+				Type: domain.MessageTypePostback,
+				Payload: &domain.Payload{Postback: &domain.Postback{
+					Data:   "menu=b",
+					Params: &domain.PostbackParams{NewRichMenuAliasID: "richmenu-b", Status: "SUCCESS"},
+				}},
+			},
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			if got := convertLinePostback(c.p); !reflect.DeepEqual(got, c.expected) {
+				t.Errorf("data inconsistent, data:%+v, expected data:%+v", got, c.expected)
+			}
+		})
+	}
+}
+
+func Test_convertLineEvent(t *testing.T) {
+	timestamp := time.Date(2022, 11, 1, 8, 0, 0, 0, time.FixedZone("CST", 8*60*60))
+
+	cases := []struct {
+		name     string
+		event    *linebot.Event
+		expected domain.Event
+	}{
+		{
+			name: "follow",
+			event: &linebot.Event{
+				Type: linebot.EventTypeFollow, WebhookEventID: "e1", Timestamp: timestamp,
+				Source: &linebot.EventSource{Type: linebot.EventSourceTypeUser, UserID: "u1"},
+			},
+			expected: domain.Event{EventID: "e1", Type: domain.EventTypeFollow, Timestamp: timestamp.UTC(), UserID: "u1"},
+		},
+		{
+			name: "unfollow",
+			event: &linebot.Event{
+				Type: linebot.EventTypeUnfollow, WebhookEventID: "e2", Timestamp: timestamp,
+				Source: &linebot.EventSource{Type: linebot.EventSourceTypeUser, UserID: "u1"},
+			},
+			expected: domain.Event{EventID: "e2", Type: domain.EventTypeUnfollow, Timestamp: timestamp.UTC(), UserID: "u1"},
+		},
+		{
+			name: "join",
+			event: &linebot.Event{
+				Type: linebot.EventTypeJoin, WebhookEventID: "e3", Timestamp: timestamp,
+				Source: &linebot.EventSource{Type: linebot.EventSourceTypeGroup, GroupID: "g1"},
+			},
+			expected: domain.Event{EventID: "e3", Type: domain.EventTypeJoin, Timestamp: timestamp.UTC(), GroupID: "g1"},
+		},
+		{
+			name: "leave",
+			event: &linebot.Event{
+				Type: linebot.EventTypeLeave, WebhookEventID: "e4", Timestamp: timestamp,
+				Source: &linebot.EventSource{Type: linebot.EventSourceTypeRoom, RoomID: "r1"},
+			},
+			expected: domain.Event{EventID: "e4", Type: domain.EventTypeLeave, Timestamp: timestamp.UTC(), RoomID: "r1"},
+		},
+		{
+			name: "member joined",
+			event: &linebot.Event{
+				Type: linebot.EventTypeMemberJoined, WebhookEventID: "e5", Timestamp: timestamp,
+				Source: &linebot.EventSource{Type: linebot.EventSourceTypeGroup, GroupID: "g1"},
+				Joined: &linebot.Members{Members: []linebot.EventSource{{UserID: "u2"}, {UserID: "u3"}}},
+			},
+			expected: domain.Event{
+				EventID: "e5", Type: domain.EventTypeMemberJoined, Timestamp: timestamp.UTC(), GroupID: "g1",
+				Members: []string{"u2", "u3"},
+			},
+		},
+		{
+			name: "member left",
+			event: &linebot.Event{
+				Type: linebot.EventTypeMemberLeft, WebhookEventID: "e6", Timestamp: timestamp,
+				Source: &linebot.EventSource{Type: linebot.EventSourceTypeGroup, GroupID: "g1"},
+				Left:   &linebot.Members{Members: []linebot.EventSource{{UserID: "u2"}}},
+			},
+			expected: domain.Event{
+				EventID: "e6", Type: domain.EventTypeMemberLeft, Timestamp: timestamp.UTC(), GroupID: "g1",
+				Members: []string{"u2"},
+			},
+		},
+		{
+			name:     "without source",
+			event:    &linebot.Event{Type: linebot.EventTypeFollow, WebhookEventID: "e7", Timestamp: timestamp},
+			expected: domain.Event{EventID: "e7", Type: domain.EventTypeFollow, Timestamp: timestamp.UTC()},
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			if got := convertLineEvent(c.event); !reflect.DeepEqual(got, c.expected) {
+				t.Errorf("data inconsistent, data:%+v, expected data:%+v", got, c.expected)
+			}
+		})
+	}
+}
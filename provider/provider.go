@@ -0,0 +1,54 @@
+package provider
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/kunmingliu/messenger/domain"
+)
+
+// ErrBroadcastNotSupported is returned when sending without recipients to a platform which can only message the
+// conversations the account is in.
+var ErrBroadcastNotSupported = fmt.Errorf("%w: the platform can't broadcast", domain.ErrBadParamInput)
+
+// DefaultTimeout bounds every request of the providers to the platforms, so a platform not responding can't hold the
+// requests of the messenger forever.
+const DefaultTimeout = 30 * time.Second
+
+// NewHTTPClient returns the client used by the providers unless another one is given.
+func NewHTTPClient() *http.Client {
+	return &http.Client{Timeout: DefaultTimeout}
+}
+
+// RedactURL drops the url from the error of a request, for the platforms which put a token in the path of the url.
+func RedactURL(err error) error {
+	var urlErr *url.Error
+	if errors.As(err, &urlErr) {
+		return fmt.Errorf("%s request failed: %w", urlErr.Op, urlErr.Err)
+	}
+	return err
+}
+
+// MulticastEach sends the message to every user one by one for the platforms without multicast, the users failed are
+// reported together after trying all of them.
+func MulticastEach(userIDs []string, push func(userID string) error) error {
+	var (
+		failed  []string
+		lastErr error
+	)
+	for _, userID := range userIDs {
+		if err := push(userID); err != nil {
+			failed = append(failed, userID)
+			lastErr = err
+		}
+	}
+	if len(failed) > 0 {
+		return fmt.Errorf("send to %d of %d users failed, users:%s, err:%w",
+			len(failed), len(userIDs), strings.Join(failed, ","), lastErr)
+	}
+	return nil
+}
@@ -0,0 +1,52 @@
+package provider
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/kunmingliu/messenger/domain"
+)
+
+// Registry keeps the provider of each channel, the first registered channel is the default one.
+type Registry struct {
+	mu             sync.RWMutex
+	providers      map[string]domain.Provider
+	defaultChannel string
+}
+
+func NewRegistry() *Registry {
+	return &Registry{providers: make(map[string]domain.Provider)}
+}
+
+// Register adds the provider of the channel, a channel can only be registered once.
+func (r *Registry) Register(channelID string, p domain.Provider) error {
+	if channelID == "" || p == nil {
+		return domain.ErrBadParamInput
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.providers[channelID]; ok {
+		return fmt.Errorf("channel %s has been registered", channelID)
+	}
+	r.providers[channelID] = p
+	if r.defaultChannel == "" {
+		r.defaultChannel = channelID
+	}
+	return nil
+}
+
+func (r *Registry) Provider(channelID string) (domain.Provider, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	p, ok := r.providers[channelID]
+	if !ok {
+		return nil, domain.ErrNotFound
+	}
+	return p, nil
+}
+
+func (r *Registry) DefaultChannel() string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.defaultChannel
+}
@@ -0,0 +1,60 @@
+package provider
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/golang/mock/gomock"
+	"github.com/kunmingliu/messenger/domain"
+	mockDomain "github.com/kunmingliu/messenger/internal/mocks/domain"
+)
+
+func TestRegistry(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+	line := mockDomain.NewMockProvider(mockCtrl)
+	demo := mockDomain.NewMockProvider(mockCtrl)
+
+	r := NewRegistry()
+	if r.DefaultChannel() != "" {
+		t.Errorf("DefaultChannel inconsistent, channel:%v, expected channel:%v", r.DefaultChannel(), "")
+	}
+	if err := r.Register("line", line); err != nil {
+		t.Fatalf("Register failed, err:%v", err)
+	}
+	if err := r.Register("demo", demo); err != nil {
+		t.Fatalf("Register failed, err:%v", err)
+	}
+	if err := r.Register("line", demo); err == nil {
+		t.Errorf("Register should fail for a registered channel")
+	}
+	if err := r.Register("", demo); !errors.Is(err, domain.ErrBadParamInput) {
+		t.Errorf("Register inconsistent, err:%v, expected err:%v", err, domain.ErrBadParamInput)
+	}
+
+	if r.DefaultChannel() != "line" {
+		t.Errorf("DefaultChannel inconsistent, channel:%v, expected channel:%v", r.DefaultChannel(), "line")
+	}
+
+	cases := []struct {
+		name        string
+		channelID   string
+		expected    domain.Provider
+		expectedErr error
+	}{
+		{name: "line", channelID: "line", expected: line},
+		{name: "demo", channelID: "demo", expected: demo},
+		{name: "unknown", channelID: "telegram", expectedErr: domain.ErrNotFound},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			got, err := r.Provider(c.channelID)
+			if !errors.Is(err, c.expectedErr) {
+				t.Fatalf("Provider inconsistent, err:%v, expected err:%v", err, c.expectedErr)
+			}
+			if got != c.expected {
+				t.Errorf("Provider inconsistent, provider:%v, expected provider:%v", got, c.expected)
+			}
+		})
+	}
+}
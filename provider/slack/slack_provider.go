@@ -0,0 +1,320 @@
+package slack
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/kunmingliu/messenger/domain"
+	"github.com/kunmingliu/messenger/provider"
+)
+
+const (
+	// DefaultBaseURL is the endpoint of the Web API.
+	DefaultBaseURL = "https://slack.com/api"
+	// fileHost serves the private files, the bot token is only sent to it.
+	fileHost = "files.slack.com"
+	// signatureTolerance is how old a webhook request can be, the older ones are rejected to prevent replay attacks.
+	signatureTolerance = 5 * time.Minute
+)
+
+// SlackProvider talks to a Slack app with the Events API and the Web API. The user id of messages is the id of the
+// conversation, so that the messages sent to it reach the same channel or direct message.
+type SlackProvider struct {
+	token         string
+	signingSecret string
+	baseURL       string
+	fileHost      string
+	client        *http.Client
+	now           func() time.Time
+}
+
+type Option func(*SlackProvider)
+
+// WithBaseURL points the provider at another Web API server, e.g. a fake for tests.
+func WithBaseURL(baseURL string) Option {
+	return func(s *SlackProvider) {
+		s.baseURL = strings.TrimSuffix(baseURL, "/")
+	}
+}
+
+func WithHTTPClient(client *http.Client) Option {
+	return func(s *SlackProvider) {
+		s.client = client
+	}
+}
+
+// NewSlackProvider creates the provider of the app with its bot token and signing secret.
+func NewSlackProvider(token, signingSecret string, options ...Option) (*SlackProvider, error) {
+	if token == "" || signingSecret == "" {
+		return nil, errors.New("token and signing secret of slack shouldn't be empty")
+	}
+	s := &SlackProvider{
+		token:         token,
+		signingSecret: signingSecret,
+		baseURL:       DefaultBaseURL,
+		fileHost:      fileHost,
+		client:        provider.NewHTTPClient(),
+		now:           time.Now,
+	}
+	for _, option := range options {
+		option(s)
+	}
+	return s, nil
+}
+
+// verify checks X-Slack-Signature, which is the HMAC-SHA256 of the version, timestamp and body signed with the
+// signing secret.
+func (s *SlackProvider) verify(r *http.Request, body []byte) error {
+	timestamp := r.Header.Get("X-Slack-Request-Timestamp")
+	seconds, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return domain.ErrInvalidSignature
+	}
+	if age := s.now().Sub(time.Unix(seconds, 0)); age > signatureTolerance || age < -signatureTolerance {
+		return domain.ErrInvalidSignature
+	}
+
+	mac := hmac.New(sha256.New, []byte(s.signingSecret))
+	mac.Write([]byte("v0:" + timestamp + ":"))
+	mac.Write(body)
+	expected := "v0=" + hex.EncodeToString(mac.Sum(nil))
+	if !hmac.Equal([]byte(r.Header.Get("X-Slack-Signature")), []byte(expected)) {
+		return domain.ErrInvalidSignature
+	}
+	return nil
+}
+
+type file struct {
+	ID       string `json:"id"`
+	Name     string `json:"name"`
+	Mimetype string `json:"mimetype"`
+	Size     int    `json:"size"`
+}
+
+type event struct {
+	Type    string `json:"type"`
+	Subtype string `json:"subtype"`
+	User    string `json:"user"`
+	BotID   string `json:"bot_id"`
+	Text    string `json:"text"`
+	Channel string `json:"channel"`
+	Files   []file `json:"files"`
+}
+
+type callback struct {
+	Type      string `json:"type"`
+	Challenge string `json:"challenge"`
+	EventID   string `json:"event_id"`
+	EventTime int64  `json:"event_time"`
+	Event     event  `json:"event"`
+}
+
+func (s *SlackProvider) ParseRequest(r *http.Request) (webhook domain.Webhook, err error) {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		return
+	}
+	if err = s.verify(r, body); err != nil {
+		return
+	}
+
+	var c callback
+	if err = json.Unmarshal(body, &c); err != nil {
+		return
+	}
+
+	switch c.Type {
+	case "url_verification":
+		webhook.Response = &domain.WebhookResponse{
+			StatusCode:  http.StatusOK,
+			ContentType: "text/plain",
+			Body:        []byte(c.Challenge),
+		}
+	case "event_callback":
+		switch c.Event.Type {
+		case "message":
+			webhook.Messages = append(webhook.Messages, convertMessage(c.EventID, c.Event)...)
+		case "member_joined_channel", "member_left_channel":
+			e := domain.Event{
+				EventID:   c.EventID,
+				Type:      domain.EventTypeMemberJoined,
+				GroupID:   c.Event.Channel,
+				Members:   []string{c.Event.User},
+				Timestamp: time.Unix(c.EventTime, 0).UTC(),
+			}
+			if c.Event.Type == "member_left_channel" {
+				e.Type = domain.EventTypeMemberLeft
+			}
+			webhook.Events = append(webhook.Events, e)
+		}
+	}
+	return
+}
+
+// convertMessage maps a message event of Slack into domain.Message, the text and each file shared become a message
+// of their own. The messages of bots, including the ones sent by us, are ignored so that they aren't stored twice, and
+// so are the changes and deletions of messages.
+func convertMessage(eventID string, e event) (msgs []domain.Message) {
+	if e.BotID != "" {
+		return nil
+	}
+	switch e.Subtype {
+	case "", "file_share", "thread_broadcast":
+	default:
+		return nil
+	}
+
+	if e.Text != "" {
+		msgs = append(msgs, domain.Message{
+			EventID: eventID,
+			UserID:  e.Channel,
+			Type:    domain.MessageTypeText,
+			Message: e.Text,
+		})
+	}
+	for i, f := range e.Files {
+		msg := domain.Message{
+			// the files are parts of the same event, so they share its id
+			EventID: fmt.Sprintf("%s#%d", eventID, i+1),
+			UserID:  e.Channel,
+			Type:    fileType(f.Mimetype),
+			Payload: &domain.Payload{Media: &domain.Media{ContentID: f.ID, ContentType: f.Mimetype}},
+		}
+		if msg.Type == domain.MessageTypeFile {
+			msg.Payload.File = &domain.File{Name: f.Name, Size: f.Size}
+		}
+		msgs = append(msgs, msg)
+	}
+	return msgs
+}
+
+func fileType(mimetype string) domain.MessageType {
+	switch {
+	case strings.HasPrefix(mimetype, "image/"):
+		return domain.MessageTypeImage
+	case strings.HasPrefix(mimetype, "video/"):
+		return domain.MessageTypeVideo
+	case strings.HasPrefix(mimetype, "audio/"):
+		return domain.MessageTypeAudio
+	default:
+		return domain.MessageTypeFile
+	}
+}
+
+// call invokes the method of the Web API and decodes the response into result, an error is returned if it's not ok.
+func (s *SlackProvider) call(ctx context.Context, req *http.Request, method string, result interface{}) error {
+	req.Header.Set("Authorization", "Bearer "+s.token)
+	res, err := s.client.Do(req.WithContext(ctx))
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	b, err := io.ReadAll(res.Body)
+	if err != nil {
+		return err
+	}
+	var resp struct {
+		OK    bool   `json:"ok"`
+		Error string `json:"error"`
+	}
+	if err = json.Unmarshal(b, &resp); err != nil {
+		return fmt.Errorf("slack %s failed, status:%d, err:%w", method, res.StatusCode, err)
+	}
+	if !resp.OK {
+		return fmt.Errorf("slack %s failed, err:%s", method, resp.Error)
+	}
+	if result == nil {
+		return nil
+	}
+	return json.Unmarshal(b, result)
+}
+
+// SendMessage fails since an app can only post to the conversations it's in.
+func (s *SlackProvider) SendMessage(ctx context.Context, msg string) error {
+	return provider.ErrBroadcastNotSupported
+}
+
+// CanBroadcast is false since the app has no list of its conversations to post to.
+func (s *SlackProvider) CanBroadcast() bool {
+	return false
+}
+
+// ReplyMessage isn't used since there is no reply token in Slack, the usecase pushes the reply to the conversation
+// instead.
+func (s *SlackProvider) ReplyMessage(ctx context.Context, replyToken, msg string) error {
+	return fmt.Errorf("%w: slack has no reply token", domain.ErrBadParamInput)
+}
+
+func (s *SlackProvider) PushMessage(ctx context.Context, userID, msg string) error {
+	body, err := json.Marshal(map[string]string{"channel": userID, "text": msg})
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.baseURL+"/chat.postMessage", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json; charset=utf-8")
+	return s.call(ctx, req, "chat.postMessage", nil)
+}
+
+// Multicast posts the message to every conversation one by one since the Web API has no multicast.
+func (s *SlackProvider) Multicast(ctx context.Context, userIDs []string, msg string) error {
+	return provider.MulticastEach(userIDs, func(userID string) error {
+		return s.PushMessage(ctx, userID, msg)
+	})
+}
+
+// GetContent downloads the file with the private url given by files.info, which requires the bot token as well.
+func (s *SlackProvider) GetContent(ctx context.Context, contentID string) (content io.ReadCloser, contentType string, err error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, s.baseURL+"/files.info?file="+url.QueryEscape(contentID), nil)
+	if err != nil {
+		return
+	}
+	var info struct {
+		File struct {
+			Mimetype           string `json:"mimetype"`
+			URLPrivateDownload string `json:"url_private_download"`
+		} `json:"file"`
+	}
+	if err = s.call(ctx, req, "files.info", &info); err != nil {
+		return
+	}
+
+	// the bot token would leak to wherever the url points at
+	u, err := url.Parse(info.File.URLPrivateDownload)
+	if err != nil || u.Scheme != "https" || u.Hostname() != s.fileHost {
+		err = fmt.Errorf("%w: unexpected download url of slack file %s", domain.ErrBadParamInput, contentID)
+		return
+	}
+	req, err = http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return
+	}
+	req.Header.Set("Authorization", "Bearer "+s.token)
+	res, err := s.client.Do(req)
+	if err != nil {
+		return
+	}
+	if res.StatusCode != http.StatusOK {
+		res.Body.Close()
+		err = fmt.Errorf("slack download file failed, status:%d", res.StatusCode)
+		return
+	}
+	content = res.Body
+	contentType = info.File.Mimetype
+	return
+}
@@ -0,0 +1,264 @@
+package slack
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/kunmingliu/messenger/domain"
+	"github.com/kunmingliu/messenger/internal/providertest"
+)
+
+// newProvider creates the provider on a fake of the Web API, which records the messages posted and serves a single
+// file over https. The other files point at the urls the token shouldn't be sent to.
+func newProvider(t *testing.T, now time.Time) (*SlackProvider, *providertest.Recorder[map[string]string]) {
+	server := providertest.NewServer(t)
+	sent := &providertest.Recorder[map[string]string]{}
+	authorized := func(handler http.HandlerFunc) http.HandlerFunc {
+		return providertest.RequireHeader("Authorization", "Bearer token", http.StatusOK, `{"ok":false,"error":"invalid_auth"}`, handler)
+	}
+	files := httptest.NewTLSServer(authorized(providertest.Content("image/png", "image")))
+	t.Cleanup(files.Close)
+	downloads := map[string]string{
+		"F1": files.URL + "/files-pri/F1/download",
+		"F4": server.URL + "/files-pri/F4/download",
+		"F5": "https://files.example.com/files-pri/F5/download",
+	}
+	server.Handle("/chat.postMessage", authorized(func(w http.ResponseWriter, r *http.Request) {
+		var params map[string]string
+		json.NewDecoder(r.Body).Decode(&params)
+		if params["channel"] == "C404" {
+			providertest.WriteJSON(w, http.StatusOK, `{"ok":false,"error":"channel_not_found"}`)
+			return
+		}
+		sent.Add(params)
+		providertest.WriteJSON(w, http.StatusOK, `{"ok":true,"ts":"1667260800.000100"}`)
+	}))
+	server.Handle("/files.info", authorized(func(w http.ResponseWriter, r *http.Request) {
+		id := r.URL.Query().Get("file")
+		download, ok := downloads[id]
+		if !ok {
+			providertest.WriteJSON(w, http.StatusOK, `{"ok":false,"error":"file_not_found"}`)
+			return
+		}
+		providertest.WriteJSON(w, http.StatusOK, `{"ok":true,"file":{"id":"`+id+`","mimetype":"image/png","url_private_download":"`+download+`"}}`)
+	}))
+	server.Handle("/files-pri/F4/download", authorized(providertest.Content("image/png", "image")))
+
+	p, err := NewSlackProvider("token", "secret", WithBaseURL(server.URL), WithHTTPClient(files.Client()))
+	if err != nil {
+		t.Fatalf("unexpected error:%v", err)
+	}
+	p.fileHost = "127.0.0.1"
+	p.now = func() time.Time { return now }
+	return p, sent
+}
+
+func sign(secret, timestamp, body string) string {
+	return "v0=" + providertest.HexHMAC(sha256.New, secret, "v0:"+timestamp+":"+body)
+}
+
+func TestSlackProvider_ParseRequest(t *testing.T) {
+	now := time.Date(2022, 11, 1, 0, 0, 0, 0, time.UTC)
+	p, _ := newProvider(t, now)
+
+	cases := []struct {
+		name        string
+		secret      string
+		timestamp   time.Time
+		body        string
+		expected    domain.Webhook
+		expectedErr error
+	}{
+		{
+			name:      "url verification",
+			secret:    "secret",
+			timestamp: now,
+			body:      `{"type":"url_verification","token":"t","challenge":"3eZbrw1aBm2rZgRNFdxV2595E9CY3gmdALWMmHkvFXO7tYXAYM8P"}`,
+			expected: domain.Webhook{Response: &domain.WebhookResponse{
+				StatusCode:  http.StatusOK,
+				ContentType: "text/plain",
+				Body:        []byte("3eZbrw1aBm2rZgRNFdxV2595E9CY3gmdALWMmHkvFXO7tYXAYM8P"),
+			}},
+		},
+		{
+			name:      "text",
+			secret:    "secret",
+			timestamp: now,
+			body:      `{"type":"event_callback","event_id":"Ev1","event_time":1667260800,"event":{"type":"message","user":"U1","text":"hello","channel":"C1"}}`,
+			expected: domain.Webhook{Messages: []domain.Message{
+				{EventID: "Ev1", UserID: "C1", Type: domain.MessageTypeText, Message: "hello"},
+			}},
+		},
+		{
+			name:      "image shared",
+			secret:    "secret",
+			timestamp: now,
+			body: `{"type":"event_callback","event_id":"Ev2","event":{"type":"message","subtype":"file_share","user":"U1","text":"look","channel":"D1",` +
+				`"files":[{"id":"F1","name":"a.png","mimetype":"image/png","size":5}]}}`,
+			expected: domain.Webhook{Messages: []domain.Message{
+				{EventID: "Ev2", UserID: "D1", Type: domain.MessageTypeText, Message: "look"},
+				{
+					EventID: "Ev2#1", UserID: "D1", Type: domain.MessageTypeImage,
+					Payload: &domain.Payload{Media: &domain.Media{ContentID: "F1", ContentType: "image/png"}},
+				},
+			}},
+		},
+		{
+			name:      "file shared",
+			secret:    "secret",
+			timestamp: now,
+			body: `{"type":"event_callback","event_id":"Ev3","event":{"type":"message","subtype":"file_share","user":"U1","channel":"D1",` +
+				`"files":[{"id":"F2","name":"a.txt","mimetype":"text/plain","size":3}]}}`,
+			expected: domain.Webhook{Messages: []domain.Message{{
+				EventID: "Ev3#1", UserID: "D1", Type: domain.MessageTypeFile,
+				Payload: &domain.Payload{
+					Media: &domain.Media{ContentID: "F2", ContentType: "text/plain"},
+					File:  &domain.File{Name: "a.txt", Size: 3},
+				},
+			}}},
+		},
+		{
+			name:      "files shared",
+			secret:    "secret",
+			timestamp: now,
+			body: `{"type":"event_callback","event_id":"Ev5","event":{"type":"message","subtype":"file_share","user":"U1","channel":"D1",` +
+				`"files":[{"id":"F1","name":"a.png","mimetype":"image/png","size":5},{"id":"F3","name":"b.mp4","mimetype":"video/mp4","size":9}]}}`,
+			expected: domain.Webhook{Messages: []domain.Message{
+				{
+					EventID: "Ev5#1", UserID: "D1", Type: domain.MessageTypeImage,
+					Payload: &domain.Payload{Media: &domain.Media{ContentID: "F1", ContentType: "image/png"}},
+				},
+				{
+					EventID: "Ev5#2", UserID: "D1", Type: domain.MessageTypeVideo,
+					Payload: &domain.Payload{Media: &domain.Media{ContentID: "F3", ContentType: "video/mp4"}},
+				},
+			}},
+		},
+		{
+			name:      "message without text or files is ignored",
+			secret:    "secret",
+			timestamp: now,
+			body:      `{"type":"event_callback","event_id":"Ev6","event":{"type":"message","user":"U1","channel":"C1"}}`,
+		},
+		{
+			name:      "message of bot is ignored",
+			secret:    "secret",
+			timestamp: now,
+			body:      `{"type":"event_callback","event_id":"Ev4","event":{"type":"message","bot_id":"B1","text":"hi","channel":"C1"}}`,
+		},
+		{
+			name:      "edited message is ignored",
+			secret:    "secret",
+			timestamp: now,
+			body:      `{"type":"event_callback","event_id":"Ev5","event":{"type":"message","subtype":"message_changed","channel":"C1"}}`,
+		},
+		{
+			name:      "member joined",
+			secret:    "secret",
+			timestamp: now,
+			body:      `{"type":"event_callback","event_id":"Ev6","event_time":1667260800,"event":{"type":"member_joined_channel","user":"U2","channel":"C1"}}`,
+			expected: domain.Webhook{Events: []domain.Event{{
+				EventID: "Ev6", Type: domain.EventTypeMemberJoined, GroupID: "C1", Members: []string{"U2"}, Timestamp: now,
+			}}},
+		},
+		{
+			name:      "member left",
+			secret:    "secret",
+			timestamp: now,
+			body:      `{"type":"event_callback","event_id":"Ev7","event_time":1667260800,"event":{"type":"member_left_channel","user":"U2","channel":"C1"}}`,
+			expected: domain.Webhook{Events: []domain.Event{{
+				EventID: "Ev7", Type: domain.EventTypeMemberLeft, GroupID: "C1", Members: []string{"U2"}, Timestamp: now,
+			}}},
+		},
+		{
+			name:        "invalid signature",
+			secret:      "wrong",
+			timestamp:   now,
+			body:        `{"type":"url_verification","challenge":"c"}`,
+			expectedErr: domain.ErrInvalidSignature,
+		},
+		{
+			name:        "expired timestamp",
+			secret:      "secret",
+			timestamp:   now.Add(-10 * time.Minute),
+			body:        `{"type":"url_verification","challenge":"c"}`,
+			expectedErr: domain.ErrInvalidSignature,
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			timestamp := strconv.FormatInt(c.timestamp.Unix(), 10)
+			req := httptest.NewRequest(http.MethodPost, "/webhook/slack", strings.NewReader(c.body))
+			req.Header.Set("X-Slack-Request-Timestamp", timestamp)
+			req.Header.Set("X-Slack-Signature", sign(c.secret, timestamp, c.body))
+			got, err := p.ParseRequest(req)
+			if !errors.Is(err, c.expectedErr) {
+				t.Fatalf("error inconsistent, caught error:%v, expected error:%v", err, c.expectedErr)
+			}
+			if !reflect.DeepEqual(got, c.expected) {
+				t.Errorf("data inconsistent, data:%+v, expected data:%+v", got, c.expected)
+			}
+		})
+	}
+}
+
+func TestSlackProvider_Send(t *testing.T) {
+	p, sent := newProvider(t, time.Now())
+
+	if err := p.PushMessage(context.Background(), "C1", "hello"); err != nil {
+		t.Fatalf("unexpected error:%v", err)
+	}
+	err := p.Multicast(context.Background(), []string{"C2", "C404", "C3"}, "hi")
+	if err == nil || !strings.Contains(err.Error(), "channel_not_found") {
+		t.Errorf("error inconsistent, caught error:%v, expected the error of the unknown channel", err)
+	}
+	if err = p.SendMessage(context.Background(), "broadcast"); !errors.Is(err, domain.ErrBadParamInput) {
+		t.Errorf("error inconsistent, caught error:%v, expected error:%v", err, domain.ErrBadParamInput)
+	}
+	if p.CanBroadcast() {
+		t.Errorf("broadcast should be unsupported")
+	}
+
+	expected := []map[string]string{
+		{"channel": "C1", "text": "hello"},
+		{"channel": "C2", "text": "hi"},
+		{"channel": "C3", "text": "hi"},
+	}
+	if got := sent.Items(); !reflect.DeepEqual(got, expected) {
+		t.Errorf("messages sent inconsistent, sent:%v, expected sent:%v", got, expected)
+	}
+}
+
+func TestSlackProvider_GetContent(t *testing.T) {
+	p, _ := newProvider(t, time.Now())
+
+	content, contentType, err := p.GetContent(context.Background(), "F1")
+	if err != nil {
+		t.Fatalf("unexpected error:%v", err)
+	}
+	defer content.Close()
+	b, _ := io.ReadAll(content)
+	if string(b) != "image" || contentType != "image/png" {
+		t.Errorf("content inconsistent, content:%s, content type:%s", b, contentType)
+	}
+
+	if _, _, err = p.GetContent(context.Background(), "F2"); err == nil {
+		t.Errorf("getting an unknown file should fail")
+	}
+	// the token is only sent to the file host over https
+	for _, contentID := range []string{"F4", "F5"} {
+		if _, _, err = p.GetContent(context.Background(), contentID); !errors.Is(err, domain.ErrBadParamInput) {
+			t.Errorf("error inconsistent, caught error:%v, expected error:%v", err, domain.ErrBadParamInput)
+		}
+	}
+}
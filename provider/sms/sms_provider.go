@@ -0,0 +1,259 @@
+package sms
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/kunmingliu/messenger/domain"
+	"github.com/kunmingliu/messenger/provider"
+)
+
+const (
+	// DefaultBaseURL is the endpoint of the REST API of Twilio.
+	DefaultBaseURL = "https://api.twilio.com"
+	// signatureHeader carries the HMAC-SHA1 of the webhook url and the parameters signed with the auth token.
+	signatureHeader = "X-Twilio-Signature"
+	// emptyResponse is the TwiML which doesn't reply to the message.
+	emptyResponse = `<?xml version="1.0" encoding="UTF-8"?><Response></Response>`
+)
+
+// SMSProvider talks to a phone number of Twilio, or any service with the same webhook and REST API. The user id of
+// messages is the phone number of the sender in E.164.
+type SMSProvider struct {
+	accountSID string
+	authToken  string
+	from       string
+	webhookURL string
+	baseURL    string
+	client     *http.Client
+}
+
+type Option func(*SMSProvider)
+
+// WithBaseURL points the provider at another REST API server, e.g. a local stand-in for tests.
+func WithBaseURL(baseURL string) Option {
+	return func(s *SMSProvider) {
+		s.baseURL = strings.TrimSuffix(baseURL, "/")
+	}
+}
+
+func WithHTTPClient(client *http.Client) Option {
+	return func(s *SMSProvider) {
+		s.client = client
+	}
+}
+
+// WithWebhookURL sets the public url of the webhook, which is signed by Twilio. The url is rebuilt from the request if
+// it isn't set, which doesn't work behind a proxy rewriting the path.
+func WithWebhookURL(webhookURL string) Option {
+	return func(s *SMSProvider) {
+		s.webhookURL = webhookURL
+	}
+}
+
+// NewSMSProvider creates the provider of the phone number with the credentials of the account.
+func NewSMSProvider(accountSID, authToken, from string, options ...Option) (*SMSProvider, error) {
+	if accountSID == "" || authToken == "" || from == "" {
+		return nil, errors.New("account sid, auth token and phone number of sms shouldn't be empty")
+	}
+	s := &SMSProvider{
+		accountSID: accountSID,
+		authToken:  authToken,
+		from:       from,
+		baseURL:    DefaultBaseURL,
+		client:     provider.NewHTTPClient(),
+	}
+	for _, option := range options {
+		option(s)
+	}
+	return s, nil
+}
+
+// requestURL is the url Twilio requested, the scheme is taken from X-Forwarded-Proto behind a proxy.
+func (s *SMSProvider) requestURL(r *http.Request) string {
+	if s.webhookURL != "" {
+		return s.webhookURL
+	}
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	if proto := r.Header.Get("X-Forwarded-Proto"); proto != "" {
+		scheme = proto
+	}
+	return scheme + "://" + r.Host + r.URL.RequestURI()
+}
+
+// verify checks X-Twilio-Signature, which is the HMAC-SHA1 of the url followed by the parameters sorted by name.
+func (s *SMSProvider) verify(r *http.Request) error {
+	var b strings.Builder
+	b.WriteString(s.requestURL(r))
+	names := make([]string, 0, len(r.PostForm))
+	for name := range r.PostForm {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		for _, value := range r.PostForm[name] {
+			b.WriteString(name + value)
+		}
+	}
+
+	mac := hmac.New(sha1.New, []byte(s.authToken))
+	mac.Write([]byte(b.String()))
+	expected := base64.StdEncoding.EncodeToString(mac.Sum(nil))
+	if !hmac.Equal([]byte(r.Header.Get(signatureHeader)), []byte(expected)) {
+		return domain.ErrInvalidSignature
+	}
+	return nil
+}
+
+// ParseRequest converts an incoming message into a text message of the body and a media message for each media. The
+// status callbacks of the messages sent are accepted but ignored.
+func (s *SMSProvider) ParseRequest(r *http.Request) (webhook domain.Webhook, err error) {
+	if err = r.ParseForm(); err != nil {
+		err = fmt.Errorf("%w: %v", domain.ErrBadParamInput, err)
+		return
+	}
+	if err = s.verify(r); err != nil {
+		return
+	}
+	webhook.Response = &domain.WebhookResponse{
+		StatusCode:  http.StatusOK,
+		ContentType: "text/xml",
+		Body:        []byte(emptyResponse),
+	}
+
+	form := r.PostForm
+	sid := form.Get("MessageSid")
+	if form.Get("MessageStatus") != "" || sid == "" {
+		return
+	}
+	from := form.Get("From")
+	if body := form.Get("Body"); body != "" {
+		webhook.Messages = append(webhook.Messages, domain.Message{
+			EventID: sid,
+			UserID:  from,
+			Type:    domain.MessageTypeText,
+			Message: body,
+		})
+	}
+	numMedia, _ := strconv.Atoi(form.Get("NumMedia"))
+	for i := 0; i < numMedia; i++ {
+		contentType := form.Get(fmt.Sprintf("MediaContentType%d", i))
+		webhook.Messages = append(webhook.Messages, domain.Message{
+			// the media are parts of the same message, so they share its sid
+			EventID: fmt.Sprintf("%s#%d", sid, i+1),
+			UserID:  from,
+			Type:    mediaType(contentType),
+			Payload: &domain.Payload{Media: &domain.Media{
+				ContentID:   form.Get(fmt.Sprintf("MediaUrl%d", i)),
+				ContentType: contentType,
+			}},
+		})
+	}
+	return
+}
+
+func mediaType(contentType string) domain.MessageType {
+	switch {
+	case strings.HasPrefix(contentType, "image/"):
+		return domain.MessageTypeImage
+	case strings.HasPrefix(contentType, "video/"):
+		return domain.MessageTypeVideo
+	case strings.HasPrefix(contentType, "audio/"):
+		return domain.MessageTypeAudio
+	default:
+		return domain.MessageTypeFile
+	}
+}
+
+// SendMessage fails since there is no list of the phone numbers to text.
+func (s *SMSProvider) SendMessage(ctx context.Context, msg string) error {
+	return provider.ErrBroadcastNotSupported
+}
+
+// CanBroadcast is false since a broadcast has no phone number to text.
+func (s *SMSProvider) CanBroadcast() bool {
+	return false
+}
+
+// ReplyMessage isn't used since there is no reply token in SMS, the usecase texts the reply to the sender instead.
+func (s *SMSProvider) ReplyMessage(ctx context.Context, replyToken, msg string) error {
+	return fmt.Errorf("%w: sms has no reply token", domain.ErrBadParamInput)
+}
+
+func (s *SMSProvider) PushMessage(ctx context.Context, userID, msg string) error {
+	form := url.Values{}
+	form.Set("From", s.from)
+	form.Set("To", userID)
+	form.Set("Body", msg)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost,
+		s.baseURL+"/2010-04-01/Accounts/"+url.PathEscape(s.accountSID)+"/Messages.json", strings.NewReader(form.Encode()))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.SetBasicAuth(s.accountSID, s.authToken)
+	res, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode/100 != 2 {
+		var resp struct {
+			Code    int    `json:"code"`
+			Message string `json:"message"`
+		}
+		if err = json.NewDecoder(res.Body).Decode(&resp); err != nil {
+			return fmt.Errorf("sms send failed, status:%d, err:%w", res.StatusCode, err)
+		}
+		return fmt.Errorf("sms send failed, status:%d, code:%d, err:%s", res.StatusCode, resp.Code, resp.Message)
+	}
+	return nil
+}
+
+// Multicast texts the message to every phone number one by one since the REST API has no multicast.
+func (s *SMSProvider) Multicast(ctx context.Context, userIDs []string, msg string) error {
+	return provider.MulticastEach(userIDs, func(userID string) error {
+		return s.PushMessage(ctx, userID, msg)
+	})
+}
+
+// GetContent downloads the media from its url, the credentials are only sent to the REST API server.
+func (s *SMSProvider) GetContent(ctx context.Context, contentID string) (content io.ReadCloser, contentType string, err error) {
+	u, err := url.Parse(contentID)
+	if err != nil || (u.Scheme != "https" && u.Scheme != "http") {
+		err = fmt.Errorf("%w: the content id of sms should be the url of the media", domain.ErrBadParamInput)
+		return
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, contentID, nil)
+	if err != nil {
+		return
+	}
+	if base, err := url.Parse(s.baseURL); err == nil && base.Host == u.Host {
+		req.SetBasicAuth(s.accountSID, s.authToken)
+	}
+	res, err := s.client.Do(req)
+	if err != nil {
+		return
+	}
+	if res.StatusCode != http.StatusOK {
+		res.Body.Close()
+		err = fmt.Errorf("sms download media failed, status:%d", res.StatusCode)
+		return
+	}
+	return res.Body, res.Header.Get("Content-Type"), nil
+}
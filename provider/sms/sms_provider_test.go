@@ -0,0 +1,209 @@
+package sms
+
+import (
+	"context"
+	"crypto/sha1"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/kunmingliu/messenger/domain"
+	"github.com/kunmingliu/messenger/internal/providertest"
+)
+
+// newProvider creates the provider on a fake of the REST API, which records the messages sent and serves a single
+// media, and returns the url of the fake.
+func newProvider(t *testing.T, options ...Option) (*SMSProvider, *providertest.Recorder[url.Values], string) {
+	server := providertest.NewServer(t)
+	sent := &providertest.Recorder[url.Values]{}
+	server.Handle("/2010-04-01/Accounts/AC1/Messages.json", func(w http.ResponseWriter, r *http.Request) {
+		if sid, token, ok := r.BasicAuth(); !ok || sid != "AC1" || token != "authtoken" {
+			providertest.WriteJSON(w, http.StatusUnauthorized, `{"code":20003,"message":"Authenticate","status":401}`)
+			return
+		}
+		r.ParseForm()
+		if r.PostForm.Get("To") == "+15550000000" {
+			providertest.WriteJSON(w, http.StatusBadRequest, `{"code":21211,"message":"The 'To' number +15550000000 is not a valid phone number.","status":400}`)
+			return
+		}
+		sent.Add(r.PostForm)
+		providertest.WriteJSON(w, http.StatusCreated, `{"sid":"SM2","status":"queued"}`)
+	})
+	server.Handle("/2010-04-01/Accounts/AC1/Messages/SM1/Media/ME1", func(w http.ResponseWriter, r *http.Request) {
+		if _, _, ok := r.BasicAuth(); !ok {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		providertest.Content("image/jpeg", "image")(w, r)
+	})
+
+	p, err := NewSMSProvider("AC1", "authtoken", "+15559870000", append([]Option{WithBaseURL(server.URL)}, options...)...)
+	if err != nil {
+		t.Fatalf("unexpected error:%v", err)
+	}
+	return p, sent, server.URL
+}
+
+func sign(authToken, u string, form url.Values) string {
+	names := make([]string, 0, len(form))
+	for name := range form {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	s := u
+	for _, name := range names {
+		s += name + form.Get(name)
+	}
+	return providertest.Base64HMAC(sha1.New, authToken, s)
+}
+
+func TestSMSProvider_ParseRequest(t *testing.T) {
+	p, _, _ := newProvider(t, WithWebhookURL("https://sms.example.com/webhook/sms"))
+	twiml := &domain.WebhookResponse{StatusCode: http.StatusOK, ContentType: "text/xml", Body: []byte(emptyResponse)}
+
+	cases := []struct {
+		name        string
+		form        url.Values
+		signature   string
+		expected    domain.Webhook
+		expectedErr error
+	}{
+		{
+			name: "text",
+			form: url.Values{
+				"AccountSid": {"AC1"}, "MessageSid": {"SM1"}, "From": {"+15551230001"}, "To": {"+15559870000"},
+				"Body": {"hello"}, "NumMedia": {"0"},
+			},
+			// signed by the algorithm of Twilio independently
+			signature: "Z+zdH6T0Hdb4Dt0hjyvdrNavqIs=",
+			expected: domain.Webhook{
+				Messages: []domain.Message{{EventID: "SM1", UserID: "+15551230001", Type: domain.MessageTypeText, Message: "hello"}},
+				Response: twiml,
+			},
+		},
+		{
+			name: "text with media",
+			form: url.Values{
+				"MessageSid": {"SM2"}, "From": {"+15551230001"}, "Body": {"look"}, "NumMedia": {"2"},
+				"MediaUrl0": {"https://api.twilio.com/m/ME1"}, "MediaContentType0": {"image/jpeg"},
+				"MediaUrl1": {"https://api.twilio.com/m/ME2"}, "MediaContentType1": {"text/vcard"},
+			},
+			expected: domain.Webhook{
+				Messages: []domain.Message{
+					{EventID: "SM2", UserID: "+15551230001", Type: domain.MessageTypeText, Message: "look"},
+					{EventID: "SM2#1", UserID: "+15551230001", Type: domain.MessageTypeImage, Payload: &domain.Payload{
+						Media: &domain.Media{ContentID: "https://api.twilio.com/m/ME1", ContentType: "image/jpeg"},
+					}},
+					{EventID: "SM2#2", UserID: "+15551230001", Type: domain.MessageTypeFile, Payload: &domain.Payload{
+						Media: &domain.Media{ContentID: "https://api.twilio.com/m/ME2", ContentType: "text/vcard"},
+					}},
+				},
+				Response: twiml,
+			},
+		},
+		{
+			name:     "status callback is ignored",
+			form:     url.Values{"MessageSid": {"SM3"}, "MessageStatus": {"delivered"}, "To": {"+15551230001"}},
+			expected: domain.Webhook{Response: twiml},
+		},
+		{
+			name:        "invalid signature",
+			form:        url.Values{"MessageSid": {"SM4"}, "From": {"+15551230001"}, "Body": {"hello"}},
+			signature:   "bm90IGEgc2lnbmF0dXJl",
+			expectedErr: domain.ErrInvalidSignature,
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, "/webhook/sms", strings.NewReader(c.form.Encode()))
+			req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			signature := c.signature
+			if signature == "" {
+				signature = sign("authtoken", "https://sms.example.com/webhook/sms", c.form)
+			}
+			req.Header.Set(signatureHeader, signature)
+			got, err := p.ParseRequest(req)
+			if !errors.Is(err, c.expectedErr) {
+				t.Fatalf("error inconsistent, caught error:%v, expected error:%v", err, c.expectedErr)
+			}
+			if !reflect.DeepEqual(got, c.expected) {
+				t.Errorf("data inconsistent, data:%+v, expected data:%+v", got, c.expected)
+			}
+		})
+	}
+}
+
+func TestSMSProvider_ParseRequestBehindProxy(t *testing.T) {
+	p, _, _ := newProvider(t)
+	form := url.Values{"MessageSid": {"SM1"}, "From": {"+15551230001"}, "Body": {"hello"}}
+
+	req := httptest.NewRequest(http.MethodPost, "http://sms.example.com/webhook/sms?channel=1", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("X-Forwarded-Proto", "https")
+	req.Header.Set(signatureHeader, sign("authtoken", "https://sms.example.com/webhook/sms?channel=1", form))
+	got, err := p.ParseRequest(req)
+	if err != nil {
+		t.Fatalf("unexpected error:%v", err)
+	}
+	if len(got.Messages) != 1 || got.Messages[0].Message != "hello" {
+		t.Errorf("messages inconsistent, messages:%+v", got.Messages)
+	}
+}
+
+func TestSMSProvider_Send(t *testing.T) {
+	p, sent, _ := newProvider(t)
+
+	if err := p.PushMessage(context.Background(), "+15551230001", "hello"); err != nil {
+		t.Fatalf("unexpected error:%v", err)
+	}
+	err := p.Multicast(context.Background(), []string{"+15551230002", "+15550000000", "+15551230003"}, "hi")
+	if err == nil || !strings.Contains(err.Error(), "not a valid phone number") {
+		t.Errorf("error inconsistent, caught error:%v, expected the error of the invalid number", err)
+	}
+	if err = p.SendMessage(context.Background(), "broadcast"); !errors.Is(err, domain.ErrBadParamInput) {
+		t.Errorf("error inconsistent, caught error:%v, expected error:%v", err, domain.ErrBadParamInput)
+	}
+	if p.CanBroadcast() {
+		t.Errorf("broadcast should be unsupported")
+	}
+
+	var messages []string
+	for _, form := range sent.Items() {
+		messages = append(messages, form.Get("From")+">"+form.Get("To")+":"+form.Get("Body"))
+	}
+	expected := []string{
+		"+15559870000>+15551230001:hello",
+		"+15559870000>+15551230002:hi",
+		"+15559870000>+15551230003:hi",
+	}
+	if !reflect.DeepEqual(messages, expected) {
+		t.Errorf("messages sent inconsistent, sent:%v, expected sent:%v", messages, expected)
+	}
+}
+
+func TestSMSProvider_GetContent(t *testing.T) {
+	p, _, baseURL := newProvider(t)
+
+	content, contentType, err := p.GetContent(context.Background(), baseURL+"/2010-04-01/Accounts/AC1/Messages/SM1/Media/ME1")
+	if err != nil {
+		t.Fatalf("unexpected error:%v", err)
+	}
+	defer content.Close()
+	b, _ := io.ReadAll(content)
+	if string(b) != "image" || contentType != "image/jpeg" {
+		t.Errorf("content inconsistent, content:%s, content type:%s", b, contentType)
+	}
+
+	if _, _, err = p.GetContent(context.Background(), baseURL+"/2010-04-01/Accounts/AC1/Messages/SM1/Media/ME2"); err == nil {
+		t.Errorf("getting an unknown media should fail")
+	}
+	if _, _, err = p.GetContent(context.Background(), "ME1"); !errors.Is(err, domain.ErrBadParamInput) {
+		t.Errorf("error inconsistent, caught error:%v, expected error:%v", err, domain.ErrBadParamInput)
+	}
+}
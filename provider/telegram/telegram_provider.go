@@ -0,0 +1,411 @@
+package telegram
+
+import (
+	"bytes"
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/kunmingliu/messenger/domain"
+	"github.com/kunmingliu/messenger/provider"
+)
+
+const (
+	// DefaultBaseURL is the endpoint of the Bot API.
+	DefaultBaseURL = "https://api.telegram.org"
+	// secretTokenHeader carries the secret token given to setWebhook.
+	secretTokenHeader = "X-Telegram-Bot-Api-Secret-Token"
+)
+
+// TelegramProvider talks to a bot of Telegram with the Bot API. The user id of messages is the id of the chat, so
+// that the messages sent to it reach the same private chat or group.
+type TelegramProvider struct {
+	token       string
+	secretToken string
+	baseURL     string
+	client      *http.Client
+}
+
+type Option func(*TelegramProvider)
+
+// WithBaseURL points the provider at another Bot API server, e.g. a local one or a fake for tests.
+func WithBaseURL(baseURL string) Option {
+	return func(t *TelegramProvider) {
+		t.baseURL = strings.TrimSuffix(baseURL, "/")
+	}
+}
+
+func WithHTTPClient(client *http.Client) Option {
+	return func(t *TelegramProvider) {
+		t.client = client
+	}
+}
+
+// NewTelegramProvider creates the provider of the bot, the webhook requests carry secretToken given to setWebhook.
+func NewTelegramProvider(token, secretToken string, options ...Option) (*TelegramProvider, error) {
+	if token == "" || secretToken == "" {
+		return nil, errors.New("token and secret token of telegram shouldn't be empty")
+	}
+	t := &TelegramProvider{
+		token:       token,
+		secretToken: secretToken,
+		baseURL:     DefaultBaseURL,
+		client:      provider.NewHTTPClient(),
+	}
+	for _, option := range options {
+		option(t)
+	}
+	return t, nil
+}
+
+type user struct {
+	ID int64 `json:"id"`
+}
+
+type chat struct {
+	ID   int64  `json:"id"`
+	Type string `json:"type"`
+}
+
+type photoSize struct {
+	FileID   string `json:"file_id"`
+	FileSize int    `json:"file_size"`
+}
+
+type media struct {
+	FileID   string `json:"file_id"`
+	FileName string `json:"file_name"`
+	FileSize int    `json:"file_size"`
+	MimeType string `json:"mime_type"`
+	// Duration is in seconds
+	Duration int `json:"duration"`
+}
+
+type sticker struct {
+	FileID  string `json:"file_id"`
+	SetName string `json:"set_name"`
+}
+
+type location struct {
+	Latitude  float64 `json:"latitude"`
+	Longitude float64 `json:"longitude"`
+}
+
+type venue struct {
+	Location location `json:"location"`
+	Title    string   `json:"title"`
+	Address  string   `json:"address"`
+}
+
+type message struct {
+	MessageID      int64       `json:"message_id"`
+	From           *user       `json:"from"`
+	Chat           chat        `json:"chat"`
+	Date           int64       `json:"date"`
+	Text           string      `json:"text"`
+	Caption        string      `json:"caption"`
+	Photo          []photoSize `json:"photo"`
+	Video          *media      `json:"video"`
+	Audio          *media      `json:"audio"`
+	Voice          *media      `json:"voice"`
+	Document       *media      `json:"document"`
+	Sticker        *sticker    `json:"sticker"`
+	Location       *location   `json:"location"`
+	Venue          *venue      `json:"venue"`
+	NewChatMembers []user      `json:"new_chat_members"`
+	LeftChatMember *user       `json:"left_chat_member"`
+}
+
+type callbackQuery struct {
+	ID      string   `json:"id"`
+	From    user     `json:"from"`
+	Message *message `json:"message"`
+	Data    string   `json:"data"`
+}
+
+type chatMember struct {
+	Status string `json:"status"`
+}
+
+type chatMemberUpdated struct {
+	Chat          chat       `json:"chat"`
+	From          user       `json:"from"`
+	Date          int64      `json:"date"`
+	NewChatMember chatMember `json:"new_chat_member"`
+}
+
+type update struct {
+	UpdateID      int64              `json:"update_id"`
+	Message       *message           `json:"message"`
+	CallbackQuery *callbackQuery     `json:"callback_query"`
+	MyChatMember  *chatMemberUpdated `json:"my_chat_member"`
+}
+
+func formatID(id int64) string {
+	return strconv.FormatInt(id, 10)
+}
+
+func (t *TelegramProvider) ParseRequest(r *http.Request) (webhook domain.Webhook, err error) {
+	if subtle.ConstantTimeCompare([]byte(r.Header.Get(secretTokenHeader)), []byte(t.secretToken)) != 1 {
+		err = domain.ErrInvalidSignature
+		return
+	}
+
+	var u update
+	if err = json.NewDecoder(r.Body).Decode(&u); err != nil {
+		err = fmt.Errorf("%w: %v", domain.ErrBadParamInput, err)
+		return
+	}
+	eventID := formatID(u.UpdateID)
+
+	switch {
+	case u.Message != nil:
+		if event, ok := convertMemberEvent(u.Message); ok {
+			event.EventID = eventID
+			webhook.Events = append(webhook.Events, event)
+			return
+		}
+		msg, ok := convertMessage(u.Message)
+		if !ok {
+			return
+		}
+		msg.EventID = eventID
+		msg.UserID = formatID(u.Message.Chat.ID)
+		webhook.Messages = append(webhook.Messages, msg)
+	case u.CallbackQuery != nil:
+		// the chat of the callback is the one the button belongs to, it's the user if the message is too old to
+		// be given
+		chatID := u.CallbackQuery.From.ID
+		if u.CallbackQuery.Message != nil {
+			chatID = u.CallbackQuery.Message.Chat.ID
+		}
+		webhook.Messages = append(webhook.Messages, domain.Message{
+			EventID: eventID,
+			UserID:  formatID(chatID),
+			Type:    domain.MessageTypePostback,
+			Payload: &domain.Payload{Postback: &domain.Postback{Data: u.CallbackQuery.Data}},
+		})
+	case u.MyChatMember != nil:
+		if event, ok := convertChatMemberUpdated(u.MyChatMember); ok {
+			event.EventID = eventID
+			webhook.Events = append(webhook.Events, event)
+		}
+	}
+	return
+}
+
+// convertMessage maps a message of Telegram into domain.Message, the unsupported types would be ignored.
+func convertMessage(m *message) (msg domain.Message, ok bool) {
+	ok = true
+	switch {
+	case m.Text != "":
+		msg.Type = domain.MessageTypeText
+		msg.Message = m.Text
+	case len(m.Photo) > 0:
+		// the sizes of a photo are sorted in ascending order
+		photo := m.Photo[len(m.Photo)-1]
+		msg.Type = domain.MessageTypeImage
+		msg.Payload = &domain.Payload{Media: &domain.Media{ContentID: photo.FileID}}
+	case m.Video != nil:
+		msg.Type = domain.MessageTypeVideo
+		msg.Payload = &domain.Payload{
+			Media: &domain.Media{ContentID: m.Video.FileID, Duration: m.Video.Duration * 1000},
+		}
+	case m.Audio != nil || m.Voice != nil:
+		audio := m.Audio
+		if audio == nil {
+			audio = m.Voice
+		}
+		msg.Type = domain.MessageTypeAudio
+		msg.Payload = &domain.Payload{
+			Media: &domain.Media{ContentID: audio.FileID, Duration: audio.Duration * 1000},
+		}
+	case m.Document != nil:
+		msg.Type = domain.MessageTypeFile
+		msg.Payload = &domain.Payload{
+			Media: &domain.Media{ContentID: m.Document.FileID},
+			File:  &domain.File{Name: m.Document.FileName, Size: m.Document.FileSize},
+		}
+	case m.Sticker != nil:
+		msg.Type = domain.MessageTypeSticker
+		msg.Payload = &domain.Payload{
+			Sticker: &domain.Sticker{PackageID: m.Sticker.SetName, StickerID: m.Sticker.FileID},
+		}
+	case m.Venue != nil:
+		msg.Type = domain.MessageTypeLocation
+		msg.Payload = &domain.Payload{Location: &domain.Location{
+			Title:     m.Venue.Title,
+			Address:   m.Venue.Address,
+			Latitude:  m.Venue.Location.Latitude,
+			Longitude: m.Venue.Location.Longitude,
+		}}
+	case m.Location != nil:
+		msg.Type = domain.MessageTypeLocation
+		msg.Payload = &domain.Payload{Location: &domain.Location{
+			Latitude:  m.Location.Latitude,
+			Longitude: m.Location.Longitude,
+		}}
+	default:
+		ok = false
+	}
+	// the caption of media is kept as the text so that it can be searched
+	if ok && msg.Message == "" {
+		msg.Message = m.Caption
+	}
+	return
+}
+
+// convertMemberEvent maps the service message of users joining or leaving a group into domain.Event.
+func convertMemberEvent(m *message) (e domain.Event, ok bool) {
+	e = domain.Event{
+		GroupID:   formatID(m.Chat.ID),
+		Timestamp: time.Unix(m.Date, 0).UTC(),
+	}
+	if m.From != nil {
+		e.UserID = formatID(m.From.ID)
+	}
+	switch {
+	case len(m.NewChatMembers) > 0:
+		e.Type = domain.EventTypeMemberJoined
+		for _, member := range m.NewChatMembers {
+			e.Members = append(e.Members, formatID(member.ID))
+		}
+	case m.LeftChatMember != nil:
+		e.Type = domain.EventTypeMemberLeft
+		e.Members = []string{formatID(m.LeftChatMember.ID)}
+	default:
+		return e, false
+	}
+	return e, true
+}
+
+// convertChatMemberUpdated maps the change of the bot's membership into domain.Event, it's a follow or unfollow in
+// private chats and a join or leave in groups.
+func convertChatMemberUpdated(c *chatMemberUpdated) (e domain.Event, ok bool) {
+	e = domain.Event{
+		UserID:    formatID(c.From.ID),
+		Timestamp: time.Unix(c.Date, 0).UTC(),
+	}
+	joined := false
+	switch c.NewChatMember.Status {
+	case "member", "administrator", "creator":
+		joined = true
+	case "left", "kicked":
+	default:
+		return e, false
+	}
+
+	if c.Chat.Type == "private" {
+		e.Type = domain.EventTypeUnfollow
+		if joined {
+			e.Type = domain.EventTypeFollow
+		}
+		return e, true
+	}
+	e.GroupID = formatID(c.Chat.ID)
+	e.Type = domain.EventTypeLeave
+	if joined {
+		e.Type = domain.EventTypeJoin
+	}
+	return e, true
+}
+
+// response is the envelope of every result of the Bot API.
+type response struct {
+	OK          bool            `json:"ok"`
+	Result      json.RawMessage `json:"result"`
+	ErrorCode   int             `json:"error_code"`
+	Description string          `json:"description"`
+}
+
+// call invokes the method of the Bot API with the params in JSON and decodes the result into result if it's not nil.
+func (t *TelegramProvider) call(ctx context.Context, method string, params interface{}, result interface{}) error {
+	body, err := json.Marshal(params)
+	if err != nil {
+		return err
+	}
+	// the url has the token, so it's left out of the errors
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, t.baseURL+"/bot"+t.token+"/"+method, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("telegram %s failed, err:%w", method, provider.RedactURL(err))
+	}
+	req.Header.Set("Content-Type", "application/json")
+	res, err := t.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("telegram %s failed, err:%w", method, provider.RedactURL(err))
+	}
+	defer res.Body.Close()
+
+	var resp response
+	if err = json.NewDecoder(res.Body).Decode(&resp); err != nil {
+		return fmt.Errorf("telegram %s failed, status:%d, err:%w", method, res.StatusCode, err)
+	}
+	if !resp.OK {
+		return fmt.Errorf("telegram %s failed, code:%d, description:%s", method, resp.ErrorCode, resp.Description)
+	}
+	if result == nil {
+		return nil
+	}
+	return json.Unmarshal(resp.Result, result)
+}
+
+// SendMessage fails since a bot can only message the chats it's in.
+func (t *TelegramProvider) SendMessage(ctx context.Context, msg string) error {
+	return provider.ErrBroadcastNotSupported
+}
+
+// CanBroadcast is false since the chats of a bot are only known from its updates.
+func (t *TelegramProvider) CanBroadcast() bool {
+	return false
+}
+
+// ReplyMessage isn't used since there is no reply token in Telegram, the usecase pushes the reply to the chat instead.
+func (t *TelegramProvider) ReplyMessage(ctx context.Context, replyToken, msg string) error {
+	return fmt.Errorf("%w: telegram has no reply token", domain.ErrBadParamInput)
+}
+
+func (t *TelegramProvider) PushMessage(ctx context.Context, userID, msg string) error {
+	return t.call(ctx, "sendMessage", map[string]string{"chat_id": userID, "text": msg}, nil)
+}
+
+// Multicast sends the message to every chat one by one since the Bot API has no multicast.
+func (t *TelegramProvider) Multicast(ctx context.Context, userIDs []string, msg string) error {
+	return provider.MulticastEach(userIDs, func(userID string) error {
+		return t.PushMessage(ctx, userID, msg)
+	})
+}
+
+func (t *TelegramProvider) GetContent(ctx context.Context, contentID string) (content io.ReadCloser, contentType string, err error) {
+	var file struct {
+		FilePath string `json:"file_path"`
+	}
+	if err = t.call(ctx, "getFile", map[string]string{"file_id": contentID}, &file); err != nil {
+		return
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, t.baseURL+"/file/bot"+t.token+"/"+file.FilePath, nil)
+	if err != nil {
+		err = fmt.Errorf("telegram download file failed, err:%w", provider.RedactURL(err))
+		return
+	}
+	res, err := t.client.Do(req)
+	if err != nil {
+		err = fmt.Errorf("telegram download file failed, err:%w", provider.RedactURL(err))
+		return
+	}
+	if res.StatusCode != http.StatusOK {
+		res.Body.Close()
+		err = fmt.Errorf("telegram download file failed, status:%d", res.StatusCode)
+		return
+	}
+	content = res.Body
+	contentType = res.Header.Get("Content-Type")
+	return
+}
@@ -0,0 +1,232 @@
+package telegram
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/kunmingliu/messenger/domain"
+	"github.com/kunmingliu/messenger/internal/providertest"
+)
+
+// newProvider creates the provider on a fake of the Bot API, which records the messages sent and serves a single file.
+func newProvider(t *testing.T) (*TelegramProvider, *providertest.Recorder[map[string]string]) {
+	server := providertest.NewServer(t)
+	sent := &providertest.Recorder[map[string]string]{}
+	server.Handle("/bottoken/sendMessage", func(w http.ResponseWriter, r *http.Request) {
+		var params map[string]string
+		json.NewDecoder(r.Body).Decode(&params)
+		if params["chat_id"] == "403" {
+			providertest.WriteJSON(w, http.StatusForbidden, `{"ok":false,"error_code":403,"description":"Forbidden: bot was blocked by the user"}`)
+			return
+		}
+		sent.Add(params)
+		providertest.WriteJSON(w, http.StatusOK, `{"ok":true,"result":{"message_id":1}}`)
+	})
+	server.Handle("/bottoken/getFile", func(w http.ResponseWriter, r *http.Request) {
+		var params map[string]string
+		json.NewDecoder(r.Body).Decode(&params)
+		if params["file_id"] != "F1" {
+			providertest.WriteJSON(w, http.StatusBadRequest, `{"ok":false,"error_code":400,"description":"Bad Request: invalid file_id"}`)
+			return
+		}
+		providertest.WriteJSON(w, http.StatusOK, `{"ok":true,"result":{"file_id":"F1","file_path":"photos/file_1.jpg"}}`)
+	})
+	server.Handle("/file/bottoken/photos/file_1.jpg", providertest.Content("image/jpeg", "image"))
+
+	p, err := NewTelegramProvider("token", "secret", WithBaseURL(server.URL))
+	if err != nil {
+		t.Fatalf("unexpected error:%v", err)
+	}
+	return p, sent
+}
+
+func TestTelegramProvider_ParseRequest(t *testing.T) {
+	p, _ := newProvider(t)
+	date := time.Date(2022, 11, 1, 0, 0, 0, 0, time.UTC)
+
+	cases := []struct {
+		name        string
+		secretToken string
+		body        string
+		expected    domain.Webhook
+		expectedErr error
+	}{
+		{
+			name:        "text",
+			secretToken: "secret",
+			body:        `{"update_id":1,"message":{"message_id":1,"from":{"id":10},"chat":{"id":10,"type":"private"},"date":1667260800,"text":"hello"}}`,
+			expected: domain.Webhook{Messages: []domain.Message{
+				{EventID: "1", UserID: "10", Type: domain.MessageTypeText, Message: "hello"},
+			}},
+		},
+		{
+			name:        "the largest photo with caption",
+			secretToken: "secret",
+			body: `{"update_id":2,"message":{"message_id":2,"chat":{"id":-20,"type":"group"},"date":1667260800,` +
+				`"photo":[{"file_id":"small"},{"file_id":"large"}],"caption":"look"}}`,
+			expected: domain.Webhook{Messages: []domain.Message{{
+				EventID: "2", UserID: "-20", Type: domain.MessageTypeImage, Message: "look",
+				Payload: &domain.Payload{Media: &domain.Media{ContentID: "large"}},
+			}}},
+		},
+		{
+			name:        "voice",
+			secretToken: "secret",
+			body:        `{"update_id":3,"message":{"message_id":3,"chat":{"id":10},"voice":{"file_id":"V1","duration":2}}}`,
+			expected: domain.Webhook{Messages: []domain.Message{{
+				EventID: "3", UserID: "10", Type: domain.MessageTypeAudio,
+				Payload: &domain.Payload{Media: &domain.Media{ContentID: "V1", Duration: 2000}},
+			}}},
+		},
+		{
+			name:        "document",
+			secretToken: "secret",
+			body:        `{"update_id":4,"message":{"message_id":4,"chat":{"id":10},"document":{"file_id":"D1","file_name":"a.txt","file_size":3}}}`,
+			expected: domain.Webhook{Messages: []domain.Message{{
+				EventID: "4", UserID: "10", Type: domain.MessageTypeFile,
+				Payload: &domain.Payload{
+					Media: &domain.Media{ContentID: "D1"},
+					File:  &domain.File{Name: "a.txt", Size: 3},
+				},
+			}}},
+		},
+		{
+			name:        "callback query",
+			secretToken: "secret",
+			body:        `{"update_id":5,"callback_query":{"id":"q1","from":{"id":10},"message":{"message_id":1,"chat":{"id":-20}},"data":"action=buy"}}`,
+			expected: domain.Webhook{Messages: []domain.Message{{
+				EventID: "5", UserID: "-20", Type: domain.MessageTypePostback,
+				Payload: &domain.Payload{Postback: &domain.Postback{Data: "action=buy"}},
+			}}},
+		},
+		{
+			name:        "members joined",
+			secretToken: "secret",
+			body:        `{"update_id":6,"message":{"message_id":6,"from":{"id":10},"chat":{"id":-20},"date":1667260800,"new_chat_members":[{"id":11},{"id":12}]}}`,
+			expected: domain.Webhook{Events: []domain.Event{{
+				EventID: "6", Type: domain.EventTypeMemberJoined, UserID: "10", GroupID: "-20",
+				Members: []string{"11", "12"}, Timestamp: date,
+			}}},
+		},
+		{
+			name:        "bot blocked in private chat",
+			secretToken: "secret",
+			body:        `{"update_id":7,"my_chat_member":{"chat":{"id":10,"type":"private"},"from":{"id":10},"date":1667260800,"new_chat_member":{"status":"kicked"}}}`,
+			expected: domain.Webhook{Events: []domain.Event{{
+				EventID: "7", Type: domain.EventTypeUnfollow, UserID: "10", Timestamp: date,
+			}}},
+		},
+		{
+			name:        "bot added to group",
+			secretToken: "secret",
+			body:        `{"update_id":8,"my_chat_member":{"chat":{"id":-20,"type":"supergroup"},"from":{"id":10},"date":1667260800,"new_chat_member":{"status":"member"}}}`,
+			expected: domain.Webhook{Events: []domain.Event{{
+				EventID: "8", Type: domain.EventTypeJoin, UserID: "10", GroupID: "-20", Timestamp: date,
+			}}},
+		},
+		{
+			name:        "unsupported message is ignored",
+			secretToken: "secret",
+			body:        `{"update_id":9,"message":{"message_id":9,"chat":{"id":10},"poll":{"id":"p1"}}}`,
+		},
+		{
+			name:        "invalid secret token",
+			secretToken: "wrong",
+			body:        `{"update_id":10}`,
+			expectedErr: domain.ErrInvalidSignature,
+		},
+		{
+			name:        "missing secret token",
+			body:        `{"update_id":11}`,
+			expectedErr: domain.ErrInvalidSignature,
+		},
+		{
+			name:        "invalid body",
+			secretToken: "secret",
+			body:        `{"update_id":`,
+			expectedErr: domain.ErrBadParamInput,
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, "/webhook/telegram", strings.NewReader(c.body))
+			req.Header.Set(secretTokenHeader, c.secretToken)
+			got, err := p.ParseRequest(req)
+			if !errors.Is(err, c.expectedErr) {
+				t.Fatalf("error inconsistent, caught error:%v, expected error:%v", err, c.expectedErr)
+			}
+			if !reflect.DeepEqual(got, c.expected) {
+				t.Errorf("data inconsistent, data:%+v, expected data:%+v", got, c.expected)
+			}
+		})
+	}
+}
+
+func TestTelegramProvider_Send(t *testing.T) {
+	p, sent := newProvider(t)
+
+	if err := p.PushMessage(context.Background(), "10", "hello"); err != nil {
+		t.Fatalf("unexpected error:%v", err)
+	}
+	err := p.Multicast(context.Background(), []string{"11", "403", "12"}, "hi")
+	if err == nil || !strings.Contains(err.Error(), "bot was blocked by the user") {
+		t.Errorf("error inconsistent, caught error:%v, expected the error of the blocked chat", err)
+	}
+	if err = p.SendMessage(context.Background(), "broadcast"); !errors.Is(err, domain.ErrBadParamInput) {
+		t.Errorf("error inconsistent, caught error:%v, expected error:%v", err, domain.ErrBadParamInput)
+	}
+	if p.CanBroadcast() {
+		t.Errorf("broadcast should be unsupported")
+	}
+
+	expected := []map[string]string{
+		{"chat_id": "10", "text": "hello"},
+		{"chat_id": "11", "text": "hi"},
+		{"chat_id": "12", "text": "hi"},
+	}
+	if got := sent.Items(); !reflect.DeepEqual(got, expected) {
+		t.Errorf("messages sent inconsistent, sent:%v, expected sent:%v", got, expected)
+	}
+}
+
+func TestTelegramProvider_SendRedactsToken(t *testing.T) {
+	p, err := NewTelegramProvider("secret-bot-token", "secret", WithBaseURL(providertest.ClosedURL()))
+	if err != nil {
+		t.Fatalf("unexpected error:%v", err)
+	}
+
+	err = p.PushMessage(context.Background(), "10", "hello")
+	if err == nil || strings.Contains(err.Error(), "secret-bot-token") {
+		t.Errorf("error inconsistent, caught error:%v, expected an error without the token", err)
+	}
+	_, _, err = p.GetContent(context.Background(), "F1")
+	if err == nil || strings.Contains(err.Error(), "secret-bot-token") {
+		t.Errorf("error inconsistent, caught error:%v, expected an error without the token", err)
+	}
+}
+
+func TestTelegramProvider_GetContent(t *testing.T) {
+	p, _ := newProvider(t)
+
+	content, contentType, err := p.GetContent(context.Background(), "F1")
+	if err != nil {
+		t.Fatalf("unexpected error:%v", err)
+	}
+	defer content.Close()
+	b, _ := io.ReadAll(content)
+	if string(b) != "image" || contentType != "image/jpeg" {
+		t.Errorf("content inconsistent, content:%s, content type:%s", b, contentType)
+	}
+
+	if _, _, err = p.GetContent(context.Background(), "F2"); err == nil {
+		t.Errorf("getting an unknown file should fail")
+	}
+}
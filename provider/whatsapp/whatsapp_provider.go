@@ -0,0 +1,475 @@
+package whatsapp
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/kunmingliu/messenger/domain"
+	"github.com/kunmingliu/messenger/provider"
+)
+
+const (
+	// DefaultBaseURL is the endpoint of the Graph API serving the Cloud API.
+	DefaultBaseURL = "https://graph.facebook.com/v15.0"
+	// signatureHeader carries the HMAC-SHA256 of the body signed with the app secret.
+	signatureHeader = "X-Hub-Signature-256"
+)
+
+// WhatsAppProvider talks to a phone number of a WhatsApp Business Account with the Cloud API. The user id of messages
+// is the WhatsApp id of the sender, i.e. the phone number without the plus sign.
+//
+// Free-form messages only reach the users who messaged the number within 24 hours, templates have to be sent to the
+// others. The delivery of the messages pushed is reported by the status callbacks of the webhook.
+type WhatsAppProvider struct {
+	phoneNumberID string
+	appSecret     string
+	accessToken   string
+	verifyToken   string
+	baseURL       string
+	client        *http.Client
+}
+
+type Option func(*WhatsAppProvider)
+
+// WithBaseURL points the provider at another Graph API server, e.g. a fake for tests.
+func WithBaseURL(baseURL string) Option {
+	return func(w *WhatsAppProvider) {
+		w.baseURL = strings.TrimSuffix(baseURL, "/")
+	}
+}
+
+func WithHTTPClient(client *http.Client) Option {
+	return func(w *WhatsAppProvider) {
+		w.client = client
+	}
+}
+
+// NewWhatsAppProvider creates the provider of the phone number with the secret of the app, the access token of the
+// business account and the verify token given to the webhook subscription.
+func NewWhatsAppProvider(phoneNumberID, appSecret, accessToken, verifyToken string, options ...Option) (*WhatsAppProvider, error) {
+	if phoneNumberID == "" || appSecret == "" || accessToken == "" {
+		return nil, errors.New("phone number id, app secret and access token of whatsapp shouldn't be empty")
+	}
+	w := &WhatsAppProvider{
+		phoneNumberID: phoneNumberID,
+		appSecret:     appSecret,
+		accessToken:   accessToken,
+		verifyToken:   verifyToken,
+		baseURL:       DefaultBaseURL,
+		client:        provider.NewHTTPClient(),
+	}
+	for _, option := range options {
+		option(w)
+	}
+	return w, nil
+}
+
+// verifySubscription answers the GET request sent when the webhook is subscribed, it echoes hub.challenge if
+// hub.verify_token is the one configured.
+func (w *WhatsAppProvider) verifySubscription(r *http.Request) (webhook domain.Webhook, err error) {
+	query := r.URL.Query()
+	if w.verifyToken == "" || query.Get("hub.mode") != "subscribe" ||
+		subtle.ConstantTimeCompare([]byte(query.Get("hub.verify_token")), []byte(w.verifyToken)) != 1 {
+		return webhook, domain.ErrInvalidSignature
+	}
+	webhook.Response = &domain.WebhookResponse{
+		StatusCode:  http.StatusOK,
+		ContentType: "text/plain",
+		Body:        []byte(query.Get("hub.challenge")),
+	}
+	return webhook, nil
+}
+
+func (w *WhatsAppProvider) verify(r *http.Request, body []byte) error {
+	mac := hmac.New(sha256.New, []byte(w.appSecret))
+	mac.Write(body)
+	expected := "sha256=" + hex.EncodeToString(mac.Sum(nil))
+	if !hmac.Equal([]byte(r.Header.Get(signatureHeader)), []byte(expected)) {
+		return domain.ErrInvalidSignature
+	}
+	return nil
+}
+
+type media struct {
+	ID       string `json:"id"`
+	MimeType string `json:"mime_type"`
+	Caption  string `json:"caption"`
+	Filename string `json:"filename"`
+}
+
+type reply struct {
+	ID    string `json:"id"`
+	Title string `json:"title"`
+}
+
+type message struct {
+	From string `json:"from"`
+	ID   string `json:"id"`
+	Type string `json:"type"`
+	Text *struct {
+		Body string `json:"body"`
+	} `json:"text"`
+	Image    *media `json:"image"`
+	Video    *media `json:"video"`
+	Audio    *media `json:"audio"`
+	Document *media `json:"document"`
+	Sticker  *media `json:"sticker"`
+	Location *struct {
+		Latitude  float64 `json:"latitude"`
+		Longitude float64 `json:"longitude"`
+		Name      string  `json:"name"`
+		Address   string  `json:"address"`
+	} `json:"location"`
+	Interactive *struct {
+		ButtonReply *reply `json:"button_reply"`
+		ListReply   *reply `json:"list_reply"`
+	} `json:"interactive"`
+	// Button is the quick reply button of a template.
+	Button *struct {
+		Payload string `json:"payload"`
+		Text    string `json:"text"`
+	} `json:"button"`
+}
+
+type status struct {
+	ID     string `json:"id"`
+	Status string `json:"status"`
+	// BizOpaqueCallbackData is given when the message is sent, it's the id of the outbound message.
+	BizOpaqueCallbackData string `json:"biz_opaque_callback_data"`
+	Errors                []struct {
+		Code  int    `json:"code"`
+		Title string `json:"title"`
+	} `json:"errors"`
+}
+
+type callback struct {
+	Object string `json:"object"`
+	Entry  []struct {
+		ID      string `json:"id"`
+		Changes []struct {
+			Field string `json:"field"`
+			Value struct {
+				Metadata struct {
+					PhoneNumberID string `json:"phone_number_id"`
+				} `json:"metadata"`
+				Messages []message `json:"messages"`
+				Statuses []status  `json:"statuses"`
+			} `json:"value"`
+		} `json:"changes"`
+	} `json:"entry"`
+}
+
+// ParseRequest converts the messages and the status callbacks of the phone number, the ones of other phone numbers of
+// the business account are ignored since they share the webhook of the app.
+func (w *WhatsAppProvider) ParseRequest(r *http.Request) (webhook domain.Webhook, err error) {
+	if r.Method == http.MethodGet {
+		return w.verifySubscription(r)
+	}
+
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		return
+	}
+	if err = w.verify(r, body); err != nil {
+		return
+	}
+
+	var c callback
+	if err = json.Unmarshal(body, &c); err != nil {
+		return
+	}
+	for _, entry := range c.Entry {
+		for _, change := range entry.Changes {
+			if change.Field != "messages" || change.Value.Metadata.PhoneNumberID != w.phoneNumberID {
+				continue
+			}
+			for _, m := range change.Value.Messages {
+				if msg, ok := convertMessage(m); ok {
+					webhook.Messages = append(webhook.Messages, msg)
+				}
+			}
+			for _, s := range change.Value.Statuses {
+				if update, ok := convertStatus(s); ok {
+					webhook.Statuses = append(webhook.Statuses, update)
+				}
+			}
+		}
+	}
+	return
+}
+
+// convertMessage maps a message into domain.Message, the types without a counterpart, e.g. reactions and contacts, are
+// ignored. The media id is the content id and the caption is the message.
+func convertMessage(m message) (msg domain.Message, ok bool) {
+	msg.EventID = m.ID
+	msg.UserID = m.From
+	switch {
+	case m.Type == "text" && m.Text != nil:
+		msg.Type = domain.MessageTypeText
+		msg.Message = m.Text.Body
+	case m.Type == "image" && m.Image != nil:
+		msg.Type = domain.MessageTypeImage
+		msg.Message = m.Image.Caption
+		msg.Payload = &domain.Payload{Media: m.Image.media()}
+	case m.Type == "video" && m.Video != nil:
+		msg.Type = domain.MessageTypeVideo
+		msg.Message = m.Video.Caption
+		msg.Payload = &domain.Payload{Media: m.Video.media()}
+	case m.Type == "audio" && m.Audio != nil:
+		msg.Type = domain.MessageTypeAudio
+		msg.Payload = &domain.Payload{Media: m.Audio.media()}
+	case m.Type == "document" && m.Document != nil:
+		msg.Type = domain.MessageTypeFile
+		msg.Message = m.Document.Caption
+		msg.Payload = &domain.Payload{Media: m.Document.media(), File: &domain.File{Name: m.Document.Filename}}
+	case m.Type == "sticker" && m.Sticker != nil:
+		msg.Type = domain.MessageTypeSticker
+		msg.Payload = &domain.Payload{Sticker: &domain.Sticker{StickerID: m.Sticker.ID}, Media: m.Sticker.media()}
+	case m.Type == "location" && m.Location != nil:
+		msg.Type = domain.MessageTypeLocation
+		msg.Payload = &domain.Payload{Location: &domain.Location{
+			Title:     m.Location.Name,
+			Address:   m.Location.Address,
+			Latitude:  m.Location.Latitude,
+			Longitude: m.Location.Longitude,
+		}}
+	case m.Type == "interactive" && m.Interactive != nil:
+		r := m.Interactive.ButtonReply
+		if r == nil {
+			r = m.Interactive.ListReply
+		}
+		if r == nil {
+			return msg, false
+		}
+		msg.Type = domain.MessageTypePostback
+		msg.Message = r.Title
+		msg.Payload = &domain.Payload{Postback: &domain.Postback{Data: r.ID}}
+	case m.Type == "button" && m.Button != nil:
+		msg.Type = domain.MessageTypePostback
+		msg.Message = m.Button.Text
+		msg.Payload = &domain.Payload{Postback: &domain.Postback{Data: m.Button.Payload}}
+	default:
+		return msg, false
+	}
+	return msg, true
+}
+
+func (m *media) media() *domain.Media {
+	return &domain.Media{ContentID: m.ID, ContentType: m.MimeType}
+}
+
+var statuses = map[string]domain.DeliveryStatus{
+	"sent":      domain.DeliveryStatusSent,
+	"delivered": domain.DeliveryStatusDelivered,
+	"read":      domain.DeliveryStatusRead,
+	"failed":    domain.DeliveryStatusFailed,
+}
+
+// convertStatus maps a status callback into domain.StatusUpdate, the ones of the messages sent by other tools of the
+// business account have no callback data and are ignored.
+func convertStatus(s status) (update domain.StatusUpdate, ok bool) {
+	update.Status, ok = statuses[s.Status]
+	if !ok || s.BizOpaqueCallbackData == "" {
+		return update, false
+	}
+	update.MessageID = s.BizOpaqueCallbackData
+	if len(s.Errors) > 0 {
+		update.Error = fmt.Sprintf("code:%d, err:%s", s.Errors[0].Code, s.Errors[0].Title)
+	}
+	return update, true
+}
+
+// SendMessage fails since the Cloud API only sends to a single user.
+func (w *WhatsAppProvider) SendMessage(ctx context.Context, msg string) error {
+	return provider.ErrBroadcastNotSupported
+}
+
+// CanBroadcast is false since the Cloud API needs a recipient for every message.
+func (w *WhatsAppProvider) CanBroadcast() bool {
+	return false
+}
+
+// CanSendTemplates is true since PushTracked sends the templates approved in the WhatsApp Manager.
+func (w *WhatsAppProvider) CanSendTemplates() bool {
+	return true
+}
+
+// ReplyMessage isn't used since there is no reply token in the Cloud API, the usecase pushes the reply to the user
+// instead.
+func (w *WhatsAppProvider) ReplyMessage(ctx context.Context, replyToken, msg string) error {
+	return fmt.Errorf("%w: whatsapp has no reply token", domain.ErrBadParamInput)
+}
+
+type text struct {
+	Body string `json:"body"`
+}
+
+type parameter struct {
+	Type string `json:"type"`
+	Text string `json:"text"`
+}
+
+type component struct {
+	Type       string      `json:"type"`
+	Parameters []parameter `json:"parameters"`
+}
+
+type template struct {
+	Name     string `json:"name"`
+	Language struct {
+		Code string `json:"code"`
+	} `json:"language"`
+	Components []component `json:"components,omitempty"`
+}
+
+type sendRequest struct {
+	MessagingProduct string    `json:"messaging_product"`
+	To               string    `json:"to"`
+	Type             string    `json:"type"`
+	Text             *text     `json:"text,omitempty"`
+	Template         *template `json:"template,omitempty"`
+	// BizOpaqueCallbackData comes back in the status callbacks of the message.
+	BizOpaqueCallbackData string `json:"biz_opaque_callback_data,omitempty"`
+}
+
+// PushMessage sends a text message without tracking its delivery.
+func (w *WhatsAppProvider) PushMessage(ctx context.Context, userID, msg string) error {
+	return w.PushTracked(ctx, domain.Message{UserID: userID, Type: domain.MessageTypeText, Message: msg})
+}
+
+// PushTracked sends a text message or a template, the id of the message is the callback data of the status callbacks.
+func (w *WhatsAppProvider) PushTracked(ctx context.Context, msg domain.Message) error {
+	s := sendRequest{
+		MessagingProduct:      "whatsapp",
+		To:                    msg.UserID,
+		BizOpaqueCallbackData: msg.ID,
+	}
+	switch msg.Type {
+	case domain.MessageTypeText:
+		s.Type = "text"
+		s.Text = &text{Body: msg.Message}
+	case domain.MessageTypeTemplate:
+		if msg.Payload == nil || msg.Payload.Template == nil {
+			return fmt.Errorf("%w: the template message has no template", domain.ErrBadParamInput)
+		}
+		s.Type = "template"
+		s.Template = newTemplate(*msg.Payload.Template)
+	default:
+		return fmt.Errorf("%w: whatsapp can't send %s messages", domain.ErrBadParamInput, msg.Type)
+	}
+	return w.send(ctx, s)
+}
+
+// newTemplate fills the placeholders of the body with the parameters in order.
+func newTemplate(t domain.Template) *template {
+	result := &template{Name: t.Name}
+	result.Language.Code = t.Language
+	if len(t.Parameters) > 0 {
+		body := component{Type: "body"}
+		for _, p := range t.Parameters {
+			body.Parameters = append(body.Parameters, parameter{Type: "text", Text: p})
+		}
+		result.Components = []component{body}
+	}
+	return result
+}
+
+func (w *WhatsAppProvider) send(ctx context.Context, s sendRequest) error {
+	body, err := json.Marshal(s)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost,
+		w.baseURL+"/"+url.PathEscape(w.phoneNumberID)+"/messages", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+w.accessToken)
+	res, err := w.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return apiError("send", res)
+	}
+	return nil
+}
+
+// apiError reads the error of the Graph API from the response.
+func apiError(action string, res *http.Response) error {
+	var resp struct {
+		Error struct {
+			Message string `json:"message"`
+			Code    int    `json:"code"`
+		} `json:"error"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&resp); err != nil {
+		return fmt.Errorf("whatsapp %s failed, status:%d, err:%w", action, res.StatusCode, err)
+	}
+	return fmt.Errorf("whatsapp %s failed, code:%d, err:%s", action, resp.Error.Code, resp.Error.Message)
+}
+
+// Multicast sends the message to every user one by one since the Cloud API has no multicast.
+func (w *WhatsAppProvider) Multicast(ctx context.Context, userIDs []string, msg string) error {
+	return provider.MulticastEach(userIDs, func(userID string) error {
+		return w.PushMessage(ctx, userID, msg)
+	})
+}
+
+// GetContent looks up the url of the media by its id and downloads it, both need the access token.
+func (w *WhatsAppProvider) GetContent(ctx context.Context, contentID string) (content io.ReadCloser, contentType string, err error) {
+	if contentID == "" || strings.Contains(contentID, "/") {
+		err = fmt.Errorf("%w: the content id of whatsapp should be the id of the media", domain.ErrBadParamInput)
+		return
+	}
+	res, err := w.get(ctx, w.baseURL+"/"+url.PathEscape(contentID))
+	if err != nil {
+		return
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		err = apiError("get media", res)
+		return
+	}
+	var m struct {
+		URL      string `json:"url"`
+		MimeType string `json:"mime_type"`
+	}
+	if err = json.NewDecoder(res.Body).Decode(&m); err != nil {
+		return
+	}
+
+	res, err = w.get(ctx, m.URL)
+	if err != nil {
+		return
+	}
+	if res.StatusCode != http.StatusOK {
+		res.Body.Close()
+		err = fmt.Errorf("whatsapp download media failed, status:%d", res.StatusCode)
+		return
+	}
+	return res.Body, m.MimeType, nil
+}
+
+func (w *WhatsAppProvider) get(ctx context.Context, u string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", "Bearer "+w.accessToken)
+	return w.client.Do(req)
+}
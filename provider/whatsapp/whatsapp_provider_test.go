@@ -0,0 +1,243 @@
+package whatsapp
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/kunmingliu/messenger/domain"
+	"github.com/kunmingliu/messenger/internal/providertest"
+)
+
+// newProvider creates the provider on a fake of the Cloud API, which records the messages sent and serves a single
+// media.
+func newProvider(t *testing.T) (*WhatsAppProvider, *providertest.Recorder[sendRequest]) {
+	server := providertest.NewServer(t)
+	sent := &providertest.Recorder[sendRequest]{}
+	authorized := func(handler http.HandlerFunc) http.HandlerFunc {
+		return providertest.RequireHeader("Authorization", "Bearer token", http.StatusUnauthorized,
+			`{"error":{"message":"Invalid OAuth access token.","type":"OAuthException","code":190}}`, handler)
+	}
+	server.Handle("/PN1/messages", authorized(func(w http.ResponseWriter, r *http.Request) {
+		var s sendRequest
+		json.NewDecoder(r.Body).Decode(&s)
+		if s.To == "15550000000" {
+			providertest.WriteJSON(w, http.StatusBadRequest, `{"error":{"message":"Re-engagement message","type":"OAuthException","code":131047}}`)
+			return
+		}
+		sent.Add(s)
+		providertest.WriteJSON(w, http.StatusOK, `{"messaging_product":"whatsapp","contacts":[{"input":"`+s.To+`"}],"messages":[{"id":"wamid.2"}]}`)
+	}))
+	server.Handle("/MEDIA1", authorized(func(w http.ResponseWriter, r *http.Request) {
+		providertest.WriteJSON(w, http.StatusOK, `{"url":"`+server.URL+`/download/MEDIA1","mime_type":"image/jpeg","id":"MEDIA1"}`)
+	}))
+	server.Handle("/download/MEDIA1", authorized(providertest.Content("application/octet-stream", "image")))
+	server.Handle("/", authorized(func(w http.ResponseWriter, r *http.Request) {
+		providertest.WriteJSON(w, http.StatusNotFound, `{"error":{"message":"Unsupported get request.","type":"GraphMethodException","code":100}}`)
+	}))
+
+	p, err := NewWhatsAppProvider("PN1", "secret", "token", "verify", WithBaseURL(server.URL))
+	if err != nil {
+		t.Fatalf("unexpected error:%v", err)
+	}
+	return p, sent
+}
+
+func sign(secret, body string) string {
+	return "sha256=" + providertest.HexHMAC(sha256.New, secret, body)
+}
+
+// change wraps the value into a callback of the messages field.
+func change(phoneNumberID, value string) string {
+	return `{"object":"whatsapp_business_account","entry":[{"id":"WABA1","changes":[{"field":"messages","value":` +
+		`{"messaging_product":"whatsapp","metadata":{"display_phone_number":"15559870000","phone_number_id":"` +
+		phoneNumberID + `"},` + value + `}}]}]}`
+}
+
+func TestWhatsAppProvider_ParseRequest(t *testing.T) {
+	p, _ := newProvider(t)
+
+	cases := []struct {
+		name        string
+		secret      string
+		body        string
+		expected    domain.Webhook
+		expectedErr error
+	}{
+		{
+			name: "text and media",
+			body: change("PN1", `"messages":[`+
+				`{"from":"15551230001","id":"wamid.1","timestamp":"1667260800","type":"text","text":{"body":"hello"}},`+
+				`{"from":"15551230001","id":"wamid.2","type":"image","image":{"id":"MEDIA1","mime_type":"image/jpeg","caption":"look"}},`+
+				`{"from":"15551230001","id":"wamid.3","type":"document","document":{"id":"MEDIA2","mime_type":"application/pdf","filename":"receipt.pdf"}},`+
+				`{"from":"15551230001","id":"wamid.4","type":"sticker","sticker":{"id":"MEDIA3","mime_type":"image/webp"}}]`),
+			expected: domain.Webhook{Messages: []domain.Message{
+				{EventID: "wamid.1", UserID: "15551230001", Type: domain.MessageTypeText, Message: "hello"},
+				{EventID: "wamid.2", UserID: "15551230001", Type: domain.MessageTypeImage, Message: "look", Payload: &domain.Payload{
+					Media: &domain.Media{ContentID: "MEDIA1", ContentType: "image/jpeg"},
+				}},
+				{EventID: "wamid.3", UserID: "15551230001", Type: domain.MessageTypeFile, Payload: &domain.Payload{
+					Media: &domain.Media{ContentID: "MEDIA2", ContentType: "application/pdf"},
+					File:  &domain.File{Name: "receipt.pdf"},
+				}},
+				{EventID: "wamid.4", UserID: "15551230001", Type: domain.MessageTypeSticker, Payload: &domain.Payload{
+					Sticker: &domain.Sticker{StickerID: "MEDIA3"},
+					Media:   &domain.Media{ContentID: "MEDIA3", ContentType: "image/webp"},
+				}},
+			}},
+		},
+		{
+			name: "location, interactive and reaction",
+			body: change("PN1", `"messages":[`+
+				`{"from":"15551230001","id":"wamid.5","type":"location","location":{"latitude":1.28,"longitude":103.85,"name":"Shop","address":"1 Main St"}},`+
+				`{"from":"15551230001","id":"wamid.6","type":"interactive","interactive":{"type":"button_reply","button_reply":{"id":"order:confirm","title":"Confirm"}}},`+
+				`{"from":"15551230001","id":"wamid.7","type":"interactive","interactive":{"type":"list_reply","list_reply":{"id":"size:m","title":"Medium"}}},`+
+				`{"from":"15551230001","id":"wamid.8","type":"button","button":{"payload":"stop","text":"Stop promotions"}},`+
+				`{"from":"15551230001","id":"wamid.9","type":"reaction","reaction":{"message_id":"wamid.1","emoji":"👍"}}]`),
+			expected: domain.Webhook{Messages: []domain.Message{
+				{EventID: "wamid.5", UserID: "15551230001", Type: domain.MessageTypeLocation, Payload: &domain.Payload{
+					Location: &domain.Location{Title: "Shop", Address: "1 Main St", Latitude: 1.28, Longitude: 103.85},
+				}},
+				{EventID: "wamid.6", UserID: "15551230001", Type: domain.MessageTypePostback, Message: "Confirm", Payload: &domain.Payload{
+					Postback: &domain.Postback{Data: "order:confirm"},
+				}},
+				{EventID: "wamid.7", UserID: "15551230001", Type: domain.MessageTypePostback, Message: "Medium", Payload: &domain.Payload{
+					Postback: &domain.Postback{Data: "size:m"},
+				}},
+				{EventID: "wamid.8", UserID: "15551230001", Type: domain.MessageTypePostback, Message: "Stop promotions", Payload: &domain.Payload{
+					Postback: &domain.Postback{Data: "stop"},
+				}},
+			}},
+		},
+		{
+			name: "statuses",
+			body: change("PN1", `"statuses":[`+
+				`{"id":"wamid.10","status":"delivered","timestamp":"1667260800","recipient_id":"15551230001","biz_opaque_callback_data":"m1"},`+
+				`{"id":"wamid.11","status":"failed","recipient_id":"15551230002","biz_opaque_callback_data":"m2","errors":[{"code":131047,"title":"Re-engagement message"}]},`+
+				`{"id":"wamid.12","status":"read","recipient_id":"15551230001"}]`),
+			expected: domain.Webhook{Statuses: []domain.StatusUpdate{
+				{MessageID: "m1", Status: domain.DeliveryStatusDelivered},
+				{MessageID: "m2", Status: domain.DeliveryStatusFailed, Error: "code:131047, err:Re-engagement message"},
+			}},
+		},
+		{
+			name: "other phone number is ignored",
+			body: change("PN2", `"messages":[{"from":"15551230001","id":"wamid.13","type":"text","text":{"body":"hello"}}]`),
+		},
+		{
+			name:        "invalid signature",
+			secret:      "other",
+			body:        change("PN1", `"messages":[]`),
+			expectedErr: domain.ErrInvalidSignature,
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			secret := c.secret
+			if secret == "" {
+				secret = "secret"
+			}
+			req := httptest.NewRequest(http.MethodPost, "/webhook/whatsapp", strings.NewReader(c.body))
+			req.Header.Set(signatureHeader, sign(secret, c.body))
+			got, err := p.ParseRequest(req)
+			if !errors.Is(err, c.expectedErr) {
+				t.Fatalf("error inconsistent, caught error:%v, expected error:%v", err, c.expectedErr)
+			}
+			if !reflect.DeepEqual(got, c.expected) {
+				t.Errorf("data inconsistent, data:%+v, expected data:%+v", got, c.expected)
+			}
+		})
+	}
+}
+
+func TestWhatsAppProvider_VerifySubscription(t *testing.T) {
+	p, _ := newProvider(t)
+
+	req := httptest.NewRequest(http.MethodGet, "/webhook/whatsapp?hub.mode=subscribe&hub.verify_token=verify&hub.challenge=1158201444", nil)
+	got, err := p.ParseRequest(req)
+	if err != nil {
+		t.Fatalf("unexpected error:%v", err)
+	}
+	if got.Response == nil || string(got.Response.Body) != "1158201444" {
+		t.Errorf("response inconsistent, response:%+v", got.Response)
+	}
+
+	req = httptest.NewRequest(http.MethodGet, "/webhook/whatsapp?hub.mode=subscribe&hub.verify_token=other&hub.challenge=1", nil)
+	if _, err = p.ParseRequest(req); !errors.Is(err, domain.ErrInvalidSignature) {
+		t.Errorf("error inconsistent, caught error:%v, expected error:%v", err, domain.ErrInvalidSignature)
+	}
+}
+
+func TestWhatsAppProvider_Send(t *testing.T) {
+	p, sent := newProvider(t)
+
+	if err := p.PushMessage(context.Background(), "15551230001", "hello"); err != nil {
+		t.Fatalf("unexpected error:%v", err)
+	}
+	err := p.PushTracked(context.Background(), domain.Message{
+		ID:     "m1",
+		UserID: "15551230002",
+		Type:   domain.MessageTypeTemplate,
+		Payload: &domain.Payload{Template: &domain.Template{
+			Name: "order_update", Language: "en_US", Parameters: []string{"A123", "shipped"},
+		}},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error:%v", err)
+	}
+	err = p.Multicast(context.Background(), []string{"15551230003", "15550000000"}, "hi")
+	if err == nil || !strings.Contains(err.Error(), "code:131047") {
+		t.Errorf("error inconsistent, caught error:%v, expected the error of the closed window", err)
+	}
+	if err = p.PushTracked(context.Background(), domain.Message{UserID: "15551230001", Type: domain.MessageTypeImage}); !errors.Is(err, domain.ErrBadParamInput) {
+		t.Errorf("error inconsistent, caught error:%v, expected error:%v", err, domain.ErrBadParamInput)
+	}
+	if err = p.SendMessage(context.Background(), "broadcast"); !errors.Is(err, domain.ErrBadParamInput) {
+		t.Errorf("error inconsistent, caught error:%v, expected error:%v", err, domain.ErrBadParamInput)
+	}
+	if p.CanBroadcast() {
+		t.Errorf("broadcast should be unsupported")
+	}
+
+	orderUpdate := &template{Name: "order_update", Components: []component{{
+		Type:       "body",
+		Parameters: []parameter{{Type: "text", Text: "A123"}, {Type: "text", Text: "shipped"}},
+	}}}
+	orderUpdate.Language.Code = "en_US"
+	expected := []sendRequest{
+		{MessagingProduct: "whatsapp", To: "15551230001", Type: "text", Text: &text{Body: "hello"}},
+		{MessagingProduct: "whatsapp", To: "15551230002", Type: "template", Template: orderUpdate, BizOpaqueCallbackData: "m1"},
+		{MessagingProduct: "whatsapp", To: "15551230003", Type: "text", Text: &text{Body: "hi"}},
+	}
+	if got := sent.Items(); !reflect.DeepEqual(got, expected) {
+		t.Errorf("messages sent inconsistent, sent:%+v, expected sent:%+v", got, expected)
+	}
+}
+
+func TestWhatsAppProvider_GetContent(t *testing.T) {
+	p, _ := newProvider(t)
+
+	content, contentType, err := p.GetContent(context.Background(), "MEDIA1")
+	if err != nil {
+		t.Fatalf("unexpected error:%v", err)
+	}
+	defer content.Close()
+	b, _ := io.ReadAll(content)
+	if string(b) != "image" || contentType != "image/jpeg" {
+		t.Errorf("content inconsistent, content:%s, content type:%s", b, contentType)
+	}
+
+	if _, _, err = p.GetContent(context.Background(), "MEDIA2"); err == nil || !strings.Contains(err.Error(), "code:100") {
+		t.Errorf("error inconsistent, caught error:%v, expected the error of the unknown media", err)
+	}
+	if _, _, err = p.GetContent(context.Background(), "https://example.com/MEDIA1"); !errors.Is(err, domain.ErrBadParamInput) {
+		t.Errorf("error inconsistent, caught error:%v, expected error:%v", err, domain.ErrBadParamInput)
+	}
+}